@@ -0,0 +1,189 @@
+// Command mbtiles inspects and maintains go-mapnik cache files.
+//
+// Usage:
+//
+//	mbtiles info file.mbtiles     show layers, tile counts, size and metadata
+//	mbtiles verify file.mbtiles   check blob checksums
+//	mbtiles prune file.mbtiles    delete blobs not referenced by any tile
+//	mbtiles vacuum file.mbtiles   reclaim unused space
+//	mbtiles export file.mbtiles layer out.mbtiles
+//	                              write a layer to a standard MBTiles file
+//	mbtiles drop [-vacuum] file.mbtiles layer
+//	                              delete all tiles of a layer
+//	mbtiles merge [-policy keep|overwrite|newest] [-layer name] out.mbtiles in.mbtiles...
+//	                              merge cache or MBTiles files into out.mbtiles
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/nkovacs/go-mapnik/maptiles"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: mbtiles info|verify|prune|vacuum file.mbtiles")
+	fmt.Fprintln(os.Stderr, "       mbtiles export file.mbtiles layer out.mbtiles")
+	fmt.Fprintln(os.Stderr, "       mbtiles drop [-vacuum] file.mbtiles layer")
+	fmt.Fprintln(os.Stderr, "       mbtiles merge [-policy keep|overwrite|newest] [-layer name] out.mbtiles in.mbtiles...")
+	os.Exit(2)
+}
+
+func info(db *maptiles.TileDb) error {
+	layers, err := db.Layers()
+	if err != nil {
+		return err
+	}
+	for _, l := range layers {
+		counts, err := db.ZoomCounts(l)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("layer %v\n", l)
+		zooms := make([]int, 0, len(counts))
+		for z := range counts {
+			zooms = append(zooms, int(z))
+		}
+		sort.Ints(zooms)
+		for _, z := range zooms {
+			fmt.Printf("  zoom %2d: %d tiles\n", z, counts[uint64(z)])
+		}
+	}
+
+	stats, err := db.Stats()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d tiles, %d distinct images, %d bytes\n", stats.Tiles, stats.Blobs, stats.BlobBytes)
+
+	md, err := db.Metadata()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(md))
+	for name := range md {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Println("metadata")
+	for _, name := range names {
+		fmt.Printf("  %v: %v\n", name, md[name])
+	}
+	return nil
+}
+
+func merge(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	fs.Usage = usage
+	policy := fs.String("policy", "keep", "conflict resolution: keep (earlier files win), overwrite (later files win) or newest")
+	layer := fs.String("layer", "", "layer for tiles of standard MBTiles files")
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		usage()
+	}
+
+	opts := maptiles.MergeOptions{Layer: *layer}
+	switch *policy {
+	case "keep":
+		opts.Policy = maptiles.MergeKeepExisting
+	case "overwrite":
+		opts.Policy = maptiles.MergeOverwrite
+	case "newest":
+		opts.Policy = maptiles.MergeNewest
+	default:
+		return fmt.Errorf("unknown policy %v", *policy)
+	}
+
+	db, err := maptiles.NewTileDb(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	for _, in := range fs.Args()[1:] {
+		if err := db.Merge(in, opts); err != nil {
+			return fmt.Errorf("%v: %v", in, err)
+		}
+	}
+	return nil
+}
+
+func drop(args []string) error {
+	fs := flag.NewFlagSet("drop", flag.ExitOnError)
+	fs.Usage = usage
+	vacuum := fs.Bool("vacuum", false, "compact the file afterwards")
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		usage()
+	}
+
+	db, err := maptiles.NewTileDb(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.DropLayer(fs.Arg(1), *vacuum)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 2 {
+		usage()
+	}
+	cmd, path := flag.Arg(0), flag.Arg(1)
+	switch cmd {
+	case "merge", "drop":
+		f := merge
+		if cmd == "drop" {
+			f = drop
+		}
+		if err := f(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := maptiles.NewTileDb(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	switch cmd {
+	case "info":
+		err = info(db)
+	case "verify":
+		var bad []string
+		bad, err = db.VerifyBlobs()
+		for _, c := range bad {
+			fmt.Println("checksum mismatch:", c)
+		}
+		if err == nil && len(bad) == 0 {
+			fmt.Println("ok")
+		}
+	case "prune":
+		var n int64
+		n, err = db.DeleteOrphanedBlobs()
+		if err == nil {
+			fmt.Printf("deleted %d orphaned blobs\n", n)
+		}
+	case "vacuum":
+		err = db.Vacuum()
+	case "export":
+		if flag.NArg() != 4 {
+			usage()
+		}
+		err = db.ExportLayer(flag.Arg(2), flag.Arg(3))
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
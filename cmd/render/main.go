@@ -0,0 +1,131 @@
+// Command render renders a single image from a mapnik stylesheet.
+//
+// Example:
+//
+//	render -style osm.xml -bbox 5.9,45.8,10.5,47.8 -size 2048x2048 -o out.png
+//	render -style osm.xml -center 8.54,47.37 -zoom 12 -size 1024x768 -o zurich.png
+//	render -style osm.xml -center 8.54,47.37 -scale 25000 -size 3508x2480 -o print.png
+//
+// The extent is either a WGS84 bounding box, which mapnik grows to match
+// the aspect ratio of the image, or a center and a zoom level or scale
+// denominator. The zoom level uses the scale of 256 pixel Web Mercator
+// tiles, so the stylesheet is expected to be in Web Mercator. The output format is derived from
+// the extension of -o, e.g. png, jpeg, pdf or svg if mapnik supports it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/nkovacs/go-mapnik/mapnik"
+	"github.com/nkovacs/go-mapnik/tilegrid"
+)
+
+func parseFloats(s string, n int) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, fmt.Errorf("expected %d comma separated numbers, got %q", n, s)
+	}
+	v := make([]float64, n)
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, err
+		}
+		v[i] = f
+	}
+	return v, nil
+}
+
+func parseSize(s string) (uint32, uint32, error) {
+	parts := strings.SplitN(s, "x", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("size must be WIDTHxHEIGHT")
+	}
+	w, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid size: %v", err)
+	}
+	h, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid size: %v", err)
+	}
+	if w == 0 || h == 0 {
+		return 0, 0, fmt.Errorf("invalid size %v", s)
+	}
+	return uint32(w), uint32(h), nil
+}
+
+func main() {
+	style := flag.String("style", "", "mapnik stylesheet")
+	bbox := flag.String("bbox", "", "extent as minlon,minlat,maxlon,maxlat")
+	center := flag.String("center", "", "center of the image as lon,lat, used with -zoom")
+	zoom := flag.Uint64("zoom", 0, "zoom level, used with -center")
+	scale := flag.Float64("scale", 0, "scale denominator, used with -center instead of -zoom")
+	size := flag.String("size", "1024x1024", "image size as WIDTHxHEIGHT")
+	bufferSize := flag.Int("buffer", 128, "pixels rendered around the image")
+	out := flag.String("o", "out.png", "output file")
+	flag.Parse()
+
+	if *style == "" {
+		log.Fatal("-style is required")
+	}
+	if (*bbox == "") == (*center == "") {
+		log.Fatal("exactly one of -bbox and -center is required")
+	}
+	width, height, err := parseSize(*size)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *zoom > tilegrid.MaxZoom {
+		log.Fatalf("zoom must be at most %d", tilegrid.MaxZoom)
+	}
+
+	m := mapnik.NewMap(width, height)
+	defer m.Free()
+	if err := m.Load(*style); err != nil {
+		log.Fatalf("loading stylesheet %v: %v", *style, err)
+	}
+	p := m.Projection()
+	defer p.Free()
+
+	var c0, c1 mapnik.Coord
+	switch {
+	case *bbox != "":
+		v, err := parseFloats(*bbox, 4)
+		if err != nil {
+			log.Fatalf("invalid bbox: %v", err)
+		}
+		c0 = p.Forward(mapnik.Coord{X: v[0], Y: v[1]})
+		c1 = p.Forward(mapnik.Coord{X: v[2], Y: v[3]})
+	case *scale > 0:
+		v, err := parseFloats(*center, 2)
+		if err != nil {
+			log.Fatalf("invalid center: %v", err)
+		}
+		c := p.Forward(mapnik.Coord{X: v[0], Y: v[1]})
+		m.ZoomToScaleDenominator(c.X, c.Y, *scale)
+		e := m.CurrentExtent()
+		c0 = mapnik.Coord{X: e[0], Y: e[1]}
+		c1 = mapnik.Coord{X: e[2], Y: e[3]}
+	default:
+		v, err := parseFloats(*center, 2)
+		if err != nil {
+			log.Fatalf("invalid center: %v", err)
+		}
+		c := p.Forward(mapnik.Coord{X: v[0], Y: v[1]})
+		res := tilegrid.Resolution(*zoom)
+		dx, dy := res*float64(width)/2, res*float64(height)/2
+		c0 = mapnik.Coord{X: c.X - dx, Y: c.Y - dy}
+		c1 = mapnik.Coord{X: c.X + dx, Y: c.Y + dy}
+	}
+
+	m.ZoomToMinMax(c0.X, c0.Y, c1.X, c1.Y)
+	m.SetBufferSize(*bufferSize)
+	if err := m.RenderToFile(*out); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,114 @@
+// Command renderworker serves the gRPC render service of render.proto, so
+// rendering capacity can be deployed separately from the tile servers.
+//
+// Example:
+//
+//	renderworker -style osm.xml -layer osm -addr :9090 -secret-file secret
+//	renderworker -config tileserver.yaml -addr :9090 -cert worker.crt -key worker.key -client-ca ca.crt
+//
+// With -config, the layers of a tile server configuration file are
+// rendered, otherwise the single -style is rendered as -layer. Without
+// -cert and -key the service is served over unencrypted HTTP/2. Clients
+// are authenticated with the shared secret of -secret-file, with client
+// certificates signed by -client-ca, or both; one of them is required.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/nkovacs/go-mapnik/maptiles"
+)
+
+func main() {
+	maptiles.RunRenderWorker()
+
+	style := flag.String("style", "", "mapnik stylesheet")
+	layer := flag.String("layer", "default", "layer name of -style")
+	config := flag.String("config", "", "tile server configuration file with the layers to render")
+	renderers := flag.Int("renderers", 0, "number of renderers of -style, 0 uses the number of CPUs")
+	addr := flag.String("addr", ":9090", "listen address")
+	cert := flag.String("cert", "", "TLS certificate file")
+	key := flag.String("key", "", "TLS key file")
+	clientCA := flag.String("client-ca", "", "CA certificate file of the required client certificates")
+	secretFile := flag.String("secret-file", "", "file containing the shared secret of the clients")
+	flag.Parse()
+
+	if *clientCA == "" && *secretFile == "" {
+		log.Fatal("-client-ca or -secret-file is required")
+	}
+	if *clientCA != "" && *cert == "" {
+		log.Fatal("-client-ca requires -cert and -key")
+	}
+
+	var service *maptiles.RenderService
+	switch {
+	case *config != "":
+		t, err := maptiles.NewTileServerFromConfig(*config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		service = t.RenderService()
+	case *style != "":
+		lmp := maptiles.NewLayerMultiplex(*renderers)
+		if err := lmp.AddRenderer(*layer, *style); err != nil {
+			log.Fatal(err)
+		}
+		service = maptiles.NewRenderService(lmp)
+	default:
+		log.Fatal("-style or -config is required")
+	}
+
+	if *secretFile != "" {
+		secret, err := ioutil.ReadFile(*secretFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		service.Secret = strings.TrimSpace(string(secret))
+	}
+
+	var opts []grpc.ServerOption
+	if *cert != "" || *key != "" {
+		config, err := tlsConfig(*cert, *key, *clientCA)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	}
+	l, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(service.NewServer(opts...).Serve(l))
+}
+
+// tlsConfig loads the server certificate and, if clientCA is set,
+// requires client certificates signed by it.
+func tlsConfig(cert, key, clientCA string) (*tls.Config, error) {
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{pair}}
+	if clientCA != "" {
+		pem, err := ioutil.ReadFile(clientCA)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = x509.NewCertPool()
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%v: no certificates found", clientCA)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
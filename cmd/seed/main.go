@@ -0,0 +1,275 @@
+// Command seed pre-renders the tiles of a region into a cache.
+//
+// Example:
+//
+//	seed -style osm.xml -bbox 5.9,45.8,10.5,47.8 -zooms 0-14 -workers 8 -out tiles.mbtiles
+//
+// If -out ends in .mbtiles, the tiles are written to a go-mapnik cache file,
+// otherwise to a {layer}/{z}/{x}/{y}.png directory tree. Interrupted runs can
+// be resumed by running the same command again, metatiles that are already
+// present in the cache are skipped unless -resume=false is given.
+//
+// With -heatmap or -access-log, only the most requested regions are seeded,
+// most requested first, e.g. to warm the cache after a style change:
+//
+//	seed -style osm.xml -layer osm -heatmap heatmap.csv -top 500 -zooms 0-16 -out tiles.mbtiles
+//
+// -heatmap reads an export of the tile server's /admin/heatmap.csv, -access-log
+// counts the tile requests of a web server access log per -heatmap-zoom tile.
+// Only the requests for -layer are used.
+//
+// With -queue, a seed is split between several machines sharing the output
+// cache: one coordinator adds the metatiles to a redis stream with -enqueue,
+// the workers render them with -worker until they are stopped:
+//
+//	seed -queue redis://redis:6379 -enqueue -layer osm -bbox 5.9,45.8,10.5,47.8 -zooms 0-16 -out /shared/tiles
+//	seed -queue redis://redis:6379 -worker -layer osm -style osm.xml -workers 8 -out /shared/tiles
+//
+// With -config, the seed section of a tile server configuration file
+// provides the defaults for -bbox, -zooms, -workers, -metasize and -order.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nkovacs/go-mapnik/mapnik"
+	"github.com/nkovacs/go-mapnik/maptiles"
+)
+
+func parseBBox(s string) (mapnik.Coord, mapnik.Coord, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return mapnik.Coord{}, mapnik.Coord{}, fmt.Errorf("bbox must be minlon,minlat,maxlon,maxlat")
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return mapnik.Coord{}, mapnik.Coord{}, fmt.Errorf("invalid bbox: %v", err)
+		}
+		v[i] = f
+	}
+	return mapnik.Coord{X: v[0], Y: v[1]}, mapnik.Coord{X: v[2], Y: v[3]}, nil
+}
+
+func parseZooms(s string) (uint64, uint64, error) {
+	parts := strings.SplitN(s, "-", 2)
+	minZ, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid zoom range: %v", err)
+	}
+	maxZ := minZ
+	if len(parts) == 2 {
+		maxZ, err = strconv.ParseUint(parts[1], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid zoom range: %v", err)
+		}
+	}
+	if maxZ < minZ || maxZ >= 30 {
+		return 0, 0, fmt.Errorf("invalid zoom range %v", s)
+	}
+	return minZ, maxZ, nil
+}
+
+// applyDefaults sets the flags that were not given on the command line
+// to the values of the seed section of a configuration file.
+func applyDefaults(cfg maptiles.SeedConfig, bbox, zooms *string, workers *int, metaSize *uint64, order *string) {
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	if !set["bbox"] && len(cfg.BBox) == 4 {
+		*bbox = fmt.Sprintf("%v,%v,%v,%v", cfg.BBox[0], cfg.BBox[1], cfg.BBox[2], cfg.BBox[3])
+	}
+	if !set["zooms"] && cfg.MaxZoom > 0 {
+		*zooms = fmt.Sprintf("%d-%d", cfg.MinZoom, cfg.MaxZoom)
+	}
+	if !set["workers"] && cfg.Workers > 0 {
+		*workers = cfg.Workers
+	}
+	if !set["metasize"] && cfg.MetaSize > 0 {
+		*metaSize = cfg.MetaSize
+	}
+	if !set["order"] && cfg.Order != "" {
+		*order = cfg.Order
+	}
+}
+
+// readCells reads heatmap cells from a CSV export or an access log.
+func readCells(heatmap, accessLog string, zoom uint64) ([]maptiles.HeatmapCell, error) {
+	path := heatmap
+	if path == "" {
+		path = accessLog
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if heatmap != "" {
+		return maptiles.ReadHeatmapCSV(f)
+	}
+	return maptiles.ParseAccessLog(f, zoom, false)
+}
+
+func main() {
+	style := flag.String("style", "", "mapnik stylesheet")
+	bbox := flag.String("bbox", "-180,-85.0511,180,85.0511", "region to seed as minlon,minlat,maxlon,maxlat")
+	zooms := flag.String("zooms", "0-5", "zoom range, e.g. 0-14")
+	workers := flag.Int("workers", 4, "number of renderers")
+	out := flag.String("out", "tiles.mbtiles", "output .mbtiles file or directory")
+	layer := flag.String("layer", "default", "layer name to store the tiles under")
+	metaSize := flag.Uint64("metasize", 8, "metatile size in tiles")
+	bufferSize := flag.Uint64("buffer", 128, "pixels rendered around each metatile")
+	order := flag.String("order", "row", "seed order, row or pyramid")
+	resume := flag.Bool("resume", true, "skip metatiles that are already cached")
+	skipBlank := flag.Bool("skip-blank", false, "do not store fully transparent tiles")
+	dedupSolid := flag.Bool("dedup-solid", false, "store single-colored tiles once per color (.mbtiles only)")
+	dryRun := flag.Bool("n", false, "only print the number of tiles that would be rendered")
+	config := flag.String("config", "", "tile server configuration file with seed defaults")
+	heatmap := flag.String("heatmap", "", "seed the cells of a heatmap CSV export instead of -bbox")
+	accessLog := flag.String("access-log", "", "seed the regions requested in an access log instead of -bbox")
+	heatmapZoom := flag.Uint64("heatmap-zoom", 10, "zoom level at which -access-log requests are counted")
+	top := flag.Int("top", 0, "only seed the most requested heatmap cells, 0 seeds all")
+	queueURL := flag.String("queue", "", "redis://host:port/db URL of a job queue")
+	enqueue := flag.Bool("enqueue", false, "add the metatiles to -queue instead of rendering them")
+	worker := flag.Bool("worker", false, "render the metatiles of -queue")
+	flag.Parse()
+
+	if *config != "" {
+		cfg, err := maptiles.LoadConfig(*config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		applyDefaults(cfg.Seed, bbox, zooms, workers, metaSize, order)
+	}
+
+	lowLeft, upRight, err := parseBBox(*bbox)
+	if err != nil {
+		log.Fatal(err)
+	}
+	minZoom, maxZoom, err := parseZooms(*zooms)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := maptiles.Seeder{
+		Layer:        *layer,
+		LowLeft:      lowLeft,
+		UpRight:      upRight,
+		MinZoom:      minZoom,
+		MaxZoom:      maxZoom,
+		MetaSize:     *metaSize,
+		Workers:      *workers,
+		SkipExisting: *resume,
+		SkipBlank:    *skipBlank,
+	}
+	if *heatmap != "" || *accessLog != "" {
+		cells, err := readCells(*heatmap, *accessLog, *heatmapZoom)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, c := range cells {
+			if c.Layer == *layer {
+				s.Cells = append(s.Cells, c)
+			}
+		}
+		if len(s.Cells) == 0 {
+			log.Fatalf("no requests for layer %v", *layer)
+		}
+		if *top > 0 && len(s.Cells) > *top {
+			s.Cells = s.Cells[:*top]
+		}
+	}
+	switch *order {
+	case "row":
+		s.Order = maptiles.SeedRowMajor
+	case "pyramid":
+		s.Order = maptiles.SeedPyramid
+	default:
+		log.Fatalf("unknown seed order %v", *order)
+	}
+
+	if *dryRun {
+		e := s.Estimate()
+		for z := minZoom; z <= maxZoom; z++ {
+			fmt.Printf("zoom %2d: %d tiles\n", z, e.TilesPerZoom[z])
+		}
+		fmt.Printf("total: %d tiles in %d metatiles\n", e.Tiles, e.MetaTiles)
+		return
+	}
+
+	var queue *maptiles.RedisQueue
+	if *enqueue || *worker {
+		if *queueURL == "" {
+			log.Fatal("-enqueue and -worker require -queue")
+		}
+		queue, err = maptiles.NewRedisQueue(*queueURL, "maptiles:seed:"+*layer)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer queue.Close()
+	}
+
+	if *style == "" && !*enqueue {
+		fmt.Fprintln(os.Stderr, "missing -style")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if strings.HasSuffix(*out, ".mbtiles") {
+		db, err := maptiles.NewTileDb(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer db.Close()
+		db.DedupSolid = *dedupSolid
+		s.Cache = db
+	} else {
+		s.Cache = &maptiles.DirCache{Dir: *out}
+	}
+
+	if *enqueue {
+		n, err := s.Enqueue(queue)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("enqueued %d metatiles\n", n)
+		return
+	}
+
+	lmp := maptiles.NewLayerMultiplex(*workers)
+	s.Renderer, err = lmp.CreateRendererFromConfig(maptiles.RendererConfig{
+		Stylesheet: *style,
+		BufferSize: *bufferSize,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *worker {
+		s.Progress = func(done, total uint64) {
+			fmt.Fprintf(os.Stderr, "\r%d metatiles   ", done)
+		}
+		s.RunJobs(queue, nil)
+		return
+	}
+
+	start := time.Now()
+	s.Progress = func(done, total uint64) {
+		if total == 0 {
+			return
+		}
+		elapsed := time.Since(start)
+		eta := time.Duration(float64(elapsed) / float64(done) * float64(total-done))
+		fmt.Fprintf(os.Stderr, "\r%d/%d metatiles (%.1f%%), eta %v   ", done, total, 100*float64(done)/float64(total), eta.Round(time.Second))
+	}
+	s.Run()
+	fmt.Fprintln(os.Stderr)
+}
@@ -21,6 +21,7 @@ func SimpleExample() {
 	// Perform a projection that is only neccessary because stylesheet.xml
 	// is using EPSG:3857 rather than WGS84
 	p := m.Projection()
+	defer p.Free()
 	ll := p.Forward(mapnik.Coord{0, 35})  // 0 degrees longitude, 35 degrees north
 	ur := p.Forward(mapnik.Coord{16, 70}) // 16 degrees east, 70 degrees north
 	m.ZoomToMinMax(ll.X, ll.Y, ur.X, ur.Y)
@@ -62,8 +63,15 @@ func GenerateOSMTiles() {
 func TileserverWithCaching() {
 	cache := "gomapnikcache.sqlite"
 	os.Remove(cache)
-	t := maptiles.NewTileServer(cache)
-	t.AddMapnikLayer("default", "sampledata/stylesheet.xml")
+	t, err := maptiles.NewTileServer(maptiles.TileServerConfig{CacheFile: cache})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if err := t.AddMapnikLayer("default", "sampledata/stylesheet.xml"); err != nil {
+		fmt.Println(err)
+		return
+	}
 	http.ListenAndServe(":8080", t)
 }
 
@@ -1,12 +1,21 @@
 #!/bin/bash
 
-[ -f mapnik_c_api.cpp ] || curl -LO https://raw.github.com/fawick/mapnik-c-api/master/mapnik_c_api.cpp
-[ -f mapnik_c_api.h ] || curl -LO https://raw.github.com/fawick/mapnik-c-api/master/mapnik_c_api.h
+# The extensions rely on the layout of the mapnik-c-api types, see
+# mapnik_private.h. Set MAPNIK_C_API_REF to a commit to pin the version.
+MAPNIK_C_API_REF=${MAPNIK_C_API_REF:-master}
+[ -f mapnik_c_api.cpp ] || curl -LO https://raw.github.com/fawick/mapnik-c-api/$MAPNIK_C_API_REF/mapnik_c_api.cpp
+[ -f mapnik_c_api.h ] || curl -LO https://raw.github.com/fawick/mapnik-c-api/$MAPNIK_C_API_REF/mapnik_c_api.h
+
+# mapnik_cairo.cpp calls cairo directly if mapnik was built with it
+CAIRO_LIBS=
+if mapnik-config --cflags | grep -q HAVE_CAIRO; then
+	CAIRO_LIBS=-lcairo
+fi
 
 cat > gen_import.go <<EOF
 package mapnik
 // #cgo CXXFLAGS: $(mapnik-config --cflags)
-// #cgo LDFLAGS: $(mapnik-config --libs) -lboost_system
+// #cgo LDFLAGS: $(mapnik-config --libs) -lboost_system $CAIRO_LIBS
 import "C"
 
 const (
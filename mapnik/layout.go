@@ -0,0 +1,41 @@
+package mapnik
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+)
+
+// checkLayout verifies that the layouts of the mapnik-c-api types copied
+// in mapnik_private.h match the linked mapnik-c-api, by passing objects
+// created by one side to functions of the other. A mismatch would
+// corrupt memory later on.
+func checkLayout() error {
+	m := NewMap(7, 5)
+	defer m.Free()
+	if w, h := m.Width(), m.Height(); w != 7 || h != 5 {
+		return fmt.Errorf("mapnik: mapnik_map_t layout mismatch, got size %dx%d for a 7x5 map; rebuild against a compatible mapnik-c-api", w, h)
+	}
+
+	img := NewImage(image.NewNRGBA(image.Rect(0, 0, 2, 3)))
+	defer img.Free()
+	blob, err := img.EncodePNG()
+	if err != nil {
+		return err
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(blob))
+	if err != nil || cfg.Width != 2 || cfg.Height != 3 {
+		return fmt.Errorf("mapnik: mapnik_image_t layout mismatch, got %dx%d for a 2x3 image (%v); rebuild against a compatible mapnik-c-api", cfg.Width, cfg.Height, err)
+	}
+
+	m.SetSRS("+proj=merc +a=6378137 +b=6378137 +lat_ts=0 +lon_0=0 +x_0=0 +y_0=0 +k=1 +units=m +no_defs")
+	p := m.Projection()
+	defer p.Free()
+	c := p.Inverse(p.Forward(Coord{X: 10, Y: 20}))
+	if math.Abs(c.X-10) > 1e-6 || math.Abs(c.Y-20) > 1e-6 {
+		return fmt.Errorf("mapnik: mapnik_projection_t layout mismatch, got %v for 10,20; rebuild against a compatible mapnik-c-api", c)
+	}
+	return nil
+}
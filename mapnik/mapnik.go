@@ -2,10 +2,21 @@ package mapnik
 
 // #include <stdlib.h>
 // #include "mapnik_c_api.h"
+// #include "mapnik_layers.h"
+// #include "mapnik_info.h"
+// #include "mapnik_map_ext.h"
+// #include "mapnik_proj.h"
+// #include "mapnik_cairo.h"
+// #include "mapnik_raster.h"
 import "C"
 
 import (
+	"encoding/json"
 	"errors"
+	"image"
+	"image/color"
+	"runtime"
+	"strings"
 	"unsafe"
 )
 
@@ -13,12 +24,36 @@ func init() {
 	// register default datasources path and fonts path like the python bindings do
 	RegisterDatasources(pluginPath)
 	RegisterFonts(fontPath)
+	if err := checkLayout(); err != nil {
+		panic(err)
+	}
 }
 
 func Version() string {
 	return "Mapnik " + C.GoString(C.mapnik_version_string())
 }
 
+// InputPlugins returns the names of the registered datasource plugins,
+// e.g. postgis, gdal, ogr, shape and geojson.
+func InputPlugins() []string {
+	return splitNames(C.mapnik_input_plugin_names())
+}
+
+// Fonts returns the face names of the registered fonts.
+func Fonts() []string {
+	return splitNames(C.mapnik_font_face_names())
+}
+
+// splitNames frees a newline separated list returned by the C API.
+func splitNames(cs *C.char) []string {
+	defer C.free(unsafe.Pointer(cs))
+	s := C.GoString(cs)
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
 func RegisterDatasources(path string) {
 	cs := C.CString(path)
 	defer C.free(unsafe.Pointer(cs))
@@ -40,37 +75,171 @@ type Coord struct {
 	X, Y float64
 }
 
+// Ownership of native resources
+//
+// Map, Projection, ProjTransform and Image wrap objects allocated by the
+// C++ library, which the Go garbage collector does not see. They should be
+// released with Free (or Close) as soon as they are no longer needed, in
+// particular by long-running servers that reload stylesheets. A finalizer
+// frees objects that are dropped without calling Free, but it may run much
+// later, or not at all before the program exits. Free may be called more
+// than once; the object must not be used afterwards.
+
 // Projection from one reference system to the other
 type Projection struct {
 	p *C.struct__mapnik_projection_t
 }
 
+// Free releases the projection.
 func (p *Projection) Free() {
+	if p.p == nil {
+		return
+	}
+	runtime.SetFinalizer(p, nil)
 	C.mapnik_projection_free(p.p)
 	p.p = nil
 }
 
+// Close releases the projection like Free. It implements io.Closer.
+func (p *Projection) Close() error {
+	p.Free()
+	return nil
+}
+
 func (p Projection) Forward(coord Coord) Coord {
 	c := C.mapnik_coord_t{C.double(coord.X), C.double(coord.Y)}
 	c = C.mapnik_projection_forward(p.p, c)
 	return Coord{float64(c.x), float64(c.y)}
 }
 
+// Inverse converts a coordinate in the target reference system back to
+// the source reference system, e.g. from map coordinates to WGS84 for
+// the projection returned by Map.Projection.
+func (p Projection) Inverse(coord Coord) Coord {
+	c := C.mapnik_coord_t{C.double(coord.X), C.double(coord.Y)}
+	c = C.mapnik_projection_inverse(p.p, c)
+	return Coord{float64(c.x), float64(c.y)}
+}
+
+// ProjTransform converts coordinates between two arbitrary spatial
+// reference systems.
+type ProjTransform struct {
+	t *C.struct__mapnik_proj_transform_t
+}
+
+// NewProjTransform creates a transform from src to dst, which are proj4
+// or EPSG strings like the srs attribute of a stylesheet, e.g.
+// "+init=epsg:4326". It returns an error if either is invalid.
+func NewProjTransform(src, dst string) (*ProjTransform, error) {
+	csrc := C.CString(src)
+	defer C.free(unsafe.Pointer(csrc))
+	cdst := C.CString(dst)
+	defer C.free(unsafe.Pointer(cdst))
+	var err *C.char
+	t := C.mapnik_proj_transform(csrc, cdst, &err)
+	if t == nil {
+		defer C.free(unsafe.Pointer(err))
+		return nil, errors.New("mapnik: " + C.GoString(err))
+	}
+	pt := &ProjTransform{t}
+	runtime.SetFinalizer(pt, (*ProjTransform).Free)
+	return pt, nil
+}
+
+// ValidSRS reports whether mapnik can use the spatial reference system.
+func ValidSRS(srs string) bool {
+	t, err := NewProjTransform(srs, srs)
+	if err != nil {
+		return false
+	}
+	t.Free()
+	return true
+}
+
+// Free releases the transform and removes its finalizer. It may be called
+// more than once; Forward and Backward must not be called afterwards.
+func (t *ProjTransform) Free() {
+	if t.t == nil {
+		return
+	}
+	runtime.SetFinalizer(t, nil)
+	C.mapnik_proj_transform_free(t.t)
+	t.t = nil
+}
+
+// Close releases the transform like Free. It implements io.Closer.
+func (t *ProjTransform) Close() error {
+	t.Free()
+	return nil
+}
+
+// Forward converts a coordinate from the source to the destination
+// reference system. It returns an error if the coordinate cannot be
+// transformed, e.g. because it is outside of the valid area.
+func (t *ProjTransform) Forward(coord Coord) (Coord, error) {
+	x, y := C.double(coord.X), C.double(coord.Y)
+	if C.mapnik_proj_transform_forward(t.t, &x, &y) != 0 {
+		return coord, errors.New("mapnik: cannot transform coordinate")
+	}
+	return Coord{float64(x), float64(y)}, nil
+}
+
+// Backward converts a coordinate from the destination to the source
+// reference system.
+func (t *ProjTransform) Backward(coord Coord) (Coord, error) {
+	x, y := C.double(coord.X), C.double(coord.Y)
+	if C.mapnik_proj_transform_backward(t.t, &x, &y) != 0 {
+		return coord, errors.New("mapnik: cannot transform coordinate")
+	}
+	return Coord{float64(x), float64(y)}, nil
+}
+
+// ReadRaster samples a band of a raster file, e.g. a GeoTIFF DEM, with
+// mapnik's gdal input plugin. The values at the centers of a width x
+// height grid covering extent (minx, miny, maxx, maxy) in the reference
+// system of the file are returned row by row from the top. Cells without
+// data are NaN. Bands are numbered from 1.
+func ReadRaster(file string, band int, extent [4]float64, width, height int) ([]float32, error) {
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("mapnik: invalid raster size")
+	}
+	cs := C.CString(file)
+	defer C.free(unsafe.Pointer(cs))
+	values := make([]float32, width*height)
+	var err *C.char
+	if C.mapnik_raster_read(cs, C.int(band), C.double(extent[0]), C.double(extent[1]), C.double(extent[2]), C.double(extent[3]),
+		C.uint(width), C.uint(height), (*C.float)(unsafe.Pointer(&values[0])), &err) != 0 {
+		defer C.free(unsafe.Pointer(err))
+		return nil, errors.New("mapnik: " + C.GoString(err))
+	}
+	return values, nil
+}
+
 // Map base type
 type Map struct {
 	m *C.struct__mapnik_map_t
 }
 
+// NewMap creates an empty map of the given size in pixels. It must be
+// freed with Free.
 func NewMap(width, height uint32) *Map {
-	return &Map{C.mapnik_map(C.uint(width), C.uint(height))}
+	return newMap(C.mapnik_map(C.uint(width), C.uint(height)))
+}
+
+func newMap(cm *C.struct__mapnik_map_t) *Map {
+	m := &Map{cm}
+	runtime.SetFinalizer(m, (*Map).Free)
+	return m
 }
 
 func (m *Map) lastError() error {
+	defer runtime.KeepAlive(m)
 	return errors.New("mapnik: " + C.GoString(C.mapnik_map_last_error(m.m)))
 }
 
 // Load initializes the map by loading its stylesheet from stylesheetFile
 func (m *Map) Load(stylesheetFile string) error {
+	defer runtime.KeepAlive(m)
 	cs := C.CString(stylesheetFile)
 	defer C.free(unsafe.Pointer(cs))
 	if C.mapnik_map_load(m.m, cs) != 0 {
@@ -82,6 +251,7 @@ func (m *Map) Load(stylesheetFile string) error {
 // LoadString initializes the map not from a file but from a stylesheet
 // provided as a string.
 func (m *Map) LoadString(stylesheet string) error {
+	defer runtime.KeepAlive(m)
 	cs := C.CString(stylesheet)
 	defer C.free(unsafe.Pointer(cs))
 	if C.mapnik_map_load_string(m.m, cs) != 0 {
@@ -90,26 +260,145 @@ func (m *Map) LoadString(stylesheet string) error {
 	return nil
 }
 
+// LoadStringBase loads a stylesheet provided as a string like LoadString,
+// resolving relative file paths in it, e.g. of shapefile datasources,
+// against basePath instead of the working directory.
+func (m *Map) LoadStringBase(stylesheet, basePath string) error {
+	defer runtime.KeepAlive(m)
+	cs := C.CString(stylesheet)
+	defer C.free(unsafe.Pointer(cs))
+	cb := C.CString(basePath)
+	defer C.free(unsafe.Pointer(cb))
+	if C.mapnik_map_load_string_base(m.m, cs, cb) != 0 {
+		return m.lastError()
+	}
+	return nil
+}
+
 func (m *Map) Resize(width, height uint32) {
+	defer runtime.KeepAlive(m)
 	C.mapnik_map_resize(m.m, C.uint(width), C.uint(height))
 }
 
+// Free releases the map with its layers, styles and datasources.
+// Projections returned by the map stay valid and must be freed separately.
 func (m *Map) Free() {
+	if m.m == nil {
+		return
+	}
+	runtime.SetFinalizer(m, nil)
 	C.mapnik_map_free(m.m)
 	m.m = nil
 }
 
+// Close releases the map like Free. It implements io.Closer.
+func (m *Map) Close() error {
+	m.Free()
+	return nil
+}
+
+// SRS returns the spatial reference system of the map as a proj4 or
+// EPSG string.
 func (m *Map) SRS() string {
+	defer runtime.KeepAlive(m)
 	return C.GoString(C.mapnik_map_get_srs(m.m))
 }
 
+// SetSRS sets the spatial reference system of the map. Layers in other
+// reference systems are reprojected when rendering.
 func (m *Map) SetSRS(srs string) {
+	defer runtime.KeepAlive(m)
 	cs := C.CString(srs)
 	defer C.free(unsafe.Pointer(cs))
 	C.mapnik_map_set_srs(m.m, cs)
 }
 
+// MaximumExtent returns the maximum extent of the map in map coordinates
+// as minx, miny, maxx, maxy. Mapnik does not render beyond it. It returns
+// false if no maximum extent is set.
+func (m *Map) MaximumExtent() ([4]float64, bool) {
+	defer runtime.KeepAlive(m)
+	var e [4]C.double
+	if C.mapnik_map_maximum_extent(m.m, &e[0]) == 0 {
+		return [4]float64{}, false
+	}
+	return [4]float64{float64(e[0]), float64(e[1]), float64(e[2]), float64(e[3])}, true
+}
+
+// SetMaximumExtent restricts rendering to the extent given in map
+// coordinates.
+func (m *Map) SetMaximumExtent(minx, miny, maxx, maxy float64) {
+	defer runtime.KeepAlive(m)
+	C.mapnik_map_set_maximum_extent(m.m, C.double(minx), C.double(miny), C.double(maxx), C.double(maxy))
+}
+
+// ResetMaximumExtent removes the maximum extent.
+func (m *Map) ResetMaximumExtent() {
+	defer runtime.KeepAlive(m)
+	C.mapnik_map_reset_maximum_extent(m.m)
+}
+
+// CurrentExtent returns the extent that is rendered next in map
+// coordinates, as set by ZoomToMinMax or ZoomAll and adjusted to the
+// aspect ratio of the map.
+func (m *Map) CurrentExtent() [4]float64 {
+	defer runtime.KeepAlive(m)
+	var e [4]C.double
+	C.mapnik_map_current_extent(m.m, &e[0])
+	return [4]float64{float64(e[0]), float64(e[1]), float64(e[2]), float64(e[3])}
+}
+
+// AspectFixMode determines how the extent or the image size are adjusted
+// when their aspect ratios differ.
+type AspectFixMode int
+
+const (
+	// GrowBBox grows the extent to match the image. This is the default.
+	GrowBBox AspectFixMode = iota
+	GrowCanvas
+	ShrinkBBox
+	ShrinkCanvas
+	AdjustBBoxWidth
+	AdjustBBoxHeight
+	AdjustCanvasWidth
+	AdjustCanvasHeight
+	// Respect renders the extent as given, distorting the image.
+	Respect
+)
+
+func (m *Map) AspectFixMode() AspectFixMode {
+	defer runtime.KeepAlive(m)
+	return AspectFixMode(C.mapnik_map_aspect_fix_mode(m.m))
+}
+
+func (m *Map) SetAspectFixMode(mode AspectFixMode) {
+	defer runtime.KeepAlive(m)
+	C.mapnik_map_set_aspect_fix_mode(m.m, C.int(mode))
+}
+
+// Scale returns the size of a pixel in map units at the current extent.
+func (m *Map) Scale() float64 {
+	defer runtime.KeepAlive(m)
+	return float64(C.mapnik_map_scale(m.m))
+}
+
+// ScaleDenominator returns the scale denominator of the current extent,
+// as used by the minimum-scale-denominator and maximum-scale-denominator
+// of styles, assuming 0.28 mm pixels.
+func (m *Map) ScaleDenominator() float64 {
+	defer runtime.KeepAlive(m)
+	return float64(C.mapnik_map_scale_denominator(m.m))
+}
+
+// ZoomToScaleDenominator centers the map at x, y in map coordinates and
+// zooms to the scale denominator, e.g. for printing at 1:25000.
+func (m *Map) ZoomToScaleDenominator(x, y, denominator float64) {
+	defer runtime.KeepAlive(m)
+	C.mapnik_map_zoom_to_scale_denominator(m.m, C.double(x), C.double(y), C.double(denominator))
+}
+
 func (m *Map) ZoomAll() error {
+	defer runtime.KeepAlive(m)
 	if C.mapnik_map_zoom_all(m.m) != 0 {
 		return m.lastError()
 	}
@@ -117,12 +406,14 @@ func (m *Map) ZoomAll() error {
 }
 
 func (m *Map) ZoomToMinMax(minx, miny, maxx, maxy float64) {
+	defer runtime.KeepAlive(m)
 	bbox := C.mapnik_bbox(C.double(minx), C.double(miny), C.double(maxx), C.double(maxy))
 	defer C.mapnik_bbox_free(bbox)
 	C.mapnik_map_zoom_to_box(m.m, bbox)
 }
 
 func (m *Map) RenderToFile(path string) error {
+	defer runtime.KeepAlive(m)
 	cs := C.CString(path)
 	defer C.free(unsafe.Pointer(cs))
 	if C.mapnik_map_render_to_file(m.m, cs) != 0 {
@@ -132,22 +423,424 @@ func (m *Map) RenderToFile(path string) error {
 }
 
 func (m *Map) RenderToMemoryPng() ([]byte, error) {
+	i, err := m.RenderImage()
+	if err != nil {
+		return nil, err
+	}
+	defer i.Free()
+	return i.EncodePNG()
+}
+
+// RenderToMemoryCairo renders the map with the cairo renderer to vector
+// output in format, which is "svg" or "pdf". scaleFactor scales symbols,
+// line widths and labels, e.g. 2 for twice the default resolution.
+// It returns an error if mapnik was built without cairo support.
+func (m *Map) RenderToMemoryCairo(format string, scaleFactor float64) ([]byte, error) {
+	return m.RenderToMemoryCairoPage(format, scaleFactor, 1)
+}
+
+// RenderToMemoryCairoPage renders the map like RenderToMemoryCairo on a
+// page scaled by pageScale, e.g. 72/300 for a PDF page measured in points
+// of a map sized in pixels at 300 dpi. Rasters keep the resolution of the
+// map.
+func (m *Map) RenderToMemoryCairoPage(format string, scaleFactor, pageScale float64) ([]byte, error) {
+	defer runtime.KeepAlive(m)
+	cf := C.CString(format)
+	defer C.free(unsafe.Pointer(cf))
+	var data *C.char
+	var n C.size_t
+	if C.mapnik_map_render_to_cairo_page(m.m, cf, C.double(scaleFactor), C.double(pageScale), &data, &n) != 0 {
+		return nil, m.lastError()
+	}
+	defer C.free(unsafe.Pointer(data))
+	return C.GoBytes(unsafe.Pointer(data), C.int(n)), nil
+}
+
+// RenderToMemoryPngWithVariables renders the map like RenderToMemoryPng,
+// substituting vars for @name variables in the stylesheet, e.g. in a
+// filter like [id] = @highlight. Numeric values are passed as numbers.
+func (m *Map) RenderToMemoryPngWithVariables(vars map[string]string) ([]byte, error) {
+	i, err := m.RenderImageWithVariables(vars)
+	if err != nil {
+		return nil, err
+	}
+	defer i.Free()
+	return i.EncodePNG()
+}
+
+// Image is a rendered image. It must be freed with Free.
+type Image struct {
+	i *C.struct__mapnik_image_t
+}
+
+// RenderImage renders the map to an image instead of encoding it right
+// away, so it can be inspected with Painted first.
+func (m *Map) RenderImage() (*Image, error) {
+	defer runtime.KeepAlive(m)
 	i := C.mapnik_map_render_to_image(m.m)
 	if i == nil {
 		return nil, m.lastError()
 	}
-	defer C.mapnik_image_free(i)
-	b := C.mapnik_image_to_png_blob(i)
+	return newImage(i), nil
+}
+
+func newImage(ci *C.struct__mapnik_image_t) *Image {
+	i := &Image{ci}
+	runtime.SetFinalizer(i, (*Image).Free)
+	return i
+}
+
+// RenderImageWithVariables is RenderImage with stylesheet variables,
+// see RenderToMemoryPngWithVariables.
+func (m *Map) RenderImageWithVariables(vars map[string]string) (*Image, error) {
+	defer runtime.KeepAlive(m)
+	keys := make([]*C.char, 0, len(vars)+1)
+	values := make([]*C.char, 0, len(vars)+1)
+	for k, v := range vars {
+		ck, cv := C.CString(k), C.CString(v)
+		defer C.free(unsafe.Pointer(ck))
+		defer C.free(unsafe.Pointer(cv))
+		keys = append(keys, ck)
+		values = append(values, cv)
+	}
+	// keep the slices non-empty so their first element can be passed
+	keys = append(keys, nil)
+	values = append(values, nil)
+	i := C.mapnik_map_render_to_image_vars(m.m, &keys[0], &values[0], C.int(len(vars)))
+	if i == nil {
+		return nil, m.lastError()
+	}
+	return newImage(i), nil
+}
+
+// RenderImageScaled renders the map to an image with symbols, line widths
+// and labels scaled by scaleFactor, e.g. 2 for printing at twice the
+// resolution mapnik assumes (0.28mm per pixel, about 90.7 DPI).
+func (m *Map) RenderImageScaled(scaleFactor float64) (*Image, error) {
+	defer runtime.KeepAlive(m)
+	i := C.mapnik_map_render_to_image_scaled(m.m, C.double(scaleFactor))
+	if i == nil {
+		return nil, m.lastError()
+	}
+	return newImage(i), nil
+}
+
+// Painted reports whether any feature was drawn on the image. Images that
+// were not painted only contain the map background.
+func (i *Image) Painted() bool {
+	defer runtime.KeepAlive(i)
+	return C.mapnik_image_painted(i.i) != 0
+}
+
+// EncodePNG returns the image as PNG.
+func (i *Image) EncodePNG() ([]byte, error) {
+	defer runtime.KeepAlive(i)
+	b := C.mapnik_image_to_png_blob(i.i)
 	defer C.mapnik_image_blob_free(b)
 	return C.GoBytes(unsafe.Pointer(b.ptr), C.int(b.len)), nil
 }
 
+// Encode returns the image in format, which is any format string mapnik
+// supports, e.g. "png", "png8:z=9", "jpeg80", "webp" or "tiff".
+func (i *Image) Encode(format string) ([]byte, error) {
+	defer runtime.KeepAlive(i)
+	cf := C.CString(format)
+	defer C.free(unsafe.Pointer(cf))
+	var data, err *C.char
+	var n C.size_t
+	if C.mapnik_image_to_blob(i.i, cf, &data, &n, &err) != 0 {
+		defer C.free(unsafe.Pointer(err))
+		return nil, errors.New("mapnik: " + C.GoString(err))
+	}
+	defer C.free(unsafe.Pointer(data))
+	return C.GoBytes(unsafe.Pointer(data), C.int(n)), nil
+}
+
+// NewImage copies img into a mapnik image, e.g. to encode it with Encode
+// in a format the Go standard library cannot write, like webp.
+func NewImage(img *image.NRGBA) *Image {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	pix := make([]byte, 0, w*h*4)
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		i := img.PixOffset(img.Rect.Min.X, y)
+		pix = append(pix, img.Pix[i:i+w*4]...)
+	}
+	var data *C.uchar
+	if len(pix) > 0 {
+		data = (*C.uchar)(unsafe.Pointer(&pix[0]))
+	}
+	return newImage(C.mapnik_image_from_rgba(data, C.unsigned(w), C.unsigned(h)))
+}
+
+// ValidCompositeOp reports whether op is the name of a mapnik compositing
+// operation, e.g. multiply, overlay, screen or src-over.
+func ValidCompositeOp(op string) bool {
+	cs := C.CString(op)
+	defer C.free(unsafe.Pointer(cs))
+	return C.mapnik_comp_op_valid(cs) != 0
+}
+
+// Composite draws src onto the image with the compositing operation op
+// and opacity between 0 and 1. Both images must have the same size.
+func (i *Image) Composite(src *Image, op string, opacity float64) error {
+	defer runtime.KeepAlive(i)
+	defer runtime.KeepAlive(src)
+	cs := C.CString(op)
+	defer C.free(unsafe.Pointer(cs))
+	if C.mapnik_image_composite(i.i, src.i, cs, C.float(opacity)) != 0 {
+		return errors.New("mapnik: unknown compositing operation " + op)
+	}
+	return nil
+}
+
+// NRGBA returns a copy of the pixels of the image, without encoding and
+// decoding it.
+func (i *Image) NRGBA() *image.NRGBA {
+	defer runtime.KeepAlive(i)
+	var w, h C.unsigned
+	data := C.mapnik_image_rgba(i.i, &w, &h)
+	img := &image.NRGBA{Rect: image.Rect(0, 0, int(w), int(h)), Stride: 4 * int(w)}
+	if w > 0 && h > 0 {
+		img.Pix = C.GoBytes(unsafe.Pointer(data), C.int(4*w*h))
+	}
+	return img
+}
+
+// Free releases the image.
+func (i *Image) Free() {
+	if i.i == nil {
+		return
+	}
+	runtime.SetFinalizer(i, nil)
+	C.mapnik_image_free(i.i)
+	i.i = nil
+}
+
+// Close releases the image like Free. It implements io.Closer.
+func (i *Image) Close() error {
+	i.Free()
+	return nil
+}
+
+// Projection returns the projection from WGS84 to the reference system of
+// the map. It is a copy that must be freed with Free. It has no finalizer,
+// see NewProjection.
 func (m *Map) Projection() Projection {
-	p := Projection{}
-	p.p = C.mapnik_map_projection(m.m)
+	defer runtime.KeepAlive(m)
+	return Projection{C.mapnik_map_projection(m.m)}
+}
+
+// NewProjection returns the projection of the map like Projection, with a
+// finalizer that frees it if Free is not called.
+func (m *Map) NewProjection() *Projection {
+	defer runtime.KeepAlive(m)
+	p := &Projection{C.mapnik_map_projection(m.m)}
+	runtime.SetFinalizer(p, (*Projection).Free)
 	return p
 }
 
 func (m *Map) SetBufferSize(s int) {
+	defer runtime.KeepAlive(m)
 	C.mapnik_map_set_buffer_size(m.m, C.int(s))
 }
+
+// BufferSize returns the number of pixels rendered around the map.
+func (m *Map) BufferSize() int {
+	defer runtime.KeepAlive(m)
+	return int(C.mapnik_map_buffer_size(m.m))
+}
+
+// Width returns the width of the map in pixels.
+func (m *Map) Width() uint32 {
+	defer runtime.KeepAlive(m)
+	return uint32(C.mapnik_map_width(m.m))
+}
+
+// Height returns the height of the map in pixels.
+func (m *Map) Height() uint32 {
+	defer runtime.KeepAlive(m)
+	return uint32(C.mapnik_map_height(m.m))
+}
+
+// Background returns the background color of the map. It returns false
+// if the stylesheet does not set one.
+func (m *Map) Background() (color.NRGBA, bool) {
+	defer runtime.KeepAlive(m)
+	var r, g, b, a C.uchar
+	if C.mapnik_map_background(m.m, &r, &g, &b, &a) == 0 {
+		return color.NRGBA{}, false
+	}
+	return color.NRGBA{uint8(r), uint8(g), uint8(b), uint8(a)}, true
+}
+
+// SetBackground sets the background color of the map. Use
+// color.Transparent to render images with an alpha channel regardless
+// of the background defined in the stylesheet.
+func (m *Map) SetBackground(c color.Color) {
+	defer runtime.KeepAlive(m)
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	C.mapnik_map_set_background(m.m, C.uchar(n.R), C.uchar(n.G), C.uchar(n.B), C.uchar(n.A))
+}
+
+// Layer describes a layer of the map's stylesheet.
+type Layer struct {
+	Name string
+
+	// MinScale and MaxScale are the scale denominators between which
+	// the layer is rendered.
+	MinScale, MaxScale float64
+
+	// Active is false for layers with status="off". Inactive layers are
+	// not rendered.
+	Active bool
+}
+
+// Layers returns the layers of the map in rendering order.
+func (m *Map) Layers() []Layer {
+	defer runtime.KeepAlive(m)
+	n := int(C.mapnik_map_layer_count(m.m))
+	layers := make([]Layer, n)
+	for i := range layers {
+		idx := C.int(i)
+		layers[i] = Layer{
+			Name:     C.GoString(C.mapnik_map_layer_name(m.m, idx)),
+			MinScale: float64(C.mapnik_map_layer_min_scale(m.m, idx)),
+			MaxScale: float64(C.mapnik_map_layer_max_scale(m.m, idx)),
+			Active:   C.mapnik_map_layer_is_active(m.m, idx) != 0,
+		}
+	}
+	return layers
+}
+
+// SetLayerActive enables or disables all layers with the given name.
+// It returns an error if the map has no such layer.
+func (m *Map) SetLayerActive(name string, active bool) error {
+	defer runtime.KeepAlive(m)
+	found := false
+	a := C.int(0)
+	if active {
+		a = 1
+	}
+	for i, l := range m.Layers() {
+		if l.Name == name {
+			C.mapnik_map_layer_set_active(m.m, C.int(i), a)
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("mapnik: no layer " + name)
+	}
+	return nil
+}
+
+// Feature is a feature found by QueryPoint.
+type Feature struct {
+	Layer      string                 `json:"layer"`
+	ID         int64                  `json:"id"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// QueryPoint returns the features at the pixel x, y of the current extent,
+// e.g. to identify what was clicked on a rendered image. If no layers are
+// given, all active layers are queried.
+func (m *Map) QueryPoint(x, y float64, layers ...string) ([]Feature, error) {
+	defer runtime.KeepAlive(m)
+	query := make(map[string]bool)
+	for _, l := range layers {
+		query[l] = true
+	}
+	var features []Feature
+	for i, l := range m.Layers() {
+		if len(layers) > 0 && !query[l.Name] || len(layers) == 0 && !l.Active {
+			continue
+		}
+		cs := C.mapnik_map_query_point(m.m, C.int(i), C.double(x), C.double(y))
+		if cs == nil {
+			return nil, m.lastError()
+		}
+		var found []Feature
+		err := json.Unmarshal([]byte(C.GoString(cs)), &found)
+		C.free(unsafe.Pointer(cs))
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range found {
+			f.Layer = l.Name
+			features = append(features, f)
+		}
+	}
+	return features, nil
+}
+
+// Clone returns a copy of the map. Layers and styles can be modified on
+// the copy without affecting the original, e.g. with SetDatasourceParams.
+// Copying is much faster than loading a large stylesheet again, so it is
+// also the cheap way to create more maps for concurrent rendering.
+// The copy must be freed separately.
+func (m *Map) Clone() *Map {
+	defer runtime.KeepAlive(m)
+	return newMap(C.mapnik_map_clone(m.m))
+}
+
+func (m *Map) layerIndex(name string) (int, error) {
+	for i, l := range m.Layers() {
+		if l.Name == name {
+			return i, nil
+		}
+	}
+	return 0, errors.New("mapnik: no layer " + name)
+}
+
+// DatasourceParams returns the datasource parameters of a layer, e.g.
+// type, table and dbname of a PostGIS layer.
+func (m *Map) DatasourceParams(layer string) (map[string]string, error) {
+	defer runtime.KeepAlive(m)
+	idx, err := m.layerIndex(layer)
+	if err != nil {
+		return nil, err
+	}
+	ci := C.int(idx)
+	n := int(C.mapnik_map_layer_datasource_param_count(m.m, ci))
+	params := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		key := C.mapnik_map_layer_datasource_param_key(m.m, ci, C.int(i))
+		if key == nil {
+			continue
+		}
+		v := C.mapnik_map_layer_datasource_param_value(m.m, ci, key)
+		if v == nil {
+			continue
+		}
+		params[C.GoString(key)] = C.GoString(v)
+		C.free(unsafe.Pointer(v))
+	}
+	return params, nil
+}
+
+// SetDatasourceParams replaces the datasource of a layer with one that
+// uses the given parameters instead of the current ones, e.g. to swap
+// the table of a PostGIS layer or add a SQL filter. Parameters that are
+// not given are kept.
+func (m *Map) SetDatasourceParams(layer string, params map[string]string) error {
+	defer runtime.KeepAlive(m)
+	idx, err := m.layerIndex(layer)
+	if err != nil {
+		return err
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	keys := make([]*C.char, 0, len(params))
+	values := make([]*C.char, 0, len(params))
+	for k, v := range params {
+		ck, cv := C.CString(k), C.CString(v)
+		defer C.free(unsafe.Pointer(ck))
+		defer C.free(unsafe.Pointer(cv))
+		keys = append(keys, ck)
+		values = append(values, cv)
+	}
+	if C.mapnik_map_layer_set_datasource_params(m.m, C.int(idx), &keys[0], &values[0], C.int(len(keys))) != 0 {
+		return m.lastError()
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+#include <mapnik/map.hpp>
+#if defined(HAVE_CAIRO)
+#include <mapnik/cairo/cairo_renderer.hpp>
+#include <mapnik/cairo/cairo_context.hpp>
+#include <cairo.h>
+#include <cairo-pdf.h>
+#include <cairo-svg.h>
+#endif
+#include <cstdlib>
+#include <cstring>
+#include <exception>
+#include <string>
+
+#include "mapnik_cairo.h"
+#include "mapnik_private.h"
+
+static void set_error(mapnik_map_t * m, std::string const& err) {
+    if (m->err) {
+        delete m->err;
+    }
+    m->err = new std::string(err);
+}
+
+#if defined(HAVE_CAIRO)
+static cairo_status_t write_to_string(void * closure, const unsigned char * data, unsigned int length) {
+    static_cast<std::string *>(closure)->append(reinterpret_cast<const char *>(data), length);
+    return CAIRO_STATUS_SUCCESS;
+}
+#endif
+
+int mapnik_map_render_to_cairo(mapnik_map_t * m, const char * format, double scale_factor, char ** data, size_t * len) {
+    return mapnik_map_render_to_cairo_page(m, format, scale_factor, 1, data, len);
+}
+
+int mapnik_map_render_to_cairo_page(mapnik_map_t * m, const char * format, double scale_factor, double page_scale, char ** data, size_t * len) {
+#if defined(HAVE_CAIRO)
+    mapnik::Map const& map = *m->m;
+    std::string out;
+    try {
+        double width = map.width() * page_scale;
+        double height = map.height() * page_scale;
+        cairo_surface_t * s;
+        if (strcmp(format, "svg") == 0) {
+            s = cairo_svg_surface_create_for_stream(write_to_string, &out, width, height);
+        } else if (strcmp(format, "pdf") == 0) {
+            s = cairo_pdf_surface_create_for_stream(write_to_string, &out, width, height);
+        } else {
+            set_error(m, std::string("unsupported cairo format ") + format);
+            return -1;
+        }
+        mapnik::cairo_surface_ptr surface(s, mapnik::cairo_surface_closer());
+        mapnik::cairo_ptr cairo = mapnik::create_context(surface);
+        cairo_scale(cairo.get(), page_scale, page_scale);
+        mapnik::cairo_renderer<mapnik::cairo_ptr> ren(map, cairo, scale_factor);
+        ren.apply();
+        cairo_surface_finish(s);
+        if (cairo_surface_status(s) != CAIRO_STATUS_SUCCESS) {
+            set_error(m, cairo_status_to_string(cairo_surface_status(s)));
+            return -1;
+        }
+    } catch (std::exception const& ex) {
+        set_error(m, ex.what());
+        return -1;
+    }
+    *len = out.size();
+    *data = static_cast<char *>(malloc(out.size()));
+    memcpy(*data, out.data(), out.size());
+    return 0;
+#else
+    (void)format;
+    (void)scale_factor;
+    (void)page_scale;
+    (void)data;
+    (void)len;
+    set_error(m, "mapnik was built without cairo support");
+    return -1;
+#endif
+}
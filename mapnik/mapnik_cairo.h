@@ -0,0 +1,29 @@
+#ifndef MAPNIK_CAIRO_H
+#define MAPNIK_CAIRO_H
+
+#include <stddef.h>
+
+#include "mapnik_c_api.h"
+
+#ifdef __cplusplus
+extern "C"
+{
+#endif
+
+// Vector output with the cairo renderer, not part of mapnik-c-api.
+// format is "svg" or "pdf". On success data is set to a buffer that must
+// be freed with free. Returns -1 and sets the map error on failure, also
+// if mapnik was built without cairo.
+MAPNIKCAPICALL int mapnik_map_render_to_cairo(mapnik_map_t * m, const char * format, double scale_factor, char ** data, size_t * len);
+
+// mapnik_map_render_to_cairo_page is mapnik_map_render_to_cairo with the
+// page scaled by page_scale, e.g. 72/300 for a map sized in pixels at
+// 300 dpi on a page measured in points. Rasters keep the resolution of
+// the map.
+MAPNIKCAPICALL int mapnik_map_render_to_cairo_page(mapnik_map_t * m, const char * format, double scale_factor, double page_scale, char ** data, size_t * len);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif // MAPNIK_CAIRO_H
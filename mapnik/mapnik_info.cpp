@@ -0,0 +1,26 @@
+#include <mapnik/datasource_cache.hpp>
+#include <mapnik/font_engine_freetype.hpp>
+#include <cstring>
+#include <string>
+#include <vector>
+
+#include "mapnik_info.h"
+
+static char * join_lines(std::vector<std::string> const& names) {
+    std::string s;
+    for (size_t i = 0; i < names.size(); i++) {
+        if (i > 0) {
+            s += "\n";
+        }
+        s += names[i];
+    }
+    return strdup(s.c_str());
+}
+
+char * mapnik_input_plugin_names() {
+    return join_lines(mapnik::datasource_cache::instance().plugin_names());
+}
+
+char * mapnik_font_face_names() {
+    return join_lines(mapnik::freetype_engine::face_names());
+}
@@ -0,0 +1,20 @@
+#ifndef MAPNIK_INFO_H
+#define MAPNIK_INFO_H
+
+#include "mapnik_c_api.h"
+
+#ifdef __cplusplus
+extern "C"
+{
+#endif
+
+// Newline separated lists, not part of mapnik-c-api.
+// The returned strings must be freed by the caller.
+MAPNIKCAPICALL char * mapnik_input_plugin_names();
+MAPNIKCAPICALL char * mapnik_font_face_names();
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif // MAPNIK_INFO_H
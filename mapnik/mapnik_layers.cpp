@@ -0,0 +1,312 @@
+#include <mapnik/map.hpp>
+#include <mapnik/layer.hpp>
+#include <mapnik/datasource.hpp>
+#include <mapnik/datasource_cache.hpp>
+#include <mapnik/params.hpp>
+#include <mapnik/agg_renderer.hpp>
+#include <mapnik/attribute.hpp>
+#include <mapnik/image.hpp>
+#include <mapnik/image_util.hpp>
+#include <mapnik/image_compositing.hpp>
+#include <mapnik/request.hpp>
+#include <mapnik/unicode.hpp>
+#include <mapnik/value.hpp>
+#include <mapnik/load_map.hpp>
+#include <mapnik/feature.hpp>
+#include <mapnik/featureset.hpp>
+#include <cstdio>
+#include <sstream>
+#include <tuple>
+#include <cstdlib>
+#include <cstring>
+#include <iterator>
+#include <string>
+
+#include "mapnik_layers.h"
+#include "mapnik_private.h"
+
+static mapnik::layer * get_layer(mapnik_map_t * m, int idx) {
+    if (!m || idx < 0 || static_cast<size_t>(idx) >= m->m->layer_count()) {
+        return NULL;
+    }
+    return &m->m->layers()[idx];
+}
+
+int mapnik_map_layer_count(mapnik_map_t * m) {
+    return m ? static_cast<int>(m->m->layer_count()) : 0;
+}
+
+const char * mapnik_map_layer_name(mapnik_map_t * m, int idx) {
+    mapnik::layer * l = get_layer(m, idx);
+    return l ? l->name().c_str() : NULL;
+}
+
+double mapnik_map_layer_min_scale(mapnik_map_t * m, int idx) {
+    mapnik::layer * l = get_layer(m, idx);
+    return l ? l->minimum_scale_denominator() : 0;
+}
+
+double mapnik_map_layer_max_scale(mapnik_map_t * m, int idx) {
+    mapnik::layer * l = get_layer(m, idx);
+    return l ? l->maximum_scale_denominator() : 0;
+}
+
+int mapnik_map_layer_is_active(mapnik_map_t * m, int idx) {
+    mapnik::layer * l = get_layer(m, idx);
+    return l && l->active();
+}
+
+void mapnik_map_layer_set_active(mapnik_map_t * m, int idx, int active) {
+    mapnik::layer * l = get_layer(m, idx);
+    if (l) {
+        l->set_active(active != 0);
+    }
+}
+
+static void set_error(mapnik_map_t * m, std::string const& err) {
+    if (m->err) {
+        delete m->err;
+    }
+    m->err = new std::string(err);
+}
+
+mapnik_map_t * mapnik_map_clone(mapnik_map_t * m) {
+    mapnik_map_t * c = new mapnik_map_t;
+    c->m = new mapnik::Map(*m->m);
+    c->err = NULL;
+    return c;
+}
+
+int mapnik_map_load_string_base(mapnik_map_t * m, const char * s, const char * base_path) {
+    try {
+        mapnik::load_map_string(*m->m, s, false, base_path);
+    } catch (std::exception const& ex) {
+        set_error(m, ex.what());
+        return -1;
+    }
+    return 0;
+}
+
+static mapnik::parameters const* get_params(mapnik_map_t * m, int idx) {
+    mapnik::layer * l = get_layer(m, idx);
+    if (!l || !l->datasource()) {
+        return NULL;
+    }
+    return &l->datasource()->params();
+}
+
+int mapnik_map_layer_datasource_param_count(mapnik_map_t * m, int idx) {
+    mapnik::parameters const* p = get_params(m, idx);
+    return p ? static_cast<int>(p->size()) : 0;
+}
+
+const char * mapnik_map_layer_datasource_param_key(mapnik_map_t * m, int idx, int param) {
+    mapnik::parameters const* p = get_params(m, idx);
+    if (!p || param < 0 || static_cast<size_t>(param) >= p->size()) {
+        return NULL;
+    }
+    mapnik::parameters::const_iterator it = p->begin();
+    std::advance(it, param);
+    return it->first.c_str();
+}
+
+// The returned string must be freed by the caller.
+char * mapnik_map_layer_datasource_param_value(mapnik_map_t * m, int idx, const char * key) {
+    mapnik::parameters const* p = get_params(m, idx);
+    if (!p) {
+        return NULL;
+    }
+    boost::optional<std::string> v = p->get<std::string>(key);
+    return v ? strdup(v->c_str()) : NULL;
+}
+
+int mapnik_map_layer_set_datasource_params(mapnik_map_t * m, int idx, const char ** keys, const char ** values, int n) {
+    mapnik::layer * l = get_layer(m, idx);
+    if (!l || !l->datasource()) {
+        set_error(m, "layer has no datasource");
+        return -1;
+    }
+    try {
+        mapnik::parameters p = l->datasource()->params();
+        for (int i = 0; i < n; i++) {
+            p[keys[i]] = std::string(values[i]);
+        }
+        l->set_datasource(mapnik::datasource_cache::instance().create(p));
+    } catch (std::exception const& ex) {
+        set_error(m, ex.what());
+        return -1;
+    }
+    return 0;
+}
+
+// to_value converts numbers to numeric values, so that they compare equal
+// to numeric feature attributes in filters.
+static mapnik::value to_value(const char * s) {
+    char * end;
+    long long i = strtoll(s, &end, 10);
+    if (*s && !*end) {
+        return mapnik::value(static_cast<mapnik::value_integer>(i));
+    }
+    double d = strtod(s, &end);
+    if (*s && !*end) {
+        return mapnik::value(d);
+    }
+    return mapnik::value(mapnik::value_unicode_string::fromUTF8(s));
+}
+
+static mapnik_image_t * render_to_image(mapnik_map_t * m, const char ** keys, const char ** values, int n, double scale_factor) {
+    mapnik::Map const& map = *m->m;
+    mapnik::image_rgba8 * im = NULL;
+    try {
+        im = new mapnik::image_rgba8(map.width(), map.height());
+        mapnik::attributes vars;
+        for (int i = 0; i < n; i++) {
+            vars[keys[i]] = to_value(values[i]);
+        }
+        mapnik::request req(map.width(), map.height(), map.get_current_extent());
+        req.set_buffer_size(map.buffer_size());
+        mapnik::agg_renderer<mapnik::image_rgba8> ren(map, req, vars, *im, scale_factor);
+        ren.apply();
+    } catch (std::exception const& ex) {
+        delete im;
+        set_error(m, ex.what());
+        return NULL;
+    }
+    mapnik_image_t * i = new mapnik_image_t;
+    i->i = im;
+    return i;
+}
+
+mapnik_image_t * mapnik_map_render_to_image_vars(mapnik_map_t * m, const char ** keys, const char ** values, int n) {
+    return render_to_image(m, keys, values, n, 1.0);
+}
+
+mapnik_image_t * mapnik_map_render_to_image_scaled(mapnik_map_t * m, double scale_factor) {
+    return render_to_image(m, NULL, NULL, 0, scale_factor);
+}
+
+int mapnik_image_painted(mapnik_image_t * i) {
+    return i && i->i->painted();
+}
+
+int mapnik_image_to_blob(mapnik_image_t * i, const char * format, char ** data, size_t * len, char ** err) {
+    std::string s;
+    try {
+        s = mapnik::save_to_string(*i->i, format);
+    } catch (std::exception const& ex) {
+        *err = strdup(ex.what());
+        return -1;
+    }
+    *len = s.size();
+    *data = static_cast<char *>(malloc(s.size()));
+    memcpy(*data, s.data(), s.size());
+    return 0;
+}
+
+static void write_json_string(std::ostringstream & out, std::string const& s) {
+    out << '"';
+    for (size_t i = 0; i < s.size(); i++) {
+        unsigned char c = s[i];
+        switch (c) {
+        case '"': out << "\\\""; break;
+        case '\\': out << "\\\\"; break;
+        case '\n': out << "\\n"; break;
+        case '\r': out << "\\r"; break;
+        case '\t': out << "\\t"; break;
+        default:
+            if (c < 0x20) {
+                char buf[8];
+                snprintf(buf, sizeof(buf), "\\u%04x", c);
+                out << buf;
+            } else {
+                out << c;
+            }
+        }
+    }
+    out << '"';
+}
+
+static void write_json_value(std::ostringstream & out, mapnik::value const& v) {
+    if (v.is_null()) {
+        out << "null";
+    } else if (v.is<mapnik::value_bool>()) {
+        out << (v.to_bool() ? "true" : "false");
+    } else if (v.is<mapnik::value_integer>() || v.is<mapnik::value_double>()) {
+        out << v.to_string();
+    } else {
+        write_json_string(out, v.to_string());
+    }
+}
+
+char * mapnik_map_query_point(mapnik_map_t * m, int idx, double x, double y) {
+    if (!get_layer(m, idx)) {
+        set_error(m, "invalid layer index");
+        return NULL;
+    }
+    try {
+        mapnik::featureset_ptr fs = m->m->query_map_point(static_cast<unsigned>(idx), x, y);
+        std::ostringstream out;
+        out << '[';
+        bool first = true;
+        mapnik::feature_ptr f;
+        while (fs && (f = fs->next())) {
+            if (!first) {
+                out << ',';
+            }
+            first = false;
+            out << "{\"id\":" << f->id() << ",\"properties\":{";
+            bool firstProp = true;
+            for (auto const& kv : *f) {
+                if (!firstProp) {
+                    out << ',';
+                }
+                firstProp = false;
+                write_json_string(out, std::get<0>(kv));
+                out << ':';
+                write_json_value(out, std::get<1>(kv));
+            }
+            out << "}}";
+        }
+        out << ']';
+        return strdup(out.str().c_str());
+    } catch (std::exception const& ex) {
+        set_error(m, ex.what());
+        return NULL;
+    }
+}
+
+int mapnik_comp_op_valid(const char * op) {
+    return mapnik::comp_op_from_string(op) ? 1 : 0;
+}
+
+// mapnik composites premultiplied images, rendered images are not.
+int mapnik_image_composite(mapnik_image_t * dst, mapnik_image_t * src, const char * op, float opacity) {
+    boost::optional<mapnik::composite_mode_e> mode = mapnik::comp_op_from_string(op);
+    if (!mode) {
+        return -1;
+    }
+    mapnik::premultiply_alpha(*dst->i);
+    mapnik::premultiply_alpha(*src->i);
+    mapnik::composite(*dst->i, *src->i, *mode, opacity, 0, 0);
+    mapnik::demultiply_alpha(*dst->i);
+    mapnik::demultiply_alpha(*src->i);
+    if (src->i->painted()) {
+        dst->i->painted(true);
+    }
+    return 0;
+}
+
+mapnik_image_t * mapnik_image_from_rgba(const unsigned char * data, unsigned width, unsigned height) {
+    mapnik::image_rgba8 * im = new mapnik::image_rgba8(width, height);
+    memcpy(im->bytes(), data, static_cast<size_t>(width) * height * 4);
+    im->painted(true);
+    mapnik_image_t * i = new mapnik_image_t;
+    i->i = im;
+    return i;
+}
+
+const unsigned char * mapnik_image_rgba(mapnik_image_t * i, unsigned * width, unsigned * height) {
+    *width = i->i->width();
+    *height = i->i->height();
+    return i->i->bytes();
+}
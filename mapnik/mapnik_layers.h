@@ -0,0 +1,67 @@
+#ifndef MAPNIK_LAYERS_H
+#define MAPNIK_LAYERS_H
+
+#include <stddef.h>
+
+#include "mapnik_c_api.h"
+
+#ifdef __cplusplus
+extern "C"
+{
+#endif
+
+// Layer access, not part of mapnik-c-api.
+MAPNIKCAPICALL int mapnik_map_layer_count(mapnik_map_t * m);
+MAPNIKCAPICALL const char * mapnik_map_layer_name(mapnik_map_t * m, int idx);
+MAPNIKCAPICALL double mapnik_map_layer_min_scale(mapnik_map_t * m, int idx);
+MAPNIKCAPICALL double mapnik_map_layer_max_scale(mapnik_map_t * m, int idx);
+MAPNIKCAPICALL int mapnik_map_layer_is_active(mapnik_map_t * m, int idx);
+MAPNIKCAPICALL void mapnik_map_layer_set_active(mapnik_map_t * m, int idx, int active);
+
+// Map copies and datasource parameters, not part of mapnik-c-api.
+MAPNIKCAPICALL mapnik_map_t * mapnik_map_clone(mapnik_map_t * m);
+MAPNIKCAPICALL int mapnik_map_layer_datasource_param_count(mapnik_map_t * m, int idx);
+MAPNIKCAPICALL const char * mapnik_map_layer_datasource_param_key(mapnik_map_t * m, int idx, int param);
+MAPNIKCAPICALL char * mapnik_map_layer_datasource_param_value(mapnik_map_t * m, int idx, const char * key);
+MAPNIKCAPICALL int mapnik_map_layer_set_datasource_params(mapnik_map_t * m, int idx, const char ** keys, const char ** values, int n);
+
+// Loading stylesheets from memory with relative file paths resolved
+// against base_path, not part of mapnik-c-api.
+MAPNIKCAPICALL int mapnik_map_load_string_base(mapnik_map_t * m, const char * s, const char * base_path);
+
+// Feature queries, not part of mapnik-c-api. Returns a JSON array of
+// {"id": ..., "properties": {...}} objects, which must be freed, or NULL
+// on error.
+MAPNIKCAPICALL char * mapnik_map_query_point(mapnik_map_t * m, int idx, double x, double y);
+
+// Rendering with @variables, not part of mapnik-c-api.
+MAPNIKCAPICALL mapnik_image_t * mapnik_map_render_to_image_vars(mapnik_map_t * m, const char ** keys, const char ** values, int n);
+MAPNIKCAPICALL int mapnik_image_painted(mapnik_image_t * i);
+
+// Rendering with a scale factor for symbols, line widths and labels, and
+// encoding in any format supported by mapnik, e.g. "tiff" or
+// "png8:z=9", not part of mapnik-c-api. mapnik_image_to_blob sets data,
+// which must be freed, or err, which must be freed, on failure.
+MAPNIKCAPICALL mapnik_image_t * mapnik_map_render_to_image_scaled(mapnik_map_t * m, double scale_factor);
+MAPNIKCAPICALL int mapnik_image_to_blob(mapnik_image_t * i, const char * format, char ** data, size_t * len, char ** err);
+
+// Compositing, not part of mapnik-c-api. op is the name of a mapnik
+// comp-op, e.g. "multiply". mapnik_image_composite returns -1 if op is
+// unknown.
+MAPNIKCAPICALL int mapnik_comp_op_valid(const char * op);
+MAPNIKCAPICALL int mapnik_image_composite(mapnik_image_t * dst, mapnik_image_t * src, const char * op, float opacity);
+
+// Images from pixels, not part of mapnik-c-api. data holds width*height
+// RGBA pixels that are not premultiplied.
+MAPNIKCAPICALL mapnik_image_t * mapnik_image_from_rgba(const unsigned char * data, unsigned width, unsigned height);
+
+// Pixels of an image, not part of mapnik-c-api. mapnik_image_rgba sets
+// width and height and returns the width*height RGBA pixels, which are
+// not premultiplied. They are owned by the image.
+MAPNIKCAPICALL const unsigned char * mapnik_image_rgba(mapnik_image_t * i, unsigned * width, unsigned * height);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif // MAPNIK_LAYERS_H
@@ -0,0 +1,89 @@
+#include <mapnik/map.hpp>
+#include <mapnik/color.hpp>
+#include <mapnik/projection.hpp>
+#include <mapnik/scale_denominator.hpp>
+#include <string>
+
+#include "mapnik_map_ext.h"
+#include "mapnik_private.h"
+
+int mapnik_map_background(mapnik_map_t * m, unsigned char * r, unsigned char * g, unsigned char * b, unsigned char * a) {
+    boost::optional<mapnik::color> const& bg = m->m->background();
+    if (!bg) {
+        return 0;
+    }
+    *r = bg->red();
+    *g = bg->green();
+    *b = bg->blue();
+    *a = bg->alpha();
+    return 1;
+}
+
+void mapnik_map_set_background(mapnik_map_t * m, unsigned char r, unsigned char g, unsigned char b, unsigned char a) {
+    m->m->set_background(mapnik::color(r, g, b, a));
+}
+
+static void copy_box(mapnik::box2d<double> const& box, double * extent) {
+    extent[0] = box.minx();
+    extent[1] = box.miny();
+    extent[2] = box.maxx();
+    extent[3] = box.maxy();
+}
+
+int mapnik_map_maximum_extent(mapnik_map_t * m, double * extent) {
+    boost::optional<mapnik::box2d<double> > const& e = m->m->maximum_extent();
+    if (!e) {
+        return 0;
+    }
+    copy_box(*e, extent);
+    return 1;
+}
+
+void mapnik_map_set_maximum_extent(mapnik_map_t * m, double minx, double miny, double maxx, double maxy) {
+    m->m->set_maximum_extent(mapnik::box2d<double>(minx, miny, maxx, maxy));
+}
+
+void mapnik_map_reset_maximum_extent(mapnik_map_t * m) {
+    m->m->reset_maximum_extent();
+}
+
+void mapnik_map_current_extent(mapnik_map_t * m, double * extent) {
+    copy_box(m->m->get_current_extent(), extent);
+}
+
+int mapnik_map_aspect_fix_mode(mapnik_map_t * m) {
+    return static_cast<int>(m->m->get_aspect_fix_mode());
+}
+
+void mapnik_map_set_aspect_fix_mode(mapnik_map_t * m, int mode) {
+    m->m->set_aspect_fix_mode(static_cast<mapnik::Map::aspect_fix_mode>(mode));
+}
+
+double mapnik_map_scale(mapnik_map_t * m) {
+    return m->m->scale();
+}
+
+double mapnik_map_scale_denominator(mapnik_map_t * m) {
+    return m->m->scale_denominator();
+}
+
+void mapnik_map_zoom_to_scale_denominator(mapnik_map_t * m, double x, double y, double denominator) {
+    bool geographic = mapnik::projection(m->m->srs(), true).is_geographic();
+    // units per pixel at the requested scale denominator
+    double scale = denominator / mapnik::scale_denominator(1.0, geographic);
+    double dx = scale * m->m->width() / 2;
+    double dy = scale * m->m->height() / 2;
+    m->m->zoom_to_box(mapnik::box2d<double>(x - dx, y - dy, x + dx, y + dy));
+}
+
+unsigned mapnik_map_width(mapnik_map_t * m) {
+    return m->m->width();
+}
+
+unsigned mapnik_map_height(mapnik_map_t * m) {
+    return m->m->height();
+}
+
+int mapnik_map_buffer_size(mapnik_map_t * m) {
+    return m->m->buffer_size();
+}
@@ -0,0 +1,31 @@
+#ifndef MAPNIK_MAP_EXT_H
+#define MAPNIK_MAP_EXT_H
+
+#include "mapnik_c_api.h"
+
+#ifdef __cplusplus
+extern "C"
+{
+#endif
+
+// Map properties, not part of mapnik-c-api.
+MAPNIKCAPICALL int mapnik_map_background(mapnik_map_t * m, unsigned char * r, unsigned char * g, unsigned char * b, unsigned char * a);
+MAPNIKCAPICALL void mapnik_map_set_background(mapnik_map_t * m, unsigned char r, unsigned char g, unsigned char b, unsigned char a);
+MAPNIKCAPICALL int mapnik_map_maximum_extent(mapnik_map_t * m, double * extent);
+MAPNIKCAPICALL void mapnik_map_set_maximum_extent(mapnik_map_t * m, double minx, double miny, double maxx, double maxy);
+MAPNIKCAPICALL void mapnik_map_reset_maximum_extent(mapnik_map_t * m);
+MAPNIKCAPICALL void mapnik_map_current_extent(mapnik_map_t * m, double * extent);
+MAPNIKCAPICALL int mapnik_map_aspect_fix_mode(mapnik_map_t * m);
+MAPNIKCAPICALL void mapnik_map_set_aspect_fix_mode(mapnik_map_t * m, int mode);
+MAPNIKCAPICALL double mapnik_map_scale(mapnik_map_t * m);
+MAPNIKCAPICALL double mapnik_map_scale_denominator(mapnik_map_t * m);
+MAPNIKCAPICALL void mapnik_map_zoom_to_scale_denominator(mapnik_map_t * m, double x, double y, double denominator);
+MAPNIKCAPICALL unsigned mapnik_map_width(mapnik_map_t * m);
+MAPNIKCAPICALL unsigned mapnik_map_height(mapnik_map_t * m);
+MAPNIKCAPICALL int mapnik_map_buffer_size(mapnik_map_t * m);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif // MAPNIK_MAP_EXT_H
@@ -0,0 +1,29 @@
+#ifndef MAPNIK_PRIVATE_H
+#define MAPNIK_PRIVATE_H
+
+#include <mapnik/map.hpp>
+#include <mapnik/image.hpp>
+#include <mapnik/proj_transform.hpp>
+#include <string>
+
+#include "mapnik_c_api.h"
+
+// The layouts of the types mapnik_c_api.cpp keeps opaque, for the
+// extensions that are not part of mapnik-c-api. mapnik-c-api has no
+// accessors for them, so they are copied here, once, and checked
+// against the linked mapnik-c-api by checkLayout in layout.go when the
+// package is initialized.
+struct _mapnik_map_t {
+    mapnik::Map * m;
+    std::string * err;
+};
+
+struct _mapnik_image_t {
+    mapnik::image_rgba8 * i;
+};
+
+struct _mapnik_projection_t {
+    mapnik::proj_transform * p;
+};
+
+#endif // MAPNIK_PRIVATE_H
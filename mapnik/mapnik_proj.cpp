@@ -0,0 +1,58 @@
+#include <mapnik/projection.hpp>
+#include <mapnik/proj_transform.hpp>
+#include <cstring>
+#include <exception>
+
+#include "mapnik_proj.h"
+#include "mapnik_private.h"
+
+mapnik_coord_t mapnik_projection_inverse(mapnik_projection_t * p, mapnik_coord_t c) {
+    if (p) {
+        double z = 0.0;
+        p->p->backward(c.x, c.y, z);
+    }
+    return c;
+}
+
+// proj_transform keeps references to the projections, so they are owned here.
+struct _mapnik_proj_transform_t {
+    mapnik::projection * src;
+    mapnik::projection * dst;
+    mapnik::proj_transform * t;
+};
+
+mapnik_proj_transform_t * mapnik_proj_transform(const char * src, const char * dst, char ** err) {
+    mapnik_proj_transform_t * t = new mapnik_proj_transform_t;
+    t->src = NULL;
+    t->dst = NULL;
+    t->t = NULL;
+    try {
+        t->src = new mapnik::projection(src);
+        t->dst = new mapnik::projection(dst);
+        t->t = new mapnik::proj_transform(*t->src, *t->dst);
+    } catch (std::exception const& ex) {
+        *err = strdup(ex.what());
+        mapnik_proj_transform_free(t);
+        return NULL;
+    }
+    return t;
+}
+
+void mapnik_proj_transform_free(mapnik_proj_transform_t * t) {
+    if (t) {
+        delete t->t;
+        delete t->dst;
+        delete t->src;
+        delete t;
+    }
+}
+
+int mapnik_proj_transform_forward(mapnik_proj_transform_t * t, double * x, double * y) {
+    double z = 0.0;
+    return t->t->forward(*x, *y, z) ? 0 : -1;
+}
+
+int mapnik_proj_transform_backward(mapnik_proj_transform_t * t, double * x, double * y) {
+    double z = 0.0;
+    return t->t->backward(*x, *y, z) ? 0 : -1;
+}
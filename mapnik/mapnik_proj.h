@@ -0,0 +1,27 @@
+#ifndef MAPNIK_PROJ_H
+#define MAPNIK_PROJ_H
+
+#include "mapnik_c_api.h"
+
+#ifdef __cplusplus
+extern "C"
+{
+#endif
+
+// Projections, not part of mapnik-c-api.
+MAPNIKCAPICALL mapnik_coord_t mapnik_projection_inverse(mapnik_projection_t * p, mapnik_coord_t c);
+
+typedef struct _mapnik_proj_transform_t mapnik_proj_transform_t;
+
+// mapnik_proj_transform returns NULL and sets err, which must be freed,
+// if src or dst are invalid.
+MAPNIKCAPICALL mapnik_proj_transform_t * mapnik_proj_transform(const char * src, const char * dst, char ** err);
+MAPNIKCAPICALL void mapnik_proj_transform_free(mapnik_proj_transform_t * t);
+MAPNIKCAPICALL int mapnik_proj_transform_forward(mapnik_proj_transform_t * t, double * x, double * y);
+MAPNIKCAPICALL int mapnik_proj_transform_backward(mapnik_proj_transform_t * t, double * x, double * y);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif // MAPNIK_PROJ_H
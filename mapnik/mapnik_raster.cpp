@@ -0,0 +1,72 @@
+#include <mapnik/datasource.hpp>
+#include <mapnik/datasource_cache.hpp>
+#include <mapnik/feature.hpp>
+#include <mapnik/featureset.hpp>
+#include <mapnik/image_util.hpp>
+#include <mapnik/params.hpp>
+#include <mapnik/query.hpp>
+#include <mapnik/raster.hpp>
+#include <cmath>
+#include <cstring>
+#include <exception>
+#include <limits>
+#include <string>
+
+#include "mapnik_raster.h"
+
+int mapnik_raster_read(const char * file, int band, double minx, double miny, double maxx, double maxy, unsigned width, unsigned height, float * out, char ** err) {
+    for (unsigned i = 0; i < width * height; i++) {
+        out[i] = std::numeric_limits<float>::quiet_NaN();
+    }
+    double rx = (maxx - minx) / width;
+    double ry = (maxy - miny) / height;
+    try {
+        mapnik::parameters p;
+        p["type"] = std::string("gdal");
+        p["file"] = std::string(file);
+        p["band"] = mapnik::value_integer(band);
+        mapnik::datasource_ptr ds = mapnik::datasource_cache::instance().create(p);
+        mapnik::box2d<double> box(minx, miny, maxx, maxy);
+        mapnik::query q(box, mapnik::query::resolution_type(1.0 / rx, 1.0 / ry), 1.0);
+        mapnik::featureset_ptr fs = ds->features(q);
+        if (!fs) {
+            return 0;
+        }
+        while (mapnik::feature_ptr f = fs->next()) {
+            mapnik::raster_ptr const& r = f->get_raster();
+            if (!r) {
+                continue;
+            }
+            mapnik::box2d<double> const& ext = r->ext_;
+            unsigned iw = r->data_.width();
+            unsigned ih = r->data_.height();
+            if (iw == 0 || ih == 0) {
+                continue;
+            }
+            boost::optional<double> nodata = r->nodata();
+            for (unsigned j = 0; j < height; j++) {
+                double y = maxy - (j + 0.5) * ry;
+                double py = (ext.maxy() - y) / ext.height() * ih;
+                if (py < 0 || py >= ih) {
+                    continue;
+                }
+                for (unsigned i = 0; i < width; i++) {
+                    double x = minx + (i + 0.5) * rx;
+                    double px = (x - ext.minx()) / ext.width() * iw;
+                    if (px < 0 || px >= iw) {
+                        continue;
+                    }
+                    double v = mapnik::get_pixel<double>(r->data_, static_cast<std::size_t>(px), static_cast<std::size_t>(py));
+                    if (nodata && v == *nodata) {
+                        continue;
+                    }
+                    out[j * width + i] = static_cast<float>(v);
+                }
+            }
+        }
+    } catch (std::exception const& ex) {
+        *err = strdup(ex.what());
+        return -1;
+    }
+    return 0;
+}
@@ -0,0 +1,22 @@
+#ifndef MAPNIK_RASTER_H
+#define MAPNIK_RASTER_H
+
+#include "mapnik_c_api.h"
+
+#ifdef __cplusplus
+extern "C"
+{
+#endif
+
+// Raster access through the gdal input plugin, not part of mapnik-c-api.
+// mapnik_raster_read samples band of file at the centers of a width x
+// height grid covering minx, miny, maxx, maxy into out, which must hold
+// width * height values. Cells without data are set to NaN. Returns -1
+// and sets err, which must be freed, on failure.
+MAPNIKCAPICALL int mapnik_raster_read(const char * file, int band, double minx, double miny, double maxx, double maxy, unsigned width, unsigned height, float * out, char ** err);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif // MAPNIK_RASTER_H
@@ -0,0 +1,336 @@
+package maptiles
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nkovacs/go-mapnik/mapnik"
+)
+
+// AdminHandler serves an HTTP API to add and remove layers at runtime:
+//
+//	POST /admin/layers          add a mapnik layer, the body is a JSON
+//	                            object with name, stylesheet and params;
+//	                            the stylesheet must be in StyleDir
+//	DELETE /admin/layers/{name} remove a layer
+//	DELETE /admin/layers/{name}/tiles
+//	                            delete the cached tiles of a layer, see
+//	                            TileServer.PurgeLayer; ?vacuum=1 also
+//	                            shrinks a TileDb file
+//	PUT /admin/layers/{name}/style
+//	                            replace the stylesheet of a mapnik layer,
+//	                            the body is the mapnik XML
+//	GET /admin/info             mapnik version, input plugins, fonts
+//	                            and layers as JSON
+//	GET /admin/layers/{name}/tiles/slowest
+//	GET /admin/layers/{name}/tiles/biggest
+//	                            cached tiles with the longest render time
+//	                            or largest size as JSON, see SlowestTiles
+//	POST /admin/layers/{name}/warm
+//	                            render the most requested tiles in the
+//	                            background, see WarmLayer
+//	GET /admin/heatmap.csv
+//	GET /admin/heatmap.geojson  requested tiles per heatmap cell, see
+//	                            TileServer.Heatmap
+//	GET /admin/usage?day=2006-01-02
+//	                            tiles served per API key on the day, by
+//	                            default today (UTC), as JSON, see
+//	                            TileServer.Usage
+//
+// Requests must carry the token in an "Authorization: Bearer" header.
+type AdminHandler struct {
+	t     *TileServer
+	token string
+
+	// StyleDir is the directory the stylesheets of added layers must be
+	// in. Relative paths are resolved against it. If empty, layers cannot
+	// be added.
+	StyleDir string
+}
+
+// NewAdminHandler creates an AdminHandler for the tile server.
+// An empty token rejects all requests.
+func NewAdminHandler(t *TileServer, token string) *AdminHandler {
+	return &AdminHandler{t: t, token: token}
+}
+
+type adminLayerRequest struct {
+	Name       string            `json:"name"`
+	Stylesheet string            `json:"stylesheet"`
+	Params     map[string]string `json:"params"`
+}
+
+func (h *AdminHandler) authorized(r *http.Request) bool {
+	if h.token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(h.token)) == 1
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ew := newEncodingWriter(w, r)
+	defer ew.Close()
+	w = ew
+	if !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/admin/info":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		h.info(w)
+	case r.URL.Path == "/admin/heatmap.csv" || r.URL.Path == "/admin/heatmap.geojson":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		h.heatmap(w, r, strings.TrimPrefix(r.URL.Path, "/admin/heatmap."))
+	case r.URL.Path == "/admin/usage":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		h.usage(w, r)
+	case r.URL.Path == "/admin/layers":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		h.addLayer(w, r)
+	case strings.HasPrefix(r.URL.Path, "/admin/layers/") && strings.HasSuffix(r.URL.Path, "/tiles"):
+		if r.Method != http.MethodDelete {
+			w.Header().Set("Allow", http.MethodDelete)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		h.purge(w, r, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/admin/layers/"), "/tiles"))
+	case strings.HasPrefix(r.URL.Path, "/admin/layers/") && strings.Contains(r.URL.Path, "/tiles/"):
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/admin/layers/"), "/tiles/", 2)
+		h.tileStats(w, r, parts[0], parts[1])
+	case strings.HasPrefix(r.URL.Path, "/admin/layers/") && strings.HasSuffix(r.URL.Path, "/warm"):
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		h.warm(w, r, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/admin/layers/"), "/warm"))
+	case strings.HasPrefix(r.URL.Path, "/admin/layers/") && strings.HasSuffix(r.URL.Path, "/style"):
+		if r.Method != http.MethodPut {
+			w.Header().Set("Allow", http.MethodPut)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		h.updateStyle(w, r, strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/admin/layers/"), "/style"))
+	case strings.HasPrefix(r.URL.Path, "/admin/layers/"):
+		if r.Method != http.MethodDelete {
+			w.Header().Set("Allow", http.MethodDelete)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		h.removeLayer(w, r, strings.TrimPrefix(r.URL.Path, "/admin/layers/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *AdminHandler) addLayer(w http.ResponseWriter, r *http.Request) {
+	var req adminLayerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !layerNameRegex.MatchString(req.Name) {
+		http.Error(w, "invalid layer name", http.StatusBadRequest)
+		return
+	}
+	if req.Stylesheet == "" {
+		http.Error(w, "missing stylesheet", http.StatusBadRequest)
+		return
+	}
+	stylesheet, err := h.stylePath(req.Stylesheet)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if h.t.lmp.hasSource(req.Name) {
+		http.Error(w, ErrLayerExists.Error(), http.StatusConflict)
+		return
+	}
+
+	err = h.t.AddNewLayer(LayerConfig{
+		Name: req.Name,
+		RendererConfig: RendererConfig{
+			Stylesheet: stylesheet,
+			Params:     req.Params,
+		},
+	})
+	if err == ErrLayerExists {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	log.Println("added layer", req.Name)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// stylePath returns the path of a stylesheet in StyleDir, following
+// symbolic links. It returns an error for stylesheets outside of it.
+func (h *AdminHandler) stylePath(stylesheet string) (string, error) {
+	if h.StyleDir == "" {
+		return "", errors.New("no style directory is configured")
+	}
+	dir, err := filepath.EvalSymlinks(h.StyleDir)
+	if err != nil {
+		return "", err
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	path := stylesheet
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	path, err = filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("stylesheet %v not found", stylesheet)
+	}
+	if rel, err := filepath.Rel(dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("stylesheet %v is outside of the style directory", stylesheet)
+	}
+	return path, nil
+}
+
+type adminInfo struct {
+	Version      string   `json:"version"`
+	InputPlugins []string `json:"input_plugins"`
+	Fonts        []string `json:"fonts"`
+	Layers       []string `json:"layers"`
+}
+
+func (h *AdminHandler) info(w http.ResponseWriter) {
+	info := adminInfo{
+		Version:      mapnik.Version(),
+		InputPlugins: mapnik.InputPlugins(),
+		Fonts:        mapnik.Fonts(),
+		Layers:       h.t.lmp.Layers(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(info); err != nil {
+		log.Println(err)
+	}
+}
+
+type adminUsage struct {
+	Day   string           `json:"day"`
+	Usage map[string]int64 `json:"usage"`
+}
+
+func (h *AdminHandler) usage(w http.ResponseWriter, r *http.Request) {
+	if h.t.Usage == nil {
+		http.Error(w, "usage accounting is disabled", http.StatusNotFound)
+		return
+	}
+	day := r.URL.Query().Get("day")
+	if day == "" {
+		day = time.Now().UTC().Format(usageDayFormat)
+	} else if _, err := time.Parse(usageDayFormat, day); err != nil {
+		http.Error(w, "invalid day: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	usage, err := h.t.Usage.Usage(day)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(adminUsage{Day: day, Usage: usage}); err != nil {
+		log.Println(err)
+	}
+}
+
+// maxStylesheetSize limits the size of uploaded stylesheets.
+const maxStylesheetSize = 16 << 20
+
+func (h *AdminHandler) updateStyle(w http.ResponseWriter, r *http.Request, name string) {
+	h.t.mu.RLock()
+	_, ok := h.t.layers[name]
+	h.t.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	xml, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxStylesheetSize))
+	if err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	// cached tiles are stale with the new style
+	invalidate := h.t.canPurge()
+	if err := h.t.UpdateStylesheet(name, xml, invalidate); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	log.Println("updated stylesheet of layer", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) purge(w http.ResponseWriter, r *http.Request, name string) {
+	if !h.t.lmp.hasSource(name) {
+		http.NotFound(w, r)
+		return
+	}
+	if !h.t.canPurge() {
+		http.Error(w, "cache does not support purging layers", http.StatusNotImplemented)
+		return
+	}
+	vacuum := r.URL.Query().Get("vacuum") == "1"
+	if err := h.t.PurgeLayer(name, vacuum); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if h.t.Peers != nil {
+		h.t.Peers.removeLayer(name)
+	}
+	log.Println("purged tiles of layer", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) removeLayer(w http.ResponseWriter, r *http.Request, name string) {
+	if !h.t.lmp.hasSource(name) {
+		http.NotFound(w, r)
+		return
+	}
+	h.t.RemoveLayer(name)
+	log.Println("removed layer", name)
+	w.WriteHeader(http.StatusNoContent)
+}
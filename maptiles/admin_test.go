@@ -0,0 +1,84 @@
+package maptiles
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nkovacs/go-mapnik/maptiles/maptilestest"
+)
+
+func TestAdminStylePath(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "styles")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{filepath.Join(dir, "osm.xml"), filepath.Join(root, "secret.xml")} {
+		if err := ioutil.WriteFile(p, []byte("<Map/>"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(root, "secret.xml"), filepath.Join(dir, "link.xml")); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &AdminHandler{StyleDir: dir}
+	tests := []struct {
+		stylesheet string
+		ok         bool
+	}{
+		{"osm.xml", true},
+		{filepath.Join(dir, "osm.xml"), true},
+		{"../secret.xml", false},
+		{filepath.Join(root, "secret.xml"), false},
+		{"link.xml", false},
+		{"missing.xml", false},
+	}
+	for _, test := range tests {
+		if _, err := h.stylePath(test.stylesheet); (err == nil) != test.ok {
+			t.Errorf("%s: got %v", test.stylesheet, err)
+		}
+	}
+
+	h.StyleDir = ""
+	if _, err := h.stylePath("osm.xml"); err == nil {
+		t.Error("stylesheet accepted without a style directory")
+	}
+}
+
+func TestAdminPurge(t *testing.T) {
+	cache := &DirCache{Dir: t.TempDir()}
+	ts, err := NewTileServer(TileServerConfig{Cache: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	ts.AddRenderer("stub", maptilestest.StubRenderer{})
+	c := TileCoord{Layer: "stub", Zoom: 1}
+	cache.BatchInsert([]TileFetchResult{{Coord: c, BlobPNG: []byte("tile")}})
+
+	h := NewAdminHandler(ts, "token")
+	tests := []struct {
+		url  string
+		code int
+	}{
+		{"/admin/layers/missing/tiles", http.StatusNotFound},
+		{"/admin/layers/stub/tiles", http.StatusNoContent},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("DELETE", test.url, nil)
+		r.Header.Set("Authorization", "Bearer token")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: got status %d, want %d", test.url, w.Code, test.code)
+		}
+	}
+	if blob, _ := cache.Fetch(c); blob != nil {
+		t.Error("tile was not purged")
+	}
+}
@@ -0,0 +1,206 @@
+package maptiles
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// APIKeyParam and APIKeyHeader carry the API key of a request if
+// TileServer.APIKeys is set.
+const (
+	APIKeyParam  = "key"
+	APIKeyHeader = "X-API-Key"
+)
+
+// APIKey is the configuration of an API key.
+type APIKey struct {
+	Key string `yaml:"key"`
+
+	// Name identifies the holder of the key.
+	Name string `yaml:"name"`
+
+	// Layers restricts the key to tile requests for these layers.
+	// If empty, all layers and endpoints are allowed.
+	Layers []string `yaml:"layers"`
+
+	// Disabled keys are rejected with 403 Forbidden.
+	Disabled bool `yaml:"disabled"`
+
+	// RateLimit and RateBurst override TileServerConfig.RateLimit and
+	// RateBurst for the key if RateLimit is not zero.
+	RateLimit float64 `yaml:"rate_limit"`
+	RateBurst int     `yaml:"rate_burst"`
+
+	// Quota is the number of tiles the key may request per day (UTC) if
+	// TileServer.Usage is set, further requests are answered with
+	// 429 Too Many Requests. Over QuotaWarn, tiles are still served but
+	// a warning is logged. Zero means no limit.
+	Quota     int64 `yaml:"quota"`
+	QuotaWarn int64 `yaml:"quota_warn"`
+}
+
+// allowsLayer reports whether the key may request tiles of the layer.
+func (k APIKey) allowsLayer(layer string) bool {
+	if len(k.Layers) == 0 {
+		return true
+	}
+	for _, l := range k.Layers {
+		if l == layer {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyLookup returns the configuration of an API key, and false if the
+// key is unknown.
+type APIKeyLookup interface {
+	LookupAPIKey(key string) (APIKey, bool)
+}
+
+// APIKeyLookupFunc adapts a function to the APIKeyLookup interface, e.g. to
+// check keys against a database.
+type APIKeyLookupFunc func(key string) (APIKey, bool)
+
+func (f APIKeyLookupFunc) LookupAPIKey(key string) (APIKey, bool) {
+	return f(key)
+}
+
+// APIKeyFile is an APIKeyLookup reading the keys from a YAML file:
+//
+//	keys:
+//	  - key: 6f1ed002ab5595859014ebf0951522d9
+//	    name: website
+//	  - key: 8d777f385d3dfec8815d20f7496026dc
+//	    name: partner
+//	    layers: [relief]
+type APIKeyFile struct {
+	Path string
+
+	mu   sync.RWMutex
+	keys map[string]APIKey
+}
+
+// LoadAPIKeyFile reads an API key file.
+func LoadAPIKeyFile(path string) (*APIKeyFile, error) {
+	f := &APIKeyFile{Path: path}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload reads the file again. If it cannot be read, the old keys are kept.
+func (f *APIKeyFile) Reload() error {
+	b, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return err
+	}
+	var file struct {
+		Keys []APIKey `yaml:"keys"`
+	}
+	if err := yaml.UnmarshalStrict(b, &file); err != nil {
+		return fmt.Errorf("%v: %v", f.Path, err)
+	}
+	keys := make(map[string]APIKey, len(file.Keys))
+	for i, k := range file.Keys {
+		if k.Key == "" {
+			return fmt.Errorf("%v: key %d: missing key", f.Path, i+1)
+		}
+		if _, ok := keys[k.Key]; ok {
+			return fmt.Errorf("%v: key %d: duplicate key", f.Path, i+1)
+		}
+		keys[k.Key] = k
+	}
+	f.mu.Lock()
+	f.keys = keys
+	f.mu.Unlock()
+	return nil
+}
+
+func (f *APIKeyFile) LookupAPIKey(key string) (APIKey, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	k, ok := f.keys[key]
+	return k, ok
+}
+
+type apiKeyContextKey struct{}
+
+// requestAPIKey returns the API key of the request, or an empty string.
+func requestAPIKey(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	return r.URL.Query().Get(APIKeyParam)
+}
+
+// authenticate answers requests without a valid API key or JWT with 401
+// Unauthorized or 403 Forbidden and returns false. Otherwise it returns the
+// request with the key attached for ServeTileRequest. A JWT is checked if
+// the request carries a bearer token, an API key otherwise.
+func (t *TileServer) authenticate(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if token := bearerToken(r); token != "" && t.JWT != nil {
+		k, err := t.JWT.Validate(token)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "invalid token: "+err.Error(), http.StatusUnauthorized)
+			return nil, false
+		}
+		return r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, k)), true
+	}
+	if t.APIKeys == nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "missing token", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	key := requestAPIKey(r)
+	if key == "" {
+		http.Error(w, "missing API key", http.StatusUnauthorized)
+		return nil, false
+	}
+	k, ok := t.APIKeys.LookupAPIKey(key)
+	if !ok || k.Disabled {
+		http.Error(w, "invalid API key", http.StatusForbidden)
+		return nil, false
+	}
+	return r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, k)), true
+}
+
+// apiKeyAllowsLayer reports whether the API key or JWT checked by ServeHTTP, if
+// any, may request tiles of the layer.
+func apiKeyAllowsLayer(r *http.Request, layer string) bool {
+	k, ok := r.Context().Value(apiKeyContextKey{}).(APIKey)
+	if !ok {
+		return true
+	}
+	if parts, err := parseComposite(layer); err == nil && parts != nil {
+		for _, p := range parts {
+			if !k.allowsLayer(p.layer) {
+				return false
+			}
+		}
+		return true
+	}
+	return k.allowsLayer(layer)
+}
+
+// authenticated reports whether the request was authenticated with an API
+// key or JWT by ServeHTTP.
+func authenticated(r *http.Request) bool {
+	_, ok := r.Context().Value(apiKeyContextKey{}).(APIKey)
+	return ok
+}
+
+// apiKeyRestricted reports whether the API key checked by ServeHTTP is
+// restricted to tile requests of some layers.
+func apiKeyRestricted(r *http.Request) bool {
+	k, ok := r.Context().Value(apiKeyContextKey{}).(APIKey)
+	return ok && len(k.Layers) > 0
+}
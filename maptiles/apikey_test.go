@@ -0,0 +1,56 @@
+package maptiles
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nkovacs/go-mapnik/maptiles/maptilestest"
+)
+
+func TestAPIKeys(t *testing.T) {
+	ts, err := NewTileServer(TileServerConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	ts.AddRenderer("osm", maptilestest.StubRenderer{})
+	ts.AddRenderer("topo", maptilestest.StubRenderer{})
+	keys := map[string]APIKey{
+		"all":      {Key: "all"},
+		"osm":      {Key: "osm", Layers: []string{"osm"}},
+		"disabled": {Key: "disabled", Disabled: true},
+	}
+	ts.APIKeys = APIKeyLookupFunc(func(key string) (APIKey, bool) {
+		k, ok := keys[key]
+		return k, ok
+	})
+
+	tests := []struct {
+		url    string
+		header string
+		code   int
+	}{
+		{"/osm/1/0/0.png", "", http.StatusUnauthorized},
+		{"/osm/1/0/0.png?key=unknown", "", http.StatusForbidden},
+		{"/osm/1/0/0.png?key=disabled", "", http.StatusForbidden},
+		{"/osm/1/0/0.png?key=all", "", http.StatusOK},
+		{"/osm/1/0/0.png", "all", http.StatusOK},
+		{"/topo/1/0/0.png?key=all", "", http.StatusOK},
+		{"/osm/1/0/0.png?key=osm", "", http.StatusOK},
+		{"/topo/1/0/0.png?key=osm", "", http.StatusForbidden},
+		{"/osm,topo/1/0/0.png?key=osm", "", http.StatusForbidden},
+		{"/stats?key=osm", "", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.url, nil)
+		if test.header != "" {
+			r.Header.Set(APIKeyHeader, test.header)
+		}
+		w := httptest.NewRecorder()
+		ts.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s: got status %d, want %d", test.url, w.Code, test.code)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package maptiles
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AutoscaleConfig configures a renderer pool that grows and shrinks
+// based on how long requests wait for a free renderer.
+type AutoscaleConfig struct {
+	// MinRenderers and MaxRenderers bound the pool size.
+	// If MinRenderers is zero, 1 will be used. If MaxRenderers is zero,
+	// runtime.GOMAXPROCS will be used.
+	MinRenderers, MaxRenderers int
+
+	// Interval is the time between scaling decisions.
+	// If zero, 5 seconds will be used.
+	Interval time.Duration
+
+	// ScaleUpWait is the average queue wait above which a renderer is added.
+	// If zero, 100ms will be used.
+	ScaleUpWait time.Duration
+
+	// ScaleDownWait is the average queue wait below which a renderer is
+	// removed. If zero, 10ms will be used.
+	ScaleDownWait time.Duration
+
+	// MaxCPU is the system CPU utilization between 0 and 1 above which
+	// the pool is not grown. Zero disables the check. CPU utilization is
+	// only available on Linux.
+	MaxCPU float64
+}
+
+type queuedRequest struct {
+	r        FetchRequest
+	enqueued time.Time
+}
+
+type autoscaledPool struct {
+	// template is the renderer new renderers are cloned from. It does not
+	// render itself and is closed when the pool stops.
+	template *TileRenderer
+	scale    AutoscaleConfig
+	queue    chan queuedRequest
+	// quit holds the signal for a renderer to stop. It is buffered so
+	// shrinking does not wait for a busy renderer.
+	quit     chan bool
+	mu       sync.Mutex
+	size     int
+	waitSum  time.Duration
+	waitN    int
+	cpu      cpuSampler
+	stopTick chan bool
+	wg       *sync.WaitGroup
+}
+
+// CreateAutoscaledRenderer starts a renderer pool for the stylesheet whose
+// size is adjusted between scale.MinRenderers and scale.MaxRenderers.
+// It returns an error if the stylesheet cannot be loaded.
+func (l *LayerMultiplex) CreateAutoscaledRenderer(cfg RendererConfig, scale AutoscaleConfig) (chan<- FetchRequest, error) {
+	if scale.MinRenderers <= 0 {
+		scale.MinRenderers = 1
+	}
+	if scale.MaxRenderers <= 0 {
+		scale.MaxRenderers = l.numRenderers
+	}
+	if scale.MaxRenderers < scale.MinRenderers {
+		scale.MaxRenderers = scale.MinRenderers
+	}
+	if scale.Interval == 0 {
+		scale.Interval = 5 * time.Second
+	}
+	if scale.ScaleUpWait == 0 {
+		scale.ScaleUpWait = 100 * time.Millisecond
+	}
+	if scale.ScaleDownWait == 0 {
+		scale.ScaleDownWait = 10 * time.Millisecond
+	}
+
+	template, err := NewTileRendererFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	template.limiter = l.limiter
+	p := &autoscaledPool{
+		template: template,
+		scale:    scale,
+		queue:    make(chan queuedRequest, scale.MaxRenderers),
+		quit:     make(chan bool, 1),
+		stopTick: make(chan bool),
+		wg:       &l.renderers,
+	}
+	for i := 0; i < scale.MinRenderers; i++ {
+		p.grow()
+	}
+
+	c := make(chan FetchRequest)
+	go func() {
+		for r := range c {
+			p.queue <- queuedRequest{r, time.Now()}
+		}
+		close(p.stopTick)
+		close(p.queue)
+	}()
+	go p.autoscale()
+
+	return c, nil
+}
+
+// grow starts another renderer by cloning the template.
+func (p *autoscaledPool) grow() {
+	t := p.template.Clone()
+	p.mu.Lock()
+	p.size++
+	p.mu.Unlock()
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer t.Close()
+		for {
+			select {
+			case q, ok := <-p.queue:
+				if !ok {
+					return
+				}
+				p.mu.Lock()
+				p.waitSum += time.Since(q.enqueued)
+				p.waitN++
+				p.mu.Unlock()
+				t.ProcessRequest(q.r)
+			case <-p.quit:
+				return
+			}
+		}
+	}()
+}
+
+// shrink asks a renderer to stop without waiting for it: the next idle
+// renderer takes the signal. The renderers exit without taking it once
+// the pool is closed. Nothing is done while the previous signal has not
+// been taken.
+func (p *autoscaledPool) shrink() {
+	// autoscale is the only sender, so the send cannot block
+	if len(p.quit) > 0 {
+		return
+	}
+	p.mu.Lock()
+	p.size--
+	p.mu.Unlock()
+	p.quit <- true
+}
+
+// autoscale periodically compares the average queue wait to the thresholds.
+func (p *autoscaledPool) autoscale() {
+	defer p.template.Close()
+	ticker := time.NewTicker(p.scale.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.stopTick:
+			return
+		}
+
+		p.mu.Lock()
+		var avg time.Duration
+		if p.waitN > 0 {
+			avg = p.waitSum / time.Duration(p.waitN)
+		}
+		busy := p.waitN > 0
+		size := p.size
+		p.waitSum, p.waitN = 0, 0
+		p.mu.Unlock()
+
+		cpu := p.cpu.utilization()
+		switch {
+		case avg > p.scale.ScaleUpWait && size < p.scale.MaxRenderers:
+			if p.scale.MaxCPU > 0 && cpu > p.scale.MaxCPU {
+				continue
+			}
+			p.grow()
+		case (!busy || avg < p.scale.ScaleDownWait) && size > p.scale.MinRenderers:
+			p.shrink()
+		}
+	}
+}
+
+// cpuSampler computes the system CPU utilization between two calls
+// from /proc/stat.
+type cpuSampler struct {
+	idle, total uint64
+}
+
+// utilization returns the CPU utilization since the previous call,
+// or 0 if it is not available.
+func (s *cpuSampler) utilization() float64 {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	if !sc.Scan() {
+		return 0
+	}
+	fields := strings.Fields(sc.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0
+	}
+	var idle, total uint64
+	for i, v := range fields[1:] {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0
+		}
+		total += n
+		// idle and iowait
+		if i == 3 || i == 4 {
+			idle += n
+		}
+	}
+
+	prevIdle, prevTotal := s.idle, s.total
+	s.idle, s.total = idle, total
+	if prevTotal == 0 || total <= prevTotal {
+		return 0
+	}
+	return 1 - float64(idle-prevIdle)/float64(total-prevTotal)
+}
@@ -0,0 +1,138 @@
+package maptiles
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+)
+
+const (
+	// maxBatchTiles is the maximum number of tiles in a batch request.
+	maxBatchTiles = 1000
+	// batchWorkers is the number of tiles of a batch request fetched or
+	// rendered concurrently.
+	batchWorkers = 8
+)
+
+// batchTile is an entry of a batch request. Y follows TmsSchema like the
+// tile URLs.
+type batchTile struct {
+	Layer string `json:"layer"`
+	Z     uint64 `json:"z"`
+	X     uint64 `json:"x"`
+	Y     uint64 `json:"y"`
+}
+
+// batchResult is a fetched tile of a batch request.
+type batchResult struct {
+	tile batchTile
+	blob []byte
+}
+
+// serveBatch answers POST /batch requests with a JSON list of
+// {"layer", "z", "x", "y"} objects. The tiles are fetched from the cache
+// or rendered concurrently and returned as a zip archive with entries
+// named layer/z/x/y.ext in the order they become ready. Tiles that don't
+// exist or fail to render are left out.
+func (t *TileServer) serveBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var tiles []batchTile
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&tiles); err != nil {
+		http.Error(w, "invalid batch: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(tiles) > maxBatchTiles {
+		http.Error(w, fmt.Sprintf("at most %d tiles can be requested at once", maxBatchTiles), http.StatusBadRequest)
+		return
+	}
+	t.mu.RLock()
+	for _, bt := range tiles {
+		tc := TileCoord{X: bt.X, Y: bt.Y, Zoom: bt.Z, Tms: t.TmsSchema, Layer: bt.Layer}
+		if !t.layers[bt.Layer].validTile(tc) {
+			t.mu.RUnlock()
+			http.Error(w, fmt.Sprintf("tile %v/%v/%v/%v out of range", bt.Layer, bt.Z, bt.X, bt.Y), http.StatusBadRequest)
+			return
+		}
+		if !apiKeyAllowsLayer(r, bt.Layer) {
+			t.mu.RUnlock()
+			http.Error(w, "access to layer "+bt.Layer+" is not allowed", http.StatusForbidden)
+			return
+		}
+	}
+	t.mu.RUnlock()
+	if !t.checkQuota(w, r, int64(len(tiles))) || !t.checkRateLimitN(w, r, len(tiles)-1) {
+		return
+	}
+
+	jobs := make(chan batchTile)
+	results := make(chan batchResult)
+	done := make(chan struct{})
+	defer close(done)
+	var wg sync.WaitGroup
+	for i := 0; i < batchWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for bt := range jobs {
+				t.stats.request(bt.Layer)
+				blob, err := t.getTile(TileCoord{X: bt.X, Y: bt.Y, Zoom: bt.Z, Tms: t.TmsSchema, Layer: bt.Layer}, RequestID(r))
+				if err != nil {
+					log.Printf("%vbatch: %v/%v/%v/%v: %v", requestTag(RequestID(r)), bt.Layer, bt.Z, bt.X, bt.Y, err)
+					continue
+				}
+				if blob == nil {
+					continue
+				}
+				select {
+				case results <- batchResult{bt, blob}:
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, bt := range tiles {
+			select {
+			case jobs <- bt:
+			case <-done:
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="tiles.zip"`)
+	zw := zip.NewWriter(w)
+	for res := range results {
+		bt := res.tile
+		// tiles are already compressed
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:   fmt.Sprintf("%v/%v/%v/%v.%v", bt.Layer, bt.Z, bt.X, bt.Y, blobExt(res.blob, t.vectorLayer(bt.Layer))),
+			Method: zip.Store,
+		})
+		if err == nil {
+			_, err = f.Write(res.blob)
+		}
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,99 @@
+package maptiles
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"sync"
+
+	"github.com/nkovacs/go-mapnik/tilegrid"
+)
+
+// solidColor reports whether the PNG tile is square and has a single color,
+// and returns the color and the tile size.
+func solidColor(blob []byte) (color.RGBA, uint64, bool) {
+	img, err := png.Decode(bytes.NewReader(blob))
+	if err != nil {
+		return color.RGBA{}, 0, false
+	}
+	b := img.Bounds()
+	if b.Empty() || b.Dx() != b.Dy() {
+		return color.RGBA{}, 0, false
+	}
+	first := color.RGBAModel.Convert(img.At(b.Min.X, b.Min.Y)).(color.RGBA)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.RGBAModel.Convert(img.At(x, y)).(color.RGBA) != first {
+				return color.RGBA{}, 0, false
+			}
+		}
+	}
+	return first, uint64(b.Dx()), true
+}
+
+// isBlank reports whether the PNG tile is fully transparent.
+func isBlank(blob []byte) bool {
+	c, _, ok := solidColor(blob)
+	return ok && c.A == 0
+}
+
+type solidKey struct {
+	c    color.RGBA
+	size uint64
+}
+
+var solidTiles struct {
+	sync.Mutex
+	tiles map[solidKey][]byte
+}
+
+// solidTile returns the PNG encoding of a size×size tile filled with c.
+// The same slice is returned for the same color and size, so all solid
+// tiles of a color share one blob in the cache.
+func solidTile(c color.RGBA, size uint64) []byte {
+	key := solidKey{c, size}
+	solidTiles.Lock()
+	defer solidTiles.Unlock()
+	if blob, ok := solidTiles.tiles[key]; ok {
+		return blob
+	}
+	img := image.NewPaletted(image.Rect(0, 0, int(size), int(size)), color.Palette{c})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		panic(err)
+	}
+	if solidTiles.tiles == nil {
+		solidTiles.tiles = make(map[solidKey][]byte)
+	}
+	solidTiles.tiles[key] = buf.Bytes()
+	return buf.Bytes()
+}
+
+// BlankTile returns a fully transparent 256×256 PNG tile.
+func BlankTile() []byte {
+	return blankTile(tilegrid.TileSize)
+}
+
+// blankTile returns a transparent tile of the given size.
+func blankTile(size uint64) []byte {
+	return solidTile(color.RGBA{}, size)
+}
+
+// gridBlankTile returns a transparent tile of the grid's tile size. A nil
+// grid has 256×256 Web Mercator tiles.
+func gridBlankTile(g *tilegrid.Grid) []byte {
+	if g == nil {
+		return BlankTile()
+	}
+	return blankTile(g.TilePixels())
+}
+
+// normalizeSolid replaces the tile with the canonical encoding of its color
+// if it has a single color. Other tiles are returned unchanged.
+func normalizeSolid(blob []byte) []byte {
+	if c, size, ok := solidColor(blob); ok {
+		return solidTile(c, size)
+	}
+	return blob
+}
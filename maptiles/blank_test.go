@@ -0,0 +1,31 @@
+package maptiles
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestNormalizeSolid(t *testing.T) {
+	for _, size := range []int{256, 512} {
+		img := image.NewNRGBA(image.Rect(0, 0, size, size))
+		for i := range img.Pix {
+			img.Pix[i] = 0x80
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		blob := normalizeSolid(buf.Bytes())
+		want := solidTile(color.RGBA{0x40, 0x40, 0x40, 0x80}, uint64(size))
+		if &blob[0] != &want[0] {
+			t.Errorf("%d: tile was not replaced by the shared solid tile", size)
+		}
+		c, got, ok := solidColor(blob)
+		if !ok || got != uint64(size) || c.A != 0x80 {
+			t.Errorf("%d: got %v %v %v", size, c, got, ok)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package maptiles
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// errCircuitOpen is returned for tiles of layers whose circuit is open.
+var errCircuitOpen = errors.New("layer unavailable")
+
+// Circuit breaker states, see LayerStats.Breaker.
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// layerBreaker is the circuit of a layer.
+type layerBreaker struct {
+	// failures is the number of consecutive failed renders
+	failures int
+	// openUntil is the end of the cooldown, zero while the circuit is closed
+	openUntil time.Time
+	// probing is set while the trial render after the cooldown runs
+	probing bool
+	trips   int64
+}
+
+// circuitBreaker stops rendering layers whose renders keep failing, e.g.
+// because their datasource is down, so requests fail fast instead of
+// queuing up in front of a broken backend. After threshold consecutive
+// failures the circuit of the layer opens for the cooldown. Then one
+// render is let through: if it succeeds the circuit closes, otherwise it
+// opens again. The methods may be called on a nil circuitBreaker.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu     sync.Mutex
+	layers map[string]*layerBreaker
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	if cooldown == 0 {
+		cooldown = 30 * time.Second
+	}
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		layers:    make(map[string]*layerBreaker),
+	}
+}
+
+// allow reports whether a tile of the layer may be rendered. If not, it
+// returns the time until the next attempt. A render that is allowed must
+// be followed by record or abort.
+func (c *circuitBreaker) allow(layer string) (bool, time.Duration) {
+	if c == nil {
+		return true, 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.layers[layer]
+	if !ok || b.openUntil.IsZero() {
+		return true, 0
+	}
+	if left := time.Until(b.openUntil); left > 0 {
+		return false, left
+	}
+	if b.probing {
+		return false, time.Second
+	}
+	b.probing = true
+	return true, 0
+}
+
+// record counts the result of a render of the layer. Busy renderers are
+// not counted as failures.
+func (c *circuitBreaker) record(layer string, err error) {
+	if c == nil {
+		return
+	}
+	if err == ErrRenderBusy {
+		c.abort(layer)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.layers[layer]
+	if err == nil {
+		if ok && !b.openUntil.IsZero() {
+			log.Println("Circuit of layer", layer, "closed")
+		}
+		if ok {
+			b.failures, b.openUntil, b.probing = 0, time.Time{}, false
+		}
+		return
+	}
+	if !ok {
+		b = new(layerBreaker)
+		c.layers[layer] = b
+	}
+	b.failures++
+	if b.probing || (b.openUntil.IsZero() && b.failures >= c.threshold) {
+		if b.openUntil.IsZero() {
+			b.trips++
+			log.Println("Circuit of layer", layer, "opened after", b.failures, "failed renders")
+		}
+		b.openUntil = time.Now().Add(c.cooldown)
+		b.probing = false
+	}
+}
+
+// abort ends an allowed render without a result.
+func (c *circuitBreaker) abort(layer string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if b, ok := c.layers[layer]; ok {
+		b.probing = false
+	}
+}
+
+// reset closes the circuit of the layer, e.g. when it is removed.
+func (c *circuitBreaker) reset(layer string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	delete(c.layers, layer)
+	c.mu.Unlock()
+}
+
+// state returns the state of the circuit of the layer and how often it
+// opened.
+func (c *circuitBreaker) state(layer string) (string, int64) {
+	if c == nil {
+		return "", 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.layers[layer]
+	switch {
+	case !ok:
+		return breakerClosed, 0
+	case b.openUntil.IsZero():
+		return breakerClosed, b.trips
+	case b.probing || !time.Now().Before(b.openUntil):
+		return breakerHalfOpen, b.trips
+	}
+	return breakerOpen, b.trips
+}
@@ -0,0 +1,198 @@
+package maptiles
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nkovacs/go-mapnik/tilegrid"
+)
+
+// maxBundleTiles is the maximum number of tiles covered by an offline
+// bundle.
+const maxBundleTiles = 20000
+
+// maxConcurrentBundles is the number of bundles assembled at once. More
+// bundle requests are answered with 503 Service Unavailable.
+const maxConcurrentBundles = 2
+
+// serveBundle answers /bundle.mbtiles?layer=&bbox=&minzoom=&maxzoom=
+// requests with an MBTiles file of the tiles of the layer covering the
+// bounding box, for offline use. The tiles are taken from the cache, up
+// to TileServerConfig.BundleRenderLimit missing tiles are rendered, the
+// rest is left out. The file is assembled in a temporary file before it
+// is sent.
+func (t *TileServer) serveBundle(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	layer := q.Get("layer")
+	if layer == "" {
+		layer = "default"
+	}
+	bbox, err := parseBBoxParam(q.Get("bbox"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	minZoom, errMin := strconv.ParseUint(q.Get("minzoom"), 10, 64)
+	maxZoom, errMax := strconv.ParseUint(q.Get("maxzoom"), 10, 64)
+	if errMin != nil || errMax != nil || minZoom > maxZoom || maxZoom > tilegrid.MaxZoom {
+		http.Error(w, fmt.Sprintf("minzoom and maxzoom must be between 0 and %d", tilegrid.MaxZoom), http.StatusBadRequest)
+		return
+	}
+	if !apiKeyAllowsLayer(r, layer) {
+		http.Error(w, "access to this layer is not allowed", http.StatusForbidden)
+		return
+	}
+
+	cache := t.m
+	t.mu.RLock()
+	if t.uncached[layer] {
+		cache = nil
+	}
+	cfg := t.layers[layer]
+	t.mu.RUnlock()
+	if cfg.Grid != nil {
+		http.Error(w, "bundles are only supported for layers in web mercator", http.StatusBadRequest)
+		return
+	}
+	if !t.lmp.hasSource(layer) {
+		http.NotFound(w, r)
+		return
+	}
+
+	var count uint64
+	for z := minZoom; z <= maxZoom; z++ {
+		min, max := tilegrid.BBoxToTileRange(tilegrid.BBox(bbox), z)
+		count += (max.X - min.X + 1) * (max.Y - min.Y + 1)
+		if count > maxBundleTiles {
+			http.Error(w, fmt.Sprintf("a bundle can contain at most %d tiles", maxBundleTiles), http.StatusBadRequest)
+			return
+		}
+	}
+	if !t.checkQuota(w, r, int64(count)) || !t.checkRateLimitN(w, r, int(count)-1) {
+		return
+	}
+	select {
+	case t.bundles <- struct{}{}:
+		defer func() { <-t.bundles }()
+	default:
+		serviceUnavailable(w, 10*time.Second)
+		return
+	}
+
+	f, err := ioutil.TempFile("", "bundle-*.mbtiles")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	if err := t.writeBundle(r, f.Name(), layer, bbox, minZoom, maxZoom, cache); err != nil {
+		if r.Context().Err() == nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	f, err = os.Open(f.Name())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", layer+".mbtiles"))
+	w.Header().Set("Content-Length", strconv.FormatInt(fi.Size(), 10))
+	if _, err := io.Copy(w, f); err != nil {
+		log.Println(err)
+	}
+}
+
+// writeBundle writes the tiles of a bundle to the MBTiles file at path.
+// It stops when the request is cancelled.
+func (t *TileServer) writeBundle(r *http.Request, path, layer string, bbox [4]float64, minZoom, maxZoom uint64, cache TileCache) error {
+	out, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	for _, query := range mbtilesSchema {
+		if _, err := out.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	tx, err := out.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare("INSERT INTO tiles VALUES(?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	format := "png"
+	if t.vectorLayer(layer) {
+		format = vectorFormat
+	}
+	rendered := 0
+	for z := minZoom; z <= maxZoom; z++ {
+		min, max := tilegrid.BBoxToTileRange(tilegrid.BBox(bbox), z)
+		for x := min.X; x <= max.X; x++ {
+			for y := min.Y; y <= max.Y; y++ {
+				if err := r.Context().Err(); err != nil {
+					return err
+				}
+				tc := TileCoord{X: x, Y: y, Zoom: z, Layer: layer}
+				var blob []byte
+				if cache != nil {
+					blob, _ = cache.Fetch(tc)
+				}
+				if blob == nil && rendered < t.bundleLimit {
+					rendered++
+					if blob, err = t.getTile(tc, RequestID(r)); err != nil {
+						log.Printf("%vbundle: %v/%v/%v/%v: %v", requestTag(RequestID(r)), layer, z, x, y, err)
+					}
+				}
+				if blob == nil {
+					continue
+				}
+				tc.SetTMS(true)
+				if _, err := stmt.Exec(z, x, tc.Y, blob); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	metadata := [][2]string{
+		{"name", layer},
+		{"format", format},
+		{"type", "overlay"},
+		{"version", "1.3"},
+		{"bounds", fmt.Sprintf("%f,%f,%f,%f", bbox[0], bbox[1], bbox[2], bbox[3])},
+		{"center", fmt.Sprintf("%f,%f,%d", (bbox[0]+bbox[2])/2, (bbox[1]+bbox[3])/2, minZoom)},
+		{"minzoom", strconv.FormatUint(minZoom, 10)},
+		{"maxzoom", strconv.FormatUint(maxZoom, 10)},
+	}
+	for _, md := range metadata {
+		if _, err := tx.Exec("INSERT INTO metadata VALUES(?, ?)", md[0], md[1]); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,45 @@
+package maptiles
+
+import (
+	"strings"
+	"time"
+)
+
+// TileCache stores rendered tiles. TileDb is the default implementation.
+type TileCache interface {
+	// Fetch returns the cached tile, or nil if it is not cached.
+	Fetch(c TileCoord) ([]byte, error)
+
+	// BatchInsert stores the tiles.
+	BatchInsert(tiles []TileFetchResult)
+
+	// BatchCheckSince checks whether the tiles are cached and were written
+	// at or after since. A zero since matches all tiles.
+	BatchCheckSince(coords []TileCoord, since time.Time) []bool
+}
+
+var _ TileCache = (*TileDb)(nil)
+
+// insertQueuer is implemented by caches that buffer inserts, like TileDb.
+type insertQueuer interface {
+	queueInsert(t TileFetchResult)
+}
+
+// insertTiles stores the tiles in the background.
+func insertTiles(cache TileCache, tiles []TileFetchResult) {
+	if q, ok := cache.(insertQueuer); ok {
+		for _, t := range tiles {
+			q.queueInsert(t)
+		}
+		return
+	}
+	go cache.BatchInsert(tiles)
+}
+
+// baseLayer strips the mapnik layer selection from a cache layer name.
+func baseLayer(l string) string {
+	if i := strings.IndexByte(l, '+'); i >= 0 {
+		return l[:i]
+	}
+	return l
+}
@@ -0,0 +1,100 @@
+package maptiles
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseIPNet parses a CIDR block or a single IP address.
+func parseIPNet(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address %q", s)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CIDR block %q", s)
+	}
+	return n, nil
+}
+
+// ipList is a list of CIDR blocks.
+type ipList []*net.IPNet
+
+// parseIPList parses IP addresses and CIDR blocks.
+func parseIPList(list []string) (ipList, error) {
+	var l ipList
+	for _, s := range list {
+		n, err := parseIPNet(s)
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, n)
+	}
+	return l, nil
+}
+
+// contains reports whether the address ip is in one of the blocks.
+func (l ipList) contains(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range l {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client that sent the request, e.g.
+// for access logs. If the request came through a trusted proxy, see
+// TileServerConfig.TrustedProxies, or a unix socket and
+// TileServerConfig.TrustUnixSocket is set, the address is taken from the
+// X-Forwarded-For or X-Real-IP header.
+func (t *TileServer) ClientIP(r *http.Request) string {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	unixSocket := t.trustUnix && (remote == "" || remote == "@")
+	if !t.trustForwarded && !unixSocket && !t.trustedProxies.contains(remote) {
+		return remote
+	}
+
+	if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+		addrs := strings.Split(strings.Join(fwd, ","), ",")
+		// every proxy appends the address it received the request from,
+		// the client is the last one not added by a trusted proxy
+		for i := len(addrs) - 1; i >= 0; i-- {
+			addr := strings.TrimSpace(addrs[i])
+			if t.trustForwarded || i == 0 || !t.trustedProxies.contains(addr) {
+				return addr
+			}
+		}
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); ip != "" {
+		return ip
+	}
+	return remote
+}
+
+// checkIP answers requests from denied or not allowed client IPs with
+// 403 Forbidden and returns false.
+func (t *TileServer) checkIP(w http.ResponseWriter, ip string) bool {
+	if t.denyIPs.contains(ip) || (len(t.allowIPs) > 0 && !t.allowIPs.contains(ip)) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return false
+	}
+	return true
+}
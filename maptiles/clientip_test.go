@@ -0,0 +1,66 @@
+package maptiles
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    TileServerConfig
+		remote string
+		fwd    string
+		realIP string
+		want   string
+	}{
+		{"direct", TileServerConfig{}, "192.0.2.1:1234", "198.51.100.1", "", "192.0.2.1"},
+		{"trust all", TileServerConfig{TrustForwardedFor: true}, "192.0.2.1:1234", "198.51.100.1, 198.51.100.2", "", "198.51.100.2"},
+		{"trusted proxy", TileServerConfig{TrustedProxies: []string{"10.0.0.0/8"}}, "10.0.0.1:1234", "198.51.100.1, 10.0.0.2", "", "198.51.100.1"},
+		{"spoofed header", TileServerConfig{TrustedProxies: []string{"10.0.0.0/8"}}, "10.0.0.1:1234", "203.0.113.9, 198.51.100.1", "", "198.51.100.1"},
+		{"untrusted proxy", TileServerConfig{TrustedProxies: []string{"10.0.0.0/8"}}, "192.0.2.1:1234", "198.51.100.1", "", "192.0.2.1"},
+		{"real ip", TileServerConfig{TrustedProxies: []string{"10.0.0.1"}}, "10.0.0.1:1234", "", "198.51.100.1", "198.51.100.1"},
+		{"unix socket", TileServerConfig{TrustUnixSocket: true}, "@", "198.51.100.1", "", "198.51.100.1"},
+		{"untrusted unix socket", TileServerConfig{}, "@", "198.51.100.1", "", "@"},
+	}
+	for _, test := range tests {
+		ts, err := NewTileServer(test.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		if test.fwd != "" {
+			r.Header.Set("X-Forwarded-For", test.fwd)
+		}
+		if test.realIP != "" {
+			r.Header.Set("X-Real-IP", test.realIP)
+		}
+		if ip := ts.ClientIP(r); ip != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, ip, test.want)
+		}
+		ts.Close()
+	}
+}
+
+func TestIPList(t *testing.T) {
+	l, err := parseIPList([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]bool{
+		"10.1.2.3":    true,
+		"192.0.2.1":   true,
+		"192.0.2.2":   false,
+		"2001:db8::1": true,
+		"2001:db9::1": false,
+		"invalid":     false,
+	} {
+		if got := l.contains(ip); got != want {
+			t.Errorf("%s: got %v, want %v", ip, got, want)
+		}
+	}
+	if _, err := parseIPList([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("invalid CIDR block accepted")
+	}
+}
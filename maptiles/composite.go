@@ -0,0 +1,177 @@
+package maptiles
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compositePart is a layer of a composite tile.
+type compositePart struct {
+	layer   string
+	opacity float64
+}
+
+// maxCompositeParts is the largest number of layers in a composite tile.
+const maxCompositeParts = 8
+
+// parseComposite splits a composite layer name of the form
+// base,overlay@0.5 into its parts. The layers are drawn in order,
+// the opacity defaults to 1. It returns nil if the name is not
+// a composite layer name, and an error if it is an invalid one.
+func parseComposite(name string) ([]compositePart, error) {
+	if !strings.ContainsAny(name, ",@") {
+		return nil, nil
+	}
+	names := strings.Split(name, ",")
+	if len(names) > maxCompositeParts {
+		return nil, fmt.Errorf("composite tile has more than %d layers", maxCompositeParts)
+	}
+	parts := make([]compositePart, 0, len(names))
+	for _, p := range names {
+		part := compositePart{layer: p, opacity: 1}
+		if i := strings.IndexByte(p, '@'); i >= 0 {
+			o, err := strconv.ParseFloat(p[i+1:], 64)
+			if err != nil || o < 0 || o > 1 {
+				return nil, fmt.Errorf("invalid opacity %q", p[i+1:])
+			}
+			part.layer, part.opacity = p[:i], o
+		}
+		if part.layer == "" {
+			return nil, errors.New("empty layer name in composite tile")
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// compositeName returns the canonical name of the composite layer, which
+// is used as the cache key, so that e.g. osm,hills@.50 and osm,hills@0.5
+// share their tiles.
+func compositeName(parts []compositePart) string {
+	names := make([]string, len(parts))
+	for i, p := range parts {
+		names[i] = p.layer
+		if p.opacity != 1 {
+			names[i] += "@" + strconv.FormatFloat(p.opacity, 'f', -1, 64)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// serveComposite answers a request for a composite tile. The composited
+// tile is cached under the composite layer name, the tiles of the parts
+// under their own names.
+func (t *TileServer) serveComposite(w http.ResponseWriter, r *http.Request, tc TileCoord, parts []compositePart) {
+	tc.Layer = compositeName(parts)
+	if t.m != nil {
+		if blob, err := t.m.Fetch(tc); err == nil && blob != nil {
+			t.writeTile(w, r, blob, false)
+			return
+		}
+	}
+
+	tiles := make([][]byte, len(parts))
+	for i, p := range parts {
+		c := tc
+		c.Layer = p.layer
+		blob, err := t.getTile(c, RequestID(r))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		tiles[i] = blob
+	}
+
+	blob, err := compositeTiles(parts, tiles)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t.writeTile(w, r, blob, false)
+	if t.m != nil {
+		insertTiles(t.m, []TileFetchResult{{Coord: tc, BlobPNG: blob}})
+	}
+}
+
+// getTile returns a tile of a single layer from the cache, or renders and
+// caches it for the request with the ID, see RequestID. It returns nil if
+// the layer has no tile at the coordinates.
+func (t *TileServer) getTile(tc TileCoord, requestID string) ([]byte, error) {
+	cache := t.m
+	t.mu.RLock()
+	cfg, mapnikLayer := t.layers[tc.Layer]
+	if t.uncached[tc.Layer] || !cfg.cacheable(tc) {
+		cache = nil
+	}
+	t.mu.RUnlock()
+
+	if mapnikLayer && (!cfg.inZoomRange(tc) || !cfg.inBounds(tc)) {
+		return nil, nil
+	}
+	if cache != nil {
+		blob, err := cache.Fetch(tc)
+		if err != nil {
+			t.tileError(err, tc, StageCacheRead, requestID)
+		} else if blob != nil {
+			return blob, nil
+		}
+	}
+
+	ch := make(chan TileFetchResult)
+	if ok, _ := t.breaker.allow(tc.Layer); !ok {
+		if cfg.Fallback != nil {
+			return cfg.Fallback.RenderTile(tc)
+		}
+		return nil, errCircuitOpen
+	}
+	if !t.lmp.SubmitRequest(TileFetchRequest{tc, ch, requestID}) {
+		t.breaker.abort(tc.Layer)
+		return nil, fmt.Errorf("no such layer %v", tc.Layer)
+	}
+	result := <-ch
+	t.breaker.record(tc.Layer, result.Error)
+	if result.Error != nil && result.Error != ErrRenderBusy {
+		t.tileError(result.Error, tc, StageRender, requestID)
+	}
+	if result.Error != nil || result.BlobPNG == nil {
+		if cfg.Fallback != nil {
+			return cfg.Fallback.RenderTile(tc)
+		}
+		return nil, result.Error
+	}
+	if cache != nil {
+		insertTiles(cache, []TileFetchResult{result})
+	}
+	return result.BlobPNG, nil
+}
+
+// compositeTiles draws the tiles on top of each other. Missing tiles
+// are skipped.
+func compositeTiles(parts []compositePart, tiles [][]byte) ([]byte, error) {
+	dst := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for i, blob := range tiles {
+		if blob == nil {
+			continue
+		}
+		img, err := png.Decode(bytes.NewReader(blob))
+		if err != nil {
+			return nil, fmt.Errorf("decoding tile of layer %v: %v", parts[i].layer, err)
+		}
+		mask := image.NewUniform(color.Alpha{uint8(parts[i].opacity*255 + 0.5)})
+		draw.DrawMask(dst, dst.Bounds(), img, img.Bounds().Min, mask, image.ZP, draw.Over)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
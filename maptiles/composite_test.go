@@ -0,0 +1,39 @@
+package maptiles
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseComposite(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts []compositePart
+		key   string
+		ok    bool
+	}{
+		{"osm", nil, "", true},
+		{"osm,hills", []compositePart{{"osm", 1}, {"hills", 1}}, "osm,hills", true},
+		{"osm,hills@.50", []compositePart{{"osm", 1}, {"hills", 0.5}}, "osm,hills@0.5", true},
+		{"osm@1,hills@0.5", []compositePart{{"osm", 1}, {"hills", 0.5}}, "osm,hills@0.5", true},
+		{"osm,hills@2", nil, "", false},
+		{"osm,hills@x", nil, "", false},
+		{"osm,", nil, "", false},
+		{"@0.5", nil, "", false},
+		{strings.Repeat("osm,", maxCompositeParts) + "osm", nil, "", false},
+	}
+	for _, test := range tests {
+		parts, err := parseComposite(test.name)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(parts, test.parts) {
+			t.Errorf("%s: got %v, want %v", test.name, parts, test.parts)
+		}
+		if parts != nil && compositeName(parts) != test.key {
+			t.Errorf("%s: got key %s, want %s", test.name, compositeName(parts), test.key)
+		}
+	}
+}
@@ -0,0 +1,921 @@
+package maptiles
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"image/color"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nkovacs/go-mapnik/tilegrid"
+	"gopkg.in/yaml.v2"
+)
+
+// Config describes a tile server and is read from a YAML file by LoadConfig:
+//
+//	renderers: 4
+//	cache:
+//	  file: cache.mbtiles
+//	  max_bytes: 1000000000
+//	http:
+//	  listen: ":8080"
+//	  admin_token: secret
+//	layers:
+//	  - name: default
+//	    stylesheet: /srv/styles/osm.xml
+//	    max_zoom: 18
+//	    ttl: 24h
+//	    fallback: https://tile.example.com/{z}/{x}/{y}.png
+//	  - name: relief
+//	    mbtiles: /srv/relief.mbtiles
+//	  - name: satellite
+//	    proxy: https://sat.example.com/{z}/{x}/{y}.jpg
+//	  - name: hillshade
+//	    dem: /srv/dem-3857.tif
+type Config struct {
+	Cache  CacheConfig       `yaml:"cache"`
+	HTTP   HTTPConfig        `yaml:"http"`
+	Layers []LayerFileConfig `yaml:"layers"`
+	Seed   SeedConfig        `yaml:"seed"`
+	Peers  PeersConfig       `yaml:"peers"`
+
+	// NumRenderers, MetaTileSize, FailureTTL, ErrorTiles, BreakerThreshold
+	// and BreakerCooldown are passed to TileServerConfig.
+	NumRenderers     int           `yaml:"renderers"`
+	MetaTileSize     uint64        `yaml:"meta_tile_size"`
+	FailureTTL       time.Duration `yaml:"failure_ttl"`
+	ErrorTiles       bool          `yaml:"error_tiles"`
+	BreakerThreshold int           `yaml:"breaker_threshold"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown"`
+
+	// ErrorTile is the path of a PNG sent instead of generated error
+	// tiles. It implies ErrorTiles.
+	ErrorTile string `yaml:"error_tile"`
+
+	// MaxConcurrentRenders, RejectBusyRenders and RenderQueueTimeout
+	// are the RenderLimit of TileServerConfig.
+	MaxConcurrentRenders int           `yaml:"max_concurrent_renders"`
+	RejectBusyRenders    bool          `yaml:"reject_busy_renders"`
+	RenderQueueTimeout   time.Duration `yaml:"render_queue_timeout"`
+
+	// RendererMaxTiles and RendererHangTimeout are the Watchdog of
+	// TileServerConfig.
+	RendererMaxTiles    uint64        `yaml:"renderer_max_tiles"`
+	RendererHangTimeout time.Duration `yaml:"renderer_hang_timeout"`
+
+	// Heatmap and HeatmapZoom are passed to TileServerConfig.
+	Heatmap     bool   `yaml:"heatmap"`
+	HeatmapZoom uint64 `yaml:"heatmap_zoom"`
+
+	// Prefetch, PrefetchQueue and PrefetchWorkers are passed to
+	// TileServerConfig.
+	Prefetch        bool `yaml:"prefetch"`
+	PrefetchQueue   int  `yaml:"prefetch_queue"`
+	PrefetchWorkers int  `yaml:"prefetch_workers"`
+}
+
+// CacheConfig selects the cache backend. File takes precedence over Dir.
+// If neither is set, tiles are not cached.
+type CacheConfig struct {
+	// File is an mbtiles cache file.
+	File string `yaml:"file"`
+	// Dir is the root of a {layer}/{z}/{x}/{y}.png directory tree.
+	Dir string `yaml:"dir"`
+
+	MaxBytes      int64         `yaml:"max_bytes"`
+	MaxTiles      int64         `yaml:"max_tiles"`
+	PruneInterval time.Duration `yaml:"prune_interval"`
+}
+
+// HTTPConfig configures how the tile server is served, see
+// TileServer.ListenAndServe.
+type HTTPConfig struct {
+	// Listen is the address to listen on, see Listen: host:port,
+	// unix:/path/to/socket or systemd for socket activation. If empty,
+	// ":8080" is used.
+	Listen string `yaml:"listen"`
+	Tms    bool   `yaml:"tms"`
+
+	// SocketMode is the permission of unix sockets, e.g. 0666 to allow
+	// all users. If zero, 0660 will be used.
+	SocketMode os.FileMode `yaml:"socket_mode"`
+
+	// AdminToken enables the AdminHandler under /admin/.
+	AdminToken string `yaml:"admin_token"`
+
+	// AdminStyleDir is the directory of the stylesheets of layers added
+	// with the AdminHandler, see AdminHandler.StyleDir.
+	AdminStyleDir string `yaml:"admin_style_dir"`
+
+	// AdminListen is a separate address like Listen for the AdminHandler,
+	// /stats and the DebugHandler, e.g. localhost:8081, so the public
+	// address only serves tiles. It must only be reachable from internal
+	// networks.
+	AdminListen string `yaml:"admin_listen"`
+
+	// DebugListen enables the DebugHandler with pprof profiles and expvar
+	// variables, and /stats, on a separate address like Listen, e.g.
+	// localhost:6060.
+	DebugListen string `yaml:"debug_listen"`
+
+	// APIKeys is an API key file, see APIKeyFile. It is read again by
+	// ReloadConfig. The usage of the keys, and of JWT subjects, is
+	// counted in a MemoryUsage, see APIKey.Quota.
+	APIKeys string `yaml:"api_keys"`
+
+	// RateLimit, RateBurst, TrustForwardedFor, TrustedProxies,
+	// TrustUnixSocket, AllowIPs, DenyIPs, AllowedReferers,
+	// AllowEmptyReferer and RefererBypass are passed to TileServerConfig.
+	RateLimit         float64  `yaml:"rate_limit"`
+	RateBurst         int      `yaml:"rate_burst"`
+	TrustForwardedFor bool     `yaml:"trust_forwarded_for"`
+	TrustedProxies    []string `yaml:"trusted_proxies"`
+	TrustUnixSocket   bool     `yaml:"trust_unix_socket"`
+	AllowIPs          []string `yaml:"allow_ips"`
+	DenyIPs           []string `yaml:"deny_ips"`
+	AllowedReferers   []string `yaml:"allowed_referers"`
+	AllowEmptyReferer bool     `yaml:"allow_empty_referer"`
+	RefererBypass     []string `yaml:"referer_bypass"`
+
+	// MaxAge and BundleRenderLimit are passed to TileServerConfig.
+	MaxAge            time.Duration `yaml:"max_age"`
+	BundleRenderLimit int           `yaml:"bundle_render_limit"`
+
+	// JWTSecret and JWTPublicKey, the path of a PEM file, enable JWT
+	// authentication with HS256 and RS256 tokens, see JWTAuth.
+	JWTSecret      string `yaml:"jwt_secret"`
+	JWTPublicKey   string `yaml:"jwt_public_key"`
+	JWTLayersClaim string `yaml:"jwt_layers_claim"`
+	JWTIssuer      string `yaml:"jwt_issuer"`
+	JWTAudience    string `yaml:"jwt_audience"`
+
+	// JWTAllowAllLayers lets tokens without the layers claim access all
+	// layers, see JWTAuth.AllowAllLayers.
+	JWTAllowAllLayers bool `yaml:"jwt_allow_all_layers"`
+}
+
+// LayerFileConfig describes a layer. Exactly one of Stylesheet, MBTiles,
+// Proxy, RenderWorkers, Debug and DEM must be set.
+type LayerFileConfig struct {
+	Name       string            `yaml:"name"`
+	Stylesheet string            `yaml:"stylesheet"`
+	Params     map[string]string `yaml:"params"`
+
+	// DisableLayers are mapnik layers of the stylesheet that are not rendered.
+	DisableLayers []string `yaml:"disable_layers"`
+
+	// DatasourceParams overrides datasource parameters per mapnik layer,
+	// see RendererConfig.DatasourceParams.
+	DatasourceParams map[string]map[string]string `yaml:"datasource_params"`
+
+	// MBTiles serves the tiles of an existing file instead of rendering them.
+	MBTiles string `yaml:"mbtiles"`
+
+	// Proxy serves the tiles of a remote tile server, see ProxySource.URL.
+	Proxy string `yaml:"proxy"`
+
+	// RenderWorkers renders the layer on remote render workers, see
+	// RemoteRenderer.Workers. The workers must serve a layer with the same
+	// name. RenderWorkerConcurrency is the number of requests sent to the
+	// workers at the same time. If zero, the number of renderers will be
+	// used. RenderWorkerTimeout is the deadline of each request, see
+	// RemoteRenderer.Timeout.
+	RenderWorkers           []string      `yaml:"render_workers"`
+	RenderWorkerConcurrency int           `yaml:"render_worker_concurrency"`
+	RenderWorkerTimeout     time.Duration `yaml:"render_worker_timeout"`
+
+	// RenderWorkerSecret is the shared secret of the render workers, see
+	// RemoteRenderer.Secret. RenderWorkerCert and RenderWorkerKey are the
+	// files of the client certificate sent to workers with https URLs,
+	// RenderWorkerCA the file of the CA certificate verifying the
+	// workers. If empty, the system roots are used.
+	RenderWorkerSecret string `yaml:"render_worker_secret"`
+	RenderWorkerCert   string `yaml:"render_worker_cert"`
+	RenderWorkerKey    string `yaml:"render_worker_key"`
+	RenderWorkerCA     string `yaml:"render_worker_ca"`
+
+	// Debug serves tiles showing their coordinates, see DebugRenderer.
+	Debug bool `yaml:"debug"`
+
+	// DEM renders hillshading or Terrain-RGB tiles from an elevation
+	// model, see DEMRenderer. DEMMode is hillshade (default) or
+	// terrain-rgb. The hillshade options are passed to DEMRenderer.
+	DEM                   string  `yaml:"dem"`
+	DEMMode               string  `yaml:"dem_mode"`
+	HillshadeAzimuth      float64 `yaml:"hillshade_azimuth"`
+	HillshadeAltitude     float64 `yaml:"hillshade_altitude"`
+	HillshadeExaggeration float64 `yaml:"hillshade_exaggeration"`
+
+	// Fallback is the URL template of a remote tile server that is asked
+	// for tiles that could not be rendered.
+	Fallback string `yaml:"fallback"`
+
+	// Format is the tile format: png, jpeg or webp. Formats are several
+	// formats chosen by the Accept header, see LayerConfig.Formats.
+	// Formats other than png require a stylesheet. Proxy layers serving
+	// vector tiles have the format pbf, the format of MBTiles layers is
+	// taken from the metadata of the file.
+	Format  string   `yaml:"format"`
+	Formats []string `yaml:"formats"`
+
+	// Variables are the stylesheet variables that can be set per request,
+	// with their allowed values, see LayerConfig.Variables.
+	Variables map[string][]string `yaml:"variables"`
+
+	// TileSize is the tile width and height in pixels. Only 256 is supported.
+	TileSize int `yaml:"tile_size"`
+
+	// MetaTileSize and BufferSize tune the placement of labels across
+	// tile edges, see LayerConfig.MetaTileSize and RendererConfig.BufferSize.
+	MetaTileSize uint64 `yaml:"meta_tile_size"`
+	BufferSize   uint64 `yaml:"buffer_size"`
+
+	MinZoom uint64 `yaml:"min_zoom"`
+	MaxZoom uint64 `yaml:"max_zoom"`
+
+	// Grid is a custom tile grid, see RendererConfig.Grid. The keys are
+	// the lowercase field names of tilegrid.Grid.
+	Grid *tilegrid.Grid `yaml:"grid"`
+
+	// GridName selects a predefined grid instead, EPSG:3857 or EPSG:4326.
+	GridName string `yaml:"grid_name"`
+
+	// Bounds is minlon, minlat, maxlon, maxlat.
+	Bounds []float64 `yaml:"bounds"`
+
+	// OutOfRange is the response for tiles outside of the zoom range and
+	// bounds: 404 (default), 204 or blank.
+	OutOfRange string `yaml:"out_of_range"`
+
+	// Overzoom scales up tiles above MaxZoom, see LayerConfig.Overzoom.
+	Overzoom      bool `yaml:"overzoom"`
+	CacheOverzoom bool `yaml:"cache_overzoom"`
+
+	// Isolate renders in child processes, see LayerConfig.Isolate.
+	Isolate bool `yaml:"isolate"`
+
+	TTL         time.Duration `yaml:"ttl"`
+	Attribution string        `yaml:"attribution"`
+
+	// Headers are sent with the tiles of the layer, e.g. an attribution
+	// or X-Tile-Source header, see TileServer.SetLayerHeaders.
+	Headers map[string]string `yaml:"headers"`
+
+	// Background replaces the background of the stylesheet: transparent,
+	// rrggbb or rrggbbaa.
+	Background string `yaml:"background"`
+
+	// Watermark stamps a text or PNG logo onto the tiles, see Watermark.
+	// WatermarkCorner is bottom-right (default), bottom-left, top-right
+	// or top-left.
+	Watermark        string  `yaml:"watermark"`
+	WatermarkLogo    string  `yaml:"watermark_logo"`
+	WatermarkCorner  string  `yaml:"watermark_corner"`
+	WatermarkOpacity float64 `yaml:"watermark_opacity"`
+
+	// PNGCompression, PNGStrategy, PNGTransMode, PNGColors and
+	// PNGTrueColor tune the PNG encoder, see PNGOptions.
+	PNGCompression int    `yaml:"png_compression"`
+	PNGStrategy    string `yaml:"png_strategy"`
+	PNGTransMode   string `yaml:"png_trans_mode"`
+	PNGColors      int    `yaml:"png_colors"`
+	PNGTrueColor   bool   `yaml:"png_true_color"`
+
+	// Composite are stylesheets composited onto Stylesheet. The keys are
+	// the lowercase field names of CompositeStylesheet:
+	//
+	//	composite:
+	//	  - stylesheet: /srv/styles/hillshade.xml
+	//	    op: multiply
+	//	    opacity: 0.6
+	Composite []CompositeStylesheet `yaml:"composite"`
+}
+
+// SeedConfig contains defaults for cmd/seed.
+type SeedConfig struct {
+	// BBox is minlon, minlat, maxlon, maxlat.
+	BBox     []float64 `yaml:"bbox"`
+	MinZoom  uint64    `yaml:"min_zoom"`
+	MaxZoom  uint64    `yaml:"max_zoom"`
+	MetaSize uint64    `yaml:"meta_size"`
+	Workers  int       `yaml:"workers"`
+	// Order is row or pyramid.
+	Order string `yaml:"order"`
+}
+
+// PeersConfig configures the PeerCache of a tile server in a cluster. The
+// URLs are updated by ReloadConfig.
+type PeersConfig struct {
+	// Self is the URL of this server in URLs. An empty string disables
+	// the peer cache.
+	Self       string   `yaml:"self"`
+	URLs       []string `yaml:"urls"`
+	Secret     string   `yaml:"secret"`
+	CacheBytes int64    `yaml:"cache_bytes"`
+}
+
+// LoadConfig reads and validates a YAML configuration file.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.UnmarshalStrict(b, &cfg); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return &cfg, nil
+}
+
+func (cfg *Config) validate() error {
+	names := make(map[string]bool)
+	for _, l := range cfg.Layers {
+		if l.Name == "" {
+			return fmt.Errorf("layer without name")
+		}
+		if names[l.Name] {
+			return fmt.Errorf("duplicate layer %v", l.Name)
+		}
+		names[l.Name] = true
+		sources := 0
+		if l.Debug {
+			sources++
+		}
+		if len(l.RenderWorkers) > 0 {
+			sources++
+		}
+		for _, src := range []string{l.Stylesheet, l.MBTiles, l.Proxy, l.DEM} {
+			if src != "" {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fmt.Errorf("layer %v: exactly one of stylesheet, mbtiles, proxy, render_workers, debug and dem must be set", l.Name)
+		}
+		if _, ok := demModes[l.DEMMode]; !ok {
+			return fmt.Errorf("layer %v: unknown dem_mode %v", l.Name, l.DEMMode)
+		}
+		if l.Fallback != "" && l.Stylesheet == "" {
+			return fmt.Errorf("layer %v: fallback requires a stylesheet", l.Name)
+		}
+		formats := append([]string{l.Format}, l.Formats...)
+		if l.Proxy != "" && l.Format == vectorFormat && len(l.Formats) == 0 {
+			formats = nil
+		}
+		for _, f := range formats {
+			if _, ok := tileFormats[f]; !ok && f != "" {
+				return fmt.Errorf("layer %v: unsupported format %v", l.Name, f)
+			}
+			if f != "" && f != "png" && l.Stylesheet == "" {
+				return fmt.Errorf("layer %v: format %v requires a stylesheet", l.Name, f)
+			}
+		}
+		if l.TileSize != 0 && l.TileSize != 256 {
+			return fmt.Errorf("layer %v: unsupported tile size %v", l.Name, l.TileSize)
+		}
+		if l.MaxZoom != 0 && l.MaxZoom < l.MinZoom {
+			return fmt.Errorf("layer %v: max_zoom is less than min_zoom", l.Name)
+		}
+		if l.Grid != nil && len(l.Grid.Resolutions) == 0 {
+			return fmt.Errorf("layer %v: grid without resolutions", l.Name)
+		}
+		if l.GridName != "" && tilegrid.Named(l.GridName) == nil {
+			return fmt.Errorf("layer %v: unknown grid %v", l.Name, l.GridName)
+		}
+		if len(l.Bounds) != 0 && len(l.Bounds) != 4 {
+			return fmt.Errorf("layer %v: bounds must be minlon,minlat,maxlon,maxlat", l.Name)
+		}
+		switch l.OutOfRange {
+		case "", "404", "204", "blank":
+		default:
+			return fmt.Errorf("layer %v: unknown out_of_range %v", l.Name, l.OutOfRange)
+		}
+		if _, ok := corners[l.WatermarkCorner]; !ok {
+			return fmt.Errorf("layer %v: unknown watermark_corner %v", l.Name, l.WatermarkCorner)
+		}
+		if l.Background != "" {
+			if _, err := parseColor(l.Background); err != nil {
+				return fmt.Errorf("layer %v: %v", l.Name, err)
+			}
+		}
+		if l.WatermarkOpacity < 0 || l.WatermarkOpacity > 1 {
+			return fmt.Errorf("layer %v: watermark_opacity must be between 0 and 1", l.Name)
+		}
+		if len(l.Composite) > 0 && l.Stylesheet == "" {
+			return fmt.Errorf("layer %v: composite requires a stylesheet", l.Name)
+		}
+		for _, c := range l.Composite {
+			if c.Stylesheet == "" {
+				return fmt.Errorf("layer %v: composite without stylesheet", l.Name)
+			}
+			if c.Opacity < 0 || c.Opacity > 1 {
+				return fmt.Errorf("layer %v: composite opacity must be between 0 and 1", l.Name)
+			}
+		}
+		if err := l.pngOptions().validate(); err != nil {
+			return fmt.Errorf("layer %v: %v", l.Name, err)
+		}
+	}
+	if cfg.HTTP.RateLimit < 0 || cfg.HTTP.RateBurst < 0 {
+		return fmt.Errorf("rate limit and burst must not be negative")
+	}
+	if len(cfg.Seed.BBox) != 0 && len(cfg.Seed.BBox) != 4 {
+		return fmt.Errorf("seed bbox must be minlon,minlat,maxlon,maxlat")
+	}
+	return nil
+}
+
+// parseColor parses transparent or a hex color in the form rrggbb or
+// rrggbbaa, optionally prefixed with #.
+func parseColor(s string) (color.Color, error) {
+	if s == "transparent" {
+		return color.Transparent, nil
+	}
+	hex := strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	switch {
+	case err != nil:
+	case len(hex) == 6:
+		return color.NRGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xff}, nil
+	case len(hex) == 8:
+		return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+	}
+	return nil, fmt.Errorf("invalid color %q, must be rrggbb, rrggbbaa or transparent", s)
+}
+
+var demModes = map[string]DEMMode{
+	"":            Hillshade,
+	"hillshade":   Hillshade,
+	"terrain-rgb": TerrainRGB,
+}
+
+// demRenderer returns the DEMRenderer of a DEM layer.
+func (l LayerFileConfig) demRenderer() *DEMRenderer {
+	grid := l.Grid
+	if l.GridName != "" {
+		grid = tilegrid.Named(l.GridName)
+	}
+	return &DEMRenderer{
+		File:         l.DEM,
+		Mode:         demModes[l.DEMMode],
+		Azimuth:      l.HillshadeAzimuth,
+		Altitude:     l.HillshadeAltitude,
+		Exaggeration: l.HillshadeExaggeration,
+		Grid:         grid,
+	}
+}
+
+// remoteRenderer returns the RemoteRenderer of a layer with render workers.
+func (l LayerFileConfig) remoteRenderer() (*RemoteRenderer, error) {
+	r := &RemoteRenderer{Workers: l.RenderWorkers, Layer: l.Name, Secret: l.RenderWorkerSecret, Timeout: l.RenderWorkerTimeout}
+	if l.RenderWorkerCert == "" && l.RenderWorkerCA == "" {
+		return r, nil
+	}
+	r.TLSConfig = &tls.Config{}
+	if l.RenderWorkerCert != "" {
+		cert, err := tls.LoadX509KeyPair(l.RenderWorkerCert, l.RenderWorkerKey)
+		if err != nil {
+			return nil, err
+		}
+		r.TLSConfig.Certificates = []tls.Certificate{cert}
+	}
+	if l.RenderWorkerCA != "" {
+		pem, err := ioutil.ReadFile(l.RenderWorkerCA)
+		if err != nil {
+			return nil, err
+		}
+		r.TLSConfig.RootCAs = x509.NewCertPool()
+		if !r.TLSConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %v", l.RenderWorkerCA)
+		}
+	}
+	return r, nil
+}
+
+var corners = map[string]Corner{
+	"":             BottomRight,
+	"bottom-right": BottomRight,
+	"bottom-left":  BottomLeft,
+	"top-right":    TopRight,
+	"top-left":     TopLeft,
+}
+
+func (l LayerFileConfig) pngOptions() PNGOptions {
+	return PNGOptions{
+		Compression: l.PNGCompression,
+		Strategy:    l.PNGStrategy,
+		TransMode:   l.PNGTransMode,
+		Colors:      l.PNGColors,
+		TrueColor:   l.PNGTrueColor,
+	}
+}
+
+func (l LayerFileConfig) layerConfig() LayerConfig {
+	var fallback Renderer
+	if l.Fallback != "" {
+		fallback = &ProxySource{URL: l.Fallback}
+	}
+	grid := l.Grid
+	if l.GridName != "" {
+		grid = tilegrid.Named(l.GridName)
+	}
+	var bounds [4]float64
+	copy(bounds[:], l.Bounds)
+	outOfRange := OutOfRangeNotFound
+	switch l.OutOfRange {
+	case "204":
+		outOfRange = OutOfRangeNoContent
+	case "blank":
+		outOfRange = OutOfRangeBlank
+	}
+	var watermark *Watermark
+	if l.Watermark != "" || l.WatermarkLogo != "" {
+		watermark = &Watermark{
+			Text:    l.Watermark,
+			Logo:    l.WatermarkLogo,
+			Corner:  corners[l.WatermarkCorner],
+			Opacity: l.WatermarkOpacity,
+		}
+	}
+	var background color.Color
+	if l.Background != "" {
+		background, _ = parseColor(l.Background)
+	}
+	formats := l.Formats
+	if len(formats) == 0 && l.Format != "" {
+		formats = []string{l.Format}
+	}
+	return LayerConfig{
+		Name: l.Name,
+		RendererConfig: RendererConfig{
+			Stylesheet:       l.Stylesheet,
+			Params:           l.Params,
+			BufferSize:       l.BufferSize,
+			Grid:             grid,
+			Watermark:        watermark,
+			DisableLayers:    l.DisableLayers,
+			DatasourceParams: l.DatasourceParams,
+			Background:       background,
+			PNG:              l.pngOptions(),
+			Composite:        l.Composite,
+		},
+		MetaTileSize:  l.MetaTileSize,
+		Attribution:   l.Attribution,
+		TTL:           l.TTL,
+		MinZoom:       l.MinZoom,
+		MaxZoom:       l.MaxZoom,
+		Bounds:        bounds,
+		OutOfRange:    outOfRange,
+		Overzoom:      l.Overzoom,
+		CacheOverzoom: l.CacheOverzoom,
+		Isolate:       l.Isolate,
+		Fallback:      fallback,
+		Formats:       formats,
+		Variables:     l.Variables,
+	}
+}
+
+// NewTileServerFromConfig creates a tile server from a configuration file
+// and adds its layers. The layers are reloaded from the file when the
+// process receives SIGHUP, see ReloadConfig.
+func NewTileServerFromConfig(path string) (*TileServer, error) {
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	tsCfg := TileServerConfig{
+		CacheFile:         cfg.Cache.File,
+		PruneInterval:     cfg.Cache.PruneInterval,
+		MaxCacheBytes:     cfg.Cache.MaxBytes,
+		MaxCacheTiles:     cfg.Cache.MaxTiles,
+		NumRenderers:      cfg.NumRenderers,
+		MetaTileSize:      cfg.MetaTileSize,
+		FailureTTL:        cfg.FailureTTL,
+		BreakerThreshold:  cfg.BreakerThreshold,
+		BreakerCooldown:   cfg.BreakerCooldown,
+		ErrorTiles:        cfg.ErrorTiles || cfg.ErrorTile != "",
+		Heatmap:           cfg.Heatmap,
+		HeatmapZoom:       cfg.HeatmapZoom,
+		Prefetch:          cfg.Prefetch,
+		PrefetchQueue:     cfg.PrefetchQueue,
+		PrefetchWorkers:   cfg.PrefetchWorkers,
+		RateLimit:         cfg.HTTP.RateLimit,
+		RateBurst:         cfg.HTTP.RateBurst,
+		TrustForwardedFor: cfg.HTTP.TrustForwardedFor,
+		TrustedProxies:    cfg.HTTP.TrustedProxies,
+		TrustUnixSocket:   cfg.HTTP.TrustUnixSocket,
+		AllowIPs:          cfg.HTTP.AllowIPs,
+		DenyIPs:           cfg.HTTP.DenyIPs,
+		AllowedReferers:   cfg.HTTP.AllowedReferers,
+		AllowEmptyReferer: cfg.HTTP.AllowEmptyReferer,
+		RefererBypass:     cfg.HTTP.RefererBypass,
+		MaxAge:            cfg.HTTP.MaxAge,
+		BundleRenderLimit: cfg.HTTP.BundleRenderLimit,
+		RenderLimit: RenderLimit{
+			MaxConcurrent: cfg.MaxConcurrentRenders,
+			Reject:        cfg.RejectBusyRenders,
+			MaxWait:       cfg.RenderQueueTimeout,
+		},
+		Watchdog: Watchdog{
+			MaxTiles:    cfg.RendererMaxTiles,
+			HangTimeout: cfg.RendererHangTimeout,
+		},
+	}
+	if cfg.ErrorTile != "" {
+		tsCfg.ErrorTile, err = ioutil.ReadFile(cfg.ErrorTile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Cache.File == "" && cfg.Cache.Dir != "" {
+		tsCfg.Cache = &DirCache{Dir: cfg.Cache.Dir}
+	}
+	t, err := NewTileServer(tsCfg)
+	if err != nil {
+		return nil, err
+	}
+	t.TmsSchema = cfg.HTTP.Tms
+	if cfg.HTTP.APIKeys != "" {
+		keys, err := LoadAPIKeyFile(cfg.HTTP.APIKeys)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.APIKeys = keys
+	}
+	if cfg.HTTP.JWTSecret != "" || cfg.HTTP.JWTPublicKey != "" {
+		t.JWT = &JWTAuth{
+			Secret:         []byte(cfg.HTTP.JWTSecret),
+			LayersClaim:    cfg.HTTP.JWTLayersClaim,
+			Issuer:         cfg.HTTP.JWTIssuer,
+			Audience:       cfg.HTTP.JWTAudience,
+			AllowAllLayers: cfg.HTTP.JWTAllowAllLayers,
+		}
+		if cfg.HTTP.JWTPublicKey != "" {
+			if t.JWT.PublicKey, err = LoadRSAPublicKey(cfg.HTTP.JWTPublicKey); err != nil {
+				t.Close()
+				return nil, err
+			}
+		}
+	}
+	if t.APIKeys != nil || t.JWT != nil {
+		t.Usage = NewMemoryUsage()
+	}
+	if cfg.Peers.Self != "" {
+		t.Peers = &PeerCache{
+			Self:     cfg.Peers.Self,
+			Peers:    cfg.Peers.URLs,
+			Secret:   cfg.Peers.Secret,
+			MaxBytes: cfg.Peers.CacheBytes,
+		}
+	}
+	t.configPath = path
+	if err := t.applyConfig(cfg); err != nil {
+		t.Close()
+		return nil, err
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	t.cfgMx.Lock()
+	t.hup = hup
+	t.cfgMx.Unlock()
+	go func() {
+		for range hup {
+			log.Println("reloading", path)
+			if err := t.ReloadConfig(); err != nil {
+				log.Println("error reloading configuration:", err)
+			}
+		}
+	}()
+	return t, nil
+}
+
+// ReloadConfig reads the configuration file again. Added and removed layers
+// are added and removed, and the stylesheets of all mapnik layers are
+// reloaded, as is the API key file. Changes to the cache and other HTTP
+// settings require a restart.
+func (t *TileServer) ReloadConfig() error {
+	if t.configPath == "" {
+		return fmt.Errorf("tile server was not created from a configuration file")
+	}
+	cfg, err := LoadConfig(t.configPath)
+	if err != nil {
+		return err
+	}
+	if keys, ok := t.APIKeys.(*APIKeyFile); ok {
+		if err := keys.Reload(); err != nil {
+			return err
+		}
+	}
+	if t.Peers != nil {
+		t.Peers.SetPeers(cfg.Peers.URLs...)
+	}
+	return t.applyConfig(cfg)
+}
+
+// applyConfig brings the layers in line with cfg. The sources of new and
+// changed layers are created first, and the stylesheets of mapnik layers
+// loaded, so if one fails, the layers are left as they were.
+func (t *TileServer) applyConfig(cfg *Config) error {
+	t.cfgMx.Lock()
+	defer t.cfgMx.Unlock()
+
+	current := t.config
+	if current == nil {
+		current = &Config{}
+	}
+	old := make(map[string]LayerFileConfig)
+	for _, l := range current.Layers {
+		old[l.Name] = l
+	}
+
+	var changes, discards []func()
+	for _, l := range cfg.Layers {
+		l := l
+		prev, known := old[l.Name]
+		delete(old, l.Name)
+		reload := known && prev.Stylesheet != "" && l.Stylesheet != ""
+		if known && !reload && reflect.DeepEqual(prev, l) {
+			continue
+		}
+		apply, discard, err := t.prepareLayer(l, reload)
+		if err != nil {
+			for _, discard := range discards {
+				discard()
+			}
+			return fmt.Errorf("layer %v: %v", l.Name, err)
+		}
+		if discard != nil {
+			discards = append(discards, discard)
+		}
+		changes = append(changes, func() {
+			if known && !reload {
+				t.RemoveLayer(l.Name)
+			}
+			apply()
+			t.SetLayerHeaders(l.Name, l.Headers)
+		})
+	}
+
+	for _, apply := range changes {
+		apply()
+	}
+	for name := range old {
+		t.RemoveLayer(name)
+	}
+	t.config = cfg
+	return nil
+}
+
+// prepareLayer creates the source of a layer without adding it. apply adds
+// the layer, or replaces the renderers of a reloaded mapnik layer, and
+// cannot fail; discard, if not nil, releases the source if apply is not
+// called.
+func (t *TileServer) prepareLayer(l LayerFileConfig, reload bool) (apply, discard func(), err error) {
+	switch {
+	case l.MBTiles != "":
+		src, err := OpenMBTilesSource(l.MBTiles)
+		if err != nil {
+			return nil, nil, err
+		}
+		return func() { t.addMBTilesSource(l.Name, src) }, func() { src.Close() }, nil
+	case l.Proxy != "":
+		return func() {
+			t.AddRenderer(l.Name, &ProxySource{URL: l.Proxy})
+			t.setVectorLayer(l.Name, l.Format == vectorFormat)
+		}, nil, nil
+	case len(l.RenderWorkers) > 0:
+		r, err := l.remoteRenderer()
+		if err != nil {
+			return nil, nil, err
+		}
+		return func() {
+			t.lmp.AddSource(l.Name, t.lmp.CreateSource(r, l.RenderWorkerConcurrency))
+		}, nil, nil
+	case l.Debug:
+		return func() { t.AddDebugLayer(l.Name) }, nil, nil
+	case l.DEM != "":
+		return func() { t.AddRenderer(l.Name, l.demRenderer()) }, nil, nil
+	}
+
+	cfg := l.layerConfig()
+	c, err := t.newLayerSource(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	discard = func() { close(c) }
+	if !reload {
+		return func() { t.installLayer(cfg, c, true) }, discard, nil
+	}
+	return func() {
+		mu := t.layerLock(l.Name)
+		mu.Lock()
+		defer mu.Unlock()
+		t.mu.Lock()
+		t.layers[l.Name] = cfg
+		t.mu.Unlock()
+		t.replaceRenderers(l.Name, c)
+		if db, ok := t.m.(*TileDb); ok {
+			db.SetLayerTTL(l.Name, l.TTL)
+		}
+	}, discard, nil
+}
+
+// ListenAndServe serves the tiles and, if an admin token is configured,
+// the AdminHandler on the address of the configuration file. If an admin
+// address is configured, the AdminHandler, /stats and the DebugHandler are
+// served on it instead and the tile address only serves tiles. If a debug
+// address is configured, the DebugHandler and /stats are served on it.
+// /stats is never served on the tile address.
+func (t *TileServer) ListenAndServe() error {
+	t.cfgMx.Lock()
+	var httpCfg HTTPConfig
+	if t.config != nil {
+		httpCfg = t.config.HTTP
+	}
+	t.cfgMx.Unlock()
+
+	addr := httpCfg.Listen
+	if addr == "" {
+		addr = ":8080"
+	}
+	stats := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.serveStats(w)
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/", t)
+	mux.Handle("/stats", http.NotFoundHandler())
+	adminMux := mux
+	if httpCfg.AdminListen != "" {
+		adminMux = http.NewServeMux()
+		adminMux.Handle("/stats", stats)
+		adminMux.Handle("/debug/", t.DebugHandler())
+	}
+	if httpCfg.AdminToken != "" {
+		admin := NewAdminHandler(t, httpCfg.AdminToken)
+		admin.StyleDir = httpCfg.AdminStyleDir
+		adminMux.Handle("/admin/", admin)
+	}
+
+	if err := checkSystemdNames(addr, httpCfg.AdminListen, httpCfg.DebugListen); err != nil {
+		return err
+	}
+	// all listeners are bound before serving, so a failing address
+	// closes the ones that were already opened
+	var listeners []net.Listener
+	listen := func(addr string) (net.Listener, error) {
+		l, err := ListenMode(addr, httpCfg.SocketMode)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+		return l, nil
+	}
+	l, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	var al, dl net.Listener
+	if httpCfg.AdminListen != "" {
+		if al, err = listen(httpCfg.AdminListen); err != nil {
+			return err
+		}
+	}
+	if httpCfg.DebugListen != "" {
+		if dl, err = listen(httpCfg.DebugListen); err != nil {
+			return err
+		}
+	}
+	serve := func(addr string, l net.Listener, h http.Handler) {
+		go func() {
+			log.Println("listener", addr+":", http.Serve(l, h))
+		}()
+	}
+	if al != nil {
+		serve(httpCfg.AdminListen, al, adminMux)
+	}
+	if dl != nil {
+		debugMux := http.NewServeMux()
+		debugMux.Handle("/stats", stats)
+		debugMux.Handle("/debug/", t.DebugHandler())
+		serve(httpCfg.DebugListen, dl, debugMux)
+	}
+	return http.Serve(l, mux)
+}
@@ -0,0 +1,92 @@
+package maptiles
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/nkovacs/go-mapnik/tilegrid"
+)
+
+// DebugRenderer renders transparent tiles showing the tile coordinates,
+// the bounding box and a border, to debug client configuration and seams
+// between tiles. Composite it over another layer to see the tile grid on
+// the map, e.g. by requesting the layer osm,debug.
+type DebugRenderer struct {
+	// Color is the color of the text and border. If nil, red is used.
+	Color color.Color
+}
+
+func (d DebugRenderer) RenderTile(c TileCoord) ([]byte, error) {
+	col := d.Color
+	if col == nil {
+		col = color.RGBA{0xff, 0, 0, 0xff}
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, 256, 256))
+	fg := image.NewUniform(col)
+	for _, r := range []image.Rectangle{
+		image.Rect(0, 0, 256, 1), image.Rect(0, 255, 256, 256),
+		image.Rect(0, 0, 1, 256), image.Rect(255, 0, 256, 256),
+	} {
+		draw.Draw(img, r, fg, image.Point{}, draw.Src)
+	}
+
+	xyz := c
+	xyz.SetTMS(false)
+	tms := c
+	tms.SetTMS(true)
+	lines := []string{
+		fmt.Sprintf("z/x/y %d/%d/%d", xyz.Zoom, xyz.X, xyz.Y),
+		fmt.Sprintf("tms y %d", tms.Y),
+	}
+	if c.Layer != "" {
+		lines = append(lines, "layer "+c.Layer)
+	}
+	if xyz.Valid() {
+		b := tilegrid.TileToBBox(tilegrid.Tile{X: xyz.X, Y: xyz.Y, Zoom: xyz.Zoom})
+		lines = append(lines,
+			fmt.Sprintf("n %.6f", b[3]),
+			fmt.Sprintf("w %.6f", b[0]),
+			fmt.Sprintf("s %.6f", b[1]),
+			fmt.Sprintf("e %.6f", b[2]),
+		)
+	}
+
+	face := basicfont.Face7x13
+	dr := font.Drawer{Dst: img, Src: fg, Face: face}
+	for i, line := range lines {
+		dr.Dot = fixed.P(6, 6+face.Ascent+i*face.Height)
+		dr.DrawString(line)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (d DebugRenderer) RenderMetaTile(c MetaTileCoord) ([]TileFetchResult, error) {
+	coords := c.TileCoords()
+	results := make([]TileFetchResult, 0, len(coords))
+	for _, tc := range coords {
+		blob, err := d.RenderTile(tc)
+		results = append(results, TileFetchResult{Coord: tc, BlobPNG: blob, Error: err})
+	}
+	return results, nil
+}
+
+// AddDebugLayer adds an uncached layer rendered by a DebugRenderer.
+func (t *TileServer) AddDebugLayer(layerName string) {
+	t.mu.Lock()
+	t.uncached[layerName] = true
+	t.mu.Unlock()
+	t.AddRenderer(layerName, DebugRenderer{})
+}
@@ -0,0 +1,177 @@
+package maptiles
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+
+	"github.com/nkovacs/go-mapnik/mapnik"
+	"github.com/nkovacs/go-mapnik/tilegrid"
+)
+
+// DEMMode selects what a DEMRenderer produces.
+type DEMMode int
+
+const (
+	// Hillshade renders grayscale relief shading with transparent nodata.
+	Hillshade DEMMode = iota
+	// TerrainRGB encodes elevations as Mapbox Terrain-RGB, where the
+	// height in meters is -10000 + (R*256*256 + G*256 + B) * 0.1.
+	TerrainRGB
+)
+
+// DEMRenderer renders tiles from a digital elevation model, read with
+// mapnik's gdal input plugin. Add it with TileServer.AddRenderer to cache
+// its tiles like rendered ones. It is safe for concurrent use.
+type DEMRenderer struct {
+	// File is a raster readable by GDAL with elevations in meters. It must
+	// be in the SRS of Grid, e.g. reprojected with gdalwarp -t_srs
+	// EPSG:3857.
+	File string
+
+	// Band is the elevation band. If zero, 1 will be used.
+	Band int
+
+	Mode DEMMode
+
+	// Azimuth and Altitude are the direction and height of the light
+	// source in degrees. If zero, 315 and 45 will be used.
+	Azimuth, Altitude float64
+
+	// Exaggeration multiplies elevations for hillshading.
+	// If zero, 1 will be used.
+	Exaggeration float64
+
+	// Grid is the tile grid. If nil, Web Mercator tiles are rendered.
+	Grid *tilegrid.Grid
+}
+
+func (d *DEMRenderer) RenderTile(c TileCoord) ([]byte, error) {
+	c.SetTMS(false)
+	grid := d.Grid
+	if grid == nil {
+		grid = tilegrid.Named("EPSG:3857")
+	}
+	if !grid.Contains(tilegrid.Tile{X: c.X, Y: c.Y, Zoom: c.Zoom}) {
+		return nil, nil
+	}
+	band := d.Band
+	if band == 0 {
+		band = 1
+	}
+	size := int(grid.TilePixels())
+	e := grid.TileExtent(c.Zoom, c.X, c.Y, c.X, c.Y)
+	res := grid.Resolutions[c.Zoom]
+
+	if d.Mode == TerrainRGB {
+		values, err := mapnik.ReadRaster(d.File, band, e, size, size)
+		if err != nil {
+			return nil, err
+		}
+		return encodeTerrainRGB(values, size)
+	}
+
+	// one pixel around the tile for the slopes at the edges
+	e = [4]float64{e[0] - res, e[1] - res, e[2] + res, e[3] + res}
+	values, err := mapnik.ReadRaster(d.File, band, e, size+2, size+2)
+	if err != nil {
+		return nil, err
+	}
+	cellSize := res
+	if grid.MetersPerUnit != 0 {
+		cellSize *= grid.MetersPerUnit
+	}
+	if grid.Name == "EPSG:3857" {
+		// Web Mercator stretches distances by 1/cos(latitude)
+		y := (e[1] + e[3]) / 2
+		lat := math.Atan(math.Sinh(y / (tilegrid.EarthCircumference / (2 * math.Pi))))
+		cellSize *= math.Cos(lat)
+	}
+	return d.hillshade(values, size, cellSize)
+}
+
+func (d *DEMRenderer) RenderMetaTile(c MetaTileCoord) ([]TileFetchResult, error) {
+	coords := c.TileCoords()
+	results := make([]TileFetchResult, 0, len(coords))
+	for _, tc := range coords {
+		blob, err := d.RenderTile(tc)
+		results = append(results, TileFetchResult{Coord: tc, BlobPNG: blob, Error: err})
+	}
+	return results, nil
+}
+
+// hillshade shades the size x size center of values, which has a border
+// of one cell, with Horn's method.
+func (d *DEMRenderer) hillshade(values []float32, size int, cellSize float64) ([]byte, error) {
+	azimuth, altitude, exaggeration := d.Azimuth, d.Altitude, d.Exaggeration
+	if azimuth == 0 {
+		azimuth = 315
+	}
+	if altitude == 0 {
+		altitude = 45
+	}
+	if exaggeration == 0 {
+		exaggeration = 1
+	}
+	zenith := (90 - altitude) * math.Pi / 180
+	// azimuth is clockwise from north, the math angle counterclockwise from east
+	az := (360 - azimuth + 90) * math.Pi / 180
+
+	stride := size + 2
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	painted := false
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			v := func(dx, dy int) float64 {
+				f := float64(values[(y+1+dy)*stride+x+1+dx])
+				if math.IsNaN(f) {
+					// use the center for missing neighbours
+					f = float64(values[(y+1)*stride+x+1])
+				}
+				return f * exaggeration
+			}
+			if math.IsNaN(float64(values[(y+1)*stride+x+1])) {
+				continue
+			}
+			dzdx := ((v(1, -1) + 2*v(1, 0) + v(1, 1)) - (v(-1, -1) + 2*v(-1, 0) + v(-1, 1))) / (8 * cellSize)
+			dzdy := ((v(-1, 1) + 2*v(0, 1) + v(1, 1)) - (v(-1, -1) + 2*v(0, -1) + v(1, -1))) / (8 * cellSize)
+			slope := math.Atan(math.Hypot(dzdx, dzdy))
+			aspect := math.Atan2(dzdy, -dzdx)
+			shade := math.Cos(zenith)*math.Cos(slope) + math.Sin(zenith)*math.Sin(slope)*math.Cos(az-aspect)
+			g := uint8(math.Max(0, shade) * 255)
+			img.SetNRGBA(x, y, color.NRGBA{g, g, g, 0xff})
+			painted = true
+		}
+	}
+	if !painted {
+		return blankTile(uint64(size)), nil
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeTerrainRGB encodes elevations as Terrain-RGB. Cells without data
+// are encoded as 0 meters.
+func encodeTerrainRGB(values []float32, size int) ([]byte, error) {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for i, h := range values {
+		if math.IsNaN(float64(h)) {
+			h = 0
+		}
+		v := uint32(math.Max(0, math.Round((float64(h)+10000)*10)))
+		img.Pix[4*i] = uint8(v >> 16)
+		img.Pix[4*i+1] = uint8(v >> 8)
+		img.Pix[4*i+2] = uint8(v)
+		img.Pix[4*i+3] = 0xff
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,91 @@
+package maptiles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DirCache stores tiles as files in a {layer}/{z}/{x}/{y}.png hierarchy,
+// suitable for static hosting. Tiles of the default layer are stored in
+// a directory called default.
+type DirCache struct {
+	Dir string
+
+	// Tms stores y counted from the bottom.
+	Tms bool
+
+	// ReportError is called with errors writing tiles. NewTileServer sets
+	// it to report to TileServer.ReportError if it is nil.
+	ReportError ErrorReporter
+}
+
+func (d *DirCache) path(c TileCoord) string {
+	c.SetTMS(d.Tms)
+	return filepath.Join(d.Dir, c.CacheLayer(), fmt.Sprintf("%d/%d/%d.%s", c.Zoom, c.X, c.Y, tileExt(c.Format)))
+}
+
+func (d *DirCache) Fetch(c TileCoord) ([]byte, error) {
+	b, err := ioutil.ReadFile(d.path(c))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return b, err
+}
+
+func (d *DirCache) BatchInsert(tiles []TileFetchResult) {
+	for _, t := range tiles {
+		p := d.path(t.Coord)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			log.Println("error creating tile directory", err)
+			d.reportError(err, t.Coord)
+			continue
+		}
+		if err := ioutil.WriteFile(p, t.BlobPNG, 0644); err != nil {
+			log.Println("error writing tile", err)
+			d.reportError(err, t.Coord)
+		}
+	}
+}
+
+func (d *DirCache) reportError(err error, c TileCoord) {
+	if d.ReportError != nil {
+		d.ReportError(ErrorReport{Err: err, Layer: c.Layer, Coord: c, Stage: StageCacheWrite})
+	}
+}
+
+// PurgeLayer deletes the tiles of a layer, including those rendered with a
+// selection of mapnik layers, variables or another format.
+func (d *DirCache) PurgeLayer(layer string) error {
+	if layer == "" {
+		layer = "default"
+	}
+	entries, err := ioutil.ReadDir(d.Dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == layer || strings.HasPrefix(e.Name(), layer+"+") {
+			if err := os.RemoveAll(filepath.Join(d.Dir, e.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *DirCache) BatchCheckSince(coords []TileCoord, since time.Time) []bool {
+	results := make([]bool, len(coords))
+	for i, c := range coords {
+		fi, err := os.Stat(d.path(c))
+		results[i] = err == nil && !fi.ModTime().Before(since)
+	}
+	return results
+}
@@ -0,0 +1,49 @@
+package maptiles
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirCachePurgeLayer(t *testing.T) {
+	d := &DirCache{Dir: t.TempDir()}
+	coords := []TileCoord{
+		{Layer: "osm", Zoom: 1},
+		{Layer: "osm", Zoom: 1, Format: "webp"},
+		{Layer: "osm2", Zoom: 1},
+	}
+	var tiles []TileFetchResult
+	for _, c := range coords {
+		tiles = append(tiles, TileFetchResult{Coord: c, BlobPNG: []byte("tile")})
+	}
+	d.BatchInsert(tiles)
+
+	if err := d.PurgeLayer("osm"); err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range coords {
+		blob, err := d.Fetch(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if purged := blob == nil; purged != (i < 2) {
+			t.Errorf("%v: purged %v", c, purged)
+		}
+	}
+}
+
+func TestDirCacheReportError(t *testing.T) {
+	dir := t.TempDir()
+	// a file where the layer directory should be
+	if err := ioutil.WriteFile(filepath.Join(dir, "osm"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var reports []ErrorReport
+	d := &DirCache{Dir: dir, ReportError: func(e ErrorReport) { reports = append(reports, e) }}
+	c := TileCoord{Layer: "osm", Zoom: 1}
+	d.BatchInsert([]TileFetchResult{{Coord: c, BlobPNG: []byte("tile")}})
+	if len(reports) != 1 || reports[0].Stage != StageCacheWrite || reports[0].Coord != c {
+		t.Errorf("got reports %+v", reports)
+	}
+}
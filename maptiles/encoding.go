@@ -0,0 +1,158 @@
+package maptiles
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compressedTypes are the content types compressed by encodingWriter.
+// Images are compressed already.
+var compressedTypes = map[string]bool{
+	"application/json":       true,
+	"application/geo+json":   true,
+	"application/x-protobuf": true,
+	"text/csv":               true,
+}
+
+// vectorTileTypes are the content types of vector tiles from a remote
+// tile server, which ProxySource stores compressed.
+var vectorTileTypes = map[string]bool{
+	"application/x-protobuf":               true,
+	"application/vnd.mapbox-vector-tile":   true,
+	"application/vnd.mapbox-vector-tile.1": true,
+}
+
+// gzipBlob compresses a blob.
+func gzipBlob(blob []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(blob); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// acceptedEncoding returns gzip or deflate if the Accept-Encoding header
+// of the request allows it, preferring gzip, or an empty string.
+func acceptedEncoding(r *http.Request) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(strings.Join(r.Header.Values("Accept-Encoding"), ","), ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, p := range params[1:] {
+			if v := strings.TrimSpace(p); strings.HasPrefix(v, "q=") {
+				q, _ = strconv.ParseFloat(v[2:], 64)
+			}
+		}
+		accepted[coding] = q > 0
+	}
+	switch {
+	case accepted["gzip"]:
+		return "gzip"
+	case accepted["deflate"]:
+		return "deflate"
+	}
+	return ""
+}
+
+// encodingWriter compresses JSON, CSV and vector tile responses if the
+// client accepts it, and decompresses compressed vector tiles for clients
+// that do not accept gzip. Close must be called when the response is done.
+type encodingWriter struct {
+	http.ResponseWriter
+	r       *http.Request
+	started bool
+
+	// zw compresses the response
+	zw io.WriteCloser
+
+	// gunzip collects a compressed response to decompress it in Close
+	gunzip *bytes.Buffer
+}
+
+func newEncodingWriter(w http.ResponseWriter, r *http.Request) *encodingWriter {
+	return &encodingWriter{ResponseWriter: w, r: r}
+}
+
+func (e *encodingWriter) start(code int) {
+	e.started = true
+	h := e.Header()
+	ct, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	if !compressedTypes[ct] {
+		return
+	}
+	h.Add("Vary", "Accept-Encoding")
+	enc := acceptedEncoding(e.r)
+	ce := h.Get("Content-Encoding")
+	if etag := h.Get("ETag"); etag != "" && ce != enc && !strings.HasPrefix(etag, "W/") {
+		// the ETag is computed from the stored tile
+		h.Set("ETag", "W/"+etag)
+	}
+	// responses without body only get the headers
+	body := code != http.StatusNoContent && code != http.StatusNotModified
+	switch {
+	case ce == "gzip" && enc != "gzip":
+		h.Del("Content-Encoding")
+		h.Del("Content-Length")
+		if body {
+			e.gunzip = new(bytes.Buffer)
+		}
+	case ce == "" && enc == "gzip" && body:
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		e.zw = gzip.NewWriter(e.ResponseWriter)
+	case ce == "" && enc == "deflate" && body:
+		h.Set("Content-Encoding", "deflate")
+		h.Del("Content-Length")
+		e.zw = zlib.NewWriter(e.ResponseWriter)
+	}
+}
+
+func (e *encodingWriter) WriteHeader(code int) {
+	if !e.started {
+		e.start(code)
+	}
+	e.ResponseWriter.WriteHeader(code)
+}
+
+func (e *encodingWriter) Write(b []byte) (int, error) {
+	if !e.started {
+		e.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case e.gunzip != nil:
+		return e.gunzip.Write(b)
+	case e.zw != nil:
+		return e.zw.Write(b)
+	}
+	return e.ResponseWriter.Write(b)
+}
+
+// Close finishes the compressed or decompressed response.
+func (e *encodingWriter) Close() {
+	switch {
+	case e.gunzip != nil:
+		zr, err := gzip.NewReader(e.gunzip)
+		if err == nil {
+			_, err = io.Copy(e.ResponseWriter, zr)
+		}
+		if err != nil {
+			log.Println("Error decompressing response:", err)
+		}
+	case e.zw != nil:
+		if err := e.zw.Close(); err != nil {
+			log.Println(err)
+		}
+	}
+}
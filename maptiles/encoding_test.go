@@ -0,0 +1,31 @@
+package maptiles
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	tests := []struct {
+		accept   []string
+		encoding string
+	}{
+		{nil, ""},
+		{[]string{"gzip"}, "gzip"},
+		{[]string{"deflate, gzip;q=0.5"}, "gzip"},
+		{[]string{"GZIP"}, "gzip"},
+		{[]string{"gzip;q=0, deflate"}, "deflate"},
+		{[]string{"br", "deflate"}, "deflate"},
+		{[]string{"gzip; q=0"}, ""},
+		{[]string{"identity"}, ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		for _, a := range test.accept {
+			r.Header.Add("Accept-Encoding", a)
+		}
+		if e := acceptedEncoding(r); e != test.encoding {
+			t.Errorf("%q: got %q, want %q", test.accept, e, test.encoding)
+		}
+	}
+}
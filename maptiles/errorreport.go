@@ -0,0 +1,41 @@
+package maptiles
+
+// ErrorStage is the stage of serving a tile an error occurred in.
+type ErrorStage string
+
+const (
+	StageRender     ErrorStage = "render"
+	StageCacheRead  ErrorStage = "cache_read"
+	StageCacheWrite ErrorStage = "cache_write"
+	StageFallback   ErrorStage = "fallback"
+)
+
+// ErrorReport describes an error while serving a tile.
+type ErrorReport struct {
+	Err   error
+	Layer string
+	Coord TileCoord
+	Stage ErrorStage
+
+	// RequestID is the ID of the request the tile was served for, see
+	// RequestID. It is empty for background work like prefetching and
+	// cache writes.
+	RequestID string
+}
+
+// ErrorReporter receives the errors of render failures and cache
+// operations, e.g. to send them to Sentry or Rollbar. The errors are
+// logged as well. It is called synchronously and must not block.
+type ErrorReporter func(ErrorReport)
+
+// reportError passes an error to t.ReportError if it is set.
+func (t *TileServer) reportError(e ErrorReport) {
+	if t.ReportError != nil {
+		t.ReportError(e)
+	}
+}
+
+// tileError reports an error while serving tc.
+func (t *TileServer) tileError(err error, tc TileCoord, stage ErrorStage, requestID string) {
+	t.reportError(ErrorReport{Err: err, Layer: tc.Layer, Coord: tc, Stage: stage, RequestID: requestID})
+}
@@ -0,0 +1,79 @@
+package maptiles
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// errorTileChars is the number of characters that fit on a line of an
+// error tile.
+const errorTileChars = 34
+
+// ErrorTile returns a tile showing the message, used as a visible
+// placeholder for tiles that could not be rendered.
+func ErrorTile(msg string) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	red := image.NewUniform(color.RGBA{0xc0, 0, 0, 0xff})
+	draw.Draw(img, img.Bounds(), red, image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(2, 2, 254, 254), image.NewUniform(color.RGBA{0xff, 0xe8, 0xe8, 0xff}), image.Point{}, draw.Src)
+
+	face := basicfont.Face7x13
+	d := font.Drawer{Dst: img, Src: red, Face: face}
+	for i, line := range wrapText(msg, errorTileChars) {
+		if i >= 254/face.Height-1 {
+			break
+		}
+		d.Dot = fixed.P(8, 8+face.Ascent+i*face.Height)
+		d.DrawString(line)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// wrapText splits s into lines of at most n characters, breaking at
+// spaces where possible.
+func wrapText(s string, n int) []string {
+	var lines []string
+	for _, word := range strings.Fields(s) {
+		for len(word) > n {
+			lines = append(lines, word[:n])
+			word = word[n:]
+		}
+		if last := len(lines) - 1; last >= 0 && len(lines[last])+1+len(word) <= n {
+			lines[last] += " " + word
+		} else {
+			lines = append(lines, word)
+		}
+	}
+	return lines
+}
+
+// serveErrorTile answers a failed request with an error tile, if enabled
+// by TileServerConfig.ErrorTiles, and reports whether it did. The tile
+// is sent with status 200 so that clients display it, but must not be
+// cached.
+func (t *TileServer) serveErrorTile(w http.ResponseWriter, r *http.Request, msg string) bool {
+	if !t.errorTiles {
+		return false
+	}
+	blob := t.errorTile
+	if blob == nil {
+		blob = ErrorTile(msg)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	t.writeTile(w, r, blob, false)
+	return true
+}
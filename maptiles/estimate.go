@@ -0,0 +1,22 @@
+package maptiles
+
+// SeedEstimate is the amount of work a Seeder run would do.
+type SeedEstimate struct {
+	MetaTiles uint64
+	Tiles     uint64
+
+	// TilesPerZoom contains the number of tiles for each zoom level.
+	TilesPerZoom map[uint64]uint64
+}
+
+// Estimate computes the number of metatiles and tiles Run would render
+// without rendering anything.
+func (s *Seeder) Estimate() SeedEstimate {
+	e := SeedEstimate{TilesPerZoom: make(map[uint64]uint64)}
+	s.eachMetaTile(func(c MetaTileCoord) {
+		e.MetaTiles++
+		e.Tiles += c.Count()
+		e.TilesPerZoom[c.Zoom] += c.Count()
+	})
+	return e
+}
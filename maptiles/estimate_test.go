@@ -0,0 +1,28 @@
+package maptiles
+
+import (
+	"testing"
+
+	"github.com/nkovacs/go-mapnik/mapnik"
+)
+
+func TestEstimate(t *testing.T) {
+	s := Seeder{
+		LowLeft:  mapnik.Coord{X: -180, Y: -85},
+		UpRight:  mapnik.Coord{X: 180, Y: 85},
+		MinZoom:  0,
+		MaxZoom:  4,
+		MetaSize: 4,
+	}
+	e := s.Estimate()
+	// one metatile up to zoom level 2, then 2×2 and 4×4
+	if e.MetaTiles != 1+1+1+4+16 {
+		t.Errorf("got %d metatiles, want 23", e.MetaTiles)
+	}
+	if e.Tiles != 1+4+16+64+256 {
+		t.Errorf("got %d tiles, want 341", e.Tiles)
+	}
+	if e.TilesPerZoom[3] != 64 {
+		t.Errorf("got %d tiles at zoom level 3, want 64", e.TilesPerZoom[3])
+	}
+}
@@ -0,0 +1,129 @@
+package maptiles
+
+import (
+	"sync"
+	"time"
+)
+
+// evictionBatch is the number of tiles deleted at once when the cache is
+// over its size limit.
+const evictionBatch = 1000
+
+type accessKey struct {
+	layer   string
+	z, x, y uint64
+}
+
+// sizeLimits holds the cache size limits and the access times recorded
+// since the last eviction run. Access times are kept in memory and written
+// in one transaction to avoid a write for every fetched tile.
+type sizeLimits struct {
+	mu       sync.Mutex
+	maxBytes int64
+	maxTiles int64
+	accessed map[accessKey]int64
+}
+
+// SetSizeLimit limits the total size of the tile images and the number of
+// tiles. Zero means no limit. The limits are enforced by EnforceSizeLimit,
+// which evicts the least recently used tiles.
+func (m *TileDb) SetSizeLimit(maxBytes, maxTiles int64) {
+	m.limits.mu.Lock()
+	defer m.limits.mu.Unlock()
+	m.limits.maxBytes = maxBytes
+	m.limits.maxTiles = maxTiles
+	if maxBytes > 0 || maxTiles > 0 {
+		if m.limits.accessed == nil {
+			m.limits.accessed = make(map[accessKey]int64)
+		}
+	} else {
+		m.limits.accessed = nil
+	}
+}
+
+// recordAccess remembers the access time of a TMS tile if a limit is set.
+func (m *TileDb) recordAccess(layer string, z, x, y uint64) {
+	m.limits.mu.Lock()
+	defer m.limits.mu.Unlock()
+	if m.limits.accessed != nil {
+		m.limits.accessed[accessKey{layer, z, x, y}] = time.Now().Unix()
+	}
+}
+
+// EnforceSizeLimit evicts the least recently used tiles until the cache
+// is within its limits, and returns the number of evicted tiles.
+func (m *TileDb) EnforceSizeLimit() (int64, error) {
+	m.limits.mu.Lock()
+	maxBytes, maxTiles := m.limits.maxBytes, m.limits.maxTiles
+	accessed := m.limits.accessed
+	if accessed != nil {
+		m.limits.accessed = make(map[accessKey]int64)
+	}
+	m.limits.mu.Unlock()
+	if maxBytes <= 0 && maxTiles <= 0 {
+		return 0, nil
+	}
+
+	if err := m.flushAccessTimes(accessed); err != nil {
+		return 0, err
+	}
+
+	var evicted int64
+	for {
+		stats, err := m.Stats()
+		if err != nil {
+			return evicted, err
+		}
+		if (maxBytes <= 0 || stats.BlobBytes <= maxBytes) && (maxTiles <= 0 || stats.Tiles <= maxTiles) {
+			return evicted, nil
+		}
+		if stats.Tiles == 0 {
+			return evicted, nil
+		}
+
+		m.dbLock.Lock()
+		res, err := m.db.Exec(`
+			DELETE FROM layered_tiles WHERE rowid IN (
+				SELECT rowid FROM layered_tiles
+				ORDER BY COALESCE(accessed_at, checked_at, updated_at, 0)
+				LIMIT ?
+			)`, evictionBatch)
+		m.dbLock.Unlock()
+		if err != nil {
+			return evicted, err
+		}
+		n, _ := res.RowsAffected()
+		evicted += n
+
+		if _, err := m.DeleteOrphanedBlobs(); err != nil {
+			return evicted, err
+		}
+	}
+}
+
+func (m *TileDb) flushAccessTimes(accessed map[accessKey]int64) error {
+	if len(accessed) == 0 {
+		return nil
+	}
+	m.dbLock.Lock()
+	defer m.dbLock.Unlock()
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(`
+		UPDATE layered_tiles SET accessed_at=?
+		WHERE layer_id=(SELECT rowid FROM layers WHERE layer_name=?)
+			AND zoom_level=? AND tile_column=? AND tile_row=?`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for k, t := range accessed {
+		if _, err := stmt.Exec(t, k.layer, k.z, k.x, k.y); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
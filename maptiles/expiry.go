@@ -0,0 +1,125 @@
+package maptiles
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// SetLayerTTL sets the time after which tiles of a layer expire.
+// Expired tiles are treated as missing, so they are rendered again on the
+// next request. A zero ttl disables expiry.
+func (m *TileDb) SetLayerTTL(layer string, ttl time.Duration) {
+	m.ttlMx.Lock()
+	defer m.ttlMx.Unlock()
+	if ttl <= 0 {
+		delete(m.ttls, ttlKey(layer))
+		return
+	}
+	m.ttls[ttlKey(layer)] = ttl
+}
+
+// ttlKey returns the key of the TTL of a layer name or cache layer name,
+// so the TTL also applies to the layer's mapnik layer selections.
+func ttlKey(layer string) string {
+	layer = baseLayer(layer)
+	if layer == "" {
+		return "default"
+	}
+	return layer
+}
+
+func (m *TileDb) layerTTL(layer string) time.Duration {
+	m.ttlMx.RLock()
+	defer m.ttlMx.RUnlock()
+	return m.ttls[ttlKey(layer)]
+}
+
+// freshAfter returns the earliest render time a tile of the layer may have
+// to count as present: the later of since and the expiry cutoff.
+func (m *TileDb) freshAfter(layer string, since time.Time) time.Time {
+	ttl := m.layerTTL(layer)
+	if ttl == 0 {
+		return since
+	}
+	if cutoff := time.Now().Add(-ttl); cutoff.After(since) {
+		return cutoff
+	}
+	return since
+}
+
+// touch records that an unchanged tile was rendered again, so it does not
+// expire. Layers without TTL are left alone to avoid needless writes.
+func (m *TileDb) touch(tx *sql.Tx, layer string, layerID int, z, x, y uint64, now int64) error {
+	if m.layerTTL(layer) == 0 {
+		return nil
+	}
+	_, err := tx.Exec("UPDATE layered_tiles SET checked_at=? WHERE layer_id=? AND zoom_level=? AND tile_column=? AND tile_row=?", now, layerID, z, x, y)
+	return err
+}
+
+// PruneExpired deletes the expired tiles of all layers with a TTL and the
+// blobs no longer referenced by any tile. It returns the number of deleted tiles.
+func (m *TileDb) PruneExpired() (int64, error) {
+	m.ttlMx.RLock()
+	ttls := make(map[string]time.Duration, len(m.ttls))
+	for l, ttl := range m.ttls {
+		ttls[l] = ttl
+	}
+	m.ttlMx.RUnlock()
+
+	var deleted int64
+	m.dbLock.Lock()
+	for l, ttl := range ttls {
+		res, err := m.db.Exec(`
+			DELETE FROM layered_tiles
+			WHERE layer_id IN (SELECT rowid FROM layers WHERE layer_name=? OR substr(layer_name, 1, ?)=?)
+				AND COALESCE(checked_at, updated_at, 0) < ?`,
+			l, len(l)+1, l+"+", time.Now().Add(-ttl).Unix())
+		if err != nil {
+			m.dbLock.Unlock()
+			return deleted, err
+		}
+		n, _ := res.RowsAffected()
+		deleted += n
+	}
+	m.dbLock.Unlock()
+
+	if deleted == 0 {
+		return 0, nil
+	}
+	_, err := m.DeleteOrphanedBlobs()
+	return deleted, err
+}
+
+// StartJanitor runs PruneExpired and EnforceSizeLimit every interval
+// until the TileDb is closed.
+func (m *TileDb) StartJanitor(interval time.Duration) {
+	if m.janitorStop != nil {
+		return
+	}
+	m.janitorStop = make(chan bool)
+	go func(stop <-chan bool) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				n, err := m.PruneExpired()
+				if err != nil {
+					log.Println("error pruning expired tiles", err)
+				} else if n > 0 {
+					log.Println("pruned", n, "expired tiles")
+				}
+				n, err = m.EnforceSizeLimit()
+				if err != nil {
+					log.Println("error evicting tiles", err)
+				} else if n > 0 {
+					log.Println("evicted", n, "tiles")
+				}
+			case <-stop:
+				return
+			}
+		}
+	}(m.janitorStop)
+}
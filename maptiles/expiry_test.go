@@ -0,0 +1,22 @@
+package maptiles
+
+import "testing"
+
+func TestTTLKey(t *testing.T) {
+	tests := []struct {
+		coord TileCoord
+		name  string
+	}{
+		{TileCoord{}, ""},
+		{TileCoord{Layer: "osm"}, "osm"},
+		{TileCoord{Layer: "osm", MapLayers: "roads"}, "osm"},
+		{TileCoord{MapLayers: "roads", Format: "jpeg"}, "default"},
+	}
+	for _, test := range tests {
+		// the TTL is set with the layer name and looked up with the cache
+		// layer name of the tiles
+		if got, want := ttlKey(test.coord.CacheLayer()), ttlKey(test.name); got != want {
+			t.Errorf("%+v: got %q, want %q", test.coord, got, want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package maptiles
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/nkovacs/go-mapnik/tilegrid"
+)
+
+// mbtilesSchema creates the tables of a standalone MBTiles file.
+var mbtilesSchema = []string{
+	"CREATE TABLE metadata (name text, value text)",
+	"CREATE TABLE tiles (zoom_level integer, tile_column integer, tile_row integer, tile_data blob)",
+	"CREATE UNIQUE INDEX tile_index ON tiles (zoom_level, tile_column, tile_row)",
+}
+
+// ExportLayer writes the tiles of a layer to a standalone MBTiles 1.3 file
+// at outPath, e.g. for use by mobile SDKs. An existing file is replaced.
+func (m *TileDb) ExportLayer(layer, outPath string) error {
+	if err := os.Remove(outPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	out, err := sql.Open("sqlite3", outPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	for _, query := range mbtilesSchema {
+		if _, err := out.Exec(query); err != nil {
+			return err
+		}
+	}
+
+	layerFormat, err := m.GetMetadata(layerFormatKey(layer))
+	if err != nil {
+		return err
+	}
+	vector := layerFormat == vectorFormat
+
+	m.dbLock.RLock()
+	defer m.dbLock.RUnlock()
+
+	rows, err := m.db.Query(`
+		SELECT zoom_level, tile_column, tile_row, tile_data
+		FROM layered_tiles
+		JOIN tile_blobs ON tile_blobs.checksum = layered_tiles.checksum
+		WHERE layer_id=(SELECT rowid FROM layers WHERE layer_name=?)`, layer)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	tx, err := out.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare("INSERT INTO tiles VALUES(?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var count int
+	format := "png"
+	var minZoom, maxZoom uint64
+	// tile range at maxZoom, in TMS coordinates
+	var minX, minY, maxX, maxY uint64
+	for rows.Next() {
+		var z, x, y uint64
+		var data []byte
+		if err := rows.Scan(&z, &x, &y, &data); err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(z, x, y, data); err != nil {
+			return err
+		}
+		// blank tiles stay png in layers of other formats
+		if ext := blobExt(data, vector); ext != "png" {
+			format = ext
+		}
+		switch {
+		case count == 0 || z > maxZoom:
+			if count == 0 {
+				minZoom = z
+			}
+			maxZoom = z
+			minX, minY, maxX, maxY = x, y, x, y
+		case z == maxZoom:
+			if x < minX {
+				minX = x
+			}
+			if x > maxX {
+				maxX = x
+			}
+			if y < minY {
+				minY = y
+			}
+			if y > maxY {
+				maxY = y
+			}
+		}
+		if z < minZoom {
+			minZoom = z
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("layer %v has no tiles", layer)
+	}
+
+	// convert the TMS tile range to WGS84 bounds
+	ll := tilegrid.FromPixelToLL([2]float64{float64(minX) * 256, float64((uint64(1)<<maxZoom)-minY) * 256}, maxZoom)
+	ur := tilegrid.FromPixelToLL([2]float64{float64(maxX+1) * 256, float64((uint64(1)<<maxZoom)-maxY-1) * 256}, maxZoom)
+	bounds := fmt.Sprintf("%f,%f,%f,%f", ll[0], ll[1], ur[0], ur[1])
+	center := fmt.Sprintf("%f,%f,%d", (ll[0]+ur[0])/2, (ll[1]+ur[1])/2, minZoom)
+
+	metadata := [][2]string{
+		{"name", layer},
+		{"format", format},
+		{"type", "overlay"},
+		{"version", "1.3"},
+		{"bounds", bounds},
+		{"center", center},
+		{"minzoom", strconv.FormatUint(minZoom, 10)},
+		{"maxzoom", strconv.FormatUint(maxZoom, 10)},
+	}
+	for _, md := range metadata {
+		if _, err := tx.Exec("INSERT INTO metadata VALUES(?, ?)", md[0], md[1]); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,63 @@
+package maptiles
+
+import (
+	"sync"
+	"time"
+)
+
+// failureCache remembers tiles whose rendering failed, so they are not
+// rendered again until the entry expires.
+type failureCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[TileCoord]time.Time // tile -> expiry
+	inserts int
+}
+
+func newFailureCache(ttl time.Duration) *failureCache {
+	return &failureCache{
+		ttl:     ttl,
+		entries: make(map[TileCoord]time.Time),
+	}
+}
+
+func failureKey(c TileCoord) TileCoord {
+	c.SetTMS(false)
+	return c
+}
+
+// check returns the time left until the tile may be rendered again,
+// or zero if it is not in the cache.
+func (f *failureCache) check(c TileCoord) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := failureKey(c)
+	expiry, ok := f.entries[key]
+	if !ok {
+		return 0
+	}
+	left := time.Until(expiry)
+	if left <= 0 {
+		delete(f.entries, key)
+		return 0
+	}
+	return left
+}
+
+func (f *failureCache) add(c TileCoord) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	f.entries[failureKey(c)] = now.Add(f.ttl)
+
+	// drop expired entries from time to time so the map does not grow
+	// with tiles that are never requested again
+	f.inserts++
+	if f.inserts%1000 == 0 {
+		for k, expiry := range f.entries {
+			if expiry.Before(now) {
+				delete(f.entries, k)
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package maptiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/nkovacs/go-mapnik/mapnik"
+)
+
+// FeatureInfoRequest asks a renderer for the features at pixel I, J of a
+// tile. The result is sent to OutChan.
+type FeatureInfoRequest struct {
+	Coord   TileCoord
+	I, J    uint64
+	OutChan chan<- FeatureInfoResult
+}
+
+// FeatureInfoResult contains the features found for a FeatureInfoRequest.
+type FeatureInfoResult struct {
+	Features []mapnik.Feature
+	Error    error
+}
+
+func (r FeatureInfoRequest) IsMetaTile() bool {
+	return false
+}
+
+func (r FeatureInfoRequest) GetCoord() TileCoord {
+	return r.Coord
+}
+
+func (r FeatureInfoRequest) GetLayer() string {
+	return r.Coord.Layer
+}
+
+func (r FeatureInfoRequest) GetMetaCoord() MetaTileCoord {
+	panic("GetMetaCoord called on FeatureInfoRequest")
+}
+
+// GetOutChan returns nil, the result is sent to OutChan.
+func (r FeatureInfoRequest) GetOutChan() chan<- TileFetchResult {
+	return nil
+}
+
+func (r FeatureInfoRequest) GetRequestID() string {
+	return ""
+}
+
+// FeatureQuerier is implemented by renderers that can look up the
+// features under a pixel of a tile, like TileRenderer.
+type FeatureQuerier interface {
+	QueryFeatures(c TileCoord, i, j uint64) ([]mapnik.Feature, error)
+}
+
+func processFeatureInfo(t Renderer, r FeatureInfoRequest) {
+	var result FeatureInfoResult
+	q, ok := t.(FeatureQuerier)
+	if !ok {
+		result.Error = fmt.Errorf("layer %v does not support feature queries", r.Coord.Layer)
+	} else {
+		result.Features, result.Error = q.QueryFeatures(r.Coord, r.I, r.J)
+	}
+	r.OutChan <- result
+}
+
+// QueryFeatures returns the features of the active mapnik layers, or of
+// c.MapLayers, at pixel i, j of the tile.
+func (t *TileRenderer) QueryFeatures(c TileCoord, i, j uint64) ([]mapnik.Feature, error) {
+	c.SetTMS(false)
+	restore, err := t.selectLayers(c.MapLayers)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+	size := t.tileSize()
+	if err := t.zoomToTiles(c.Zoom, c.X, c.Y, size, size, 1, 1); err != nil {
+		return nil, err
+	}
+	return t.m.QueryPoint(float64(i)+0.5, float64(j)+0.5)
+}
+
+var featureInfoRegex = regexp.MustCompile(`^/([A-Za-z0-9]+)/([0-9]+)/([0-9]+)/([0-9]+)/([0-9]+)/([0-9]+)/info\.json$`)
+
+// serveFeatureInfo answers /{layer}/{z}/{x}/{y}/{i}/{j}/info.json requests
+// with the attributes of the features at pixel i, j of the tile as JSON.
+func (t *TileServer) serveFeatureInfo(w http.ResponseWriter, r *http.Request, m []string) {
+	z, _ := strconv.ParseUint(m[2], 10, 64)
+	x, _ := strconv.ParseUint(m[3], 10, 64)
+	y, _ := strconv.ParseUint(m[4], 10, 64)
+	i, _ := strconv.ParseUint(m[5], 10, 64)
+	j, _ := strconv.ParseUint(m[6], 10, 64)
+	tc := TileCoord{X: x, Y: y, Zoom: z, Tms: t.TmsSchema, Layer: m[1]}
+
+	t.mu.RLock()
+	cfg, ok := t.layers[tc.Layer]
+	t.mu.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	size := uint64(256)
+	if cfg.Grid != nil {
+		size = cfg.Grid.TilePixels()
+	}
+	if !cfg.validTile(tc) || i >= size || j >= size {
+		http.Error(w, "tile coordinates out of range", http.StatusBadRequest)
+		return
+	}
+	tc.MapLayers = mapLayersParam(r)
+
+	ch := make(chan FeatureInfoResult)
+	if !t.lmp.SubmitRequest(FeatureInfoRequest{tc, i, j, ch}) {
+		http.NotFound(w, r)
+		return
+	}
+	result := <-ch
+	if result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+	features := result.Features
+	if features == nil {
+		features = []mapnik.Feature{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"features": features}); err != nil {
+		log.Println(err)
+	}
+}
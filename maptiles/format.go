@@ -0,0 +1,141 @@
+package maptiles
+
+import (
+	"image"
+	"image/draw"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/nkovacs/go-mapnik/mapnik"
+)
+
+// tileFormats maps the supported tile formats to their content type.
+var tileFormats = map[string]string{
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"webp": "image/webp",
+}
+
+// mapnikFormats are the mapnik format strings of the formats other than
+// png, which is encoded with the PNGOptions of the layer.
+var mapnikFormats = map[string]string{
+	"jpeg": "jpeg85",
+	"webp": "webp",
+}
+
+// vectorFormat is the format of layers serving gzip compressed vector
+// tiles, like in MBTiles files, see TileServer.vectorLayer.
+const vectorFormat = "pbf"
+
+// tileExt returns the file extension of tiles in format.
+func tileExt(format string) string {
+	switch format {
+	case "jpeg":
+		return "jpg"
+	case "webp":
+		return "webp"
+	case vectorFormat:
+		return "pbf"
+	}
+	return "png"
+}
+
+// tileContentType returns the content type of an encoded tile. vector
+// tells whether the tile is a compressed vector tile, the image formats
+// are detected from the tile.
+func tileContentType(blob []byte, vector bool) string {
+	if vector {
+		return "application/x-protobuf"
+	}
+	switch ct := http.DetectContentType(blob); ct {
+	case "image/jpeg", "image/webp":
+		return ct
+	}
+	return "image/png"
+}
+
+// blobExt returns the file extension of an encoded tile, see
+// tileContentType.
+func blobExt(blob []byte, vector bool) string {
+	switch tileContentType(blob, vector) {
+	case "application/x-protobuf":
+		return "pbf"
+	case "image/jpeg":
+		return "jpg"
+	case "image/webp":
+		return "webp"
+	}
+	return "png"
+}
+
+// negotiateFormat chooses the first of formats the Accept header of the
+// request names explicitly. WebP is only sent to clients naming it, other
+// clients get the first other format. png is returned as the empty
+// TileCoord.Format.
+func negotiateFormat(r *http.Request, formats []string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(strings.Join(r.Header.Values("Accept"), ","), ",") {
+		mt, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q <= 0 {
+			continue
+		}
+		accepted[mt] = true
+	}
+	format := ""
+	for _, f := range formats {
+		if accepted[tileFormats[f]] {
+			format = f
+			break
+		}
+	}
+	if format == "" {
+		return defaultFormat(formats)
+	}
+	if format == "png" {
+		return ""
+	}
+	return format
+}
+
+// defaultFormat returns the format of formats sent to clients that don't
+// name any of them: the first one other than WebP. png is returned as the
+// empty TileCoord.Format.
+func defaultFormat(formats []string) string {
+	format := ""
+	for _, f := range formats {
+		if f != "webp" {
+			format = f
+			break
+		}
+	}
+	if format == "" && len(formats) > 0 {
+		format = formats[0]
+	}
+	if format == "png" {
+		return ""
+	}
+	return format
+}
+
+// encodeImage encodes img in format with mapnik.
+func encodeImage(img image.Image, format string) ([]byte, error) {
+	return encodeMapnik(img, mapnikFormats[format])
+}
+
+// encodeMapnik encodes img with mapnik in a mapnik format string like
+// png8:z=1 or jpeg85.
+func encodeMapnik(img image.Image, format string) ([]byte, error) {
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok {
+		nrgba = image.NewNRGBA(img.Bounds())
+		draw.Draw(nrgba, nrgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	}
+	mi := mapnik.NewImage(nrgba)
+	defer mi.Free()
+	return mi.Encode(format)
+}
@@ -0,0 +1,35 @@
+package maptiles
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		formats []string
+		accept  string
+		format  string
+	}{
+		{nil, "", ""},
+		{[]string{"png", "jpeg"}, "", ""},
+		{[]string{"jpeg", "png"}, "image/png", ""},
+		{[]string{"jpeg", "png"}, "", "jpeg"},
+		{[]string{"webp", "jpeg"}, "image/webp,*/*", "webp"},
+		{[]string{"webp", "jpeg"}, "*/*", "jpeg"},
+		{[]string{"webp"}, "", "webp"},
+		{[]string{"webp", "png"}, "image/webp;q=0", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		if f := negotiateFormat(r, test.formats); f != test.format {
+			t.Errorf("%v, %q: got %q, want %q", test.formats, test.accept, f, test.format)
+		}
+	}
+	if ext := tileExt(defaultFormat([]string{"webp", "jpeg"})); ext != "jpg" {
+		t.Errorf("got metadata format %q, want jpg", ext)
+	}
+}
@@ -3,6 +3,7 @@ package maptiles
 import (
 	"fmt"
 	"github.com/nkovacs/go-mapnik/mapnik"
+	"github.com/nkovacs/go-mapnik/tilegrid"
 	"io/ioutil"
 	"log"
 	"os"
@@ -30,31 +31,44 @@ func (g *Generator) Run(lowLeft, upRight mapnik.Coord, minZ, maxZ uint64, name s
 
 	ensureDirExists(g.TileDir)
 
+	renderers := make([]chan<- FetchRequest, 0, g.Threads)
 	for i := 0; i < g.Threads; i++ {
-		go func(id int, ctc <-chan TileCoord, q chan bool) {
-			requests := NewTileRendererChan(g.MapFile)
+		requests, err := NewTileRendererChan(g.MapFile)
+		if err != nil {
+			log.Println("error starting job", name, ":", err)
+			for _, r := range renderers {
+				close(r)
+			}
+			return
+		}
+		renderers = append(renderers, requests)
+	}
+
+	for i := 0; i < g.Threads; i++ {
+		go func(id int, requests chan<- FetchRequest, ctc <-chan TileCoord, q chan bool) {
+			defer close(requests)
 			results := make(chan TileFetchResult)
 			for t := range ctc {
-				requests <- TileFetchRequest{t, results}
+				requests <- TileFetchRequest{Coord: t, OutChan: results}
 				r := <-results
 				ioutil.WriteFile(r.Coord.OSMFilename(), r.BlobPNG, 0644)
 			}
 			q <- true
-		}(i, c, q)
+		}(i, renderers[i], c, q)
 	}
 
 	ll0 := [2]float64{lowLeft.X, upRight.Y}
 	ll1 := [2]float64{upRight.X, lowLeft.Y}
 
 	for z := minZ; z <= maxZ; z++ {
-		px0 := fromLLtoPixel(ll0, z)
-		px1 := fromLLtoPixel(ll1, z)
+		px0 := tilegrid.FromLLToPixel(ll0, z)
+		px1 := tilegrid.FromLLToPixel(ll1, z)
 
 		ensureDirExists(fmt.Sprintf("%d", z))
 		for x := uint64(px0[0] / 256.0); x <= uint64(px1[0]/256.0); x++ {
 			ensureDirExists(fmt.Sprintf("%d/%d", z, x))
 			for y := uint64(px0[1] / 256.0); y <= uint64(px1[1]/256.0); y++ {
-				c <- TileCoord{x, y, z, false, ""}
+				c <- TileCoord{X: x, Y: y, Zoom: z}
 			}
 		}
 	}
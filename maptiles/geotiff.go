@@ -0,0 +1,203 @@
+package maptiles
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TIFF field types.
+const (
+	tiffShort  = 3
+	tiffLong   = 4
+	tiffASCII  = 2
+	tiffDouble = 12
+)
+
+// GeoTIFF keys, see the GeoTIFF 1.0 specification.
+const (
+	gtModelTypeGeoKey     = 1024
+	gtRasterTypeGeoKey    = 1025
+	gtCitationGeoKey      = 1026
+	geographicTypeGeoKey  = 2048
+	projectedCSTypeGeoKey = 3072
+	modelTypeProjected    = 1
+	modelTypeGeographic   = 2
+	rasterPixelIsArea     = 1
+)
+
+var epsgCode = regexp.MustCompile(`(?i)epsg:(\d+)`)
+
+// srsEPSG returns the EPSG code of a mapnik SRS string, or 0 if it is not
+// known. Besides +init=epsg:n it recognizes the proj4 definitions of Web
+// Mercator and WGS84.
+func srsEPSG(srs string) int {
+	if m := epsgCode.FindStringSubmatch(srs); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return n
+	}
+	switch {
+	case strings.Contains(srs, "+proj=merc") && strings.Contains(srs, "+a=6378137") && strings.Contains(srs, "+b=6378137"):
+		return 3857
+	case strings.Contains(srs, "+proj=longlat") && strings.Contains(srs, "+datum=WGS84"):
+		return 4326
+	}
+	return 0
+}
+
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	data  []byte
+}
+
+func shorts(v ...uint16) []byte {
+	b := make([]byte, 2*len(v))
+	for i, s := range v {
+		binary.LittleEndian.PutUint16(b[2*i:], s)
+	}
+	return b
+}
+
+func longs(v ...uint32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, l := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], l)
+	}
+	return b
+}
+
+func doubles(v ...float64) []byte {
+	b := make([]byte, 8*len(v))
+	for i, d := range v {
+		binary.LittleEndian.PutUint64(b[8*i:], math.Float64bits(d))
+	}
+	return b
+}
+
+// EncodeGeoTIFF encodes img as an uncompressed RGBA GeoTIFF whose pixels
+// cover extent (minx, miny, maxx, maxy) in the reference system srs. It
+// fails if the EPSG code of srs is not known, see srsEPSG.
+func EncodeGeoTIFF(img image.Image, extent [4]float64, srs string) ([]byte, error) {
+	code := srsEPSG(srs)
+	if code <= 0 || code > math.MaxUint16 {
+		return nil, fmt.Errorf("geotiff: unknown SRS %q", srs)
+	}
+
+	b := img.Bounds()
+	nrgba, ok := img.(*image.NRGBA)
+	if !ok || nrgba.Stride != 4*b.Dx() {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(nrgba, nrgba.Bounds(), img, b.Min, draw.Src)
+	}
+	width, height := uint32(b.Dx()), uint32(b.Dy())
+
+	citation := srs + "|\x00"
+	keys := []uint16{1, 1, 0, 0}
+	addKey := func(id, location, count, value uint16) {
+		keys = append(keys, id, location, count, value)
+		keys[3]++
+	}
+	geographic := code >= 4000 && code < 5000
+	if geographic {
+		addKey(gtModelTypeGeoKey, 0, 1, modelTypeGeographic)
+	} else {
+		addKey(gtModelTypeGeoKey, 0, 1, modelTypeProjected)
+	}
+	addKey(gtRasterTypeGeoKey, 0, 1, rasterPixelIsArea)
+	addKey(gtCitationGeoKey, 34737, uint16(len(citation)-1), 0)
+	if geographic {
+		addKey(geographicTypeGeoKey, 0, 1, uint16(code))
+	} else {
+		addKey(projectedCSTypeGeoKey, 0, 1, uint16(code))
+	}
+
+	const headerSize = 8
+	pixels := nrgba.Pix
+	entries := []tiffEntry{
+		{256, tiffLong, 1, longs(width)},
+		{257, tiffLong, 1, longs(height)},
+		{258, tiffShort, 4, shorts(8, 8, 8, 8)},
+		// no compression
+		{259, tiffShort, 1, shorts(1)},
+		// RGB
+		{262, tiffShort, 1, shorts(2)},
+		{273, tiffLong, 1, longs(headerSize)},
+		{277, tiffShort, 1, shorts(4)},
+		{278, tiffLong, 1, longs(height)},
+		{279, tiffLong, 1, longs(uint32(len(pixels)))},
+		// chunky
+		{284, tiffShort, 1, shorts(1)},
+		// unassociated alpha
+		{338, tiffShort, 1, shorts(2)},
+		{33550, tiffDouble, 3, doubles(
+			(extent[2]-extent[0])/float64(width),
+			(extent[3]-extent[1])/float64(height),
+			0,
+		)},
+		{33922, tiffDouble, 6, doubles(0, 0, 0, extent[0], extent[3], 0)},
+		{34735, tiffShort, uint32(len(keys)), shorts(keys...)},
+		{34737, tiffASCII, uint32(len(citation)), []byte(citation)},
+	}
+
+	// layout: header, pixels, values that do not fit into entries, IFD
+	var extra bytes.Buffer
+	extraStart := headerSize + len(pixels)
+	if extraStart%2 != 0 {
+		extraStart++
+	}
+	offsets := make([]uint32, len(entries))
+	for i, e := range entries {
+		if len(e.data) > 4 {
+			offsets[i] = uint32(extraStart + extra.Len())
+			extra.Write(e.data)
+			if extra.Len()%2 != 0 {
+				extra.WriteByte(0)
+			}
+		}
+	}
+	ifdStart := extraStart + extra.Len()
+
+	var buf bytes.Buffer
+	buf.Grow(ifdStart + 2 + 12*len(entries) + 4)
+	buf.WriteString("II*\x00")
+	buf.Write(longs(uint32(ifdStart)))
+	buf.Write(pixels)
+	if len(pixels)%2 != 0 {
+		buf.WriteByte(0)
+	}
+	buf.Write(extra.Bytes())
+	buf.Write(shorts(uint16(len(entries))))
+	for i, e := range entries {
+		buf.Write(shorts(e.tag, e.typ))
+		buf.Write(longs(e.count))
+		if len(e.data) > 4 {
+			buf.Write(longs(offsets[i]))
+		} else {
+			var v [4]byte
+			copy(v[:], e.data)
+			buf.Write(v[:])
+		}
+	}
+	// no further IFDs
+	buf.Write(longs(0))
+	return buf.Bytes(), nil
+}
+
+// renderGeoTIFF renders the map at its current extent as a GeoTIFF.
+func (t *TileRenderer) renderGeoTIFF(scale float64) ([]byte, error) {
+	img, err := t.m.RenderImageScaled(scale)
+	if err != nil {
+		return nil, err
+	}
+	pixels := img.NRGBA()
+	img.Free()
+	return EncodeGeoTIFF(pixels, t.m.CurrentExtent(), t.m.SRS())
+}
@@ -0,0 +1,50 @@
+package maptiles
+
+import (
+	"encoding/binary"
+	"image"
+	"testing"
+)
+
+func TestSRSEPSG(t *testing.T) {
+	tests := []struct {
+		srs  string
+		code int
+	}{
+		{"+init=epsg:3857", 3857},
+		{"EPSG:25833", 25833},
+		{"+proj=merc +a=6378137 +b=6378137 +lat_ts=0.0 +lon_0=0.0 +x_0=0.0 +y_0=0 +k=1.0 +units=m +nadgrids=@null +wktext +no_defs +over", 3857},
+		{"+proj=longlat +ellps=WGS84 +datum=WGS84 +no_defs", 4326},
+		{"+proj=lcc +lat_1=49 +lat_2=46", 0},
+	}
+	for _, test := range tests {
+		if code := srsEPSG(test.srs); code != test.code {
+			t.Errorf("%s: got %d, want %d", test.srs, code, test.code)
+		}
+	}
+}
+
+func TestEncodeGeoTIFF(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	blob, err := EncodeGeoTIFF(img, [4]float64{0, 0, 4, 2}, "+init=epsg:3857")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(blob[:4]) != "II*\x00" {
+		t.Fatalf("got header %q", blob[:4])
+	}
+	ifd := binary.LittleEndian.Uint32(blob[4:])
+	n := binary.LittleEndian.Uint16(blob[ifd:])
+	tags := make(map[uint16]uint32)
+	for i := uint32(0); i < uint32(n); i++ {
+		e := blob[ifd+2+12*i:]
+		tags[binary.LittleEndian.Uint16(e)] = binary.LittleEndian.Uint32(e[8:])
+	}
+	if tags[256] != 4 || tags[257] != 2 || tags[279] != 4*2*4 {
+		t.Errorf("got width %d, height %d, %d bytes", tags[256], tags[257], tags[279])
+	}
+
+	if _, err := EncodeGeoTIFF(img, [4]float64{0, 0, 4, 2}, "+proj=lcc +lat_1=49 +lat_2=46"); err == nil {
+		t.Error("unknown SRS accepted")
+	}
+}
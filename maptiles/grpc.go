@@ -0,0 +1,295 @@
+package maptiles
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/nkovacs/go-mapnik/maptiles/renderpb"
+)
+
+// defaultMaxMetaTileSize is the largest metatile rendered by a
+// RenderService without MaxMetaTileSize.
+const defaultMaxMetaTileSize = 8
+
+// RenderService is a gRPC service rendering the layers of a LayerMultiplex
+// without caching, see renderpb/render.proto, so rendering can run on
+// separate machines and tile servers only cache and serve tiles. Register
+// it on a grpc.Server with Register, or create a server with NewServer.
+// RemoteRenderer is its client.
+type RenderService struct {
+	renderpb.UnimplementedRenderServer
+
+	lmp *LayerMultiplex
+
+	// Secret is a shared secret clients must send, see
+	// RemoteRenderer.Secret. If empty, clients are not authenticated by
+	// the service and should be authenticated with client certificates,
+	// i.e. with tls.RequireAndVerifyClientCert in the credentials of the
+	// server.
+	Secret string
+
+	// MaxMetaTileSize is the largest width and height of a metatile in
+	// tiles. If zero, 8 will be used.
+	MaxMetaTileSize uint64
+}
+
+// NewRenderService creates a render service for the layers of lmp.
+func NewRenderService(lmp *LayerMultiplex) *RenderService {
+	return &RenderService{lmp: lmp}
+}
+
+// RenderService returns a render service for the layers of the server.
+// Metatiles are limited to the largest configured metatile size.
+func (t *TileServer) RenderService() *RenderService {
+	s := NewRenderService(t.lmp)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	s.MaxMetaTileSize = t.metaTileSize
+	for _, cfg := range t.layers {
+		if cfg.MetaTileSize > s.MaxMetaTileSize {
+			s.MaxMetaTileSize = cfg.MetaTileSize
+		}
+	}
+	return s
+}
+
+// Register registers the render service and the standard gRPC health
+// service on srv. Use NewServer to also check the shared secret.
+func (s *RenderService) Register(srv *grpc.Server) {
+	renderpb.RegisterRenderServer(srv, s)
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+}
+
+// NewServer creates a gRPC server with the options, e.g. grpc.Creds,
+// serving the render service and checking Secret.
+func (s *RenderService) NewServer(opts ...grpc.ServerOption) *grpc.Server {
+	srv := grpc.NewServer(append(opts, grpc.UnaryInterceptor(s.authenticate))...)
+	s.Register(srv)
+	return srv
+}
+
+// authenticate rejects calls without the shared secret.
+func (s *RenderService) authenticate(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if s.Secret != "" {
+		md, _ := metadata.FromIncomingContext(ctx)
+		var token string
+		if auth := md.Get("authorization"); len(auth) > 0 {
+			token = strings.TrimPrefix(auth[0], "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.Secret)) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "invalid secret")
+		}
+	}
+	return handler(ctx, req)
+}
+
+// secretCredentials sends the shared secret of a RenderService.
+type secretCredentials string
+
+func (c secretCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(c)}, nil
+}
+
+// RequireTransportSecurity allows unencrypted HTTP/2 for workers in a
+// private network.
+func (c secretCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// incomingRequestID returns the request ID sent by the client, if valid.
+func incomingRequestID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ids := md.Get(RequestIDHeader); len(ids) > 0 && validRequestID(ids[0]) {
+		return ids[0]
+	}
+	return ""
+}
+
+// errNoLayer returns the error of requests for a layer without a source.
+func errNoLayer(layer string) error {
+	return status.Error(codes.NotFound, "no such layer "+layer)
+}
+
+// errBusy returns the error of requests rejected by a busy renderer.
+func errBusy() error {
+	return status.Error(codes.ResourceExhausted, ErrRenderBusy.Error())
+}
+
+func (s *RenderService) RenderTile(ctx context.Context, req *renderpb.TileCoord) (*renderpb.Tile, error) {
+	c := tileCoordFromProto(req)
+	if !c.Valid() {
+		return nil, status.Error(codes.InvalidArgument, "invalid tile")
+	}
+	ch := make(chan TileFetchResult, 1)
+	if !s.lmp.SubmitRequest(TileFetchRequest{c, ch, incomingRequestID(ctx)}) {
+		return nil, errNoLayer(c.Layer)
+	}
+	result := <-ch
+	if result.Error == ErrRenderBusy {
+		return nil, errBusy()
+	}
+	return tileToProto(result), nil
+}
+
+func (s *RenderService) RenderMetaTile(ctx context.Context, req *renderpb.MetaTileCoord) (*renderpb.MetaTile, error) {
+	c := metaTileCoordFromProto(req)
+	if !c.Valid() {
+		return nil, status.Error(codes.InvalidArgument, "invalid metatile")
+	}
+	max := s.MaxMetaTileSize
+	if max == 0 {
+		max = defaultMaxMetaTileSize
+	}
+	if c.MaxX-c.MinX >= max || c.MaxY-c.MinY >= max {
+		return nil, status.Errorf(codes.InvalidArgument, "metatiles are limited to %dx%d tiles", max, max)
+	}
+	ch := make(chan TileFetchResult, c.Count())
+	if !s.lmp.SubmitRequest(MetaTileFetchRequest{c, ch, incomingRequestID(ctx)}) {
+		return nil, errNoLayer(c.Layer)
+	}
+	var err error
+	reply := &renderpb.MetaTile{}
+	for n := uint64(0); n < c.Count(); n++ {
+		result := <-ch
+		if result.Error == ErrRenderBusy {
+			err = errBusy()
+		}
+		reply.Tiles = append(reply.Tiles, tileToProto(result))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (s *RenderService) RenderStatic(ctx context.Context, req *renderpb.StaticMap) (*renderpb.StaticImage, error) {
+	if len(req.GetBbox()) != 4 {
+		return nil, status.Error(codes.InvalidArgument, "bbox must have 4 values")
+	}
+	sm := StaticMapRequest{Layer: req.GetLayer(), Width: req.GetWidth(), Height: req.GetHeight(), Format: req.GetFormat()}
+	copy(sm.BBox[:], req.GetBbox())
+	if sm.Format == "" {
+		sm.Format = "png"
+	}
+	if _, ok := staticMapFormats[sm.Format]; !ok {
+		return nil, status.Error(codes.InvalidArgument, "unsupported format "+sm.Format)
+	}
+	if sm.Width == 0 || sm.Height == 0 || sm.Width > maxStaticMapSize || sm.Height > maxStaticMapSize {
+		return nil, status.Errorf(codes.InvalidArgument, "width and height must be between 1 and %d", maxStaticMapSize)
+	}
+	ch := make(chan TileFetchResult, 1)
+	sm.OutChan = ch
+	if !s.lmp.SubmitRequest(sm) {
+		return nil, errNoLayer(sm.Layer)
+	}
+	result := <-ch
+	if result.Error == ErrRenderBusy {
+		return nil, errBusy()
+	}
+	if result.Error != nil {
+		return nil, status.Error(codes.Unknown, result.Error.Error())
+	}
+	return &renderpb.StaticImage{Image: result.BlobPNG}, nil
+}
+
+func tileCoordToProto(c TileCoord) *renderpb.TileCoord {
+	return &renderpb.TileCoord{
+		X:         c.X,
+		Y:         c.Y,
+		Zoom:      c.Zoom,
+		Tms:       c.Tms,
+		Layer:     c.Layer,
+		MapLayers: c.MapLayers,
+		Variables: c.Variables,
+		Format:    c.Format,
+	}
+}
+
+func tileCoordFromProto(c *renderpb.TileCoord) TileCoord {
+	return TileCoord{
+		X:         c.GetX(),
+		Y:         c.GetY(),
+		Zoom:      c.GetZoom(),
+		Tms:       c.GetTms(),
+		Layer:     c.GetLayer(),
+		MapLayers: c.GetMapLayers(),
+		Variables: c.GetVariables(),
+		Format:    c.GetFormat(),
+	}
+}
+
+func metaTileCoordToProto(c MetaTileCoord) *renderpb.MetaTileCoord {
+	return &renderpb.MetaTileCoord{
+		MinX:      c.MinX,
+		MinY:      c.MinY,
+		MaxX:      c.MaxX,
+		MaxY:      c.MaxY,
+		Zoom:      c.Zoom,
+		Tms:       c.Tms,
+		Layer:     c.Layer,
+		MapLayers: c.MapLayers,
+		Variables: c.Variables,
+		Format:    c.Format,
+	}
+}
+
+func metaTileCoordFromProto(c *renderpb.MetaTileCoord) MetaTileCoord {
+	return MetaTileCoord{
+		MinX:      c.GetMinX(),
+		MinY:      c.GetMinY(),
+		MaxX:      c.GetMaxX(),
+		MaxY:      c.GetMaxY(),
+		Zoom:      c.GetZoom(),
+		Tms:       c.GetTms(),
+		Layer:     c.GetLayer(),
+		MapLayers: c.GetMapLayers(),
+		Variables: c.GetVariables(),
+		Format:    c.GetFormat(),
+	}
+}
+
+func tileToProto(r TileFetchResult) *renderpb.Tile {
+	t := &renderpb.Tile{Coord: tileCoordToProto(r.Coord), Png: r.BlobPNG}
+	if r.Error != nil {
+		t.Error = r.Error.Error()
+	}
+	if r.Stats != nil {
+		t.DurationNs = int64(r.Stats.Duration)
+		t.Renderer = r.Stats.Renderer
+	}
+	return t
+}
+
+func tileFromProto(t *renderpb.Tile) TileFetchResult {
+	r := TileFetchResult{Coord: tileCoordFromProto(t.GetCoord()), BlobPNG: t.GetPng()}
+	if t.GetError() != "" {
+		r.Error = errors.New(t.GetError())
+	}
+	if t.GetDurationNs() != 0 || t.GetRenderer() != "" {
+		r.Stats = &RenderStats{Duration: time.Duration(t.GetDurationNs()), Renderer: t.GetRenderer()}
+	}
+	return r
+}
+
+// checkHealth calls the standard gRPC health service of a worker.
+func checkHealth(ctx context.Context, conn *grpc.ClientConn) error {
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("health check: %v", resp.GetStatus())
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package maptiles
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/nkovacs/go-mapnik/maptiles/maptilestest"
+)
+
+// startRenderService serves the stub renderer as layer "default" on a
+// local port and returns the worker URL.
+func startRenderService(t *testing.T, secret string) string {
+	lmp := NewLayerMultiplex(1)
+	lmp.AddSource("default", lmp.CreateSource(maptilestest.StubRenderer{}, 1))
+	s := NewRenderService(lmp)
+	s.Secret = secret
+	s.MaxMetaTileSize = 2
+	srv := s.NewServer()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() {
+		srv.Stop()
+		lmp.Close()
+	})
+	return "http://" + l.Addr().String()
+}
+
+func TestRenderService(t *testing.T) {
+	url := startRenderService(t, "secret")
+
+	r := &RemoteRenderer{Workers: []string{url}, Secret: "secret"}
+	defer r.Close()
+	blob, err := r.RenderTile(TileCoord{Layer: "default", Zoom: 1, X: 1, Y: 0})
+	if err != nil || len(blob) == 0 {
+		t.Fatalf("got %d bytes, %v", len(blob), err)
+	}
+	results, err := r.RenderMetaTile(MetaTileCoord{Layer: "default", Zoom: 2, MaxX: 1, MaxY: 1})
+	if err != nil || len(results) != 4 {
+		t.Fatalf("got %d tiles, %v", len(results), err)
+	}
+	_, err = r.RenderMetaTile(MetaTileCoord{Layer: "default", Zoom: 2, MaxX: 2, MaxY: 2})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("oversized metatile: got %v, want InvalidArgument", err)
+	}
+	_, err = r.RenderTile(TileCoord{Layer: "missing"})
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("missing layer: got %v, want NotFound", err)
+	}
+}
+
+func TestRenderServiceSecret(t *testing.T) {
+	url := startRenderService(t, "secret")
+	for _, secret := range []string{"", "wrong"} {
+		r := &RemoteRenderer{Workers: []string{url}, Secret: secret}
+		_, err := r.RenderTile(TileCoord{Layer: "default"})
+		r.Close()
+		if status.Code(err) != codes.Unauthenticated {
+			t.Errorf("secret %q: got %v, want Unauthenticated", secret, err)
+		}
+	}
+}
+
+func TestRemoteRendererTimeout(t *testing.T) {
+	lmp := NewLayerMultiplex(1)
+	lmp.AddSource("hung", make(chan FetchRequest))
+	srv := NewRenderService(lmp).NewServer()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	defer srv.Stop()
+
+	r := &RemoteRenderer{Workers: []string{"http://" + l.Addr().String()}, Timeout: 50 * time.Millisecond}
+	defer r.Close()
+	_, err = r.RenderTile(TileCoord{Layer: "hung"})
+	if status.Code(err) != codes.DeadlineExceeded {
+		t.Errorf("got %v, want DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,73 @@
+package maptiles
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+var layerTileRegex = regexp.MustCompile(`/([0-9]+)/([0-9]+)/([0-9]+)\.png$`)
+
+var layerInfoRegex = regexp.MustCompile(`/([0-9]+)/([0-9]+)/([0-9]+)/([0-9]+)/([0-9]+)/info\.json$`)
+
+// Handler returns a handler for the tiles of one layer, to mount the layer
+// in another router, e.g. under /maps/osm/ with http.ServeMux, chi or
+// gorilla/mux. The request path must end in /{z}/{x}/{y}.png or
+// /{z}/{x}/{y}/{i}/{j}/info.json, the part before is ignored. Requests go
+// through the middleware and access checks like ServeHTTP.
+func (t *TileServer) Handler(layer string) http.Handler {
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := newEncodingWriter(w, r)
+		defer ew.Close()
+		w = ew
+		r, ok := t.checkAccess(w, r)
+		if !ok {
+			return
+		}
+		if m := layerInfoRegex.FindStringSubmatch(r.URL.Path); m != nil {
+			if apiKeyRestricted(r) {
+				http.Error(w, "the credentials only allow tile requests", http.StatusForbidden)
+				return
+			}
+			t.serveFeatureInfo(w, r, append([]string{m[0], layer}, m[1:]...))
+			return
+		}
+		m := layerTileRegex.FindStringSubmatch(r.URL.Path)
+		if m == nil {
+			http.NotFound(w, r)
+			return
+		}
+		z, _ := strconv.ParseUint(m[1], 10, 64)
+		x, _ := strconv.ParseUint(m[2], 10, 64)
+		y, _ := strconv.ParseUint(m[3], 10, 64)
+		t.ServeTileRequest(w, r, TileCoord{X: x, Y: y, Zoom: z, Tms: t.TmsSchema, Layer: layer})
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withRequestID(w, r)
+		t.chain(inner).ServeHTTP(w, r)
+	})
+}
+
+// Router is implemented by http.ServeMux and chi.Router.
+type Router interface {
+	Handle(pattern string, handler http.Handler)
+}
+
+// RegisterRoutes registers the endpoints of the server below prefix,
+// e.g. "/tiles" or "" for the root, instead of handling every path:
+// /tms/, /staticmap, /stats, /batch and /bundle.mbtiles, and /{layer}/ for every layer. Layers added
+// later must be registered with Handler.
+func (t *TileServer) RegisterRoutes(mux Router, prefix string) {
+	h := http.StripPrefix(prefix, t)
+	mux.Handle(prefix+"/tms/", h)
+	mux.Handle(prefix+"/staticmap", h)
+	mux.Handle(prefix+"/stats", h)
+	mux.Handle(prefix+"/batch", h)
+	mux.Handle(prefix+"/bundle.mbtiles", h)
+	for _, layer := range t.lmp.Layers() {
+		if layer == "" {
+			continue
+		}
+		mux.Handle(prefix+"/"+layer+"/", t.Handler(layer))
+	}
+}
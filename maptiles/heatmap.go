@@ -0,0 +1,183 @@
+package maptiles
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/nkovacs/go-mapnik/tilegrid"
+)
+
+// heatmapKey is a cell of the request heatmap.
+type heatmapKey struct {
+	layer string
+	x, y  uint64
+}
+
+// heatmap counts tile requests per cell at a fixed zoom level.
+type heatmap struct {
+	zoom   uint64
+	mu     sync.Mutex
+	counts map[heatmapKey]int64
+}
+
+func newHeatmap(zoom uint64) *heatmap {
+	return &heatmap{zoom: zoom, counts: make(map[heatmapKey]int64)}
+}
+
+// record counts a request for the tile. Tiles above the cell zoom level
+// cover several cells and are not counted.
+func (h *heatmap) record(c TileCoord) {
+	if c.Zoom < h.zoom {
+		return
+	}
+	c.SetTMS(false)
+	shift := c.Zoom - h.zoom
+	k := heatmapKey{c.Layer, c.X >> shift, c.Y >> shift}
+	h.mu.Lock()
+	h.counts[k]++
+	h.mu.Unlock()
+}
+
+// HeatmapCell is the number of requests for the tiles within a tile
+// at the heatmap zoom level, see TileServer.Heatmap.
+type HeatmapCell struct {
+	Layer      string
+	Zoom, X, Y uint64
+	Count      int64
+	// BBox is the WGS84 bounding box of the cell.
+	BBox tilegrid.BBox
+}
+
+// Heatmap returns the request counts per layer and cell, most requested
+// first. It returns nil if the heatmap is disabled.
+func (t *TileServer) Heatmap() []HeatmapCell {
+	if t.heatmap == nil {
+		return nil
+	}
+	return t.heatmap.cells()
+}
+
+func (h *heatmap) cells() []HeatmapCell {
+	h.mu.Lock()
+	cells := make([]HeatmapCell, 0, len(h.counts))
+	for k, n := range h.counts {
+		cells = append(cells, HeatmapCell{Layer: k.layer, Zoom: h.zoom, X: k.x, Y: k.y, Count: n})
+	}
+	h.mu.Unlock()
+	for i := range cells {
+		c := &cells[i]
+		c.BBox = tilegrid.TileToBBox(tilegrid.Tile{X: c.X, Y: c.Y, Zoom: c.Zoom})
+	}
+	sort.Slice(cells, func(i, j int) bool {
+		if cells[i].Count != cells[j].Count {
+			return cells[i].Count > cells[j].Count
+		}
+		if cells[i].Layer != cells[j].Layer {
+			return cells[i].Layer < cells[j].Layer
+		}
+		if cells[i].X != cells[j].X {
+			return cells[i].X < cells[j].X
+		}
+		return cells[i].Y < cells[j].Y
+	})
+	return cells
+}
+
+// WriteHeatmapCSV writes the cells as CSV with a header line.
+func WriteHeatmapCSV(w io.Writer, cells []HeatmapCell) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"layer", "z", "x", "y", "count", "min_lon", "min_lat", "max_lon", "max_lat"})
+	for _, c := range cells {
+		record := []string{
+			c.Layer,
+			strconv.FormatUint(c.Zoom, 10),
+			strconv.FormatUint(c.X, 10),
+			strconv.FormatUint(c.Y, 10),
+			strconv.FormatInt(c.Count, 10),
+		}
+		for _, v := range c.BBox {
+			record = append(record, strconv.FormatFloat(v, 'f', 6, 64))
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONPolygon         `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONPolygon struct {
+	Type        string         `json:"type"`
+	Coordinates [][][2]float64 `json:"coordinates"`
+}
+
+// WriteHeatmapGeoJSON writes the cells as a GeoJSON FeatureCollection of
+// polygons with layer, z, x, y and count properties.
+func WriteHeatmapGeoJSON(w io.Writer, cells []HeatmapCell) error {
+	fc := geoJSONFeatureCollection{Type: "FeatureCollection", Features: make([]geoJSONFeature, 0, len(cells))}
+	for _, c := range cells {
+		b := c.BBox
+		ring := [][2]float64{{b[0], b[1]}, {b[2], b[1]}, {b[2], b[3]}, {b[0], b[3]}, {b[0], b[1]}}
+		fc.Features = append(fc.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONPolygon{Type: "Polygon", Coordinates: [][][2]float64{ring}},
+			Properties: map[string]interface{}{
+				"layer": c.Layer,
+				"z":     c.Zoom,
+				"x":     c.X,
+				"y":     c.Y,
+				"count": c.Count,
+			},
+		})
+	}
+	return json.NewEncoder(w).Encode(fc)
+}
+
+// heatmap answers GET /admin/heatmap.csv and /admin/heatmap.geojson.
+// The layer parameter restricts the export to one layer.
+func (h *AdminHandler) heatmap(w http.ResponseWriter, r *http.Request, format string) {
+	if h.t.heatmap == nil {
+		http.Error(w, "the heatmap is disabled", http.StatusNotImplemented)
+		return
+	}
+	cells := h.t.Heatmap()
+	if layer := r.URL.Query().Get("layer"); layer != "" {
+		filtered := cells[:0]
+		for _, c := range cells {
+			if c.Layer == layer {
+				filtered = append(filtered, c)
+			}
+		}
+		cells = filtered
+	}
+	var err error
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		err = WriteHeatmapCSV(w, cells)
+	case "geojson":
+		w.Header().Set("Content-Type", "application/geo+json")
+		err = WriteHeatmapGeoJSON(w, cells)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
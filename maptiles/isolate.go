@@ -0,0 +1,350 @@
+package maptiles
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/nkovacs/go-mapnik/mapnik"
+)
+
+// workerEnv is set in the environment of render worker processes.
+const workerEnv = "MAPTILES_RENDER_WORKER"
+
+// ErrWorkerCrashed is the error of requests whose render worker process
+// exited while rendering them.
+var ErrWorkerCrashed = errors.New("render worker crashed")
+
+func init() {
+	// possible RendererConfig.Background values
+	gob.Register(color.NRGBA{})
+	gob.Register(color.RGBA{})
+	gob.Register(color.Alpha16{})
+}
+
+// workerRequest is sent by the server to a worker process.
+type workerRequest struct {
+	// Op is tile, meta, static or query.
+	Op    string
+	Coord TileCoord
+	Meta  MetaTileCoord
+
+	// static map
+	BBox          [4]float64
+	Width, Height uint32
+	Format        string
+
+	// feature query
+	I, J uint64
+}
+
+// workerTile is a TileFetchResult with the error as a string.
+type workerTile struct {
+	Coord   TileCoord
+	BlobPNG []byte
+	Err     string
+	Stats   *RenderStats
+}
+
+// workerResponse is the answer of a worker process.
+type workerResponse struct {
+	Blob     []byte
+	Tiles    []workerTile
+	Features []mapnik.Feature
+	Err      string
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func stringError(s string) error {
+	if s == "" {
+		return nil
+	}
+	return errors.New(s)
+}
+
+// RunRenderWorker must be called at the start of main by programs using
+// isolated layers, see LayerConfig.Isolate. In a render worker process it
+// answers render requests on stdin and stdout and exits, otherwise it
+// returns immediately.
+func RunRenderWorker() {
+	if os.Getenv(workerEnv) == "" {
+		return
+	}
+	if err := runRenderWorker(os.Stdin, os.Stdout); err != nil {
+		log.Println("Render worker:", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func runRenderWorker(r io.Reader, w io.Writer) error {
+	dec := gob.NewDecoder(r)
+	enc := gob.NewEncoder(w)
+	var cfg RendererConfig
+	if err := dec.Decode(&cfg); err != nil {
+		return err
+	}
+	t, err := NewTileRendererFromConfig(cfg)
+	if err := enc.Encode(workerResponse{Err: errorString(err)}); err != nil {
+		return err
+	}
+	if err != nil {
+		return nil
+	}
+	defer t.Close()
+
+	for {
+		var req workerRequest
+		if err := dec.Decode(&req); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		var resp workerResponse
+		switch req.Op {
+		case "tile":
+			start := time.Now()
+			blob, err := t.RenderTile(req.Coord)
+			resp.Tiles = []workerTile{{Coord: req.Coord, BlobPNG: blob, Err: errorString(err), Stats: renderStats(t, start)}}
+		case "meta":
+			results, err := t.RenderMetaTile(req.Meta)
+			resp.Err = errorString(err)
+			for _, result := range results {
+				resp.Tiles = append(resp.Tiles, workerTile{Coord: result.Coord, BlobPNG: result.BlobPNG, Err: errorString(result.Error), Stats: result.Stats})
+			}
+		case "static":
+			resp.Blob, err = t.RenderStaticMap(req.BBox, req.Width, req.Height, req.Format)
+			resp.Err = errorString(err)
+		case "query":
+			resp.Features, err = t.QueryFeatures(req.Coord, req.I, req.J)
+			resp.Err = errorString(err)
+		default:
+			resp.Err = fmt.Sprintf("unknown operation %q", req.Op)
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// workerProcess is a running render worker.
+type workerProcess struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	enc   *gob.Encoder
+	dec   *gob.Decoder
+}
+
+// startWorker starts a render worker for the stylesheet and waits until it
+// has been loaded.
+func startWorker(cfg RendererConfig) (*workerProcess, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe)
+	cmd.Env = append(os.Environ(), workerEnv+"=1")
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	w := &workerProcess{cmd: cmd, stdin: stdin, enc: gob.NewEncoder(stdin), dec: gob.NewDecoder(stdout)}
+	var resp workerResponse
+	if err := w.enc.Encode(cfg); err == nil {
+		err = w.dec.Decode(&resp)
+	}
+	if err != nil {
+		w.kill()
+		return nil, fmt.Errorf("starting render worker: %v", err)
+	}
+	if resp.Err != "" {
+		w.stop()
+		return nil, errors.New(resp.Err)
+	}
+	return w, nil
+}
+
+func (w *workerProcess) do(req workerRequest) (workerResponse, error) {
+	var resp workerResponse
+	if err := w.enc.Encode(req); err != nil {
+		return resp, err
+	}
+	err := w.dec.Decode(&resp)
+	return resp, err
+}
+
+// stop closes stdin, so the worker exits, and waits for it.
+func (w *workerProcess) stop() {
+	w.stdin.Close()
+	w.cmd.Wait()
+}
+
+func (w *workerProcess) kill() {
+	w.cmd.Process.Kill()
+	w.stop()
+}
+
+// ProcessRenderer renders in child processes, so a crash of mapnik or a
+// datasource plugin only kills a worker, not the tile server. Crashed
+// workers are restarted automatically, the request they were rendering
+// fails with ErrWorkerCrashed. The program must call RunRenderWorker.
+type ProcessRenderer struct {
+	cfg  RendererConfig
+	idle chan *workerProcess
+
+	mu     sync.Mutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+var (
+	_ Renderer       = (*ProcessRenderer)(nil)
+	_ StaticRenderer = (*ProcessRenderer)(nil)
+	_ FeatureQuerier = (*ProcessRenderer)(nil)
+)
+
+// NewProcessRenderer starts n render workers for the stylesheet. It returns
+// an error if the stylesheet cannot be loaded. The renderer can be used by
+// n goroutines at the same time, others wait for a free worker.
+func NewProcessRenderer(cfg RendererConfig, n int) (*ProcessRenderer, error) {
+	p := &ProcessRenderer{cfg: cfg, idle: make(chan *workerProcess, n)}
+	for i := 0; i < n; i++ {
+		w, err := startWorker(cfg)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.idle <- w
+	}
+	return p, nil
+}
+
+func (p *ProcessRenderer) do(req workerRequest) (workerResponse, error) {
+	w := <-p.idle
+	resp, err := w.do(req)
+	if err != nil {
+		log.Println("Render worker for", p.cfg.Stylesheet, "failed:", err)
+		w.kill()
+		p.respawn()
+		return resp, ErrWorkerCrashed
+	}
+	p.idle <- w
+	return resp, nil
+}
+
+// respawn starts a replacement for a crashed worker in the background,
+// retrying every second while it cannot be started.
+func (p *ProcessRenderer) respawn() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for {
+			w, err := startWorker(p.cfg)
+			if err == nil {
+				if p.isClosed() {
+					w.stop()
+				} else {
+					p.idle <- w
+				}
+				return
+			}
+			log.Println("Error restarting render worker for", p.cfg.Stylesheet, err)
+			time.Sleep(time.Second)
+			if p.isClosed() {
+				return
+			}
+		}
+	}()
+}
+
+func (p *ProcessRenderer) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+func (p *ProcessRenderer) RenderTile(c TileCoord) ([]byte, error) {
+	resp, err := p.do(workerRequest{Op: "tile", Coord: c})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Tiles) != 1 {
+		return nil, stringError(resp.Err)
+	}
+	return resp.Tiles[0].BlobPNG, stringError(resp.Tiles[0].Err)
+}
+
+func (p *ProcessRenderer) RenderMetaTile(c MetaTileCoord) ([]TileFetchResult, error) {
+	resp, err := p.do(workerRequest{Op: "meta", Meta: c})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Err != "" {
+		return nil, errors.New(resp.Err)
+	}
+	results := make([]TileFetchResult, len(resp.Tiles))
+	for i, t := range resp.Tiles {
+		results[i] = TileFetchResult{Coord: t.Coord, BlobPNG: t.BlobPNG, Error: stringError(t.Err), Stats: t.Stats}
+	}
+	return results, nil
+}
+
+func (p *ProcessRenderer) RenderStaticMap(bbox [4]float64, width, height uint32, format string) ([]byte, error) {
+	resp, err := p.do(workerRequest{Op: "static", BBox: bbox, Width: width, Height: height, Format: format})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Blob, stringError(resp.Err)
+}
+
+func (p *ProcessRenderer) QueryFeatures(c TileCoord, i, j uint64) ([]mapnik.Feature, error) {
+	resp, err := p.do(workerRequest{Op: "query", Coord: c, I: i, J: j})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Features, stringError(resp.Err)
+}
+
+// Close stops the workers. It must not be called while requests are
+// being rendered.
+func (p *ProcessRenderer) Close() {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.wg.Wait()
+	for {
+		select {
+		case w := <-p.idle:
+			w.stop()
+		default:
+			return
+		}
+	}
+}
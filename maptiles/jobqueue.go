@@ -0,0 +1,120 @@
+package maptiles
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// RenderJob is a metatile to render into the cache, received from a
+// JobQueue.
+type RenderJob struct {
+	Coord MetaTileCoord
+
+	// ID identifies the job in the queue.
+	ID string
+}
+
+// JobQueue distributes render jobs to workers on several machines, e.g. a
+// RedisQueue, so large seeds can be split between them. A coordinator
+// enqueues the metatiles with Seeder.Enqueue, the workers render them into
+// a shared cache with Seeder.RunJobs.
+type JobQueue interface {
+	// Enqueue adds jobs for the metatiles.
+	Enqueue(coords []MetaTileCoord) error
+
+	// Next waits up to timeout for a job. It returns nil if there is none.
+	// Jobs that are not acknowledged are delivered again, e.g. after a
+	// worker crashed or failed to render them.
+	Next(timeout time.Duration) (*RenderJob, error)
+
+	// Ack marks a job as done.
+	Ack(job *RenderJob) error
+}
+
+// enqueueBatch is the number of jobs Seeder.Enqueue adds at once.
+const enqueueBatch = 1000
+
+// Enqueue adds the metatiles of the seed to the queue instead of rendering
+// them and returns their number. With SkipExisting, metatiles already in
+// the cache are not added.
+func (s *Seeder) Enqueue(q JobQueue) (uint64, error) {
+	var n uint64
+	var err error
+	batch := make([]MetaTileCoord, 0, enqueueBatch)
+	flush := func() {
+		if err == nil && len(batch) > 0 {
+			err = q.Enqueue(batch)
+			n += uint64(len(batch))
+		}
+		batch = batch[:0]
+	}
+	s.eachMetaTile(func(coord MetaTileCoord) {
+		if err != nil || (s.SkipExisting && s.exists(coord)) {
+			return
+		}
+		batch = append(batch, coord)
+		if len(batch) == enqueueBatch {
+			flush()
+		}
+	})
+	flush()
+	return n, err
+}
+
+// RunJobs renders the jobs of the queue with Workers workers and stores the
+// tiles in the cache until stop is closed. All jobs are rendered by
+// Renderer, so the queue must only contain jobs of its layer. Jobs with
+// render errors are not acknowledged, so they are retried. Progress is
+// called with a total of 0.
+func (s *Seeder) RunJobs(q JobQueue, stop <-chan struct{}) {
+	workers := s.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+	var done uint64
+	var progressMx sync.Mutex
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			results := make(chan TileFetchResult)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				job, err := q.Next(5 * time.Second)
+				if err != nil {
+					log.Println("Error receiving render job:", err)
+					time.Sleep(time.Second)
+					continue
+				}
+				if job == nil {
+					continue
+				}
+				if !s.SkipExisting || !s.exists(job.Coord) {
+					err = s.seedMetaTile(job.Coord, results)
+				}
+				if err != nil {
+					// not acknowledged, so it is delivered again
+					log.Println("Error rendering job", job.ID, ":", err)
+					continue
+				}
+				if err := q.Ack(job); err != nil {
+					log.Println("Error acknowledging render job:", err)
+				}
+				if s.Progress != nil {
+					progressMx.Lock()
+					done++
+					s.Progress(done, 0)
+					progressMx.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,197 @@
+package maptiles
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// JWTAuth validates JWT bearer tokens signed with HS256 or RS256, see
+// TileServer.JWT. The layers a token may access are taken from a claim,
+// so one server can serve several tenants.
+type JWTAuth struct {
+	// Secret is the HS256 key. Tokens signed with HS256 are rejected
+	// if it is empty.
+	Secret []byte
+
+	// PublicKey is the RS256 key. Tokens signed with RS256 are rejected
+	// if it is nil.
+	PublicKey *rsa.PublicKey
+
+	// LayersClaim is the claim listing the layers the token may access,
+	// either as an array or as a space separated string. Tokens without
+	// the claim are rejected unless AllowAllLayers is set. If empty,
+	// "layers" will be used.
+	LayersClaim string
+
+	// AllowAllLayers lets tokens without LayersClaim access all layers.
+	AllowAllLayers bool
+
+	// Issuer and Audience are compared with the iss and aud claims if set.
+	Issuer   string
+	Audience string
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+}
+
+// Validate checks the signature and the exp, nbf, iss and aud claims of a
+// token. It returns the subject and the allowed layers as an APIKey.
+// Tokens without a sub claim are rejected, because usage is counted per
+// subject.
+func (a *JWTAuth) Validate(token string) (APIKey, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return APIKey{}, fmt.Errorf("malformed token")
+	}
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return APIKey{}, fmt.Errorf("invalid header: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return APIKey{}, fmt.Errorf("invalid signature: %v", err)
+	}
+	signed := parts[0] + "." + parts[1]
+	switch header.Alg {
+	case "HS256":
+		if len(a.Secret) == 0 {
+			return APIKey{}, fmt.Errorf("HS256 tokens are not accepted")
+		}
+		mac := hmac.New(sha256.New, a.Secret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return APIKey{}, fmt.Errorf("invalid signature")
+		}
+	case "RS256":
+		if a.PublicKey == nil {
+			return APIKey{}, fmt.Errorf("RS256 tokens are not accepted")
+		}
+		sum := sha256.Sum256([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(a.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+			return APIKey{}, fmt.Errorf("invalid signature")
+		}
+	default:
+		return APIKey{}, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return APIKey{}, fmt.Errorf("invalid claims: %v", err)
+	}
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); ok && now >= exp {
+		return APIKey{}, fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		return APIKey{}, fmt.Errorf("token not valid yet")
+	}
+	if a.Issuer != "" && claims["iss"] != a.Issuer {
+		return APIKey{}, fmt.Errorf("invalid issuer")
+	}
+	if a.Audience != "" && !jwtHasAudience(claims["aud"], a.Audience) {
+		return APIKey{}, fmt.Errorf("invalid audience")
+	}
+
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return APIKey{}, fmt.Errorf("the token has no subject")
+	}
+	k := APIKey{Key: "jwt:" + sub, Name: sub}
+	claim := a.LayersClaim
+	if claim == "" {
+		claim = "layers"
+	}
+	switch layers := claims[claim].(type) {
+	case nil:
+		if !a.AllowAllLayers {
+			return APIKey{}, fmt.Errorf("the token has no %v claim", claim)
+		}
+	case string:
+		k.Layers = strings.Fields(layers)
+	case []interface{}:
+		for _, l := range layers {
+			name, ok := l.(string)
+			if !ok {
+				return APIKey{}, fmt.Errorf("invalid %v claim", claim)
+			}
+			k.Layers = append(k.Layers, name)
+		}
+	default:
+		return APIKey{}, fmt.Errorf("invalid %v claim", claim)
+	}
+	if claims[claim] != nil && len(k.Layers) == 0 {
+		return APIKey{}, fmt.Errorf("the token does not allow any layer")
+	}
+	return k, nil
+}
+
+func decodeJWTPart(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// jwtHasAudience reports whether the aud claim, a string or an array of
+// strings, contains the audience.
+func jwtHasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// LoadRSAPublicKey reads a PEM encoded RSA public key in PKIX or PKCS #1
+// form, e.g. for JWTAuth.PublicKey.
+func LoadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%v: no PEM data found", path)
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%v: not an RSA public key", path)
+	}
+	return rsaKey, nil
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header, or
+// an empty string.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(auth, "Bearer ")
+}
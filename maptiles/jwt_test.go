@@ -0,0 +1,104 @@
+package maptiles
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// signJWT creates a token with the claims, signed with HS256 if key is a
+// []byte and with RS256 if it is an *rsa.PrivateKey.
+func signJWT(t *testing.T, alg string, key interface{}, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	var sig []byte
+	switch key := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sum := sha256.Sum256([]byte(signed))
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWTValidate(t *testing.T) {
+	secret := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	a := &JWTAuth{Secret: secret, PublicKey: &rsaKey.PublicKey, Issuer: "iss", Audience: "tiles"}
+	valid := func(extra map[string]interface{}) map[string]interface{} {
+		claims := map[string]interface{}{"sub": "alice", "iss": "iss", "aud": "tiles", "layers": "osm topo"}
+		for k, v := range extra {
+			if v == nil {
+				delete(claims, k)
+			} else {
+				claims[k] = v
+			}
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		layers []string
+		ok     bool
+	}{
+		{"HS256", signJWT(t, "HS256", secret, valid(nil)), []string{"osm", "topo"}, true},
+		{"RS256", signJWT(t, "RS256", rsaKey, valid(nil)), []string{"osm", "topo"}, true},
+		{"layers array", signJWT(t, "HS256", secret, valid(map[string]interface{}{"layers": []string{"osm"}})), []string{"osm"}, true},
+		{"audience array", signJWT(t, "HS256", secret, valid(map[string]interface{}{"aud": []string{"other", "tiles"}})), []string{"osm", "topo"}, true},
+		{"not expired", signJWT(t, "HS256", secret, valid(map[string]interface{}{"exp": now + 60, "nbf": now - 60})), []string{"osm", "topo"}, true},
+		{"wrong secret", signJWT(t, "HS256", []byte("wrong"), valid(nil)), nil, false},
+		{"wrong key", signJWT(t, "RS256", otherKey, valid(nil)), nil, false},
+		{"none", signJWT(t, "none", nil, valid(nil)), nil, false},
+		{"malformed", "a.b", nil, false},
+		{"expired", signJWT(t, "HS256", secret, valid(map[string]interface{}{"exp": now - 1})), nil, false},
+		{"not valid yet", signJWT(t, "HS256", secret, valid(map[string]interface{}{"nbf": now + 60})), nil, false},
+		{"wrong issuer", signJWT(t, "HS256", secret, valid(map[string]interface{}{"iss": "other"})), nil, false},
+		{"wrong audience", signJWT(t, "HS256", secret, valid(map[string]interface{}{"aud": "other"})), nil, false},
+		{"no subject", signJWT(t, "HS256", secret, valid(map[string]interface{}{"sub": nil})), nil, false},
+		{"no layers", signJWT(t, "HS256", secret, valid(map[string]interface{}{"layers": nil})), nil, false},
+		{"empty layers", signJWT(t, "HS256", secret, valid(map[string]interface{}{"layers": ""})), nil, false},
+		{"invalid layers", signJWT(t, "HS256", secret, valid(map[string]interface{}{"layers": 1})), nil, false},
+	}
+	for _, test := range tests {
+		k, err := a.Validate(test.token)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.name, err)
+			continue
+		}
+		if test.ok && (k.Name != "alice" || !reflect.DeepEqual(k.Layers, test.layers)) {
+			t.Errorf("%s: got %+v", test.name, k)
+		}
+	}
+
+	a.AllowAllLayers = true
+	k, err := a.Validate(signJWT(t, "HS256", secret, valid(map[string]interface{}{"layers": nil})))
+	if err != nil || k.Layers != nil {
+		t.Errorf("token without layers with AllowAllLayers: got %+v, %v", k, err)
+	}
+}
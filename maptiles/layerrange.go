@@ -0,0 +1,59 @@
+package maptiles
+
+import (
+	"net/http"
+
+	"github.com/nkovacs/go-mapnik/tilegrid"
+)
+
+// OutOfRange determines how requests for tiles outside of a layer's zoom
+// range or bounds are answered.
+type OutOfRange int
+
+const (
+	// OutOfRangeNotFound responds with 404 Not Found.
+	OutOfRangeNotFound OutOfRange = iota
+
+	// OutOfRangeNoContent responds with 204 No Content.
+	OutOfRangeNoContent
+
+	// OutOfRangeBlank responds with a transparent tile.
+	OutOfRangeBlank
+)
+
+func (cfg LayerConfig) inZoomRange(tc TileCoord) bool {
+	return tc.Zoom >= cfg.MinZoom && (cfg.MaxZoom == 0 || tc.Zoom <= cfg.MaxZoom)
+}
+
+// validTile reports whether the tile exists in the layer's grid.
+func (cfg LayerConfig) validTile(tc TileCoord) bool {
+	if cfg.Grid == nil {
+		return tc.Valid()
+	}
+	if tc.Zoom > tilegrid.MaxZoom {
+		return false
+	}
+	tc.SetTMS(false)
+	return cfg.Grid.Contains(tilegrid.Tile{X: tc.X, Y: tc.Y, Zoom: tc.Zoom})
+}
+
+// inBounds reports whether the tile intersects the layer's bounds.
+func (cfg LayerConfig) inBounds(tc TileCoord) bool {
+	if cfg.Grid != nil || cfg.Bounds == [4]float64{} {
+		return true
+	}
+	tc.SetTMS(false)
+	b := metaTileBounds(MetaTileCoord{MinX: tc.X, MinY: tc.Y, MaxX: tc.X, MaxY: tc.Y, Zoom: tc.Zoom})
+	return b[0] < cfg.Bounds[2] && b[2] > cfg.Bounds[0] && b[1] < cfg.Bounds[3] && b[3] > cfg.Bounds[1]
+}
+
+func (t *TileServer) serveOutOfRange(w http.ResponseWriter, r *http.Request, cfg LayerConfig) {
+	switch cfg.OutOfRange {
+	case OutOfRangeNoContent:
+		w.WriteHeader(http.StatusNoContent)
+	case OutOfRangeBlank:
+		t.writeTile(w, r, gridBlankTile(cfg.Grid), false)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,99 @@
+package maptiles
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd.
+const listenFdsStart = 3
+
+// Listen listens on an address of HTTPConfig.Listen:
+//
+//	:8080, localhost:8080     TCP
+//	unix:/run/maptiles.sock   unix socket, replacing a stale socket file
+//	systemd                   the first socket passed by systemd socket activation
+//	systemd:name              the socket with FileDescriptorName=name
+//
+// Unix sockets are created accessible to the user and group, see
+// ListenMode.
+func Listen(addr string) (net.Listener, error) {
+	return ListenMode(addr, 0)
+}
+
+// ListenMode is Listen creating unix sockets with the permissions mode. If
+// mode is zero, 0660 will be used.
+func ListenMode(addr string, mode os.FileMode) (net.Listener, error) {
+	if mode == 0 {
+		mode = 0660
+	}
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		path := strings.TrimPrefix(addr, "unix:")
+		if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		l, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			l.Close()
+			return nil, err
+		}
+		return l, nil
+	case addr == "systemd" || strings.HasPrefix(addr, "systemd:"):
+		return systemdListener(strings.TrimPrefix(strings.TrimPrefix(addr, "systemd"), ":"))
+	}
+	return net.Listen("tcp", addr)
+}
+
+// checkSystemdNames returns an error if several of the addresses are
+// systemd sockets without distinct names, which would all get the same
+// socket.
+func checkSystemdNames(addrs ...string) error {
+	seen := make(map[string]bool)
+	n := 0
+	for _, addr := range addrs {
+		if addr != "systemd" && !strings.HasPrefix(addr, "systemd:") {
+			continue
+		}
+		n++
+		name := strings.TrimPrefix(strings.TrimPrefix(addr, "systemd"), ":")
+		if seen[name] {
+			return fmt.Errorf("several listeners use the systemd socket %q, give the sockets distinct names with systemd:name", name)
+		}
+		seen[name] = true
+	}
+	if n > 1 && seen[""] {
+		return fmt.Errorf("several listeners use systemd sockets, give each a name with systemd:name")
+	}
+	return nil
+}
+
+// systemdListener returns a socket passed by systemd, see sd_listen_fds(3).
+// If name is empty, the first socket is returned.
+func systemdListener(name string) (net.Listener, error) {
+	if pid, _ := strconv.Atoi(os.Getenv("LISTEN_PID")); pid != os.Getpid() {
+		return nil, fmt.Errorf("no sockets passed by systemd")
+	}
+	n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		fdName := ""
+		if i < len(names) {
+			fdName = names[i]
+		}
+		if name != "" && fdName != name {
+			continue
+		}
+		f := os.NewFile(uintptr(listenFdsStart+i), fdName)
+		l, err := net.FileListener(f)
+		f.Close()
+		return l, err
+	}
+	return nil, fmt.Errorf("no socket %q passed by systemd", name)
+}
@@ -0,0 +1,40 @@
+package maptiles
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnixMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "maptiles.sock")
+	l, err := Listen("unix:" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := fi.Mode().Perm(); mode != 0660 {
+		t.Errorf("got mode %o, want 660", mode)
+	}
+}
+
+func TestCheckSystemdNames(t *testing.T) {
+	tests := []struct {
+		addrs []string
+		ok    bool
+	}{
+		{[]string{"systemd", ":8081", ""}, true},
+		{[]string{"systemd:tiles", "systemd:admin", ""}, true},
+		{[]string{"systemd", "systemd:admin", ""}, false},
+		{[]string{"systemd:tiles", "systemd:tiles", ""}, false},
+	}
+	for _, test := range tests {
+		if err := checkSystemdNames(test.addrs...); (err == nil) != test.ok {
+			t.Errorf("%v: got %v", test.addrs, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Package maptilestest provides a tile cache and a renderer for testing
+// tile serving setups, e.g. with maptiles.TileServer, without mapnik.
+package maptilestest
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"sync"
+	"time"
+
+	"github.com/nkovacs/go-mapnik/maptiles/tile"
+)
+
+// MemoryCache is a maptiles.TileCache that keeps tiles in memory.
+// It is meant for tests and small deployments.
+type MemoryCache struct {
+	mu    sync.RWMutex
+	tiles map[tile.Coord]memoryTile
+}
+
+type memoryTile struct {
+	data    []byte
+	written time.Time
+}
+
+// NewMemoryCache creates an empty MemoryCache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{tiles: make(map[tile.Coord]memoryTile)}
+}
+
+func memoryKey(c tile.Coord) tile.Coord {
+	c.SetTMS(false)
+	c.Layer = c.CacheLayer()
+	return c
+}
+
+func (m *MemoryCache) Fetch(c tile.Coord) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.tiles[memoryKey(c)].data, nil
+}
+
+func (m *MemoryCache) BatchInsert(tiles []tile.FetchResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, t := range tiles {
+		m.tiles[memoryKey(t.Coord)] = memoryTile{t.BlobPNG, now}
+	}
+}
+
+func (m *MemoryCache) BatchCheckSince(coords []tile.Coord, since time.Time) []bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	results := make([]bool, len(coords))
+	for i, c := range coords {
+		t, ok := m.tiles[memoryKey(c)]
+		results[i] = ok && !t.written.Before(since)
+	}
+	return results
+}
+
+// Len returns the number of cached tiles.
+func (m *MemoryCache) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.tiles)
+}
+
+// StubRenderer is a maptiles.Renderer that renders placeholder tiles
+// filled with a solid color and a one pixel border. It can be added to a
+// TileServer with AddRenderer.
+type StubRenderer struct {
+	// Color is the fill color. If nil, light gray is used.
+	Color color.Color
+}
+
+func (s StubRenderer) RenderTile(c tile.Coord) ([]byte, error) {
+	fill := s.Color
+	if fill == nil {
+		fill = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Black}, image.ZP, draw.Src)
+	draw.Draw(img, image.Rect(1, 1, 255, 255), &image.Uniform{fill}, image.ZP, draw.Src)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s StubRenderer) RenderMetaTile(c tile.MetaCoord) ([]tile.FetchResult, error) {
+	coords := c.TileCoords()
+	results := make([]tile.FetchResult, 0, len(coords))
+	for _, tc := range coords {
+		blob, err := s.RenderTile(tc)
+		results = append(results, tile.FetchResult{Coord: tc, BlobPNG: blob, Error: err})
+	}
+	return results, nil
+}
@@ -0,0 +1,79 @@
+package maptilestest
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/nkovacs/go-mapnik/maptiles/tile"
+)
+
+func TestMemoryCache(t *testing.T) {
+	m := NewMemoryCache()
+	c := tile.Coord{X: 1, Y: 2, Zoom: 3, Layer: "osm"}
+	if blob, _ := m.Fetch(c); blob != nil {
+		t.Fatal("empty cache returned a tile")
+	}
+
+	start := time.Now()
+	m.BatchInsert([]tile.FetchResult{{Coord: c, BlobPNG: []byte("tile")}})
+	if m.Len() != 1 {
+		t.Fatalf("got %d tiles, want 1", m.Len())
+	}
+
+	tms := c
+	tms.SetTMS(true)
+	for _, fc := range []tile.Coord{c, tms} {
+		if blob, _ := m.Fetch(fc); string(blob) != "tile" {
+			t.Errorf("%+v: got %q, want tile", fc, blob)
+		}
+	}
+	webp := c
+	webp.Format = "webp"
+	if blob, _ := m.Fetch(webp); blob != nil {
+		t.Error("tile found in another format")
+	}
+
+	found := m.BatchCheckSince([]tile.Coord{c, webp}, time.Time{})
+	if !found[0] || found[1] {
+		t.Errorf("got %v, want [true false]", found)
+	}
+	if found := m.BatchCheckSince([]tile.Coord{c}, start.Add(time.Hour)); found[0] {
+		t.Error("tile written before since was found")
+	}
+}
+
+func TestStubRenderer(t *testing.T) {
+	blob, err := StubRenderer{Color: color.White}.RenderTile(tile.Coord{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 256 || b.Dy() != 256 {
+		t.Errorf("got %v image, want 256x256", b)
+	}
+	if r, _, _, _ := img.At(0, 0).RGBA(); r != 0 {
+		t.Error("border is not black")
+	}
+	if r, _, _, _ := img.At(128, 128).RGBA(); r != 0xffff {
+		t.Error("tile is not filled with the color")
+	}
+
+	results, err := StubRenderer{}.RenderMetaTile(tile.MetaCoord{MaxX: 1, MaxY: 1, Zoom: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("got %d tiles, want 4", len(results))
+	}
+	for _, r := range results {
+		if r.Error != nil || len(r.BlobPNG) == 0 {
+			t.Errorf("%+v: no tile rendered", r.Coord)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package maptiles
+
+import (
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MBTilesDirWatcher exposes the MBTiles files of a directory as read-only
+// layers of a TileServer. See TileServer.WatchMBTilesDir.
+type MBTilesDirWatcher struct {
+	t        *TileServer
+	dir      string
+	layers   map[string]time.Time // layer name -> file modification time
+	stop     chan bool
+	stopOnce sync.Once
+}
+
+var layerNameRegex = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// WatchMBTilesDir adds a read-only layer for each *.mbtiles file in dir,
+// named after the file without the extension. The directory is rescanned
+// every interval, so added, replaced and removed files are picked up while
+// the server is running. If interval is zero, 10 seconds will be used.
+func (t *TileServer) WatchMBTilesDir(dir string, interval time.Duration) (*MBTilesDirWatcher, error) {
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+	w := &MBTilesDirWatcher{
+		t:      t,
+		dir:    dir,
+		layers: make(map[string]time.Time),
+		stop:   make(chan bool),
+	}
+	if err := w.scan(); err != nil {
+		return nil, err
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := w.scan(); err != nil {
+					log.Println("error scanning", dir, ":", err)
+				}
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+	return w, nil
+}
+
+// Stop stops watching the directory. The layers stay registered.
+func (w *MBTilesDirWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+}
+
+func (w *MBTilesDirWatcher) scan() error {
+	files, err := ioutil.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".mbtiles") {
+			continue
+		}
+		name := strings.TrimSuffix(fi.Name(), ".mbtiles")
+		seen[name] = true
+
+		modified, known := w.layers[name]
+		if known && modified.Equal(fi.ModTime()) {
+			continue
+		}
+		if known {
+			log.Println("reloading layer", name)
+			w.t.RemoveLayer(name)
+			delete(w.layers, name)
+		}
+		if !layerNameRegex.MatchString(name) {
+			log.Println("layer", name, "cannot be requested with the default URL scheme")
+		}
+		if err := w.t.AddMBTilesLayer(name, filepath.Join(w.dir, fi.Name())); err != nil {
+			log.Println("error adding layer", name, ":", err)
+			continue
+		}
+		w.layers[name] = fi.ModTime()
+	}
+
+	for name := range w.layers {
+		if !seen[name] {
+			log.Println("removing layer", name)
+			w.t.RemoveLayer(name)
+			delete(w.layers, name)
+		}
+	}
+	return nil
+}
@@ -6,12 +6,18 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	//"net/http"
 	//"strconv"
 )
 
+// insertQueueSize is the number of tiles the insert queue of a TileDb
+// holds. Further tiles are dropped until the queue drains.
+const insertQueueSize = 1000
+
 // MBTiles 1.2-compatible Tile Db with multi-layer support.
 // Was named Mbtiles before, hence the use of *m in methods.
 type TileDb struct {
@@ -21,18 +27,52 @@ type TileDb struct {
 	layerIds    map[string]int
 	qc          chan bool
 	dbLock      sync.RWMutex
+	// insertMx serializes BatchInsert, which only holds dbLock for
+	// reading, so tiles can be read while others are written
+	insertMx sync.Mutex
+	// dropped counts the tiles dropped because the insert queue was full
+	dropped     uint64
+	extent      metadataExtent
+	extentMx    sync.Mutex
+	ttls        map[string]time.Duration
+	ttlMx       sync.RWMutex
+	janitorStop chan bool
+	limits      sizeLimits
+
+	// closed is set by Close, inserts are dropped afterwards
+	closed   bool
+	closedMx sync.RWMutex
+
+	// ChunkSize is the maximum number of tiles BatchInsert writes in one
+	// transaction. Zero means all tiles are written in one transaction.
+	ChunkSize int
+
+	// FlushSize and FlushInterval control the write-behind buffer of the
+	// insert queue, see Run. If zero, 100 tiles and one second are used.
+	// They must be set before the first tile is queued.
+	FlushSize     int
+	FlushInterval time.Duration
+
+	// DedupSolid stores single-colored tiles, like ocean or empty land,
+	// in a canonical encoding, so all tiles of a color share one blob.
+	DedupSolid bool
+
+	// ReportError is called with errors writing tiles, for the first tile
+	// of the failed transaction. NewTileServer sets it to report to
+	// TileServer.ReportError if it is nil.
+	ReportError ErrorReporter
 }
 
-func NewTileDb(path string) *TileDb {
+// NewTileDb opens or creates the cache file at path.
+func NewTileDb(path string) (*TileDb, error) {
 	m := TileDb{}
 	var err error
 	m.db, err = sql.Open("sqlite3", path)
 	if err != nil {
-		log.Println("Error opening db", err.Error())
-		return nil
+		return nil, err
 	}
 	queries := []string{
-		"PRAGMA journal_mode = OFF",
+		"PRAGMA journal_mode = WAL",
 		"PRAGMA synchronous=OFF",
 		"CREATE TABLE IF NOT EXISTS layers(layer_name text PRIMARY KEY NOT NULL)",
 		"CREATE TABLE IF NOT EXISTS metadata (name text PRIMARY KEY NOT NULL, value text NOT NULL)",
@@ -40,48 +80,90 @@ func NewTileDb(path string) *TileDb {
 		"CREATE TABLE IF NOT EXISTS tile_blobs (checksum text, tile_data blob)",
 		"CREATE VIEW IF NOT EXISTS tiles AS SELECT layered_tiles.zoom_level as zoom_level, layered_tiles.tile_column as tile_column, layered_tiles.tile_row as tile_row, (SELECT tile_data FROM tile_blobs WHERE checksum=layered_tiles.checksum) as tile_data FROM layered_tiles WHERE layered_tiles.layer_id = (SELECT rowid FROM layers WHERE layer_name='default')",
 		"CREATE UNIQUE INDEX IF NOT EXISTS tile_blobs_checksum ON tile_blobs(checksum)",
-		"REPLACE INTO metadata VALUES('name', 'go-mapnik cache file')",
-		"REPLACE INTO metadata VALUES('type', 'overlay')",
-		"REPLACE INTO metadata VALUES('version', '0')",
-		"REPLACE INTO metadata VALUES('description', 'Compatible with MBTiles spec 1.2. However, this file may contain multiple overlay layers, but only the layer called default is exported as MBtiles')",
-		"REPLACE INTO metadata VALUES('format', 'png')",
-		"REPLACE INTO metadata VALUES('bounds', '-180.0,-85,180,85')",
+		"INSERT OR IGNORE INTO metadata VALUES('name', 'go-mapnik cache file')",
+		"INSERT OR IGNORE INTO metadata VALUES('type', 'overlay')",
+		"INSERT OR IGNORE INTO metadata VALUES('version', '0')",
+		"INSERT OR IGNORE INTO metadata VALUES('description', 'Compatible with MBTiles spec 1.2. However, this file may contain multiple overlay layers, but only the layer called default is exported as MBtiles')",
+		"INSERT OR IGNORE INTO metadata VALUES('format', 'png')",
 		"INSERT OR IGNORE INTO layers(layer_name) VALUES('default')",
 	}
 
 	for _, query := range queries {
 		_, err = m.db.Exec(query)
 		if err != nil {
-			log.Println("Error setting up db", err.Error())
-			return nil
+			m.db.Close()
+			return nil, fmt.Errorf("setting up db: %v", err)
+		}
+	}
+
+	// updated_at is the time the tile content last changed,
+	// checked_at the time it was last rendered,
+	// accessed_at the time it was last fetched
+	for _, column := range []string{"updated_at", "checked_at", "accessed_at"} {
+		if err = m.addColumn("layered_tiles", column, "integer"); err != nil {
+			m.db.Close()
+			return nil, fmt.Errorf("setting up db: %v", err)
+		}
+	}
+	// render statistics of the last render, see RenderStats
+	for column, definition := range map[string]string{"render_ms": "integer", "size": "integer", "renderer": "text"} {
+		if err = m.addColumn("layered_tiles", column, definition); err != nil {
+			m.db.Close()
+			return nil, fmt.Errorf("setting up db: %v", err)
 		}
 	}
+	m.ttls = make(map[string]time.Duration)
+
+	if err = m.readLayers(); err != nil {
+		m.db.Close()
+		return nil, fmt.Errorf("fetching layer definitions: %v", err)
+	}
 
-	m.readLayers()
+	if err = m.loadExtent(); err != nil {
+		m.db.Close()
+		return nil, fmt.Errorf("reading tile extent: %v", err)
+	}
 
-	m.insertChan = make(chan TileFetchResult)
+	m.insertChan = make(chan TileFetchResult, insertQueueSize)
 	m.requestChan = make(chan TileFetchRequest)
 	m.Run()
-	return &m
+	return &m, nil
+}
+
+// addColumn adds a column to a table created by an older version.
+func (m *TileDb) addColumn(table, column, definition string) error {
+	var n int
+	row := m.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name=?", table, column)
+	if err := row.Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err := m.db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
 }
 
-func (m *TileDb) readLayers() {
-	m.layerIds = make(map[string]int)
+func (m *TileDb) readLayers() error {
 	rows, err := m.db.Query("SELECT rowid, layer_name FROM layers")
 	if err != nil {
-		log.Fatal("Error fetching layer definitions", err.Error())
+		return err
 	}
+	defer rows.Close()
+	layerIds := make(map[string]int)
 	var s string
 	var i int
 	for rows.Next() {
 		if err := rows.Scan(&i, &s); err != nil {
-			log.Fatal(err)
+			return err
 		}
-		m.layerIds[s] = i
+		layerIds[s] = i
 	}
 	if err := rows.Err(); err != nil {
-		log.Fatal(err)
+		return err
 	}
+	m.layerIds = layerIds
+	return nil
 }
 
 var layerMx sync.RWMutex
@@ -98,13 +180,22 @@ func (m *TileDb) ensureLayer(layer string) {
 		if _, err := m.db.Exec("INSERT OR IGNORE INTO layers(layer_name) VALUES(?)", layer); err != nil {
 			log.Println(err)
 		}
-		m.readLayers()
+		if err := m.readLayers(); err != nil {
+			log.Println("error fetching layer definitions", err)
+		}
 		return
 	}
 	layerMx.RUnlock()
 }
 
 func (m *TileDb) Close() {
+	if m.janitorStop != nil {
+		close(m.janitorStop)
+	}
+	// wait for queueInsert calls in progress, Run still receives them
+	m.closedMx.Lock()
+	m.closed = true
+	m.closedMx.Unlock()
 	close(m.insertChan)
 	close(m.requestChan)
 	if m.qc != nil {
@@ -116,211 +207,224 @@ func (m *TileDb) Close() {
 
 }
 
+// InsertQueue returns the insert queue. It must not be used after Close,
+// see insertTiles for a safe way to queue tiles.
 func (m *TileDb) InsertQueue() chan<- TileFetchResult {
 	return m.insertChan
 }
 
+// queueInsert queues a tile for insertion. The tile is dropped if the
+// TileDb is closed or the queue is full, so slow writes do not hold up
+// the requests that rendered the tiles.
+func (m *TileDb) queueInsert(t TileFetchResult) {
+	m.closedMx.RLock()
+	defer m.closedMx.RUnlock()
+	if m.closed {
+		return
+	}
+	select {
+	case m.insertChan <- t:
+	default:
+		atomic.AddUint64(&m.dropped, 1)
+	}
+}
+
+// DroppedInserts returns the number of tiles that were not cached because
+// the insert queue was full.
+func (m *TileDb) DroppedInserts() uint64 {
+	return atomic.LoadUint64(&m.dropped)
+}
+
 func (m *TileDb) RequestQueue() chan<- TileFetchRequest {
 	return m.requestChan
 }
 
-// Best executed in a dedicated go routine.
+// Run starts answering the request and insert queues.
+// Inserted tiles are buffered and written in batches of FlushSize tiles,
+// or FlushInterval after the first buffered tile, whichever comes first.
 func (m *TileDb) Run() {
 	m.qc = make(chan bool)
 	go func() {
-		requestClosed := false
-		insertClosed := false
-		for {
+		requestChan := m.requestChan
+		insertChan := m.insertChan
+		var buf []TileFetchResult
+		var flush <-chan time.Time
+		for requestChan != nil || insertChan != nil {
 			select {
-			case r, ok := <-m.requestChan:
+			case r, ok := <-requestChan:
 				if !ok {
-					requestClosed = true
+					requestChan = nil
 				} else {
 					go m.fetch(r)
 				}
-			case i, ok := <-m.insertChan:
+			case i, ok := <-insertChan:
 				if !ok {
-					insertClosed = true
-				} else {
-					go m.insert(i)
+					insertChan = nil
+					break
 				}
+				buf = append(buf, i)
+				if len(buf) == 1 {
+					flush = time.After(m.flushInterval())
+				}
+				if len(buf) < m.flushSize() {
+					break
+				}
+				m.BatchInsert(buf)
+				buf, flush = nil, nil
+			case <-flush:
+				m.BatchInsert(buf)
+				buf, flush = nil, nil
 			}
-			if requestClosed && insertClosed {
-				break
-			}
+		}
+		if len(buf) > 0 {
+			m.BatchInsert(buf)
 		}
 		m.qc <- true
 	}()
 }
 
-type batchBlob struct {
-	data     []byte
-	checksum string
+func (m *TileDb) flushSize() int {
+	if m.FlushSize <= 0 {
+		return 100
+	}
+	return m.FlushSize
 }
 
-type batchTile struct {
-	layerID int
-	z       uint64
-	x       uint64
-	y       uint64
-	s       string
+func (m *TileDb) flushInterval() time.Duration {
+	if m.FlushInterval <= 0 {
+		return time.Second
+	}
+	return m.FlushInterval
 }
 
-// maximum length of inserts is 199 due to SQLITE_MAX_VARIABLE_NUMBER being 999
+// BatchInsert stores the tiles in a single transaction, or in transactions
+// of ChunkSize tiles if ChunkSize is set. Tiles whose content did not change
+// are not written again.
 func (m *TileDb) BatchInsert(inserts []TileFetchResult) {
-	m.dbLock.Lock()
-	defer m.dbLock.Unlock()
-
-	tiles := make([]batchTile, 0)
-	blobs := make([]batchBlob, 0)
-
-	var tilesMx sync.Mutex
-	var blobsMx sync.Mutex
-	var wg sync.WaitGroup
-	wg.Add(len(inserts))
-
-	tileSql := "REPLACE INTO layered_tiles VALUES" // VALUES(?, ?, ?, ?, ?) m.layerIds[l], z, x, y, s
-	blobSql := "REPLACE INTO tile_blobs VALUES"    // VALUES(?,?) checksum, blob
+	if m.DedupSolid {
+		// decoding the tiles is slow, don't hold the database lock for it
+		normalized := make([]TileFetchResult, len(inserts))
+		for idx, i := range inserts {
+			i.BlobPNG = normalizeSolid(i.BlobPNG)
+			normalized[idx] = i
+		}
+		inserts = normalized
+	}
 
+	// layers are created outside of the transaction
 	for idx := range inserts {
-		i := &inserts[idx]
-		go func() {
-			defer wg.Done()
-			i.Coord.setTMS(true)
-			x, y, z, l := i.Coord.X, i.Coord.Y, i.Coord.Zoom, i.Coord.Layer
-			if l == "" {
-				l = "default"
-			}
-			m.ensureLayer(l)
-			s := fmt.Sprintf("%x", md5.Sum(i.BlobPNG))
-
-			tilesMx.Lock()
-			tiles = append(tiles, batchTile{
-				layerID: m.layerIds[l],
-				z:       z,
-				x:       x,
-				y:       y,
-				s:       s,
-			})
-			tilesMx.Unlock()
-
-			row := m.db.QueryRow("SELECT 1 FROM tile_blobs WHERE checksum=?", s)
-			var dummy uint64
-			err := row.Scan(&dummy)
-			switch {
-			case err == sql.ErrNoRows:
-				blobsMx.Lock()
-				defer blobsMx.Unlock()
-				blobs = append(blobs, batchBlob{
-					data:     i.BlobPNG,
-					checksum: s,
-				})
-				return
-			case err != nil:
-				log.Println("error during test", err)
-				return
-			default:
-				//log.Println("Reusing blob", s)
-			}
-		}()
+		m.ensureLayer(inserts[idx].Coord.CacheLayer())
 	}
 
-	wg.Wait()
-
-	if len(blobs) > 0 {
-		first := true
-		args := make([]interface{}, 0, 2*len(blobs))
-		for idx := range blobs {
-			if first {
-				first = false
-			} else {
-				blobSql += ","
-			}
-			blobSql += "(?, ?)"
-			blob := &blobs[idx]
-			args = append(args, blob.checksum, blob.data)
-		}
+	m.insertMx.Lock()
+	defer m.insertMx.Unlock()
+	m.dbLock.RLock()
+	defer m.dbLock.RUnlock()
 
-		blobStatement, err := m.db.Prepare(blobSql + ";")
-		if err != nil {
-			log.Println("error during blob statement preparation", err)
-			return
+	chunk := m.ChunkSize
+	if chunk <= 0 {
+		chunk = len(inserts)
+	}
+	written := make([]TileCoord, 0, len(inserts))
+	for start := 0; start < len(inserts); start += chunk {
+		end := start + chunk
+		if end > len(inserts) {
+			end = len(inserts)
 		}
-		defer blobStatement.Close()
-
-		_, err = blobStatement.Exec(args...)
+		coords, err := m.insertChunk(inserts[start:end])
 		if err != nil {
-			log.Println("error inserting blobs", err)
-			return
-		}
-	}
-
-	first := true
-	args := make([]interface{}, 0, 5*len(blobs))
-	for idx := range tiles {
-		if first {
-			first = false
-		} else {
-			tileSql += ","
+			log.Println("error inserting tiles", err)
+			if m.ReportError != nil {
+				c := inserts[start].Coord
+				m.ReportError(ErrorReport{Err: err, Layer: c.Layer, Coord: c, Stage: StageCacheWrite})
+			}
+			break
 		}
-		tileSql += "(?, ?, ?, ?, ?)" // m.layerIds[l], z, x, y, s
-		tile := &tiles[idx]
-		args = append(args, tile.layerID, tile.z, tile.x, tile.y, tile.s)
+		written = append(written, coords...)
 	}
+	m.extendExtent(written)
+}
 
-	tileStatement, err := m.db.Prepare(tileSql + ";")
+// insertChunk writes the tiles in one transaction and returns the
+// coordinates of the written tiles.
+func (m *TileDb) insertChunk(inserts []TileFetchResult) ([]TileCoord, error) {
+	tx, err := m.db.Begin()
 	if err != nil {
-		log.Println("error during tile statement preparation", err)
-		return
+		return nil, err
 	}
-	defer tileStatement.Close()
+	defer tx.Rollback()
 
-	_, err = tileStatement.Exec(args...)
+	checksumStmt, err := tx.Prepare("SELECT checksum FROM layered_tiles WHERE layer_id=? AND zoom_level=? AND tile_column=? AND tile_row=?")
 	if err != nil {
-		log.Println("error inserting tiles", err)
-		return
+		return nil, err
 	}
-}
-
-func (m *TileDb) insert(i TileFetchResult) {
-	m.dbLock.Lock()
-	defer m.dbLock.Unlock()
-	i.Coord.setTMS(true)
-	x, y, z, l := i.Coord.X, i.Coord.Y, i.Coord.Zoom, i.Coord.Layer
-	if l == "" {
-		l = "default"
+	defer checksumStmt.Close()
+	blobStmt, err := tx.Prepare("INSERT OR IGNORE INTO tile_blobs VALUES(?, ?)")
+	if err != nil {
+		return nil, err
 	}
-	h := md5.New()
-	_, err := h.Write(i.BlobPNG)
+	defer blobStmt.Close()
+	tileStmt, err := tx.Prepare("REPLACE INTO layered_tiles(layer_id, zoom_level, tile_column, tile_row, checksum, updated_at, checked_at, render_ms, size, renderer) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
-		log.Println(err)
-		return
+		return nil, err
 	}
-	s := fmt.Sprintf("%x", h.Sum(nil))
-	row := m.db.QueryRow("SELECT 1 FROM tile_blobs WHERE checksum=?", s)
-	var dummy uint64
-	err = row.Scan(&dummy)
-	switch {
-	case err == sql.ErrNoRows:
-		if _, err = m.db.Exec("REPLACE INTO tile_blobs VALUES(?,?)", s, i.BlobPNG); err != nil {
-			log.Println("error during insert", err)
-			return
+	defer tileStmt.Close()
+
+	now := time.Now().Unix()
+	written := make([]TileCoord, 0, len(inserts))
+	for idx := range inserts {
+		i := &inserts[idx]
+		c := i.Coord
+		c.SetTMS(true)
+		l := c.CacheLayer()
+		layerID := m.layerIds[l]
+		blob := i.BlobPNG
+		s := fmt.Sprintf("%x", md5.Sum(blob))
+		var renderMs, renderer interface{}
+		if i.Stats != nil {
+			renderMs = i.Stats.Duration.Nanoseconds() / int64(time.Millisecond)
+			renderer = i.Stats.Renderer
 		}
-	case err != nil:
-		log.Println("error during test", err)
-		return
-	default:
-		//log.Println("Reusing blob", s)
-	}
-	m.ensureLayer(l)
-	sql := "REPLACE INTO layered_tiles VALUES(?, ?, ?, ?, ?)"
-	if _, err = m.db.Exec(sql, m.layerIds[l], z, x, y, s); err != nil {
-		log.Println(err)
+
+		var stored string
+		err := checksumStmt.QueryRow(layerID, c.Zoom, c.X, c.Y).Scan(&stored)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if stored == s {
+			// tile content did not change, leave the stored row alone
+			if err := m.touch(tx, l, layerID, c.Zoom, c.X, c.Y, now); err != nil {
+				return nil, err
+			}
+			if i.Stats != nil {
+				if _, err := tx.Exec("UPDATE layered_tiles SET render_ms=?, renderer=? WHERE layer_id=? AND zoom_level=? AND tile_column=? AND tile_row=?", renderMs, renderer, layerID, c.Zoom, c.X, c.Y); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+
+		if _, err := blobStmt.Exec(s, blob); err != nil {
+			return nil, err
+		}
+		if _, err := tileStmt.Exec(layerID, c.Zoom, c.X, c.Y, s, now, now, renderMs, len(blob), renderer); err != nil {
+			return nil, err
+		}
+		written = append(written, c)
 	}
+
+	return written, tx.Commit()
 }
 
 // BatchCheck checks whether the provided coordinates have tiles in the database.
 func (m *TileDb) BatchCheck(coords []TileCoord) []bool {
+	return m.BatchCheckSince(coords, time.Time{})
+}
+
+// BatchCheckSince checks whether the provided coordinates have tiles in the
+// database that were rendered at or after since. A zero since matches all
+// tiles. Expired tiles are reported as missing.
+func (m *TileDb) BatchCheckSince(coords []TileCoord, since time.Time) []bool {
 
 	queryString := `
 		SELECT 1
@@ -332,6 +436,7 @@ func (m *TileDb) BatchCheck(coords []TileCoord) []bool {
 				AND tile_column=?
 				AND tile_row=?
 				AND layer_id=(SELECT rowid FROM layers WHERE layer_name=?)
+				AND (? = 0 OR COALESCE(checked_at, updated_at, 0) >= ?)
 		)`
 
 	m.dbLock.RLock()
@@ -346,12 +451,13 @@ func (m *TileDb) BatchCheck(coords []TileCoord) []bool {
 
 	results := make([]bool, len(coords))
 	for i, coord := range coords {
-		coord.setTMS(true)
-		l := coord.Layer
-		if l == "" {
-			l = "default"
+		coord.SetTMS(true)
+		l := coord.CacheLayer()
+		var sinceUnix int64
+		if fresh := m.freshAfter(l, since); !fresh.IsZero() {
+			sinceUnix = fresh.Unix()
 		}
-		row := selectStatement.QueryRow(coord.Zoom, coord.X, coord.Y, l)
+		row := selectStatement.QueryRow(coord.Zoom, coord.X, coord.Y, l, sinceUnix, sinceUnix)
 		var dummy uint64
 		err := row.Scan(&dummy)
 		switch {
@@ -369,36 +475,40 @@ func (m *TileDb) BatchCheck(coords []TileCoord) []bool {
 }
 
 func (m *TileDb) fetch(r TileFetchRequest) {
+	result := TileFetchResult{Coord: r.Coord}
+	result.BlobPNG, result.Error = m.Fetch(r.Coord)
+	r.OutChan <- result
+}
+
+// Fetch returns the stored tile, or nil if it is not in the database.
+func (m *TileDb) Fetch(c TileCoord) ([]byte, error) {
 	m.dbLock.RLock()
 	defer m.dbLock.RUnlock()
-	r.Coord.setTMS(true)
-	zoom, x, y, l := r.Coord.Zoom, r.Coord.X, r.Coord.Y, r.Coord.Layer
-	if l == "" {
-		l = "default"
-	}
-	result := TileFetchResult{r.Coord, nil, nil}
+	c.SetTMS(true)
+	zoom, x, y, l := c.Zoom, c.X, c.Y, c.CacheLayer()
 	queryString := `
-		SELECT tile_data 
-		FROM tile_blobs 
-		WHERE checksum=(
-			SELECT checksum 
-			FROM layered_tiles 
-			WHERE zoom_level=? 
-				AND tile_column=? 
-				AND tile_row=?
-				AND layer_id=(SELECT rowid FROM layers WHERE layer_name=?)
-		)`
+		SELECT tile_data, COALESCE(checked_at, updated_at, 0)
+		FROM layered_tiles
+		JOIN tile_blobs ON tile_blobs.checksum = layered_tiles.checksum
+		WHERE zoom_level=?
+			AND tile_column=?
+			AND tile_row=?
+			AND layer_id=(SELECT rowid FROM layers WHERE layer_name=?)`
 	var blob []byte
+	var checked int64
 	row := m.db.QueryRow(queryString, zoom, x, y, l)
-	err := row.Scan(&blob)
+	err := row.Scan(&blob, &checked)
 	switch {
 	case err == sql.ErrNoRows:
-		result.BlobPNG = nil
+		return nil, nil
 	case err != nil:
 		log.Println(err)
-		result.Error = err
-	default:
-		result.BlobPNG = blob
+		return nil, err
 	}
-	r.OutChan <- result
+	if fresh := m.freshAfter(l, time.Time{}); !fresh.IsZero() && checked < fresh.Unix() {
+		// expired tiles are treated as missing so they get rendered again
+		return nil, nil
+	}
+	m.recordAccess(l, zoom, x, y)
+	return blob, nil
 }
@@ -0,0 +1,74 @@
+package maptiles
+
+import (
+	"database/sql"
+	"os"
+)
+
+// MBTilesSource serves the tiles of an existing MBTiles file without
+// rendering anything. For go-mapnik cache files the default layer is served.
+type MBTilesSource struct {
+	db     *sql.DB
+	format string
+}
+
+// OpenMBTilesSource opens an MBTiles file read-only.
+func OpenMBTilesSource(path string) (*MBTilesSource, error) {
+	// sql.Open does not fail for missing files, and sqlite would create one
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	s := &MBTilesSource{db: db}
+	err = db.QueryRow("SELECT value FROM metadata WHERE name='format'").Scan(&s.format)
+	if err != nil && err != sql.ErrNoRows {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Format returns the tile format from the metadata of the file, e.g. png
+// or pbf for gzip compressed vector tiles.
+func (s *MBTilesSource) Format() string {
+	return s.format
+}
+
+func (s *MBTilesSource) Close() error {
+	return s.db.Close()
+}
+
+// RenderTile returns the stored tile, or nil if the file does not contain it.
+func (s *MBTilesSource) RenderTile(c TileCoord) ([]byte, error) {
+	c.SetTMS(true)
+	var blob []byte
+	err := s.db.QueryRow("SELECT tile_data FROM tiles WHERE zoom_level=? AND tile_column=? AND tile_row=?", c.Zoom, c.X, c.Y).Scan(&blob)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return blob, err
+}
+
+func (s *MBTilesSource) RenderMetaTile(c MetaTileCoord) ([]TileFetchResult, error) {
+	coords := c.TileCoords()
+	results := make([]TileFetchResult, 0, len(coords))
+	for _, tc := range coords {
+		blob, err := s.RenderTile(tc)
+		results = append(results, TileFetchResult{Coord: tc, BlobPNG: blob, Error: err})
+	}
+	return results, nil
+}
+
+// Listen answers TileFetchRequests on c until it is closed.
+func (s *MBTilesSource) Listen(c <-chan FetchRequest) {
+	for request := range c {
+		processRequest(s, request)
+	}
+}
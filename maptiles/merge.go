@@ -0,0 +1,161 @@
+package maptiles
+
+import (
+	"crypto/md5"
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// MergePolicy decides which tile is kept when a merged file contains a tile
+// that is already in the database.
+type MergePolicy int
+
+const (
+	// MergeKeepExisting keeps the tile already in the database, so files
+	// merged earlier take priority.
+	MergeKeepExisting MergePolicy = iota
+
+	// MergeOverwrite replaces the tile, so files merged later take priority.
+	MergeOverwrite
+
+	// MergeNewest keeps the tile that was written last. Tiles of standard
+	// MBTiles files and of cache files written before tiles had an update
+	// time have the modification time of the file.
+	MergeNewest
+)
+
+// MergeOptions configures TileDb.Merge.
+type MergeOptions struct {
+	// Layer is the layer tiles of standard MBTiles files are stored under.
+	// If empty, the default layer is used.
+	Layer string
+
+	Policy MergePolicy
+}
+
+// Merge copies the tiles of another go-mapnik cache file or a standard
+// MBTiles file into the database.
+func (m *TileDb) Merge(path string, opts MergeOptions) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	src, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	var n int
+	if err := src.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name='layered_tiles'").Scan(&n); err != nil {
+		return err
+	}
+	var query string
+	var args []interface{}
+	if n > 0 {
+		// the source is not migrated, old cache files have no updated_at
+		var updated int
+		row := src.QueryRow("SELECT COUNT(*) FROM pragma_table_info('layered_tiles') WHERE name='updated_at'")
+		if err := row.Scan(&updated); err != nil {
+			return err
+		}
+		updatedAt := "COALESCE(updated_at, 0)"
+		if updated == 0 {
+			updatedAt = "?"
+			args = []interface{}{fi.ModTime().Unix()}
+		}
+		query = `
+			SELECT layer_name, zoom_level, tile_column, tile_row, tile_data, ` + updatedAt + `
+			FROM layered_tiles
+			JOIN layers ON layers.rowid = layered_tiles.layer_id
+			JOIN tile_blobs ON tile_blobs.checksum = layered_tiles.checksum`
+	} else {
+		layer := opts.Layer
+		if layer == "" {
+			layer = "default"
+		}
+		query = "SELECT ? AS layer_name, zoom_level, tile_column, tile_row, tile_data, ? FROM tiles"
+		args = []interface{}{layer, fi.ModTime().Unix()}
+	}
+
+	// create the layers before the transaction locks the database
+	layerRows, err := src.Query("SELECT DISTINCT layer_name FROM ("+query+")", args...)
+	if err != nil {
+		return err
+	}
+	for layerRows.Next() {
+		var l string
+		if err := layerRows.Scan(&l); err != nil {
+			layerRows.Close()
+			return err
+		}
+		m.ensureLayer(l)
+	}
+	layerRows.Close()
+
+	rows, err := src.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	m.dbLock.Lock()
+	defer m.dbLock.Unlock()
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var merged []TileCoord
+	for rows.Next() {
+		var l string
+		var z, x, y uint64
+		var data []byte
+		var updated int64
+		if err := rows.Scan(&l, &z, &x, &y, &data, &updated); err != nil {
+			return err
+		}
+		layerID := m.layerIds[l]
+
+		if opts.Policy != MergeOverwrite {
+			var existing int64
+			err := tx.QueryRow(`
+				SELECT COALESCE(updated_at, 0)
+				FROM layered_tiles
+				WHERE layer_id=? AND zoom_level=? AND tile_column=? AND tile_row=?`,
+				layerID, z, x, y).Scan(&existing)
+			switch {
+			case err == sql.ErrNoRows:
+			case err != nil:
+				return err
+			case opts.Policy == MergeKeepExisting, updated <= existing:
+				continue
+			}
+		}
+
+		s := fmt.Sprintf("%x", md5.Sum(data))
+		if _, err := tx.Exec("INSERT OR IGNORE INTO tile_blobs VALUES(?, ?)", s, data); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`
+			REPLACE INTO layered_tiles(layer_id, zoom_level, tile_column, tile_row, checksum, updated_at)
+			VALUES(?, ?, ?, ?, ?, ?)`,
+			layerID, z, x, y, s, updated)
+		if err != nil {
+			return err
+		}
+		merged = append(merged, TileCoord{X: x, Y: y, Zoom: z, Tms: true, Layer: l})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	m.extendExtent(merged)
+	return nil
+}
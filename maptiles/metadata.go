@@ -0,0 +1,129 @@
+package maptiles
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+)
+
+// GetMetadata returns a value of the metadata table, or an empty string
+// if it is not set.
+func (m *TileDb) GetMetadata(name string) (string, error) {
+	m.dbLock.RLock()
+	defer m.dbLock.RUnlock()
+	var value string
+	err := m.db.QueryRow("SELECT value FROM metadata WHERE name=?", name).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+// SetMetadata sets a value of the metadata table.
+// The bounds, minzoom and maxzoom values are maintained automatically
+// as tiles of the default layer are inserted.
+func (m *TileDb) SetMetadata(name, value string) error {
+	m.dbLock.Lock()
+	defer m.dbLock.Unlock()
+	_, err := m.db.Exec("REPLACE INTO metadata VALUES(?, ?)", name, value)
+	return err
+}
+
+// layerFormatKey is the metadata name of the tile format of a layer. The
+// format of the default layer is the MBTiles format value.
+func layerFormatKey(layer string) string {
+	if layer == "default" {
+		return "format"
+	}
+	return "format:" + layer
+}
+
+// metadataExtent is the zoom range and WGS84 bounds of the default layer.
+type metadataExtent struct {
+	valid            bool
+	minZoom, maxZoom uint64
+	bounds           [4]float64
+}
+
+func (e *metadataExtent) extend(z uint64, bounds [4]float64) bool {
+	if !e.valid {
+		*e = metadataExtent{true, z, z, bounds}
+		return true
+	}
+	old := *e
+	if z < e.minZoom {
+		e.minZoom = z
+	}
+	if z > e.maxZoom {
+		e.maxZoom = z
+	}
+	e.bounds[0] = math.Min(e.bounds[0], bounds[0])
+	e.bounds[1] = math.Min(e.bounds[1], bounds[1])
+	e.bounds[2] = math.Max(e.bounds[2], bounds[2])
+	e.bounds[3] = math.Max(e.bounds[3], bounds[3])
+	return *e != old
+}
+
+// tmsRangeBounds returns the WGS84 bounds of a range of TMS tiles.
+func tmsRangeBounds(z, minX, minY, maxX, maxY uint64) [4]float64 {
+	n := uint64(1) << z
+	return metaTileBounds(MetaTileCoord{MinX: minX, MinY: n - 1 - maxY, MaxX: maxX, MaxY: n - 1 - minY, Zoom: z})
+}
+
+// loadExtent initializes the extent from the stored tiles of the default layer.
+func (m *TileDb) loadExtent() error {
+	rows, err := m.db.Query(`
+		SELECT zoom_level, MIN(tile_column), MIN(tile_row), MAX(tile_column), MAX(tile_row)
+		FROM layered_tiles
+		WHERE layer_id=(SELECT rowid FROM layers WHERE layer_name='default')
+		GROUP BY zoom_level`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	m.extentMx.Lock()
+	defer m.extentMx.Unlock()
+	for rows.Next() {
+		var z, minX, minY, maxX, maxY uint64
+		if err := rows.Scan(&z, &minX, &minY, &maxX, &maxY); err != nil {
+			return err
+		}
+		m.extent.extend(z, tmsRangeBounds(z, minX, minY, maxX, maxY))
+	}
+	return rows.Err()
+}
+
+// extendExtent updates the bounds, minzoom and maxzoom metadata if the
+// inserted tiles of the default layer are outside the current extent.
+// It must be called with dbLock held.
+func (m *TileDb) extendExtent(coords []TileCoord) {
+	m.extentMx.Lock()
+	defer m.extentMx.Unlock()
+	changed := false
+	for _, c := range coords {
+		if c.CacheLayer() != "default" {
+			continue
+		}
+		c.SetTMS(true)
+		if m.extent.extend(c.Zoom, tmsRangeBounds(c.Zoom, c.X, c.Y, c.X, c.Y)) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	e := m.extent
+	values := [][2]string{
+		{"bounds", fmt.Sprintf("%f,%f,%f,%f", e.bounds[0], e.bounds[1], e.bounds[2], e.bounds[3])},
+		{"minzoom", strconv.FormatUint(e.minZoom, 10)},
+		{"maxzoom", strconv.FormatUint(e.maxZoom, 10)},
+	}
+	for _, v := range values {
+		if _, err := m.db.Exec("REPLACE INTO metadata VALUES(?, ?)", v[0], v[1]); err != nil {
+			log.Println("error updating metadata", err)
+		}
+	}
+}
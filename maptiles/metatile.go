@@ -0,0 +1,100 @@
+package maptiles
+
+import (
+	"github.com/nkovacs/go-mapnik/tilegrid"
+)
+
+// metaRender is a metatile render in progress. Requests for other tiles
+// of the same metatile wait for it instead of rendering it again.
+type metaRender struct {
+	done    chan bool
+	results []TileFetchResult
+	ok      bool
+}
+
+// enclosingMetaTile returns the XYZ metatile of size×size tiles containing c.
+// If grid is nil, the Web Mercator grid is used.
+func enclosingMetaTile(c TileCoord, size uint64, grid *tilegrid.Grid) MetaTileCoord {
+	c.SetTMS(false)
+	minX := c.X / size * size
+	minY := c.Y / size * size
+	mc := MetaTileCoord{
+		MinX:      minX,
+		MinY:      minY,
+		MaxX:      tilegrid.ClampTile(minX+size-1, c.Zoom),
+		MaxY:      tilegrid.ClampTile(minY+size-1, c.Zoom),
+		Zoom:      c.Zoom,
+		Layer:     c.Layer,
+		MapLayers: c.MapLayers,
+		Variables: c.Variables,
+		Format:    c.Format,
+	}
+	if grid != nil {
+		cols, rows := grid.MatrixSize(c.Zoom)
+		if mc.MaxX = minX + size - 1; mc.MaxX >= cols {
+			mc.MaxX = cols - 1
+		}
+		if mc.MaxY = minY + size - 1; mc.MaxY >= rows {
+			mc.MaxY = rows - 1
+		}
+	}
+	return mc
+}
+
+// renderMetaTile renders the metatile containing tc, stores its tiles in
+// the cache and returns the result for tc. It returns false if the layer
+// does not exist. requestID is passed on to the renderer if the metatile
+// is not being rendered already.
+func (t *TileServer) renderMetaTile(tc TileCoord, cache TileCache, size uint64, grid *tilegrid.Grid, requestID string) (TileFetchResult, bool) {
+	mc := enclosingMetaTile(tc, size, grid)
+
+	t.inflightMx.Lock()
+	mr, rendering := t.inflight[mc]
+	if !rendering {
+		mr = &metaRender{done: make(chan bool)}
+		t.inflight[mc] = mr
+	}
+	t.inflightMx.Unlock()
+
+	if !rendering {
+		ch := make(chan TileFetchResult, mc.Count())
+		mr.ok = t.lmp.SubmitRequest(MetaTileFetchRequest{mc, ch, requestID})
+		if mr.ok {
+			mr.results = make([]TileFetchResult, 0, mc.Count())
+			for n := uint64(0); n < mc.Count(); n++ {
+				mr.results = append(mr.results, <-ch)
+			}
+		}
+		t.inflightMx.Lock()
+		delete(t.inflight, mc)
+		t.inflightMx.Unlock()
+		close(mr.done)
+
+		if mr.ok && cache != nil {
+			tiles := make([]TileFetchResult, 0, len(mr.results))
+			for _, r := range mr.results {
+				if r.Error == nil && r.BlobPNG != nil && !t.skipCaching(r.BlobPNG, grid) {
+					tiles = append(tiles, r)
+				}
+			}
+			if len(tiles) > 0 {
+				insertTiles(cache, tiles)
+			}
+		}
+	} else {
+		<-mr.done
+	}
+
+	if !mr.ok {
+		return TileFetchResult{}, false
+	}
+	xyz := tc
+	xyz.SetTMS(false)
+	for _, r := range mr.results {
+		if r.Coord.X == xyz.X && r.Coord.Y == xyz.Y {
+			r.Coord = tc
+			return r, true
+		}
+	}
+	return TileFetchResult{Coord: tc}, true
+}
@@ -0,0 +1,59 @@
+package maptiles
+
+import (
+	"net/http"
+	"time"
+)
+
+// Middleware wraps the handler of a TileServer, e.g. to add
+// authentication, metrics or headers. See TileServer.Use.
+type Middleware func(http.Handler) http.Handler
+
+// Use adds middleware around ServeHTTP and the handlers returned by
+// Handler. The first middleware added is the outermost one, so it sees
+// requests first, before the built-in access checks.
+func (t *TileServer) Use(mw ...Middleware) {
+	t.mwMx.Lock()
+	t.middleware = append(t.middleware, mw...)
+	t.mwMx.Unlock()
+}
+
+// chain wraps h in the middleware.
+func (t *TileServer) chain(h http.Handler) http.Handler {
+	t.mwMx.RLock()
+	defer t.mwMx.RUnlock()
+	for i := len(t.middleware) - 1; i >= 0; i-- {
+		h = t.middleware[i](h)
+	}
+	return h
+}
+
+// RenderHooks are called around renders of tiles requested through
+// ServeTileRequest that are not found in the cache.
+type RenderHooks struct {
+	// BeforeRender is called before the tile is rendered. If it returns
+	// an error, the tile is not rendered and the request is answered
+	// with 403 Forbidden and the error.
+	BeforeRender func(r *http.Request, tc TileCoord) error
+
+	// AfterRender is called with the result and the time it took, before
+	// the tile is sent and cached.
+	AfterRender func(r *http.Request, result TileFetchResult, d time.Duration)
+}
+
+func (t *TileServer) beforeRender(w http.ResponseWriter, r *http.Request, tc TileCoord) bool {
+	if t.Hooks.BeforeRender == nil {
+		return true
+	}
+	if err := t.Hooks.BeforeRender(r, tc); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (t *TileServer) afterRender(r *http.Request, result TileFetchResult, d time.Duration) {
+	if t.Hooks.AfterRender != nil {
+		t.Hooks.AfterRender(r, result, d)
+	}
+}
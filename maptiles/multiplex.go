@@ -3,11 +3,43 @@ package maptiles
 import (
 	"log"
 	"runtime"
+	"sort"
+	"sync"
+	"time"
 )
 
+// defaultCloseTimeout is how long Close waits for busy renderers without
+// a watchdog hang timeout.
+const defaultCloseTimeout = 30 * time.Second
+
+// source is the channel of a layer.
+type source struct {
+	c chan<- FetchRequest
+	// pending counts the submissions in progress, so the channel can be
+	// closed once they are done
+	pending sync.WaitGroup
+	// removed is closed when the layer is removed or replaced, which
+	// aborts the submissions waiting for busy renderers
+	removed chan struct{}
+}
+
+func newSource(c chan<- FetchRequest) *source {
+	return &source{c: c, removed: make(chan struct{})}
+}
+
 type LayerMultiplex struct {
-	layerChans   map[string]chan<- FetchRequest
+	layerChans   map[string]*source
 	numRenderers int
+	mu           sync.RWMutex
+
+	// renderers tracks the renderers started by the multiplex
+	renderers sync.WaitGroup
+
+	// limiter is shared by the mapnik renderers, see SetRenderLimit
+	limiter *renderLimiter
+
+	// watchdog of the mapnik renderers, see SetWatchdog
+	watchdog Watchdog
 }
 
 func NewLayerMultiplex(numRenderers int) *LayerMultiplex {
@@ -15,44 +47,245 @@ func NewLayerMultiplex(numRenderers int) *LayerMultiplex {
 		numRenderers = runtime.GOMAXPROCS(0)
 	}
 	l := LayerMultiplex{
-		layerChans:   make(map[string]chan<- FetchRequest),
+		layerChans:   make(map[string]*source),
 		numRenderers: numRenderers,
+		limiter:      new(renderLimiter),
 	}
 	return &l
 }
 
-func DefaultRenderMultiplex(defaultStylesheet string, numRenderers int) *LayerMultiplex {
+func DefaultRenderMultiplex(defaultStylesheet string, numRenderers int) (*LayerMultiplex, error) {
 	l := NewLayerMultiplex(numRenderers)
-	renderer := l.CreateRenderer(defaultStylesheet)
+	renderer, err := l.CreateRenderer(defaultStylesheet)
+	if err != nil {
+		return nil, err
+	}
 	l.AddSource("", renderer)
 	l.AddSource("default", renderer)
-	return l
+	return l, nil
 }
 
-func (l *LayerMultiplex) CreateRenderer(stylesheet string) chan<- FetchRequest {
+func (l *LayerMultiplex) CreateRenderer(stylesheet string) (chan<- FetchRequest, error) {
+	return l.CreateRendererFromConfig(RendererConfig{Stylesheet: stylesheet})
+}
+
+// CreateRendererFromConfig starts numRenderers renderers listening on the
+// returned channel. The stylesheet is loaded once and the other renderers
+// are clones of the first. If the stylesheet cannot be loaded, no renderer
+// is started and the error is returned. The renderers are replaced
+// according to the watchdog set by SetWatchdog.
+func (l *LayerMultiplex) CreateRendererFromConfig(cfg RendererConfig) (chan<- FetchRequest, error) {
+	first, err := NewTileRendererFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	first.limiter = l.limiter
+	renderers := make([]*TileRenderer, 0, l.numRenderers)
+	renderers = append(renderers, first)
+	for i := 1; i < l.numRenderers; i++ {
+		renderers = append(renderers, first.Clone())
+	}
+
+	l.mu.RLock()
+	dog := l.watchdog
+	l.mu.RUnlock()
+	if dog.enabled() {
+		return l.startWatchdogPool(cfg, dog, renderers), nil
+	}
+
 	c := make(chan FetchRequest)
-	for i := 0; i < l.numRenderers; i++ {
-		renderer := NewTileRenderer(stylesheet)
-		go renderer.Listen(c)
+	l.renderers.Add(len(renderers))
+	for _, renderer := range renderers {
+		go func(renderer *TileRenderer) {
+			defer l.renderers.Done()
+			defer renderer.Close()
+			renderer.Listen(c)
+		}(renderer)
 	}
 
+	return c, nil
+}
+
+// CreateSource starts concurrency listeners answering the requests on the
+// returned channel with r, which must be safe for concurrent use if
+// concurrency is greater than one. If concurrency is zero, numRenderers
+// is used. When the channel is closed, r is closed if it has a Close method.
+func (l *LayerMultiplex) CreateSource(r Renderer, concurrency int) chan<- FetchRequest {
+	if concurrency <= 0 {
+		concurrency = l.numRenderers
+	}
+	c := make(chan FetchRequest)
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for request := range c {
+				processRequest(r, request)
+			}
+		}()
+	}
+	l.renderers.Add(1)
+	go func() {
+		defer l.renderers.Done()
+		wg.Wait()
+		closeRenderer(r)
+	}()
 	return c
 }
 
-func (l *LayerMultiplex) AddRenderer(name string, stylesheet string) {
-	l.AddSource(name, l.CreateRenderer(stylesheet))
+func (l *LayerMultiplex) AddRenderer(name string, stylesheet string) error {
+	c, err := l.CreateRenderer(stylesheet)
+	if err != nil {
+		return err
+	}
+	l.AddSource(name, c)
+	return nil
 }
 
 func (l *LayerMultiplex) AddSource(name string, fetchChan chan<- FetchRequest) {
-	l.layerChans[name] = fetchChan
+	l.ReplaceSource(name, fetchChan)
 }
 
-func (l LayerMultiplex) SubmitRequest(r FetchRequest) bool {
-	c, ok := l.layerChans[r.GetLayer()]
-	if ok {
-		c <- r
-	} else {
-		log.Println("No such layer", r.GetLayer())
+// addSourceIfAbsent adds the channel of a layer unless the layer already
+// exists, and reports whether it was added.
+func (l *LayerMultiplex) addSourceIfAbsent(name string, fetchChan chan<- FetchRequest) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.layerChans[name]; ok {
+		return false
 	}
+	l.layerChans[name] = newSource(fetchChan)
+	return true
+}
+
+// RemoveSource removes a layer and returns its channel. Once RemoveSource
+// returns, no more requests are submitted to the channel, so the caller may
+// close it. Submissions waiting for busy renderers of the layer are
+// aborted and fail.
+func (l *LayerMultiplex) RemoveSource(name string) (chan<- FetchRequest, bool) {
+	l.mu.Lock()
+	s, ok := l.layerChans[name]
+	delete(l.layerChans, name)
+	l.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	s.remove()
+	return s.c, true
+}
+
+// ReplaceSource replaces the channel of a layer and returns the previous
+// one. Like with RemoveSource, no more requests are submitted to the
+// previous channel once ReplaceSource returns. Submissions waiting for
+// the previous renderers are submitted to the new channel.
+func (l *LayerMultiplex) ReplaceSource(name string, fetchChan chan<- FetchRequest) (chan<- FetchRequest, bool) {
+	l.mu.Lock()
+	s, ok := l.layerChans[name]
+	l.layerChans[name] = newSource(fetchChan)
+	l.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	s.remove()
+	return s.c, true
+}
+
+// remove aborts the submissions to a source that is no longer registered
+// and waits until they have returned.
+func (s *source) remove() {
+	close(s.removed)
+	s.pending.Wait()
+}
+
+// Layers returns the sorted names of the layers.
+func (l *LayerMultiplex) Layers() []string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	names := make([]string, 0, len(l.layerChans))
+	for name := range l.layerChans {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (l *LayerMultiplex) hasSource(name string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.layerChans[name]
 	return ok
 }
+
+// SubmitRequest sends the request to the renderers of its layer. It
+// returns false if there is no such layer or the layer is removed while
+// the request waits for its renderers.
+func (l *LayerMultiplex) SubmitRequest(r FetchRequest) bool {
+	for {
+		l.mu.RLock()
+		s, ok := l.layerChans[r.GetLayer()]
+		if ok {
+			// registered under the lock, so RemoveSource waits for the send
+			s.pending.Add(1)
+		}
+		l.mu.RUnlock()
+		if !ok {
+			log.Println("No such layer", r.GetLayer())
+			return false
+		}
+		// the lock is not held while sending, busy or hung renderers of one
+		// layer must not block the other layers or RemoveSource
+		select {
+		case s.c <- r:
+			s.pending.Done()
+			return true
+		case <-s.removed:
+			// try the replacement of the source, if any
+			s.pending.Done()
+		}
+	}
+}
+
+// Close removes all layers, closes their channels and waits until the
+// renderers started by the multiplex have finished and freed their maps.
+// Renderers still busy after the hang timeout of the watchdog, or 30
+// seconds without one, are abandoned like hung renderers: Close returns
+// and they free their maps when they finish.
+func (l *LayerMultiplex) Close() {
+	l.mu.Lock()
+	chans := make(map[chan<- FetchRequest]bool)
+	var sources []*source
+	for name, s := range l.layerChans {
+		chans[s.c] = true
+		sources = append(sources, s)
+		delete(l.layerChans, name)
+	}
+	l.mu.Unlock()
+
+	for _, s := range sources {
+		s.remove()
+	}
+	// a channel may be registered under several names
+	for c := range chans {
+		close(c)
+	}
+
+	l.mu.RLock()
+	timeout := l.watchdog.HangTimeout
+	l.mu.RUnlock()
+	if timeout == 0 {
+		timeout = defaultCloseTimeout
+	}
+	done := make(chan struct{})
+	go func() {
+		l.renderers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Println("Renderers still busy after", timeout, "abandoning them")
+	}
+}
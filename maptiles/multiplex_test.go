@@ -0,0 +1,94 @@
+package maptiles
+
+import (
+	"testing"
+	"time"
+)
+
+// within fails the test if f does not return in time, e.g. because it is
+// blocked by a hung layer.
+func within(t *testing.T, what string, f func()) {
+	t.Helper()
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal(what, "is blocked")
+	}
+}
+
+func TestSubmitRequestHungLayer(t *testing.T) {
+	l := NewLayerMultiplex(1)
+	hung := make(chan FetchRequest)
+	other := make(chan FetchRequest, 1)
+	l.AddSource("hung", hung)
+	l.AddSource("other", other)
+
+	submitted := make(chan bool)
+	go func() {
+		submitted <- l.SubmitRequest(TileFetchRequest{Coord: TileCoord{Layer: "hung"}})
+	}()
+	within(t, "SubmitRequest for another layer", func() {
+		if !l.SubmitRequest(TileFetchRequest{Coord: TileCoord{Layer: "other"}}) {
+			t.Error("request for other layer was not submitted")
+		}
+	})
+
+	// the pending submission is aborted whether it was waiting or not
+	within(t, "RemoveSource", func() { l.RemoveSource("hung") })
+	if <-submitted {
+		t.Error("request submitted to removed layer")
+	}
+	if l.SubmitRequest(TileFetchRequest{Coord: TileCoord{Layer: "hung"}}) {
+		t.Error("request submitted to removed layer")
+	}
+}
+
+func TestSubmitRequestReplacedLayer(t *testing.T) {
+	l := NewLayerMultiplex(1)
+	l.AddSource("layer", make(chan FetchRequest))
+
+	submitted := make(chan bool)
+	go func() {
+		submitted <- l.SubmitRequest(TileFetchRequest{Coord: TileCoord{Layer: "layer", Zoom: 3}})
+	}()
+	fresh := make(chan FetchRequest, 1)
+	within(t, "ReplaceSource", func() { l.ReplaceSource("layer", fresh) })
+	if !<-submitted {
+		t.Fatal("request was not submitted to the new source")
+	}
+	if r := <-fresh; r.(TileFetchRequest).Coord.Zoom != 3 {
+		t.Errorf("got request %v", r)
+	}
+}
+
+// blockingRenderer renders once release is closed.
+type blockingRenderer struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (r blockingRenderer) RenderTile(c TileCoord) ([]byte, error) {
+	close(r.started)
+	<-r.release
+	return nil, nil
+}
+
+func (r blockingRenderer) RenderMetaTile(c MetaTileCoord) ([]TileFetchResult, error) {
+	return nil, nil
+}
+
+func TestCloseHungRenderer(t *testing.T) {
+	l := NewLayerMultiplex(1)
+	l.SetWatchdog(Watchdog{HangTimeout: 10 * time.Millisecond})
+	r := blockingRenderer{make(chan struct{}), make(chan struct{})}
+	defer close(r.release)
+	l.AddSource("hung", l.CreateSource(r, 1))
+	go l.SubmitRequest(TileFetchRequest{Coord: TileCoord{Layer: "hung"}, OutChan: make(chan TileFetchResult, 1)})
+	<-r.started
+	within(t, "Close", l.Close)
+}
@@ -0,0 +1,429 @@
+package maptiles
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"sort"
+
+	"github.com/nkovacs/go-mapnik/mapnik"
+)
+
+// Marker is a filled circle drawn at a WGS84 coordinate.
+type Marker struct {
+	Pos mapnik.Coord
+	// Radius is in pixels. If zero, 6 is used.
+	Radius int
+	Color  color.Color
+}
+
+// Path is a line through WGS84 coordinates. If Fill is set, the path is
+// closed and filled as a polygon.
+type Path struct {
+	Coords []mapnik.Coord
+	// Holes are the inner rings of a filled polygon. They are left
+	// unfilled and outlined like Coords.
+	Holes [][]mapnik.Coord
+	// Width is the line width in pixels. If zero, 3 is used.
+	Width int
+	Color color.Color
+	Fill  color.Color
+}
+
+// Overlay contains the annotations drawn on top of a static map.
+type Overlay struct {
+	Markers []Marker
+	Paths   []Path
+}
+
+// maxOverlayVertices is the maximum number of markers and path vertices
+// in an overlay.
+const maxOverlayVertices = 10000
+
+// Empty reports whether the overlay has nothing to draw.
+func (o Overlay) Empty() bool {
+	return len(o.Markers) == 0 && len(o.Paths) == 0
+}
+
+// Validate returns an error if the overlay has a coordinate that is not a
+// finite number or more than maxOverlayVertices markers and vertices.
+func (o Overlay) Validate() error {
+	n := len(o.Markers)
+	for _, m := range o.Markers {
+		if !finiteCoord(m.Pos) {
+			return fmt.Errorf("invalid marker position %v,%v", m.Pos.X, m.Pos.Y)
+		}
+	}
+	for _, p := range o.Paths {
+		for _, ring := range append([][]mapnik.Coord{p.Coords}, p.Holes...) {
+			n += len(ring)
+			for _, c := range ring {
+				if !finiteCoord(c) {
+					return fmt.Errorf("invalid path coordinate %v,%v", c.X, c.Y)
+				}
+			}
+		}
+	}
+	if n > maxOverlayVertices {
+		return fmt.Errorf("too many markers and path vertices, the maximum is %d", maxOverlayVertices)
+	}
+	return nil
+}
+
+func finiteCoord(c mapnik.Coord) bool {
+	return !math.IsNaN(c.X) && !math.IsInf(c.X, 0) && !math.IsNaN(c.Y) && !math.IsInf(c.Y, 0)
+}
+
+type geoJSON struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Geometry    *geoJSON        `json:"geometry"`
+	Geometries  []geoJSON       `json:"geometries"`
+	Features    []geoJSON       `json:"features"`
+}
+
+// ParseGeoJSONOverlay converts a GeoJSON object to an overlay. Points become
+// markers, line strings paths and polygons filled paths, drawn in c.
+func ParseGeoJSONOverlay(data []byte, c color.Color) (Overlay, error) {
+	var g geoJSON
+	if err := json.Unmarshal(data, &g); err != nil {
+		return Overlay{}, err
+	}
+	var o Overlay
+	err := o.addGeoJSON(g, c)
+	return o, err
+}
+
+func (o *Overlay) addGeoJSON(g geoJSON, c color.Color) error {
+	fill := translucent(c)
+	switch g.Type {
+	case "FeatureCollection":
+		for _, f := range g.Features {
+			if err := o.addGeoJSON(f, c); err != nil {
+				return err
+			}
+		}
+	case "Feature":
+		if g.Geometry != nil {
+			return o.addGeoJSON(*g.Geometry, c)
+		}
+	case "GeometryCollection":
+		for _, geom := range g.Geometries {
+			if err := o.addGeoJSON(geom, c); err != nil {
+				return err
+			}
+		}
+	case "Point":
+		var p [2]float64
+		if err := json.Unmarshal(g.Coordinates, &p); err != nil {
+			return err
+		}
+		o.Markers = append(o.Markers, Marker{Pos: mapnik.Coord{X: p[0], Y: p[1]}, Color: c})
+	case "MultiPoint":
+		var ps [][2]float64
+		if err := json.Unmarshal(g.Coordinates, &ps); err != nil {
+			return err
+		}
+		for _, p := range ps {
+			o.Markers = append(o.Markers, Marker{Pos: mapnik.Coord{X: p[0], Y: p[1]}, Color: c})
+		}
+	case "LineString":
+		var ls [][2]float64
+		if err := json.Unmarshal(g.Coordinates, &ls); err != nil {
+			return err
+		}
+		o.Paths = append(o.Paths, Path{Coords: toCoords(ls), Color: c})
+	case "MultiLineString":
+		var mls [][][2]float64
+		if err := json.Unmarshal(g.Coordinates, &mls); err != nil {
+			return err
+		}
+		for _, ls := range mls {
+			o.Paths = append(o.Paths, Path{Coords: toCoords(ls), Color: c})
+		}
+	case "Polygon":
+		var rings [][][2]float64
+		if err := json.Unmarshal(g.Coordinates, &rings); err != nil {
+			return err
+		}
+		o.addPolygon(rings, c, fill)
+	case "MultiPolygon":
+		var polys [][][][2]float64
+		if err := json.Unmarshal(g.Coordinates, &polys); err != nil {
+			return err
+		}
+		for _, rings := range polys {
+			o.addPolygon(rings, c, fill)
+		}
+	default:
+		return fmt.Errorf("unsupported GeoJSON type %q", g.Type)
+	}
+	return nil
+}
+
+// addPolygon adds a GeoJSON polygon. The first ring is the exterior,
+// the others are holes.
+func (o *Overlay) addPolygon(rings [][][2]float64, c, fill color.Color) {
+	if len(rings) == 0 {
+		return
+	}
+	p := Path{Coords: toCoords(rings[0]), Color: c, Fill: fill}
+	for _, r := range rings[1:] {
+		p.Holes = append(p.Holes, toCoords(r))
+	}
+	o.Paths = append(o.Paths, p)
+}
+
+func toCoords(ps [][2]float64) []mapnik.Coord {
+	coords := make([]mapnik.Coord, len(ps))
+	for i, p := range ps {
+		coords[i] = mapnik.Coord{X: p[0], Y: p[1]}
+	}
+	return coords
+}
+
+// translucent returns c with a third of its opacity, used to fill polygons.
+func translucent(c color.Color) color.Color {
+	r, g, b, a := c.RGBA()
+	return color.NRGBA64{uint16(r * 0xffff / max32(a, 1)), uint16(g * 0xffff / max32(a, 1)), uint16(b * 0xffff / max32(a, 1)), uint16(a / 3)}
+}
+
+func max32(a, b uint32) uint32 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// mercatorXY projects a WGS84 coordinate to Web Mercator meters.
+func mercatorXY(c mapnik.Coord) (float64, float64) {
+	const r = 6378137
+	lat := math.Max(math.Min(c.Y, 85.0511), -85.0511)
+	return r * c.X * math.Pi / 180, r * math.Log(math.Tan(math.Pi/4+lat*math.Pi/360))
+}
+
+// DrawOverlay draws the overlay on a PNG static map of the WGS84 bbox.
+// The map is assumed to be in Web Mercator. Like mapnik, the extent is
+// grown to match the aspect ratio of the image.
+func DrawOverlay(blob []byte, bbox [4]float64, o Overlay) ([]byte, error) {
+	return DrawOverlaySRS(blob, bbox, o, "")
+}
+
+// DrawOverlaySRS is like DrawOverlay for a map in the spatial reference
+// system srs, e.g. the SRS of a layer's tile grid. If srs is empty, Web
+// Mercator is used.
+func DrawOverlaySRS(blob []byte, bbox [4]float64, o Overlay, srs string) ([]byte, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	project := func(c mapnik.Coord) (float64, float64, error) {
+		x, y := mercatorXY(c)
+		return x, y, nil
+	}
+	if srs != "" {
+		pt, err := mapnik.NewProjTransform("+init=epsg:4326", srs)
+		if err != nil {
+			return nil, err
+		}
+		defer pt.Free()
+		project = func(c mapnik.Coord) (float64, float64, error) {
+			p, err := pt.Forward(c)
+			return p.X, p.Y, err
+		}
+	}
+
+	src, err := png.Decode(bytes.NewReader(blob))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	img := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(img, img.Bounds(), src, b.Min, draw.Src)
+
+	minX, minY, err := project(mapnik.Coord{X: bbox[0], Y: bbox[1]})
+	if err != nil {
+		return nil, err
+	}
+	maxX, maxY, err := project(mapnik.Coord{X: bbox[2], Y: bbox[3]})
+	if err != nil {
+		return nil, err
+	}
+	w, h := float64(b.Dx()), float64(b.Dy())
+	scale := math.Max((maxX-minX)/w, (maxY-minY)/h)
+	cx, cy := (minX+maxX)/2, (minY+maxY)/2
+	toPixel := func(c mapnik.Coord) (float64, float64, error) {
+		x, y, err := project(c)
+		return w/2 + (x-cx)/scale, h/2 - (y-cy)/scale, err
+	}
+	toPixels := func(coords []mapnik.Coord) ([][2]float64, error) {
+		pts := make([][2]float64, len(coords))
+		for i, c := range coords {
+			var err error
+			if pts[i][0], pts[i][1], err = toPixel(c); err != nil {
+				return nil, err
+			}
+		}
+		return pts, nil
+	}
+
+	for _, p := range o.Paths {
+		var rings [][][2]float64
+		for _, coords := range append([][]mapnik.Coord{p.Coords}, p.Holes...) {
+			pts, err := toPixels(coords)
+			if err != nil {
+				return nil, err
+			}
+			rings = append(rings, pts)
+		}
+		if p.Fill != nil {
+			fillPolygon(img, rings, p.Fill)
+		}
+		width := p.Width
+		if width == 0 {
+			width = 3
+		}
+		col := p.Color
+		if col == nil {
+			col = color.RGBA{0xff, 0, 0, 0xff}
+		}
+		for _, pts := range rings {
+			for i := 1; i < len(pts); i++ {
+				drawLine(img, pts[i-1], pts[i], width, col)
+			}
+		}
+	}
+	for _, m := range o.Markers {
+		x, y, err := toPixel(m.Pos)
+		if err != nil {
+			return nil, err
+		}
+		radius := m.Radius
+		if radius == 0 {
+			radius = 6
+		}
+		col := m.Color
+		if col == nil {
+			col = color.RGBA{0xff, 0, 0, 0xff}
+		}
+		fillCircle(img, x, y, float64(radius)+1, color.White)
+		fillCircle(img, x, y, float64(radius), col)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// blend draws c over the pixel at x, y.
+func blend(img *image.RGBA, x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(img.Bounds())) {
+		return
+	}
+	draw.Draw(img, image.Rect(x, y, x+1, y+1), image.NewUniform(c), image.Point{}, draw.Over)
+}
+
+func fillCircle(img *image.RGBA, cx, cy, r float64, c color.Color) {
+	for y := int(cy - r); y <= int(cy+r); y++ {
+		for x := int(cx - r); x <= int(cx+r); x++ {
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			if dx*dx+dy*dy <= r*r {
+				blend(img, x, y, c)
+			}
+		}
+	}
+}
+
+// drawLine draws a line of the given width by stamping squares along it.
+// The segment is clipped to the image first, so the number of steps is
+// bounded by the image size.
+func drawLine(img *image.RGBA, a, b [2]float64, width int, c color.Color) {
+	r := float64(width) / 2
+	bounds := img.Bounds()
+	a, b, ok := clipSegment(a, b, float64(bounds.Min.X)-r, float64(bounds.Min.Y)-r, float64(bounds.Max.X)+r, float64(bounds.Max.Y)+r)
+	if !ok {
+		return
+	}
+	steps := int(math.Max(math.Abs(b[0]-a[0]), math.Abs(b[1]-a[1]))) + 1
+	drawn := make(map[image.Point]bool)
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		x := a[0] + (b[0]-a[0])*t
+		y := a[1] + (b[1]-a[1])*t
+		for py := int(math.Floor(y - r + 0.5)); py < int(math.Floor(y+r+0.5)); py++ {
+			for px := int(math.Floor(x - r + 0.5)); px < int(math.Floor(x+r+0.5)); px++ {
+				p := image.Point{px, py}
+				if !drawn[p] {
+					drawn[p] = true
+					blend(img, px, py, c)
+				}
+			}
+		}
+	}
+}
+
+// clipSegment clips the segment from a to b to the rectangle with the
+// Liang-Barsky algorithm. It returns false if the segment is outside.
+func clipSegment(a, b [2]float64, minX, minY, maxX, maxY float64) ([2]float64, [2]float64, bool) {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	t0, t1 := 0.0, 1.0
+	for _, e := range [4][2]float64{
+		{-dx, a[0] - minX},
+		{dx, maxX - a[0]},
+		{-dy, a[1] - minY},
+		{dy, maxY - a[1]},
+	} {
+		p, q := e[0], e[1]
+		if p == 0 {
+			if q < 0 {
+				return a, b, false
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 {
+			t0 = math.Max(t0, t)
+		} else {
+			t1 = math.Min(t1, t)
+		}
+		if t0 > t1 {
+			return a, b, false
+		}
+	}
+	return [2]float64{a[0] + t0*dx, a[1] + t0*dy}, [2]float64{a[0] + t1*dx, a[1] + t1*dy}, true
+}
+
+// fillPolygon fills the polygon with the even-odd rule. The first ring is
+// the exterior, holes in it are left unfilled.
+func fillPolygon(img *image.RGBA, rings [][][2]float64, c color.Color) {
+	if len(rings) == 0 || len(rings[0]) < 3 {
+		return
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		sy := float64(y) + 0.5
+		var xs []float64
+		for _, pts := range rings {
+			for i := range pts {
+				p, q := pts[i], pts[(i+1)%len(pts)]
+				if (p[1] > sy) != (q[1] > sy) {
+					xs = append(xs, p[0]+(sy-p[1])*(q[0]-p[0])/(q[1]-p[1]))
+				}
+			}
+		}
+		sort.Float64s(xs)
+		for i := 0; i+1 < len(xs); i += 2 {
+			x0 := math.Max(math.Ceil(xs[i]-0.5), float64(b.Min.X))
+			x1 := math.Min(math.Ceil(xs[i+1]-0.5), float64(b.Max.X))
+			for x := int(x0); x < int(x1); x++ {
+				blend(img, x, y, c)
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package maptiles
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nkovacs/go-mapnik/mapnik"
+)
+
+func blankPNG(t *testing.T, w, h int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseOverlayInvalid(t *testing.T) {
+	tests := []string{
+		"/staticmap?markers=NaN,1",
+		"/staticmap?path=0,0|Inf,1",
+		`/staticmap?geojson={"type":"Point","coordinates":[1e999,0]}`,
+	}
+	for _, u := range tests {
+		if _, err := parseOverlay(httptest.NewRequest("GET", u, nil)); err == nil {
+			t.Errorf("%s: expected error", u)
+		}
+	}
+	if _, err := parseBBoxParam("0,0,NaN,1"); err == nil {
+		t.Error("expected error for NaN bbox")
+	}
+
+	var o Overlay
+	for i := 0; i <= maxOverlayVertices; i++ {
+		o.Markers = append(o.Markers, Marker{Pos: mapnik.Coord{X: 0, Y: 0}})
+	}
+	if err := o.Validate(); err == nil {
+		t.Error("expected error for too many vertices")
+	}
+}
+
+func TestDrawOverlayFarAway(t *testing.T) {
+	o := Overlay{Paths: []Path{{Coords: []mapnik.Coord{{X: -179, Y: -85}, {X: 179, Y: 85}}}}}
+	// A tiny bbox puts the path billions of pixels away from the image.
+	blob, err := DrawOverlay(blankPNG(t, 16, 16), [4]float64{0, 0, 1e-7, 1e-7}, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(bytes.NewReader(blob)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClipSegment(t *testing.T) {
+	a, b, ok := clipSegment([2]float64{-1e12, 5}, [2]float64{1e12, 5}, 0, 0, 10, 10)
+	if !ok || a != [2]float64{0, 5} || b != [2]float64{10, 5} {
+		t.Errorf("got %v %v %v", a, b, ok)
+	}
+	if _, _, ok := clipSegment([2]float64{-5, -5}, [2]float64{-1, 20}, 0, 0, 10, 10); ok {
+		t.Error("segment outside of the rectangle was not rejected")
+	}
+}
+
+func TestFillPolygonHole(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	outer := [][2]float64{{0, 0}, {10, 0}, {10, 10}, {0, 10}}
+	hole := [][2]float64{{3, 3}, {7, 3}, {7, 7}, {3, 7}}
+	fillPolygon(img, [][][2]float64{outer, hole}, color.Black)
+	if img.RGBAAt(1, 1).A == 0 {
+		t.Error("polygon was not filled")
+	}
+	if img.RGBAAt(5, 5).A != 0 {
+		t.Error("hole was filled")
+	}
+
+	o, err := ParseGeoJSONOverlay([]byte(`{"type":"Polygon","coordinates":[
+		[[0,0],[10,0],[10,10],[0,10],[0,0]],
+		[[3,3],[7,3],[7,7],[3,7],[3,3]]]}`), color.Black)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(o.Paths) != 1 || len(o.Paths[0].Holes) != 1 {
+		t.Errorf("got %d paths, want one polygon with a hole", len(o.Paths))
+	}
+}
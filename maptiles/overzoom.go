@@ -0,0 +1,92 @@
+package maptiles
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+)
+
+// maxOverzoom is the maximum number of zoom levels a tile is scaled up,
+// at which a single pixel of the parent tile fills the whole tile.
+const maxOverzoom = 8
+
+// serveOverzoom answers a request above the layer's MaxZoom by scaling up
+// the relevant part of the ancestor tile at MaxZoom.
+func (t *TileServer) serveOverzoom(w http.ResponseWriter, r *http.Request, tc TileCoord, cfg LayerConfig) {
+	dz := tc.Zoom - cfg.MaxZoom
+	if dz > maxOverzoom {
+		http.NotFound(w, r)
+		return
+	}
+
+	cache := t.m
+	t.mu.RLock()
+	if t.uncached[tc.Layer] || !cfg.CacheOverzoom || !cfg.cacheable(tc) {
+		cache = nil
+	}
+	t.mu.RUnlock()
+	if cache != nil {
+		if blob, err := cache.Fetch(tc); err == nil && blob != nil {
+			t.writeTile(w, r, blob, false)
+			return
+		}
+	}
+
+	xyz := tc
+	xyz.SetTMS(false)
+	parent := TileCoord{
+		X:         xyz.X >> dz,
+		Y:         xyz.Y >> dz,
+		Zoom:      cfg.MaxZoom,
+		Layer:     tc.Layer,
+		MapLayers: tc.MapLayers,
+		Variables: tc.Variables,
+	}
+	blob, err := t.getTile(parent, RequestID(r))
+	if err != nil || blob == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	mask := uint64(1)<<dz - 1
+	blob, err = overzoomTile(blob, int(dz), int(xyz.X&mask), int(xyz.Y&mask))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t.writeTile(w, r, blob, false)
+	if cache != nil {
+		insertTiles(cache, []TileFetchResult{{Coord: tc, BlobPNG: blob}})
+	}
+}
+
+// overzoomTile crops the part (x, y) of the parent tile divided into
+// 2^dz×2^dz parts and scales it up to 256×256 pixels.
+func overzoomTile(blob []byte, dz, x, y int) ([]byte, error) {
+	src, err := png.Decode(bytes.NewReader(blob))
+	if err != nil {
+		return nil, err
+	}
+	b := src.Bounds()
+	size := b.Dx() >> uint(dz)
+	if size < 1 {
+		size = 1
+	}
+	x0 := b.Min.X + x*size
+	y0 := b.Min.Y + y*size
+
+	// nearest neighbour scaling keeps the tile sharp
+	dst := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for py := 0; py < 256; py++ {
+		for px := 0; px < 256; px++ {
+			dst.Set(px, py, src.At(x0+px*size/256, y0+py*size/256))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,376 @@
+package maptiles
+
+import (
+	"container/list"
+	"crypto/subtle"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// peerHeader carries PeerCache.Secret in requests between peers.
+const peerHeader = "X-Maptiles-Peer"
+
+// peerFormatHeader carries the tile format negotiated by the forwarding
+// server, so the owner renders the format stored under the tile's key.
+const peerFormatHeader = "X-Maptiles-Format"
+
+// peerInvalidatePath is the path peers post layer names to when the tiles
+// of the layer changed.
+const peerInvalidatePath = "/_peers/invalidate"
+
+// peerReplicas is the number of points of a peer on the hash ring.
+const peerReplicas = 50
+
+// PeerCache shares hot tiles between the tile servers of a cluster, like
+// groupcache. Every metatile is owned by one server, chosen by consistent
+// hashing. Requests for tiles owned by another server are forwarded to it,
+// so it renders them once for the whole cluster and keeps them in memory.
+// The other servers keep the tiles they forwarded in a smaller hot cache.
+// If the owner cannot be reached, the tile is served locally.
+type PeerCache struct {
+	// Self is the URL of this server in Peers.
+	Self string
+
+	// Peers are the base URLs of all servers of the cluster including
+	// Self, e.g. http://10.0.0.2:8080. Requests are forwarded with their
+	// original path and query, so all servers must serve the same layers
+	// under the same paths. See SetPeers.
+	Peers []string
+
+	// Secret authenticates requests between peers, which skip the access
+	// checks of the TileServer. If empty, forwarded requests are checked
+	// like other requests, with the address of the forwarding server, and
+	// the hot caches of the other peers are not invalidated when a layer
+	// is reloaded.
+	Secret string
+
+	// MaxBytes is the memory used for the tiles this server owns. If zero,
+	// 64 MiB will be used. The hot cache uses an eighth of it.
+	MaxBytes int64
+
+	// Client is used for requests to peers. If nil, a client with a
+	// timeout of 30 seconds is used.
+	Client *http.Client
+
+	once sync.Once
+	mu   sync.RWMutex
+	ring []peerPoint
+	main *lruCache
+	hot  *lruCache
+}
+
+type peerPoint struct {
+	hash uint32
+	peer string
+}
+
+var defaultPeerClient = &http.Client{Timeout: 30 * time.Second}
+
+func (p *PeerCache) init() {
+	p.once.Do(func() {
+		max := p.MaxBytes
+		if max == 0 {
+			max = 64 << 20
+		}
+		p.main = newLRUCache(max)
+		p.hot = newLRUCache(max / 8)
+		p.setPeers(p.Peers)
+	})
+}
+
+// SetPeers replaces the peers, e.g. when servers are added to the cluster.
+func (p *PeerCache) SetPeers(peers ...string) {
+	p.init()
+	p.setPeers(peers)
+}
+
+func (p *PeerCache) setPeers(peers []string) {
+	ring := make([]peerPoint, 0, len(peers)*peerReplicas)
+	for _, peer := range peers {
+		for i := 0; i < peerReplicas; i++ {
+			ring = append(ring, peerPoint{hashString(strconv.Itoa(i) + peer), peer})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	p.mu.Lock()
+	p.Peers = peers
+	p.ring = ring
+	p.mu.Unlock()
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// owner returns the peer owning the key.
+func (p *PeerCache) owner(key string) string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.ring) == 0 {
+		return p.Self
+	}
+	h := hashString(key)
+	i := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+	if i == len(p.ring) {
+		i = 0
+	}
+	return p.ring[i].peer
+}
+
+// fromPeer reports whether the request was forwarded by a peer, so it is
+// not forwarded again.
+func (p *PeerCache) fromPeer(r *http.Request) bool {
+	v := r.Header.Get(peerHeader)
+	return v != "" && (p.Secret == "" || p.trusted(r))
+}
+
+// trusted reports whether the request was forwarded by a peer knowing the
+// secret, so it skips the access checks.
+func (p *PeerCache) trusted(r *http.Request) bool {
+	v := r.Header.Get(peerHeader)
+	return p.Secret != "" && subtle.ConstantTimeCompare([]byte(v), []byte(p.Secret)) == 1
+}
+
+// tileKey identifies a tile in the caches.
+func tileKey(tc TileCoord) string {
+	return fmt.Sprintf("%s/%d/%d/%d/%t/%s/%s/%s", tc.Layer, tc.Zoom, tc.X, tc.Y, tc.Tms, tc.MapLayers, tc.Variables, tc.Format)
+}
+
+// ownerKey is the metatile of the tile, so all its tiles have the same
+// owner and the metatile is rendered once.
+func ownerKey(tc TileCoord, metaTileSize uint64) string {
+	if metaTileSize < 1 {
+		metaTileSize = 1
+	}
+	return fmt.Sprintf("%s/%d/%d/%d/%s/%s/%s", tc.Layer, tc.Zoom, tc.X/metaTileSize, tc.Y/metaTileSize, tc.MapLayers, tc.Variables, tc.Format)
+}
+
+// get returns the tile from memory or from its owner. It returns nil if
+// this server owns the tile and has to serve it.
+func (p *PeerCache) get(r *http.Request, tc TileCoord, metaTileSize uint64) []byte {
+	p.init()
+	key := tileKey(tc)
+	if blob := p.main.get(key); blob != nil {
+		return blob
+	}
+	if blob := p.hot.get(key); blob != nil {
+		return blob
+	}
+	owner := p.owner(ownerKey(tc, metaTileSize))
+	if owner == p.Self || p.fromPeer(r) {
+		return nil
+	}
+	blob, err := p.fetch(owner, r, tc.Format)
+	if err != nil {
+		log.Println("Error fetching tile from peer", owner, err)
+		return nil
+	}
+	p.hot.add(key, blob)
+	return blob
+}
+
+// fetch forwards the request for a tile in format to a peer.
+func (p *PeerCache) fetch(peer string, r *http.Request, format string) ([]byte, error) {
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, peer+uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.Secret != "" {
+		req.Header.Set(peerHeader, p.Secret)
+	} else {
+		req.Header.Set(peerHeader, "1")
+		// the peer checks the request like other requests
+		for _, h := range []string{"X-API-Key", "Authorization", "Referer", "Origin"} {
+			if v := r.Header.Get(h); v != "" {
+				req.Header.Set(h, v)
+			}
+		}
+	}
+	if id := RequestID(r); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+	if format == "" {
+		format = "png"
+	}
+	req.Header.Set(peerFormatHeader, format)
+	req.Header["Accept"] = r.Header["Accept"]
+	// compressed vector tiles are kept compressed
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer: %v", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (p *PeerCache) client() *http.Client {
+	if p.Client == nil {
+		return defaultPeerClient
+	}
+	return p.Client
+}
+
+// format returns the tile format the forwarding peer negotiated, or false
+// if the request was not forwarded by a peer or the format is not one of
+// formats.
+func (p *PeerCache) format(r *http.Request, formats []string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+	f := r.Header.Get(peerFormatHeader)
+	if f == "" || !p.fromPeer(r) || !stringInSlice(f, formats) {
+		return "", false
+	}
+	if f == "png" {
+		return "", true
+	}
+	return f, true
+}
+
+// add keeps a tile served by this server in memory if it owns it.
+func (p *PeerCache) add(tc TileCoord, metaTileSize uint64, blob []byte) {
+	p.init()
+	if p.owner(ownerKey(tc, metaTileSize)) == p.Self {
+		p.main.add(tileKey(tc), blob)
+	}
+}
+
+// removeLayer drops the tiles of a layer from memory, e.g. after its
+// stylesheet changed, and tells the other peers to drop them from their
+// hot caches.
+func (p *PeerCache) removeLayer(layer string) {
+	p.removeLocal(layer)
+	if p.Secret == "" {
+		return
+	}
+	p.mu.RLock()
+	peers := p.Peers
+	p.mu.RUnlock()
+	for _, peer := range peers {
+		if peer != p.Self {
+			go p.invalidate(peer, layer)
+		}
+	}
+}
+
+func (p *PeerCache) removeLocal(layer string) {
+	p.init()
+	p.main.removePrefix(layer + "/")
+	p.hot.removePrefix(layer + "/")
+}
+
+// invalidate tells a peer to drop the tiles of the layer.
+func (p *PeerCache) invalidate(peer, layer string) {
+	req, err := http.NewRequest(http.MethodPost, peer+peerInvalidatePath+"?layer="+url.QueryEscape(layer), nil)
+	if err != nil {
+		log.Println("Error invalidating layer", layer, "on peer", peer, err)
+		return
+	}
+	req.Header.Set(peerHeader, p.Secret)
+	resp, err := p.client().Do(req)
+	if err != nil {
+		log.Println("Error invalidating layer", layer, "on peer", peer, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		log.Println("Error invalidating layer", layer, "on peer", peer, resp.Status)
+	}
+}
+
+// serveInvalidate drops the tiles of a layer reloaded by a peer.
+func (p *PeerCache) serveInvalidate(w http.ResponseWriter, r *http.Request) {
+	if !p.trusted(r) {
+		http.Error(w, "invalid peer secret", http.StatusForbidden)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	p.removeLocal(r.URL.Query().Get("layer"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// lruCache keeps tiles up to a total size, evicting the least recently
+// used ones.
+type lruCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type lruEntry struct {
+	key  string
+	blob []byte
+}
+
+func newLRUCache(maxBytes int64) *lruCache {
+	return &lruCache{maxBytes: maxBytes, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *lruCache) get(key string) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		return e.Value.(*lruEntry).blob
+	}
+	return nil
+}
+
+func (c *lruCache) add(key string, blob []byte) {
+	if int64(len(blob)) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.bytes += int64(len(blob) - len(e.Value.(*lruEntry).blob))
+		e.Value.(*lruEntry).blob = blob
+		c.ll.MoveToFront(e)
+	} else {
+		c.items[key] = c.ll.PushFront(&lruEntry{key, blob})
+		c.bytes += int64(len(blob))
+	}
+	for c.bytes > c.maxBytes {
+		e := c.ll.Back()
+		entry := e.Value.(*lruEntry)
+		c.ll.Remove(e)
+		delete(c.items, entry.key)
+		c.bytes -= int64(len(entry.blob))
+	}
+}
+
+func (c *lruCache) removePrefix(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.items {
+		if strings.HasPrefix(key, prefix) {
+			c.ll.Remove(e)
+			delete(c.items, key)
+			c.bytes -= int64(len(e.Value.(*lruEntry).blob))
+		}
+	}
+}
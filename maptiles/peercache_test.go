@@ -0,0 +1,62 @@
+package maptiles
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPeerCacheFetchFormat(t *testing.T) {
+	var got http.Header
+	owner := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		w.Write([]byte("tile"))
+	}))
+	defer owner.Close()
+
+	p := &PeerCache{Self: "http://self", Secret: "secret"}
+	r := httptest.NewRequest("GET", "/osm/1/0/0.png", nil)
+	r.Header.Set("Accept", "image/webp,*/*")
+	if _, err := p.fetch(owner.URL, r, "webp"); err != nil {
+		t.Fatal(err)
+	}
+	if got.Get(peerFormatHeader) != "webp" || got.Get("Accept") != "image/webp,*/*" {
+		t.Errorf("got format %q and Accept %q", got.Get(peerFormatHeader), got.Get("Accept"))
+	}
+
+	// the owner uses the format of the forwarding peer
+	r = httptest.NewRequest("GET", "/osm/1/0/0.png", nil)
+	r.Header = got
+	if f, ok := p.format(r, []string{"png", "webp"}); !ok || f != "webp" {
+		t.Errorf("got format %q, %v, want webp", f, ok)
+	}
+	r.Header.Set(peerHeader, "wrong")
+	if _, ok := p.format(r, []string{"png", "webp"}); ok {
+		t.Error("format of an untrusted request was used")
+	}
+}
+
+func TestPeerCacheInvalidate(t *testing.T) {
+	other := &PeerCache{Self: "http://other", Secret: "secret"}
+	other.init()
+	other.hot.add("osm/1/0/0", []byte("tile"))
+	other.hot.add("topo/1/0/0", []byte("tile"))
+	srv := httptest.NewServer(http.HandlerFunc(other.serveInvalidate))
+	defer srv.Close()
+
+	p := &PeerCache{Self: "http://self", Secret: "secret"}
+	p.invalidate(srv.URL, "osm")
+	if other.hot.get("osm/1/0/0") != nil {
+		t.Error("tile of the invalidated layer was kept")
+	}
+	if other.hot.get("topo/1/0/0") == nil {
+		t.Error("tile of another layer was dropped")
+	}
+
+	p.Secret = "wrong"
+	other.hot.add("osm/1/0/0", []byte("tile"))
+	p.invalidate(srv.URL, "osm")
+	if other.hot.get("osm/1/0/0") == nil {
+		t.Error("untrusted request invalidated the layer")
+	}
+}
@@ -0,0 +1,71 @@
+package maptiles
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PNGOptions tune mapnik's PNG encoder, trading tile size for CPU time
+// when rendering and seeding. The zero value uses mapnik's defaults,
+// which is a 256 color palette with zlib's default compression.
+type PNGOptions struct {
+	// Compression is the zlib level from 1 (fastest) to 9 (smallest).
+	// If zero, the zlib default will be used.
+	Compression int
+
+	// Strategy is the zlib strategy: default, filtered, huff or rle.
+	// huff and rle are much faster than the default at a larger size.
+	Strategy string
+
+	// TransMode is the alpha channel of paletted images: full (default),
+	// binary for fully transparent or opaque pixels, or none.
+	TransMode string
+
+	// Colors is the palette size from 2 to 256. If zero, 256 will be used.
+	Colors int
+
+	// TrueColor encodes 32 bit RGBA instead of a palette. Tiles are larger
+	// but gradients and hillshading are not quantized.
+	TrueColor bool
+}
+
+var pngStrategies = map[string]bool{"": true, "default": true, "filtered": true, "huff": true, "rle": true}
+
+var pngTransModes = map[string]string{"": "", "none": "0", "binary": "1", "full": "2"}
+
+func (o PNGOptions) validate() error {
+	if o.Compression < 0 || o.Compression > 9 {
+		return fmt.Errorf("png compression must be between 1 and 9")
+	}
+	if !pngStrategies[o.Strategy] {
+		return fmt.Errorf("unknown png strategy %v", o.Strategy)
+	}
+	if _, ok := pngTransModes[o.TransMode]; !ok {
+		return fmt.Errorf("unknown png trans mode %v", o.TransMode)
+	}
+	if o.Colors != 0 && (o.Colors < 2 || o.Colors > 256) {
+		return fmt.Errorf("png colors must be between 2 and 256")
+	}
+	return nil
+}
+
+// format returns the mapnik format string, e.g. png8:z=1:s=rle.
+func (o PNGOptions) format() string {
+	parts := []string{"png"}
+	if o.TrueColor {
+		parts[0] = "png32"
+	}
+	if o.Colors != 0 && !o.TrueColor {
+		parts = append(parts, fmt.Sprintf("c=%d", o.Colors))
+	}
+	if o.Compression != 0 {
+		parts = append(parts, fmt.Sprintf("z=%d", o.Compression))
+	}
+	if o.Strategy != "" {
+		parts = append(parts, "s="+o.Strategy)
+	}
+	if t := pngTransModes[o.TransMode]; t != "" && !o.TrueColor {
+		parts = append(parts, "t="+t)
+	}
+	return strings.Join(parts, ":")
+}
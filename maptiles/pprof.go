@@ -0,0 +1,39 @@
+package maptiles
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+)
+
+// DebugHandler serves the profiles of net/http/pprof under /debug/pprof/
+// and the expvar variables under /debug/vars, with the Stats of the server
+// as the maptiles variable. It must not be reachable from the internet,
+// serve it on a separate address like HTTPConfig.DebugListen.
+func (t *TileServer) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/vars", t.serveVars)
+	return mux
+}
+
+// serveVars writes the expvar variables like expvar.Handler. The stats are
+// not published with expvar.Publish, which allows one server per process.
+func (t *TileServer) serveVars(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	fmt.Fprintf(w, "{\n")
+	expvar.Do(func(kv expvar.KeyValue) {
+		fmt.Fprintf(w, "%q: %s,\n", kv.Key, kv.Value)
+	})
+	stats, err := json.Marshal(t.Stats())
+	if err != nil {
+		stats = []byte("null")
+	}
+	fmt.Fprintf(w, "%q: %s\n}\n", "maptiles", stats)
+}
@@ -0,0 +1,135 @@
+package maptiles
+
+import (
+	"sync"
+)
+
+// prefetcher renders the neighbours of requested tiles in the background.
+type prefetcher struct {
+	queue chan TileCoord
+	wg    sync.WaitGroup
+
+	mu      sync.Mutex
+	pending map[TileCoord]bool
+	closed  bool
+}
+
+func newPrefetcher(queueSize int) *prefetcher {
+	return &prefetcher{
+		queue:   make(chan TileCoord, queueSize),
+		pending: make(map[TileCoord]bool),
+	}
+}
+
+// enqueue adds the tile to the queue unless it is already queued.
+// Tiles are dropped if the queue is full.
+func (p *prefetcher) enqueue(tc TileCoord) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || p.pending[tc] {
+		return
+	}
+	select {
+	case p.queue <- tc:
+		p.pending[tc] = true
+	default:
+	}
+}
+
+func (p *prefetcher) done(tc TileCoord) {
+	p.mu.Lock()
+	delete(p.pending, tc)
+	p.mu.Unlock()
+}
+
+// close stops accepting tiles and waits until the workers have finished.
+// Queued tiles are discarded.
+func (p *prefetcher) close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+		// drain the queue so the workers stop after their current tile
+		for range p.queue {
+		}
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
+
+// startPrefetch starts the workers rendering queued tiles.
+func (t *TileServer) startPrefetch(workers int) {
+	p := t.prefetch
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer p.wg.Done()
+			for tc := range p.queue {
+				t.prefetchTile(tc)
+				p.done(tc)
+			}
+		}()
+	}
+}
+
+// prefetchNeighbours queues the 8 neighbours of a tile that was rendered
+// on demand. With metatiles, only neighbours in other metatiles need to be
+// rendered, the others are found in the cache.
+func (t *TileServer) prefetchNeighbours(tc TileCoord, cfg LayerConfig, mapnikLayer bool) {
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			if dx == 0 && dy == 0 {
+				continue
+			}
+			n := tc
+			n.X += uint64(dx)
+			n.Y += uint64(dy)
+			if !cfg.validTile(n) {
+				continue
+			}
+			if mapnikLayer && !cfg.inBounds(n) {
+				continue
+			}
+			t.prefetch.enqueue(n)
+		}
+	}
+}
+
+// prefetchTile renders a tile into the cache unless it is already cached.
+func (t *TileServer) prefetchTile(tc TileCoord) {
+	t.mu.RLock()
+	uncached := t.uncached[tc.Layer]
+	cfg := t.layers[tc.Layer]
+	t.mu.RUnlock()
+	cache := t.m
+	if cache == nil || uncached {
+		return
+	}
+	if blob, err := cache.Fetch(tc); err == nil && blob != nil {
+		return
+	}
+	// broken layers are not prefetched
+	if state, _ := t.breaker.state(tc.Layer); state == breakerOpen || state == breakerHalfOpen {
+		return
+	}
+
+	metaTileSize := t.metaTileSize
+	if cfg.MetaTileSize > 0 {
+		metaTileSize = cfg.MetaTileSize
+	}
+	if metaTileSize > 1 {
+		t.renderMetaTile(tc, cache, metaTileSize, cfg.Grid, "")
+		return
+	}
+	ch := make(chan TileFetchResult)
+	if !t.lmp.SubmitRequest(TileFetchRequest{Coord: tc, OutChan: ch}) {
+		return
+	}
+	result := <-ch
+	if result.Error != nil && result.Error != ErrRenderBusy {
+		t.tileError(result.Error, tc, StageRender, "")
+	}
+	if result.Error == nil && result.BlobPNG != nil && !t.skipCaching(result.BlobPNG, cfg.Grid) {
+		insertTiles(cache, []TileFetchResult{result})
+	}
+}
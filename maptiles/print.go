@@ -0,0 +1,160 @@
+package maptiles
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/nkovacs/go-mapnik/mapnik"
+)
+
+// mapnikDPI is the resolution mapnik assumes when computing scale
+// denominators and symbol sizes, from its standard pixel size of 0.28mm.
+const mapnikDPI = 25.4 / 0.28
+
+// maxPrintSize is the maximum width and height of a printed raster image
+// in pixels.
+const maxPrintSize = 20000
+
+// PaperSizes are the width and height of the supported paper sizes in
+// millimetres, in portrait orientation.
+var PaperSizes = map[string][2]float64{
+	"a0":     {841, 1189},
+	"a1":     {594, 841},
+	"a2":     {420, 594},
+	"a3":     {297, 420},
+	"a4":     {210, 297},
+	"a5":     {148, 210},
+	"letter": {215.9, 279.4},
+	"legal":  {215.9, 355.6},
+}
+
+// PrintOptions describe a printed map.
+type PrintOptions struct {
+	// BBox is the WGS84 extent as minlon, minlat, maxlon, maxlat. It is
+	// grown to the aspect ratio of the paper. It is ignored if
+	// ScaleDenominator is set.
+	BBox [4]float64
+
+	// Center is the WGS84 center of a map printed at ScaleDenominator,
+	// e.g. 25000 for 1:25000. The scale assumes a map in meters, in Web
+	// Mercator it is true at the latitude of Center.
+	Center           mapnik.Coord
+	ScaleDenominator float64
+
+	// Paper is one of PaperSizes. If empty, Width and Height are the
+	// size in millimetres.
+	Paper         string
+	Landscape     bool
+	Width, Height float64
+
+	// DPI is the resolution of raster output and of rasters in PDFs. If
+	// zero, 300 will be used.
+	DPI float64
+
+	// Format is png, tiff, geotiff or pdf. If empty, png will be used.
+	// GeoTIFFs are georeferenced in the SRS of the stylesheet.
+	Format string
+}
+
+// PrintRenderer renders map extents at a physical size for printing,
+// with symbols, lines and labels scaled to the output resolution.
+// It is not safe for concurrent use.
+type PrintRenderer struct {
+	t *TileRenderer
+}
+
+// NewPrintRenderer loads the stylesheet of cfg. It returns an error if
+// the stylesheet cannot be loaded.
+func NewPrintRenderer(cfg RendererConfig) (*PrintRenderer, error) {
+	t, err := NewTileRendererFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &PrintRenderer{t}, nil
+}
+
+// Close frees the mapnik map. The renderer must not be used afterwards.
+func (p *PrintRenderer) Close() {
+	p.t.Close()
+}
+
+// paperSize returns the width and height of the map in millimetres.
+func (o PrintOptions) paperSize() (float64, float64, error) {
+	w, h := o.Width, o.Height
+	if o.Paper != "" {
+		size, ok := PaperSizes[o.Paper]
+		if !ok {
+			return 0, 0, fmt.Errorf("unknown paper size %v", o.Paper)
+		}
+		w, h = size[0], size[1]
+	}
+	if w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("invalid paper size %vx%vmm", w, h)
+	}
+	if o.Landscape {
+		w, h = h, w
+	}
+	return w, h, nil
+}
+
+// Render renders the map described by o.
+func (p *PrintRenderer) Render(o PrintOptions) ([]byte, error) {
+	wmm, hmm, err := o.paperSize()
+	if err != nil {
+		return nil, err
+	}
+	dpi := o.DPI
+	if dpi == 0 {
+		dpi = 300
+	}
+	format := o.Format
+	if format == "" {
+		format = "png"
+	}
+	switch format {
+	case "png", "tiff", "geotiff", "pdf":
+	default:
+		return nil, fmt.Errorf("unsupported format %v", format)
+	}
+
+	width := math.Round(wmm / 25.4 * dpi)
+	height := math.Round(hmm / 25.4 * dpi)
+	if width < 1 || height < 1 || width > maxPrintSize || height > maxPrintSize {
+		return nil, fmt.Errorf("image size %vx%v out of range", width, height)
+	}
+
+	t := p.t
+	t.m.Resize(uint32(width), uint32(height))
+	if o.ScaleDenominator > 0 {
+		c := t.mp.Forward(o.Center)
+		// Web Mercator stretches distances by 1/cos(lat)
+		k := 1.0
+		switch srsEPSG(t.m.SRS()) {
+		case 3857, 900913:
+			k = 1 / math.Cos(o.Center.Y*math.Pi/180)
+		}
+		dx := wmm / 1000 * o.ScaleDenominator / 2 * k
+		dy := hmm / 1000 * o.ScaleDenominator / 2 * k
+		t.m.ZoomToMinMax(c.X-dx, c.Y-dy, c.X+dx, c.Y+dy)
+	} else {
+		c0 := t.mp.Forward(mapnik.Coord{X: o.BBox[0], Y: o.BBox[1]})
+		c1 := t.mp.Forward(mapnik.Coord{X: o.BBox[2], Y: o.BBox[3]})
+		t.m.ZoomToMinMax(c0.X, c0.Y, c1.X, c1.Y)
+	}
+	scale := dpi / mapnikDPI
+	t.m.SetBufferSize(int(float64(t.bufferSize) * scale))
+
+	switch format {
+	case "pdf":
+		// cairo measures PDF pages in points, rasters keep the dpi
+		return t.m.RenderToMemoryCairoPage(format, scale, 72/dpi)
+	case "geotiff":
+		return t.renderGeoTIFF(scale)
+	}
+	img, err := t.m.RenderImageScaled(scale)
+	if err != nil {
+		return nil, err
+	}
+	defer img.Free()
+	return img.Encode(format)
+}
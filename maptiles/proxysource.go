@@ -0,0 +1,96 @@
+package maptiles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ProxySource fetches tiles from a remote tile server.
+// It can serve a layer on its own, see TileServer.AddRenderer, or be used
+// as LayerConfig.Fallback when rendering fails. Vector tiles are stored
+// gzip compressed, like in MBTiles files.
+type ProxySource struct {
+	// URL is the tile URL template. {z}, {x} and {y} are replaced with the
+	// tile coordinates, {-y} with the TMS y coordinate, e.g.
+	// https://tile.example.com/{z}/{x}/{y}.png
+	URL string
+
+	// Cache optionally stores the fetched tiles, so they are only
+	// requested once.
+	Cache TileCache
+
+	// Client is used for the requests. If nil, a client with a timeout
+	// of 30 seconds is used.
+	Client *http.Client
+}
+
+var defaultProxyClient = &http.Client{Timeout: 30 * time.Second}
+
+func (p *ProxySource) url(c TileCoord) string {
+	c.SetTMS(false)
+	tmsY := (uint64(1) << c.Zoom) - c.Y - 1
+	r := strings.NewReplacer(
+		"{z}", strconv.FormatUint(c.Zoom, 10),
+		"{x}", strconv.FormatUint(c.X, 10),
+		"{y}", strconv.FormatUint(c.Y, 10),
+		"{-y}", strconv.FormatUint(tmsY, 10),
+	)
+	return r.Replace(p.URL)
+}
+
+// RenderTile fetches the tile, or returns nil if the remote server
+// responds with 404 Not Found.
+func (p *ProxySource) RenderTile(c TileCoord) ([]byte, error) {
+	if p.Cache != nil {
+		if blob, err := p.Cache.Fetch(c); err == nil && blob != nil {
+			return blob, nil
+		}
+	}
+
+	client := p.Client
+	if client == nil {
+		client = defaultProxyClient
+	}
+	resp, err := client.Get(p.url(c))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("upstream tile server: %v", resp.Status)
+	}
+	blob, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	// vector tiles are stored compressed, the client has decompressed
+	// them unless the server sent them compressed without being asked
+	if ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); vectorTileTypes[ct] && resp.Header.Get("Content-Encoding") != "gzip" {
+		if blob, err = gzipBlob(blob); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.Cache != nil {
+		insertTiles(p.Cache, []TileFetchResult{{Coord: c, BlobPNG: blob}})
+	}
+	return blob, nil
+}
+
+func (p *ProxySource) RenderMetaTile(c MetaTileCoord) ([]TileFetchResult, error) {
+	coords := c.TileCoords()
+	results := make([]TileFetchResult, 0, len(coords))
+	for _, tc := range coords {
+		blob, err := p.RenderTile(tc)
+		results = append(results, TileFetchResult{Coord: tc, BlobPNG: blob, Error: err})
+	}
+	return results, nil
+}
@@ -0,0 +1,10 @@
+package maptiles
+
+import (
+	"github.com/nkovacs/go-mapnik/maptiles/tile"
+)
+
+// TileCoordFromQuadkey returns the XYZ coordinates of a Bing Maps quadkey.
+func TileCoordFromQuadkey(quadkey string, layer string) (TileCoord, error) {
+	return tile.FromQuadkey(quadkey, layer)
+}
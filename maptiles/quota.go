@@ -0,0 +1,121 @@
+package maptiles
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// usageDayFormat is the format of the days of a UsageStore, in UTC.
+const usageDayFormat = "2006-01-02"
+
+// usageRetention is the number of days MemoryUsage keeps.
+const usageRetention = 90
+
+// UsageStore counts the tiles served per account and day, see
+// TileServer.Usage. The account is the name of the API key or JWT subject,
+// or the key if it has no name. Days are formatted as 2006-01-02 in UTC.
+type UsageStore interface {
+	// AddUsage adds n tiles to the usage of the account on day and
+	// returns the new total. n is negative to take back tiles that were
+	// not served. The addition must be atomic, quotas are checked against
+	// the total.
+	AddUsage(account, day string, n int64) (int64, error)
+	// Usage returns the tiles served per account on day.
+	Usage(day string) (map[string]int64, error)
+}
+
+// MemoryUsage is a UsageStore keeping the usage of the last 90 days in
+// memory. It is lost when the process exits.
+type MemoryUsage struct {
+	mu   sync.Mutex
+	days map[string]map[string]int64
+}
+
+// NewMemoryUsage creates an empty MemoryUsage.
+func NewMemoryUsage() *MemoryUsage {
+	return &MemoryUsage{days: make(map[string]map[string]int64)}
+}
+
+func (m *MemoryUsage) AddUsage(account, day string, n int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usage, ok := m.days[day]
+	if !ok {
+		usage = make(map[string]int64)
+		m.days[day] = usage
+		// day strings sort chronologically
+		oldest := time.Now().UTC().AddDate(0, 0, -usageRetention).Format(usageDayFormat)
+		for d := range m.days {
+			if d < oldest {
+				delete(m.days, d)
+			}
+		}
+	}
+	usage[account] += n
+	return usage[account], nil
+}
+
+func (m *MemoryUsage) Usage(day string) (map[string]int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	usage := make(map[string]int64, len(m.days[day]))
+	for account, n := range m.days[day] {
+		usage[account] = n
+	}
+	return usage, nil
+}
+
+// usageAccount returns the account the usage of the key is counted for.
+func usageAccount(k APIKey) string {
+	if k.Name != "" {
+		return k.Name
+	}
+	return k.Key
+}
+
+// checkQuota counts n tiles requested with the API key or JWT of the
+// request in t.Usage. Requests over the daily Quota of the key are
+// answered with 429 Too Many Requests until midnight UTC, and false is
+// returned; over QuotaWarn, a warning is logged and sent in the
+// X-Quota-Warning header. Errors of the store are logged and the request
+// is allowed.
+func (t *TileServer) checkQuota(w http.ResponseWriter, r *http.Request, n int64) bool {
+	k, ok := r.Context().Value(apiKeyContextKey{}).(APIKey)
+	if !ok || t.Usage == nil {
+		return true
+	}
+	now := time.Now().UTC()
+	day := now.Format(usageDayFormat)
+	account := usageAccount(k)
+	// the tiles are counted first, so concurrent requests cannot exceed
+	// the quota together
+	total, err := t.Usage.AddUsage(account, day, n)
+	if err != nil {
+		log.Printf("usage of %v: %v", account, err)
+		return true
+	}
+	if k.Quota > 0 {
+		if total > k.Quota {
+			if _, err := t.Usage.AddUsage(account, day, -n); err != nil {
+				log.Printf("usage of %v: %v", account, err)
+			}
+			midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(midnight.Sub(now).Seconds()))))
+			http.Error(w, fmt.Sprintf("daily quota of %d tiles exceeded", k.Quota), http.StatusTooManyRequests)
+			return false
+		}
+		w.Header().Set("X-Quota-Remaining", strconv.FormatInt(k.Quota-total, 10))
+	}
+	if k.QuotaWarn > 0 && total > k.QuotaWarn {
+		if total-n <= k.QuotaWarn {
+			log.Printf("%v exceeded its soft quota of %d tiles", account, k.QuotaWarn)
+		}
+		w.Header().Set("X-Quota-Warning", fmt.Sprintf("soft quota of %d tiles exceeded", k.QuotaWarn))
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package maptiles
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCheckQuota(t *testing.T) {
+	ts := &TileServer{Usage: NewMemoryUsage()}
+	k := APIKey{Key: "secret", Name: "acme", Quota: 10, QuotaWarn: 5}
+	r := httptest.NewRequest("GET", "/default/0/0/0.png", nil)
+	r = r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, k))
+
+	var mu sync.Mutex
+	allowed := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 30; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ts.checkQuota(httptest.NewRecorder(), r, 1) {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 10 {
+		t.Errorf("%d requests allowed, want 10", allowed)
+	}
+
+	day := time.Now().UTC().Format(usageDayFormat)
+	usage, _ := ts.Usage.Usage(day)
+	if usage["acme"] != 10 {
+		t.Errorf("got usage %d, want 10", usage["acme"])
+	}
+
+	w := httptest.NewRecorder()
+	if ts.checkQuota(w, r, 1) || w.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d over quota, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+}
@@ -0,0 +1,121 @@
+package maptiles
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows burst requests at once and rate requests per second
+// on average.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+	rate   float64
+	burst  float64
+}
+
+// take removes n tokens from the bucket. If the bucket is empty, it returns
+// the time until the next token is available. Taking more tokens than are
+// left is allowed as long as there is one, the bucket then stays empty
+// until the debt is paid off, so requests larger than the burst are not
+// rejected forever.
+func (b *tokenBucket) take(now time.Time, n int) (bool, time.Duration) {
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens -= float64(n)
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// full reports whether the bucket has refilled completely, i.e. its client
+// has been idle long enough to forget it.
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.burst
+}
+
+// rateLimiter keeps a token bucket per API key or client IP.
+type rateLimiter struct {
+	rate  float64
+	burst int
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	takes   int
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst == 0 {
+		burst = int(math.Ceil(rate))
+	}
+	return &rateLimiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow takes n tokens from the bucket of the client. rate and burst
+// override the defaults if they are not zero.
+func (l *rateLimiter) allow(client string, n int, rate float64, burst int) (bool, time.Duration) {
+	if rate == 0 {
+		rate = l.rate
+	}
+	if burst == 0 {
+		burst = l.burst
+		if rate != l.rate {
+			burst = int(math.Ceil(rate))
+		}
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: float64(burst), last: now}
+		l.buckets[client] = b
+	}
+	b.rate, b.burst = rate, float64(burst)
+
+	// drop idle clients from time to time so the map does not grow with
+	// every address ever seen
+	l.takes++
+	if l.takes%10000 == 0 {
+		for k, b := range l.buckets {
+			if b.full(now) {
+				delete(l.buckets, k)
+			}
+		}
+	}
+	return b.take(now, n)
+}
+
+// checkRateLimitN counts n tiles against the rate limit of the API key or
+// client IP of the request. Requests over the limit are answered with
+// 429 Too Many Requests and it returns false. ServeHTTP counts each
+// request as one tile, /batch and /bundle.mbtiles count the rest of
+// their tiles themselves.
+func (t *TileServer) checkRateLimitN(w http.ResponseWriter, r *http.Request, n int) bool {
+	if t.rateLimit == nil || n <= 0 {
+		return true
+	}
+	var client string
+	var rate float64
+	var burst int
+	if k, ok := r.Context().Value(apiKeyContextKey{}).(APIKey); ok {
+		client = "key:" + k.Key
+		rate, burst = k.RateLimit, k.RateBurst
+	} else {
+		client = "ip:" + t.ClientIP(r)
+	}
+	ok, retryAfter := t.rateLimit.allow(client, n, rate, burst)
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+	}
+	return ok
+}
@@ -0,0 +1,55 @@
+package maptiles
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	start := time.Unix(0, 0)
+	b := &tokenBucket{tokens: 2, last: start, rate: 1, burst: 2}
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.take(start, 1); !ok {
+			t.Fatalf("request %d within the burst was limited", i)
+		}
+	}
+	ok, wait := b.take(start, 1)
+	if ok || wait != time.Second {
+		t.Errorf("got %v, %v, want to wait a second", ok, wait)
+	}
+	if ok, _ := b.take(start.Add(time.Second), 1); !ok {
+		t.Error("request limited after the bucket refilled")
+	}
+
+	// a batch larger than the burst is allowed, the next request waits
+	// until the tiles are paid off
+	b = &tokenBucket{tokens: 2, last: start, rate: 1, burst: 2}
+	if ok, _ := b.take(start, 10); !ok {
+		t.Fatal("batch was limited")
+	}
+	if ok, wait := b.take(start.Add(5*time.Second), 1); ok || wait != 4*time.Second {
+		t.Errorf("got %v, %v, want to wait 4s", ok, wait)
+	}
+	if !b.full(start.Add(20 * time.Second)) {
+		t.Error("bucket did not refill")
+	}
+}
+
+func TestRateLimiterClients(t *testing.T) {
+	l := newRateLimiter(1, 1)
+	if ok, _ := l.allow("a", 1, 0, 0); !ok {
+		t.Fatal("first request was limited")
+	}
+	if ok, _ := l.allow("a", 1, 0, 0); ok {
+		t.Error("second request was not limited")
+	}
+	if ok, _ := l.allow("b", 1, 0, 0); !ok {
+		t.Error("other client was limited")
+	}
+	// an API key with its own limit
+	for i := 0; i < 5; i++ {
+		if ok, _ := l.allow("key", 1, 5, 0); !ok {
+			t.Fatalf("request %d within the burst of the key was limited", i)
+		}
+	}
+}
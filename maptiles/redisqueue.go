@@ -0,0 +1,206 @@
+package maptiles
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisQueue is a JobQueue stored in a redis stream. Workers read it as a
+// consumer group, so every job is rendered once. Requires redis 6.2.
+type RedisQueue struct {
+	// Addr is the host:port of the redis server.
+	Addr     string
+	Password string
+	DB       int
+
+	// Stream is the key of the stream, e.g. maptiles:seed:osm.
+	Stream string
+
+	// Group is the consumer group of the workers. If empty, maptiles
+	// will be used.
+	Group string
+
+	// Consumer identifies the worker. If empty, host/pid will be used.
+	Consumer string
+
+	// ClaimAfter is the time after which a job that was not acknowledged
+	// is delivered to another worker. If zero, 10 minutes will be used.
+	ClaimAfter time.Duration
+
+	once    sync.Once
+	client  *redis.Client
+	mu      sync.Mutex
+	created bool
+}
+
+var _ JobQueue = (*RedisQueue)(nil)
+
+// NewRedisQueue creates a queue for the stream from a URL of the form
+// redis://[:password@]host[:port][/db].
+func NewRedisQueue(rawurl, stream string) (*RedisQueue, error) {
+	if !strings.HasPrefix(rawurl, "redis://") {
+		return nil, fmt.Errorf("unsupported queue URL %v", rawurl)
+	}
+	opts, err := redis.ParseURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisQueue{Addr: opts.Addr, Password: opts.Password, DB: opts.DB, Stream: stream}, nil
+}
+
+func (q *RedisQueue) conn() *redis.Client {
+	q.once.Do(func() {
+		q.client = redis.NewClient(&redis.Options{Addr: q.Addr, Password: q.Password, DB: q.DB})
+	})
+	return q.client
+}
+
+func (q *RedisQueue) group() string {
+	if q.Group == "" {
+		return "maptiles"
+	}
+	return q.Group
+}
+
+func (q *RedisQueue) consumer() string {
+	if q.Consumer == "" {
+		host, _ := os.Hostname()
+		return fmt.Sprintf("%s/%d", host, os.Getpid())
+	}
+	return q.Consumer
+}
+
+// createGroup creates the stream and the consumer group if they do not
+// exist. The group starts at the beginning of the stream, so jobs added
+// before the first worker started are not lost.
+func (q *RedisQueue) createGroup(ctx context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.created {
+		return nil
+	}
+	err := q.conn().XGroupCreateMkStream(ctx, q.Stream, q.group(), "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	q.created = true
+	return nil
+}
+
+func (q *RedisQueue) Enqueue(coords []MetaTileCoord) error {
+	ctx := context.Background()
+	if err := q.createGroup(ctx); err != nil {
+		return err
+	}
+	_, err := q.conn().Pipelined(ctx, func(p redis.Pipeliner) error {
+		for _, coord := range coords {
+			p.XAdd(ctx, &redis.XAddArgs{Stream: q.Stream, Values: []string{
+				"layer", coord.Layer,
+				"zoom", strconv.FormatUint(coord.Zoom, 10),
+				"min_x", strconv.FormatUint(coord.MinX, 10),
+				"min_y", strconv.FormatUint(coord.MinY, 10),
+				"max_x", strconv.FormatUint(coord.MaxX, 10),
+				"max_y", strconv.FormatUint(coord.MaxY, 10),
+			}})
+		}
+		return nil
+	})
+	return err
+}
+
+func (q *RedisQueue) Next(timeout time.Duration) (*RenderJob, error) {
+	ctx := context.Background()
+	if err := q.createGroup(ctx); err != nil {
+		return nil, err
+	}
+
+	claimAfter := q.ClaimAfter
+	if claimAfter == 0 {
+		claimAfter = 10 * time.Minute
+	}
+	// jobs of crashed workers first
+	claimed, _, err := q.conn().XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   q.Stream,
+		Group:    q.group(),
+		Consumer: q.consumer(),
+		MinIdle:  claimAfter,
+		Start:    "0-0",
+		Count:    1,
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range claimed {
+		// deleted entries have no values
+		if len(m.Values) > 0 {
+			return parseRedisJob(m)
+		}
+	}
+
+	streams, err := q.conn().XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    q.group(),
+		Consumer: q.consumer(),
+		Streams:  []string{q.Stream, ">"},
+		Count:    1,
+		Block:    timeout,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(streams) == 0 || len(streams[0].Messages) == 0 {
+		return nil, nil
+	}
+	return parseRedisJob(streams[0].Messages[0])
+}
+
+// parseRedisJob parses a stream entry.
+func parseRedisJob(m redis.XMessage) (*RenderJob, error) {
+	job := &RenderJob{ID: m.ID}
+	for k, value := range m.Values {
+		v, _ := value.(string)
+		n, _ := strconv.ParseUint(v, 10, 64)
+		switch k {
+		case "layer":
+			job.Coord.Layer = v
+		case "zoom":
+			job.Coord.Zoom = n
+		case "min_x":
+			job.Coord.MinX = n
+		case "min_y":
+			job.Coord.MinY = n
+		case "max_x":
+			job.Coord.MaxX = n
+		case "max_y":
+			job.Coord.MaxY = n
+		}
+	}
+	if !job.Coord.Valid() {
+		return nil, fmt.Errorf("invalid render job %v", m.ID)
+	}
+	return job, nil
+}
+
+// Ack acknowledges the job and removes it from the stream, so the length
+// of the stream is the number of remaining jobs.
+func (q *RedisQueue) Ack(job *RenderJob) error {
+	ctx := context.Background()
+	if err := q.conn().XAck(ctx, q.Stream, q.group(), job.ID).Err(); err != nil {
+		return err
+	}
+	return q.conn().XDel(ctx, q.Stream, job.ID).Err()
+}
+
+// Close closes the connections.
+func (q *RedisQueue) Close() error {
+	return q.conn().Close()
+}
@@ -0,0 +1,58 @@
+package maptiles
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// refererPolicy restricts requests to pages on allowed hosts.
+type refererPolicy struct {
+	hosts      []string
+	allowEmpty bool
+	bypass     ipList
+}
+
+func newRefererPolicy(hosts []string, allowEmpty bool, bypass []string) (*refererPolicy, error) {
+	p := &refererPolicy{allowEmpty: allowEmpty}
+	for _, h := range hosts {
+		h = strings.ToLower(h)
+		if _, err := path.Match(h, ""); err != nil {
+			return nil, fmt.Errorf("invalid referer pattern %q", h)
+		}
+		p.hosts = append(p.hosts, h)
+	}
+	var err error
+	if p.bypass, err = parseIPList(bypass); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// allowed reports whether the Origin or Referer header of the request, or
+// the client IP ip, is allowed.
+func (p *refererPolicy) allowed(r *http.Request, ip string) bool {
+	if p.bypass.contains(ip) {
+		return true
+	}
+	ref := r.Header.Get("Origin")
+	if ref == "" || ref == "null" {
+		ref = r.Header.Get("Referer")
+	}
+	if ref == "" {
+		return p.allowEmpty
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range p.hosts {
+		if ok, _ := path.Match(pattern, host); ok {
+			return true
+		}
+	}
+	return false
+}
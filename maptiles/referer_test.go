@@ -0,0 +1,47 @@
+package maptiles
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRefererPolicy(t *testing.T) {
+	p, err := newRefererPolicy([]string{"example.com", "*.example.org"}, false, []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		origin, referer, ip string
+		ok                  bool
+	}{
+		{"", "https://example.com/map", "192.0.2.1", true},
+		{"", "https://EXAMPLE.com:8080/map", "192.0.2.1", true},
+		{"", "https://tiles.example.org/", "192.0.2.1", true},
+		{"https://example.com", "https://evil.test/", "192.0.2.1", true},
+		{"null", "https://example.com/", "192.0.2.1", true},
+		{"", "https://example.com.evil.test/", "192.0.2.1", false},
+		{"", "https://example.org/", "192.0.2.1", false},
+		{"", "", "192.0.2.1", false},
+		{"", "", "10.0.0.1", true},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if test.referer != "" {
+			r.Header.Set("Referer", test.referer)
+		}
+		if ok := p.allowed(r, test.ip); ok != test.ok {
+			t.Errorf("origin %q, referer %q, ip %s: got %v", test.origin, test.referer, test.ip, ok)
+		}
+	}
+
+	p.allowEmpty = true
+	if !p.allowed(httptest.NewRequest("GET", "/", nil), "192.0.2.1") {
+		t.Error("request without referer rejected with allowEmpty")
+	}
+	if _, err := newRefererPolicy([]string{"[example.com"}, false, nil); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+}
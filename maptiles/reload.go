@@ -0,0 +1,208 @@
+package maptiles
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ReloadLayer loads the stylesheet of a mapnik layer again and replaces the
+// layer's renderers without interrupting requests. Requests already queued
+// are finished by the old renderers. If invalidate is set, the cached tiles
+// of the layer are deleted, see PurgeLayer; it returns an error without
+// reloading if the cache does not support that.
+// If the stylesheet cannot be loaded, the old renderers keep serving the layer.
+func (t *TileServer) ReloadLayer(layerName string, invalidate bool) error {
+	mu := t.layerLock(layerName)
+	mu.Lock()
+	defer mu.Unlock()
+	if err := t.checkInvalidate(invalidate); err != nil {
+		return err
+	}
+	if err := t.reloadLayer(layerName); err != nil {
+		return err
+	}
+	return t.invalidateLayer(layerName, invalidate)
+}
+
+// checkInvalidate returns an error if invalidate is set and the cached
+// tiles cannot be deleted.
+func (t *TileServer) checkInvalidate(invalidate bool) error {
+	if invalidate && t.m != nil && !t.canPurge() {
+		return fmt.Errorf("cache does not support purging layers")
+	}
+	return nil
+}
+
+// layerLock returns the mutex serializing the reloads and stylesheet
+// updates of a layer.
+func (t *TileServer) layerLock(layerName string) *sync.Mutex {
+	mu, _ := t.layerLocks.LoadOrStore(layerName, new(sync.Mutex))
+	return mu.(*sync.Mutex)
+}
+
+func (t *TileServer) reloadLayer(layerName string) error {
+	t.mu.RLock()
+	cfg, ok := t.layers[layerName]
+	t.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no mapnik layer %v", layerName)
+	}
+
+	c, err := t.createRenderer(cfg)
+	if err != nil {
+		return err
+	}
+	t.replaceRenderers(layerName, c)
+	return nil
+}
+
+// replaceRenderers replaces the renderers of a layer with c, the old ones
+// finish the queued requests.
+func (t *TileServer) replaceRenderers(layerName string, c chan<- FetchRequest) {
+	if old, ok := t.lmp.ReplaceSource(layerName, c); ok {
+		close(old)
+	}
+}
+
+// invalidateLayer deletes the tiles of a reloaded layer from the caches if
+// invalidate is set.
+func (t *TileServer) invalidateLayer(layerName string, invalidate bool) error {
+	if !invalidate {
+		return nil
+	}
+	if t.Peers != nil {
+		t.Peers.removeLayer(layerName)
+	}
+	if t.m != nil {
+		return t.PurgeLayer(layerName, false)
+	}
+	return nil
+}
+
+// UpdateStylesheet validates new stylesheet XML for a mapnik layer,
+// replaces the layer's stylesheet file with it and reloads the layer.
+// If the layer cannot be reloaded, the old file is restored. Updates and
+// reloads of the same layer are done one at a time.
+func (t *TileServer) UpdateStylesheet(layerName string, xml []byte, invalidate bool) error {
+	mu := t.layerLock(layerName)
+	mu.Lock()
+	defer mu.Unlock()
+	t.mu.RLock()
+	cfg, ok := t.layers[layerName]
+	t.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("no mapnik layer %v", layerName)
+	}
+	if err := t.checkInvalidate(invalidate); err != nil {
+		return err
+	}
+	if err := ValidateStylesheetFile(xml, cfg.Params, cfg.Stylesheet); err != nil {
+		return fmt.Errorf("invalid stylesheet: %v", err)
+	}
+
+	old, err := ioutil.ReadFile(cfg.Stylesheet)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(cfg.Stylesheet, xml); err != nil {
+		return err
+	}
+	if err := t.reloadLayer(layerName); err != nil {
+		if rerr := writeFileAtomic(cfg.Stylesheet, old); rerr != nil {
+			log.Println("error restoring stylesheet", cfg.Stylesheet, ":", rerr)
+		}
+		return err
+	}
+	return t.invalidateLayer(layerName, invalidate)
+}
+
+// writeFileAtomic replaces the file by renaming a temporary file in the
+// same directory, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if fi, err := os.Stat(path); err == nil {
+		os.Chmod(f.Name(), fi.Mode())
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// StylesheetWatcher reloads a layer when its stylesheet changes.
+// See TileServer.WatchStylesheet.
+type StylesheetWatcher struct {
+	stop     chan bool
+	stopOnce sync.Once
+}
+
+// WatchStylesheet checks the stylesheet of a mapnik layer for changes every
+// interval and calls ReloadLayer when its modification time changes.
+// If interval is zero, 10 seconds will be used.
+func (t *TileServer) WatchStylesheet(layerName string, interval time.Duration, invalidate bool) (*StylesheetWatcher, error) {
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+	t.mu.RLock()
+	cfg, ok := t.layers[layerName]
+	t.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no mapnik layer %v", layerName)
+	}
+	fi, err := os.Stat(cfg.Stylesheet)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &StylesheetWatcher{stop: make(chan bool)}
+	go func(modified time.Time) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				fi, err := os.Stat(cfg.Stylesheet)
+				if err != nil {
+					log.Println("error checking stylesheet", cfg.Stylesheet, ":", err)
+					continue
+				}
+				if fi.ModTime().Equal(modified) {
+					continue
+				}
+				modified = fi.ModTime()
+				log.Println("reloading layer", layerName)
+				if err := t.ReloadLayer(layerName, invalidate); err != nil {
+					log.Println("error reloading layer", layerName, ":", err)
+				}
+			case <-w.stop:
+				return
+			}
+		}
+	}(fi.ModTime())
+	return w, nil
+}
+
+// Stop stops watching the stylesheet.
+func (w *StylesheetWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+}
@@ -0,0 +1,397 @@
+package maptiles
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/nkovacs/go-mapnik/maptiles/renderpb"
+)
+
+// errNoWorkers is returned by a RemoteRenderer without suitable workers.
+var errNoWorkers = errors.New("no render workers")
+
+// RemoteRenderer renders on a pool of remote render workers, so rendering
+// scales beyond the mapnik throughput of one machine. Requests are spread
+// over the healthy workers and retried on another worker if a worker
+// fails. It is safe for concurrent use.
+type RemoteRenderer struct {
+	// Workers are the URLs of the workers: URLs of gRPC render services,
+	// see RenderService, e.g. http://render-1:9090 for unencrypted HTTP/2
+	// or https://render-1:9090 for TLS, or tile URL templates of tile
+	// servers like
+	// ProxySource.URL, e.g. http://render-1:8080/osm/{z}/{x}/{y}.png.
+	// Static maps are only rendered by gRPC workers.
+	Workers []string
+
+	// Layer is the layer requested from gRPC workers. If empty, the layer
+	// of the requested tiles is used and static maps are rendered for
+	// the default layer.
+	Layer string
+
+	// Client is used for the requests to tile servers. If nil, a client
+	// with Timeout is used.
+	Client *http.Client
+
+	// Timeout is the deadline of each request sent to a worker. If zero,
+	// 30 seconds will be used.
+	Timeout time.Duration
+
+	// TLSConfig is used for gRPC workers with https URLs, e.g. with a
+	// client certificate for workers requiring one. If nil, the server
+	// certificate is verified with the system roots.
+	TLSConfig *tls.Config
+
+	// Secret is sent to gRPC workers, see RenderService.Secret.
+	Secret string
+
+	// Retries is the number of other workers a request is sent to if a
+	// worker fails or is busy. If zero, 2 will be used.
+	Retries int
+
+	// HealthInterval is the time between health checks. Failed workers
+	// get no requests until they pass a health check. If zero, 10
+	// seconds will be used.
+	HealthInterval time.Duration
+
+	once    sync.Once
+	client  *http.Client
+	workers []*remoteWorker
+	next    uint32
+	stop    chan struct{}
+}
+
+var (
+	_ Renderer        = (*RemoteRenderer)(nil)
+	_ StaticRenderer  = (*RemoteRenderer)(nil)
+	_ requestRenderer = (*RemoteRenderer)(nil)
+)
+
+// remoteWorker is a worker of a RemoteRenderer.
+type remoteWorker struct {
+	url string
+
+	// proxy is set for tile servers
+	proxy *ProxySource
+
+	// conn is the connection to gRPC workers, err is set if it could not
+	// be created.
+	conn   *grpc.ClientConn
+	client renderpb.RenderClient
+	err    error
+
+	healthy int32
+}
+
+// workerError is a failure of a worker, as opposed to a render error, so
+// the request is retried on another worker.
+type workerError struct {
+	err error
+}
+
+func (e *workerError) Error() string {
+	return e.err.Error()
+}
+
+func (e *workerError) Unwrap() error {
+	return e.err
+}
+
+func (r *RemoteRenderer) start() {
+	r.stop = make(chan struct{})
+	r.client = r.Client
+	if r.client == nil {
+		r.client = &http.Client{Timeout: r.timeout()}
+	}
+	for _, u := range r.Workers {
+		w := &remoteWorker{url: strings.TrimSuffix(u, "/"), healthy: 1}
+		if strings.Contains(u, "{z}") {
+			w.proxy = &ProxySource{URL: u, Client: r.client}
+		} else if w.conn, w.err = r.dial(w.url); w.err == nil {
+			w.client = renderpb.NewRenderClient(w.conn)
+		}
+		r.workers = append(r.workers, w)
+	}
+	interval := r.HealthInterval
+	if interval == 0 {
+		interval = 10 * time.Second
+	}
+	go r.healthCheck(interval)
+}
+
+// dial creates the connection to a gRPC worker. Connections are
+// established lazily by grpc.
+func (r *RemoteRenderer) dial(workerURL string) (*grpc.ClientConn, error) {
+	u, err := url.Parse(workerURL)
+	if err != nil {
+		return nil, err
+	}
+	var opts []grpc.DialOption
+	switch u.Scheme {
+	case "http":
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	case "https":
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(r.TLSConfig)))
+	default:
+		return nil, fmt.Errorf("render worker %v: unsupported scheme", workerURL)
+	}
+	if r.Secret != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(secretCredentials(r.Secret)))
+	}
+	return grpc.NewClient(u.Host, opts...)
+}
+
+func (r *RemoteRenderer) healthCheck(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+		for _, w := range r.workers {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			err := r.check(ctx, w)
+			cancel()
+			healthy := int32(1)
+			if err != nil {
+				healthy = 0
+			}
+			if old := atomic.SwapInt32(&w.healthy, healthy); old != healthy {
+				if err != nil {
+					log.Println("Render worker", w.url, "is down:", err)
+				} else {
+					log.Println("Render worker", w.url, "is up")
+				}
+			}
+		}
+	}
+}
+
+// check calls the health service of a gRPC worker, or fetches tile 0/0/0
+// from a tile server.
+func (r *RemoteRenderer) check(ctx context.Context, w *remoteWorker) error {
+	if w.proxy != nil {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.proxy.url(TileCoord{}), nil)
+		if err != nil {
+			return err
+		}
+		resp, err := r.client.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			return fmt.Errorf("health check: %v", resp.Status)
+		}
+		return nil
+	}
+	if w.err != nil {
+		return w.err
+	}
+	return checkHealth(ctx, w.conn)
+}
+
+func (r *RemoteRenderer) timeout() time.Duration {
+	if r.Timeout <= 0 {
+		return 30 * time.Second
+	}
+	return r.Timeout
+}
+
+// grpcCall calls a method of a gRPC worker with a deadline of Timeout.
+// The request ID of ctx is sent along, see RequestID. Transport errors,
+// timeouts and busy workers are returned as workerError.
+func (r *RemoteRenderer) grpcCall(ctx context.Context, w *remoteWorker, call func(ctx context.Context, c renderpb.RenderClient) error) error {
+	if w.err != nil {
+		return &workerError{w.err}
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout())
+	defer cancel()
+	if id := contextRequestID(ctx); id != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, RequestIDHeader, id)
+	}
+	err := call(ctx, w.client)
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.ResourceExhausted, codes.Unavailable, codes.DeadlineExceeded, codes.Canceled, codes.Unauthenticated:
+		return &workerError{err}
+	}
+	return err
+}
+
+// pick returns up to n workers to try in order, starting with the next
+// healthy one. If no worker is healthy, all are tried.
+func (r *RemoteRenderer) pick(n int, grpcOnly bool) []*remoteWorker {
+	r.once.Do(r.start)
+	start := int(atomic.AddUint32(&r.next, 1))
+	var healthy, all []*remoteWorker
+	for i := range r.workers {
+		w := r.workers[(start+i)%len(r.workers)]
+		if grpcOnly && w.proxy != nil {
+			continue
+		}
+		all = append(all, w)
+		if atomic.LoadInt32(&w.healthy) != 0 {
+			healthy = append(healthy, w)
+		}
+	}
+	if len(healthy) == 0 {
+		healthy = all
+	}
+	if len(healthy) > n {
+		healthy = healthy[:n]
+	}
+	return healthy
+}
+
+// do calls f with workers until it succeeds or fails with an error that is
+// not a workerError. Failed workers are marked as down.
+func (r *RemoteRenderer) do(grpcOnly bool, f func(w *remoteWorker) error) error {
+	retries := r.Retries
+	if retries == 0 {
+		retries = 2
+	}
+	err := errNoWorkers
+	for _, w := range r.pick(retries+1, grpcOnly) {
+		err = f(w)
+		we, failed := err.(*workerError)
+		if !failed {
+			return err
+		}
+		if status.Code(we.err) != codes.ResourceExhausted {
+			if atomic.SwapInt32(&w.healthy, 0) != 0 {
+				log.Println("Render worker", w.url, "is down:", err)
+			}
+		}
+	}
+	return err
+}
+
+// remoteCoord returns the coordinate requested from gRPC workers.
+func (r *RemoteRenderer) remoteCoord(c TileCoord) TileCoord {
+	if r.Layer != "" {
+		c.Layer = r.Layer
+	}
+	return c
+}
+
+func (r *RemoteRenderer) RenderTile(c TileCoord) ([]byte, error) {
+	return r.renderTileRequest(c, "")
+}
+
+func (r *RemoteRenderer) RenderMetaTile(c MetaTileCoord) ([]TileFetchResult, error) {
+	return r.renderMetaTileRequest(c, "")
+}
+
+// requestContext returns a context carrying the request ID for grpcCall.
+func requestContext(requestID string) context.Context {
+	return context.WithValue(context.Background(), requestIDContextKey{}, requestID)
+}
+
+func (r *RemoteRenderer) renderTileRequest(c TileCoord, requestID string) ([]byte, error) {
+	var blob []byte
+	err := r.do(false, func(w *remoteWorker) error {
+		if w.proxy != nil {
+			var err error
+			if blob, err = w.proxy.RenderTile(c); err != nil {
+				return &workerError{err}
+			}
+			return nil
+		}
+		var reply *renderpb.Tile
+		err := r.grpcCall(requestContext(requestID), w, func(ctx context.Context, client renderpb.RenderClient) (err error) {
+			reply, err = client.RenderTile(ctx, tileCoordToProto(r.remoteCoord(c)))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		result := tileFromProto(reply)
+		blob = result.BlobPNG
+		return result.Error
+	})
+	return blob, err
+}
+
+func (r *RemoteRenderer) renderMetaTileRequest(c MetaTileCoord, requestID string) ([]TileFetchResult, error) {
+	var results []TileFetchResult
+	err := r.do(false, func(w *remoteWorker) error {
+		if w.proxy != nil {
+			results, _ = w.proxy.RenderMetaTile(c)
+			return nil
+		}
+		mc := c
+		if r.Layer != "" {
+			mc.Layer = r.Layer
+		}
+		var reply *renderpb.MetaTile
+		err := r.grpcCall(requestContext(requestID), w, func(ctx context.Context, client renderpb.RenderClient) (err error) {
+			reply, err = client.RenderMetaTile(ctx, metaTileCoordToProto(mc))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		results = results[:0]
+		for _, t := range reply.GetTiles() {
+			result := tileFromProto(t)
+			result.Coord.Layer = c.Layer
+			results = append(results, result)
+		}
+		if len(results) != int(c.Count()) {
+			return &workerError{fmt.Errorf("render worker returned %d tiles instead of %d", len(results), c.Count())}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (r *RemoteRenderer) RenderStaticMap(bbox [4]float64, width, height uint32, format string) ([]byte, error) {
+	var blob []byte
+	err := r.do(true, func(w *remoteWorker) error {
+		layer := r.Layer
+		if layer == "" {
+			layer = "default"
+		}
+		req := &renderpb.StaticMap{Layer: layer, Bbox: bbox[:], Width: width, Height: height, Format: format}
+		return r.grpcCall(context.Background(), w, func(ctx context.Context, client renderpb.RenderClient) error {
+			reply, err := client.RenderStatic(ctx, req)
+			blob = reply.GetImage()
+			return err
+		})
+	})
+	return blob, err
+}
+
+// Close stops the health checks and closes the connections to the gRPC
+// workers.
+func (r *RemoteRenderer) Close() {
+	r.once.Do(r.start)
+	close(r.stop)
+	for _, w := range r.workers {
+		if w.conn != nil {
+			w.conn.Close()
+		}
+	}
+}
@@ -1,98 +1,46 @@
 package maptiles
 
 import (
+	"bytes"
 	"fmt"
-	"log"
 	"image"
+	"image/color"
 	"image/png"
-	"bytes"
+	"log"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/nkovacs/go-mapnik/mapnik"
+	"github.com/nkovacs/go-mapnik/maptiles/tile"
+	"github.com/nkovacs/go-mapnik/tilegrid"
 )
 
-type TileCoord struct {
-	X, Y, Zoom uint64
-	Tms        bool
-	Layer      string
-}
-
-type MetaTileCoord struct {
-	MinX, MinY, MaxX, MaxY, Zoom uint64
-	Tms bool
-	Layer string
-}
-
-func (c TileCoord) OSMFilename() string {
-	return fmt.Sprintf("%d/%d/%d.png", c.Zoom, c.X, c.Y)
-}
-
-func (c *TileCoord) setTMS(tms bool) {
-	if c.Tms != tms {
-		c.Y = (1 << c.Zoom) - c.Y - 1
-		c.Tms = tms
-	}
-}
-
-func (c *MetaTileCoord) setTMS(tms bool) {
-	if c.Tms != tms {
-		c.MinY = (1 << c.Zoom) - c.MinY - 1
-		c.MaxY = (1 << c.Zoom) - c.MaxY - 1
-		c.MinY, c.MaxY = c.MaxY, c.MinY
-		c.Tms = tms
-	}
-}
-
-func (c *MetaTileCoord) XSize() uint64 {
-	if c.MaxX < c.MinX {
-		panic(fmt.Errorf("Invalid metatile coordinates"))
-	}
-	return c.MaxX - c.MinX + 1
-}
-
-func (c *MetaTileCoord) YSize() uint64 {
-	if c.MaxY < c.MinY {
-		panic(fmt.Errorf("Invalid metatile coordinates"))
-	}
-	return c.MaxY - c.MinY + 1
-}
-
-func (c *MetaTileCoord) Count() uint64 {
-	return c.XSize() * c.YSize()
-}
-
-func (c *MetaTileCoord) TileCoords() []TileCoord {
-	xSize := c.XSize()
-	ySize := c.YSize()
-	coords := make([]TileCoord, 0, xSize * ySize)
-	for x := 0; x < int(xSize); x++ {
-		for y := 0; y < int(ySize); y++ {
-			coords = append(coords, TileCoord{
-				X: c.MinX + uint64(x),
-				Y: c.MinY + uint64(y),
-				Zoom: c.Zoom,
-				Tms: c.Tms,
-				Layer: c.Layer,
-			})
-		}
-	}
-	return coords
-}
-
-type TileFetchResult struct {
-	Coord   TileCoord
-	BlobPNG []byte
-	Error   error
-}
+// The tile types are defined in package tile, so tools and tests can use
+// them without mapnik.
+type (
+	TileCoord       = tile.Coord
+	MetaTileCoord   = tile.MetaCoord
+	TileFetchResult = tile.FetchResult
+	RenderStats     = tile.RenderStats
+)
 
 type TileFetchRequest struct {
 	Coord   TileCoord
 	OutChan chan<- TileFetchResult
+
+	// RequestID identifies the HTTP request the tile is rendered for in
+	// log messages and is passed on to render workers, see RequestID.
+	RequestID string
 }
 
 type MetaTileFetchRequest struct {
-	Coord   MetaTileCoord
+	Coord MetaTileCoord
 	// Will output multiple results
 	OutChan chan<- TileFetchResult
+
+	// RequestID is the RequestID of the request that caused the render.
+	RequestID string
 }
 
 type FetchRequest interface {
@@ -101,6 +49,7 @@ type FetchRequest interface {
 	GetLayer() string
 	GetMetaCoord() MetaTileCoord
 	GetOutChan() chan<- TileFetchResult
+	GetRequestID() string
 }
 
 func (r TileFetchRequest) IsMetaTile() bool {
@@ -123,6 +72,10 @@ func (r TileFetchRequest) GetOutChan() chan<- TileFetchResult {
 	return r.OutChan
 }
 
+func (r TileFetchRequest) GetRequestID() string {
+	return r.RequestID
+}
+
 func (r MetaTileFetchRequest) IsMetaTile() bool {
 	return true
 }
@@ -143,23 +96,49 @@ func (r MetaTileFetchRequest) GetOutChan() chan<- TileFetchResult {
 	return r.OutChan
 }
 
-func NewTileRendererChan(stylesheet string) chan<- FetchRequest {
+func (r MetaTileFetchRequest) GetRequestID() string {
+	return r.RequestID
+}
+
+// NewTileRendererChan starts a TileRenderer listening on the returned channel.
+// The renderer stops when the channel is closed.
+func NewTileRendererChan(stylesheet string) (chan<- FetchRequest, error) {
+	t, err := NewTileRenderer(stylesheet)
+	if err != nil {
+		return nil, err
+	}
 	c := make(chan FetchRequest)
 
 	go func(requestChan <-chan FetchRequest) {
-		t := NewTileRenderer(stylesheet)
+		defer t.Close()
 		for request := range requestChan {
 			t.ProcessRequest(request)
 		}
 	}(c)
 
-	return c
+	return c, nil
 }
 
 // TileRenderer renders images as Web Mercator tiles
 type TileRenderer struct {
-	m  *mapnik.Map
-	mp mapnik.Projection
+	m          *mapnik.Map
+	mp         *mapnik.Projection
+	bufferSize uint64
+	grid       *tilegrid.Grid
+	watermark  *stamp
+	// transparent is set if the map has no opaque background
+	transparent bool
+	// pngFormat is the mapnik format string tiles are encoded with
+	pngFormat string
+	// composite are rendered along with m and composited onto it
+	composite []compositeMap
+	// id identifies the renderer in RenderStats
+	id string
+	// vars are the stylesheet variables of the request being rendered
+	vars map[string]string
+	// limiter caps the concurrent renders, it is nil for renderers not
+	// created by a LayerMultiplex
+	limiter *renderLimiter
 }
 
 // Listen starts listening for TileFetchRequests on c.
@@ -175,46 +154,139 @@ func (t *TileRenderer) Listen(c <-chan FetchRequest) {
 	}
 }
 
+// Clone returns a renderer for a copy of the map, including the changes
+// made by the RendererConfig, without parsing the stylesheet again.
+// The clone must be closed separately.
+func (t *TileRenderer) Clone() *TileRenderer {
+	c := *t
+	c.m = t.m.Clone()
+	c.mp = c.m.NewProjection()
+	c.composite = cloneComposite(t.composite)
+	c.id = newRendererID()
+	c.vars = nil
+	return &c
+}
+
+// Close frees the mapnik maps. The renderer must not be used afterwards.
+func (t *TileRenderer) Close() {
+	t.mp.Free()
+	t.m.Free()
+	freeComposite(t.composite)
+}
+
 func (t *TileRenderer) ProcessRequest(request FetchRequest) {
+	release, err := t.acquireSlot(request)
+	if err != nil {
+		rejectRequest(request, err)
+		return
+	}
+	defer release()
+	processRequest(t, request)
+}
+
+// acquireSlot waits for a slot of the render limit for the request and
+// returns the function releasing it. Feature queries need no slot.
+func (t *TileRenderer) acquireSlot(request FetchRequest) (func(), error) {
+	if _, query := request.(FeatureInfoRequest); t.limiter == nil || query {
+		return func() {}, nil
+	}
+	sem, err := t.limiter.acquire()
+	if err != nil {
+		return nil, err
+	}
+	if sem == nil {
+		return func() {}, nil
+	}
+	return func() { <-sem }, nil
+}
+
+// Renderer is implemented by everything that can answer FetchRequests,
+// e.g. TileRenderer, MBTilesSource and maptilestest.StubRenderer.
+// RenderTile returns nil if the source does not have the tile.
+type Renderer interface {
+	RenderTile(c TileCoord) ([]byte, error)
+	RenderMetaTile(c MetaTileCoord) ([]TileFetchResult, error)
+}
+
+var (
+	_ Renderer = (*TileRenderer)(nil)
+	_ Renderer = (*MBTilesSource)(nil)
+	_ Renderer = DebugRenderer{}
+	_ Renderer = (*DEMRenderer)(nil)
+)
+
+// closeRenderer calls the Close method of the renderer, if it has one.
+func closeRenderer(r Renderer) {
+	switch c := r.(type) {
+	case interface{ Close() }:
+		c.Close()
+	case interface{ Close() error }:
+		if err := c.Close(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func processRequest(t Renderer, request FetchRequest) {
+	switch r := request.(type) {
+	case StaticMapRequest:
+		processStaticMap(t, r)
+		return
+	case FeatureInfoRequest:
+		processFeatureInfo(t, r)
+		return
+	}
 	if request.IsMetaTile() {
-		t.processRequestMeta(request.GetMetaCoord(), request.GetOutChan())
+		processRequestMeta(t, request.GetMetaCoord(), request.GetOutChan(), request.GetRequestID())
 	} else {
-		t.processRequestTile(request.GetCoord(), request.GetOutChan())
+		processRequestTile(t, request.GetCoord(), request.GetOutChan(), request.GetRequestID())
 	}
 }
 
-func (t *TileRenderer) processRequestTile(coord TileCoord, outchan chan<- TileFetchResult) {
-	result := TileFetchResult{coord, nil, nil}
+// requestRenderer is implemented by renderers passing the ID of the
+// request on, like RemoteRenderer.
+type requestRenderer interface {
+	renderTileRequest(c TileCoord, requestID string) ([]byte, error)
+	renderMetaTileRequest(c MetaTileCoord, requestID string) ([]TileFetchResult, error)
+}
+
+func processRequestTile(t Renderer, coord TileCoord, outchan chan<- TileFetchResult, requestID string) {
+	result := TileFetchResult{Coord: coord}
 	var err error
-	result.BlobPNG, err = t.RenderTile(coord)
+	start := time.Now()
+	if rr, ok := t.(requestRenderer); ok && requestID != "" {
+		result.BlobPNG, err = rr.renderTileRequest(coord, requestID)
+	} else {
+		result.BlobPNG, err = t.RenderTile(coord)
+	}
+	result.Stats = renderStats(t, start)
 	if err != nil {
-		log.Println("Error while rendering", coord, ":", err.Error())
+		log.Println(requestTag(requestID)+"Error while rendering", coord, ":", err.Error())
 		result.BlobPNG = nil
 		result.Error = err
 	}
 	outchan <- result
 }
 
-func (t *TileRenderer) processRequestMeta(coord MetaTileCoord, outchan chan<- TileFetchResult) {
+func processRequestMeta(t Renderer, coord MetaTileCoord, outchan chan<- TileFetchResult, requestID string) {
 	resultCount := coord.Count()
-	results, err := t.RenderMetaTile(coord)
+	start := time.Now()
+	var results []TileFetchResult
+	var err error
+	if rr, ok := t.(requestRenderer); ok && requestID != "" {
+		results, err = rr.renderMetaTileRequest(coord, requestID)
+	} else {
+		results, err = t.RenderMetaTile(coord)
+	}
+	stats := renderStats(t, start)
 	if err != nil {
+		log.Println(requestTag(requestID)+"Error while rendering", coord, ":", err.Error())
 		// global error, replicate it resultCount times, since receiver expects resultCount results
-		xSize := coord.XSize()
-		ySize := coord.YSize()
-		for x := 0; x < int(xSize); x++ {
-			for y := 0; y < int(ySize); y++ {
-				outchan <- TileFetchResult{
-					Coord: TileCoord{
-						X: coord.MinX + uint64(x),
-						Y: coord.MinY + uint64(y),
-						Zoom: coord.Zoom,
-						Tms: coord.Tms,
-						Layer: coord.Layer,
-					},
-					BlobPNG: nil,
-					Error: err,
-				}
+		for _, c := range coord.TileCoords() {
+			outchan <- TileFetchResult{
+				Coord:   c,
+				BlobPNG: nil,
+				Error:   err,
 			}
 		}
 		return
@@ -223,26 +295,221 @@ func (t *TileRenderer) processRequestMeta(coord MetaTileCoord, outchan chan<- Ti
 		panic(fmt.Errorf("metatile rendering result count mismatch: %v != expected %v", len(results), resultCount))
 	}
 	for _, result := range results {
+		if result.Stats == nil {
+			result.Stats = stats
+		}
 		outchan <- result
 	}
 }
 
-func NewTileRenderer(stylesheet string) *TileRenderer {
+// RendererConfig configures a TileRenderer.
+type RendererConfig struct {
+	// Stylesheet is the path of the mapnik XML file.
+	Stylesheet string
+
+	// Params are substituted for ${NAME} placeholders in the datasource
+	// parameters of the stylesheet, e.g. passwords. Placeholders missing
+	// from Params are taken from the environment.
+	Params map[string]string
+
+	// BufferSize is the number of pixels rendered around tiles and
+	// metatiles, so labels and symbols crossing the edges are placed
+	// consistently. If zero, 128 will be used.
+	BufferSize uint64
+
+	// Grid is the tile grid to render. If nil, Web Mercator tiles are
+	// rendered in the projection of the stylesheet. Tiles of grids other
+	// than tilegrid.Geodetic must be requested with XYZ coordinates.
+	Grid *tilegrid.Grid
+
+	// Watermark is stamped onto every tile if not nil.
+	Watermark *Watermark
+
+	// DisableLayers are mapnik layers of the stylesheet that are not
+	// rendered, so one stylesheet can serve several variants of a map,
+	// e.g. with and without labels.
+	DisableLayers []string
+
+	// DatasourceParams overrides datasource parameters of mapnik layers,
+	// keyed by layer name, e.g. to serve a tenant specific table or to
+	// filter a PostGIS query by date with one stylesheet.
+	DatasourceParams map[string]map[string]string
+
+	// Background replaces the background color of the stylesheet, e.g.
+	// color.Transparent for overlay layers. If nil, the stylesheet's
+	// background is used.
+	Background color.Color
+
+	// PNG configures the encoding of rendered tiles.
+	PNG PNGOptions
+
+	// Composite are stylesheets rendered in parallel with Stylesheet and
+	// composited onto it in order.
+	Composite []CompositeStylesheet
+}
+
+// NewTileRenderer creates a renderer for the stylesheet. It returns an error
+// if the stylesheet cannot be loaded.
+func NewTileRenderer(stylesheet string) (*TileRenderer, error) {
+	return NewTileRendererFromConfig(RendererConfig{Stylesheet: stylesheet})
+}
+
+func NewTileRendererFromConfig(cfg RendererConfig) (*TileRenderer, error) {
+	if err := cfg.PNG.validate(); err != nil {
+		return nil, err
+	}
 	t := new(TileRenderer)
+	t.id = newRendererID()
+	t.pngFormat = cfg.PNG.format()
+	t.m = mapnik.NewMap(256, 256)
+	if err := loadStylesheet(t.m, cfg.Stylesheet, cfg.Params); err != nil {
+		t.m.Free()
+		return nil, fmt.Errorf("loading stylesheet %v: %v", cfg.Stylesheet, err)
+	}
+	for _, name := range cfg.DisableLayers {
+		if err := t.m.SetLayerActive(name, false); err != nil {
+			t.m.Free()
+			return nil, fmt.Errorf("%v: %v", cfg.Stylesheet, err)
+		}
+	}
+	if cfg.Background != nil {
+		t.m.SetBackground(cfg.Background)
+	}
+	for layer, params := range cfg.DatasourceParams {
+		if err := t.m.SetDatasourceParams(layer, params); err != nil {
+			t.m.Free()
+			return nil, fmt.Errorf("%v: layer %v: %v", cfg.Stylesheet, layer, err)
+		}
+	}
+	if cfg.Grid != nil {
+		t.m.SetSRS(cfg.Grid.SRS)
+		t.grid = cfg.Grid
+	}
+	if cfg.Watermark != nil {
+		wm, err := newStamp(*cfg.Watermark)
+		if err != nil {
+			t.m.Free()
+			return nil, err
+		}
+		t.watermark = wm
+	}
+	bg, ok := t.m.Background()
+	t.transparent = !ok || bg.A == 0
+	if len(cfg.Composite) > 0 {
+		composite, err := loadComposite(cfg.Composite, t.m.SRS())
+		if err != nil {
+			t.m.Free()
+			return nil, err
+		}
+		t.composite = composite
+		for _, c := range composite {
+			if bg, ok := c.m.Background(); ok && bg.A != 0 {
+				t.transparent = false
+			}
+		}
+	}
+	t.mp = t.m.NewProjection()
+	t.bufferSize = cfg.BufferSize
+	if t.bufferSize == 0 {
+		t.bufferSize = 128
+	}
+
+	return t, nil
+}
+
+func (t *TileRenderer) RenderTile(c TileCoord) ([]byte, error) {
+	c.SetTMS(false)
+	restore, err := t.selectLayers(c.MapLayers)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+	if err := t.setVariables(c.Variables); err != nil {
+		return nil, err
+	}
+	defer t.setVariables("")
+	return t.renderTile(c.Zoom, c.X, c.Y, c.Format)
+}
+
+// setVariables sets the stylesheet variables for the next render.
+func (t *TileRenderer) setVariables(encoded string) error {
+	t.vars = nil
+	if encoded == "" {
+		return nil
+	}
+	v, err := url.ParseQuery(encoded)
+	if err != nil {
+		return fmt.Errorf("invalid variables: %v", err)
+	}
+	t.vars = make(map[string]string, len(v))
+	for name := range v {
+		t.vars[name] = v.Get(name)
+	}
+	return nil
+}
+
+// render renders the map at its current extent and encodes it in format,
+// or as PNG with the PNGOptions of the renderer. If nothing was painted on
+// a transparent map and no watermark has to be stamped, it returns no
+// image and reports that the result is empty, so the caller can use
+// shared blank tiles instead of encoding the same empty PNG again.
+func (t *TileRenderer) render(format string) ([]byte, bool, error) {
+	var img *mapnik.Image
 	var err error
+	if len(t.composite) > 0 {
+		img, err = t.renderComposite()
+	} else {
+		img, err = t.renderImage(t.m)
+	}
 	if err != nil {
-		log.Fatal(err)
+		return nil, false, err
 	}
-	t.m = mapnik.NewMap(256, 256)
-	t.m.Load(stylesheet)
-	t.mp = t.m.Projection()
+	defer img.Free()
+	if t.transparent && t.watermark == nil && !img.Painted() {
+		return nil, true, nil
+	}
+	mf := t.pngFormat
+	if format != "" {
+		mf = mapnikFormats[format]
+	}
+	blob, err := img.Encode(mf)
+	return blob, false, err
+}
 
-	return t
+// renderImage renders m with the stylesheet variables of the request.
+func (t *TileRenderer) renderImage(m *mapnik.Map) (*mapnik.Image, error) {
+	if len(t.vars) > 0 {
+		return m.RenderImageWithVariables(t.vars)
+	}
+	return m.RenderImage()
 }
 
-func (t *TileRenderer) RenderTile(c TileCoord) ([]byte, error) {
-	c.setTMS(false)
-	return t.RenderTileZXY(c.Zoom, c.X, c.Y)
+// selectLayers activates only the comma separated mapnik layers for the
+// next render. The returned function restores the previous state.
+func (t *TileRenderer) selectLayers(names string) (func(), error) {
+	if names == "" {
+		return func() {}, nil
+	}
+	layers := t.m.Layers()
+	known := make(map[string]bool)
+	for _, l := range layers {
+		known[l.Name] = true
+	}
+	selected := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		if !known[name] {
+			return nil, fmt.Errorf("no mapnik layer %v", name)
+		}
+		selected[name] = true
+	}
+	for _, l := range layers {
+		t.m.SetLayerActive(l.Name, selected[l.Name])
+	}
+	return func() {
+		for _, l := range layers {
+			t.m.SetLayerActive(l.Name, l.Active)
+		}
+	}, nil
 }
 
 type SubImager interface {
@@ -251,34 +518,70 @@ type SubImager interface {
 
 // RenderMetaTile renders multiple tiles as a single tile, then slices them up.
 func (t *TileRenderer) RenderMetaTile(c MetaTileCoord) ([]TileFetchResult, error) {
-	c.setTMS(false)
+	c.SetTMS(false)
 	if c.MaxX < c.MinX || c.MaxY < c.MinY {
 		return nil, fmt.Errorf("Invalid metatile coordinates")
 	}
 	xSize := c.XSize()
 	ySize := c.YSize()
 
-	xTileSize := 256
-	yTileSize := 256
+	restore, err := t.selectLayers(c.MapLayers)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+	if err := t.setVariables(c.Variables); err != nil {
+		return nil, err
+	}
+	defer t.setVariables("")
+
+	xTileSize := int(t.tileSize())
+	yTileSize := int(t.tileSize())
 
-	blob, err := t.renderTileInternal(c.Zoom, c.MinX, c.MinY, uint64(xTileSize), uint64(yTileSize), xSize, ySize, 128)
+	// single tiles without watermark are encoded in their format by mapnik,
+	// the others are decoded to be cut or stamped
+	format := ""
+	if xSize == 1 && ySize == 1 && t.watermark == nil {
+		format = c.Format
+	}
+	blob, empty, err := t.renderTileInternal(c.Zoom, c.MinX, c.MinY, uint64(xTileSize), uint64(yTileSize), xSize, ySize, t.bufferSize, format)
 	if err != nil {
 		return nil, err
 	}
 
 	results := make([]TileFetchResult, 0, xSize * ySize)
 
+	if empty {
+		tile := blankTile(t.tileSize())
+		for _, tc := range c.TileCoords() {
+			results = append(results, TileFetchResult{Coord: tc, BlobPNG: tile})
+		}
+		return results, nil
+	}
+
 	if xSize == 1 && ySize == 1 {
+		if t.watermark != nil {
+			img, err := t.watermark.applyPNG(blob)
+			if err != nil {
+				return nil, err
+			}
+			if blob, err = t.encode(img, c.Format); err != nil {
+				return nil, err
+			}
+		}
 		results = append(results, TileFetchResult{
 			Coord: TileCoord{
-				X: c.MinX,
-				Y: c.MinY,
-				Zoom: c.Zoom,
-				Tms: c.Tms,
-				Layer: c.Layer,
+				X:         c.MinX,
+				Y:         c.MinY,
+				Zoom:      c.Zoom,
+				Tms:       c.Tms,
+				Layer:     c.Layer,
+				MapLayers: c.MapLayers,
+				Variables: c.Variables,
+				Format:    c.Format,
 			},
 			BlobPNG: blob,
-			Error: nil,
+			Error:   nil,
 		})
 		return results, nil
 	}
@@ -316,20 +619,24 @@ func (t *TileRenderer) RenderMetaTile(c MetaTileCoord) ([]TileFetchResult, error
 				},
 			})
 
-			var buf bytes.Buffer
-
-			err := png.Encode(&buf, subimg)
+			if t.watermark != nil {
+				subimg = t.watermark.apply(subimg)
+			}
+			tile, err := t.encode(subimg, c.Format)
 
 			results = append(results, TileFetchResult{
 				Coord: TileCoord{
-					X: c.MinX + uint64(x),
-					Y: c.MinY + uint64(y),
-					Zoom: c.Zoom,
-					Tms: c.Tms,
-					Layer: c.Layer,
+					X:         c.MinX + uint64(x),
+					Y:         c.MinY + uint64(y),
+					Zoom:      c.Zoom,
+					Tms:       c.Tms,
+					Layer:     c.Layer,
+					MapLayers: c.MapLayers,
+					Variables: c.Variables,
+					Format:    c.Format,
 				},
-				BlobPNG: buf.Bytes(),
-				Error: err,
+				BlobPNG: tile,
+				Error:   err,
 			})
 		}
 	}
@@ -337,14 +644,41 @@ func (t *TileRenderer) RenderMetaTile(c MetaTileCoord) ([]TileFetchResult, error
 	return results, nil
 }
 
-func (t *TileRenderer) renderTileInternal(zoom, x, y, xTileSize, yTileSize, xMetaTile, yMetaTile, bufferSize uint64) ([]byte, error) {
+func (t *TileRenderer) tileSize() uint64 {
+	if t.grid != nil {
+		return t.grid.TilePixels()
+	}
+	return 256
+}
+
+func (t *TileRenderer) renderTileInternal(zoom, x, y, xTileSize, yTileSize, xMetaTile, yMetaTile, bufferSize uint64, format string) ([]byte, bool, error) {
+	if err := t.zoomToTiles(zoom, x, y, xTileSize, yTileSize, xMetaTile, yMetaTile); err != nil {
+		return nil, false, err
+	}
+	t.m.SetBufferSize(int(bufferSize))
+	return t.render(format)
+}
+
+// zoomToTiles sets the size and extent of the map to the xMetaTile×yMetaTile
+// tiles starting at x, y.
+func (t *TileRenderer) zoomToTiles(zoom, x, y, xTileSize, yTileSize, xMetaTile, yMetaTile uint64) error {
+	if t.grid != nil {
+		if zoom > t.grid.MaxZoom() {
+			return fmt.Errorf("zoom level %v is not part of the grid", zoom)
+		}
+		e := t.grid.TileExtent(zoom, x, y, x+xMetaTile-1, y+yMetaTile-1)
+		t.m.Resize(uint32(xTileSize*xMetaTile), uint32(yTileSize*yMetaTile))
+		t.m.ZoomToMinMax(e[0], e[1], e[2], e[3])
+		return nil
+	}
+
 	// Calculate pixel positions of bottom left & top right
 	p0 := [2]float64{float64(x) * float64(xTileSize), (float64(y) + float64(yMetaTile)) * float64(yTileSize)}
 	p1 := [2]float64{(float64(x) + float64(xMetaTile)) * float64(xTileSize), float64(y) * float64(yTileSize)}
 
 	// Convert to LatLong(EPSG:4326)
-	l0 := fromPixelToLL(p0, zoom)
-	l1 := fromPixelToLL(p1, zoom)
+	l0 := tilegrid.FromPixelToLL(p0, zoom)
+	l1 := tilegrid.FromPixelToLL(p1, zoom)
 
 	// Convert to map projection (e.g. mercartor co-ords EPSG:3857)
 	c0 := t.mp.Forward(mapnik.Coord{X: l0[0], Y: l0[1]})
@@ -353,10 +687,7 @@ func (t *TileRenderer) renderTileInternal(zoom, x, y, xTileSize, yTileSize, xMet
 	// Bounding box for the Tile
 	t.m.Resize(uint32(xTileSize * xMetaTile), uint32(yTileSize * yMetaTile))
 	t.m.ZoomToMinMax(c0.X, c0.Y, c1.X, c1.Y)
-	t.m.SetBufferSize(int(bufferSize))
-
-	blob, err := t.m.RenderToMemoryPng()
-	return blob, err
+	return nil
 }
 
 // Render a tile with coordinates in Google tile format.
@@ -365,5 +696,38 @@ func (t *TileRenderer) renderTileInternal(zoom, x, y, xTileSize, yTileSize, xMet
 // threads or setup multiple goroutinesand communicate with channels,
 // see NewTileRendererChan.
 func (t *TileRenderer) RenderTileZXY(zoom, x, y uint64) ([]byte, error) {
-	return t.renderTileInternal(zoom, x, y, 256, 256, 1, 1, 128)
+	return t.renderTile(zoom, x, y, "")
+}
+
+// renderTile renders a tile in format. Only tiles stamped with the
+// watermark are decoded, the others are encoded by mapnik directly. Blank
+// tiles stay PNG, so transparent areas stay transparent in formats without
+// alpha channel.
+func (t *TileRenderer) renderTile(zoom, x, y uint64, format string) ([]byte, error) {
+	size := t.tileSize()
+	renderFormat := format
+	if t.watermark != nil {
+		renderFormat = ""
+	}
+	blob, empty, err := t.renderTileInternal(zoom, x, y, size, size, 1, 1, t.bufferSize, renderFormat)
+	if empty {
+		return blankTile(size), nil
+	}
+	if err != nil || t.watermark == nil {
+		return blob, err
+	}
+	img, err := t.watermark.applyPNG(blob)
+	if err != nil {
+		return nil, err
+	}
+	return t.encode(img, format)
+}
+
+// encode encodes a tile cut from a metatile or stamped with the watermark
+// in format, or as PNG with the PNGOptions of the renderer.
+func (t *TileRenderer) encode(img image.Image, format string) ([]byte, error) {
+	if format == "" {
+		return encodeMapnik(img, t.pngFormat)
+	}
+	return encodeImage(img, format)
 }
@@ -0,0 +1,100 @@
+package maptiles
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRenderBusy is the error of renders rejected by a RenderLimit.
+// TileServer answers them with 503 Service Unavailable.
+var ErrRenderBusy = errors.New("too many concurrent renders")
+
+// RenderLimit caps the number of mapnik renders running at the same time
+// across all layers of a LayerMultiplex, independent of the number of
+// renderers per layer, so memory usage stays bounded with many layers.
+type RenderLimit struct {
+	// MaxConcurrent is the number of renders. Zero disables the limit.
+	MaxConcurrent int
+
+	// Reject fails renders over the limit with ErrRenderBusy instead of
+	// queueing them.
+	Reject bool
+
+	// MaxWait is the time a queued render waits for its turn before it
+	// fails with ErrRenderBusy. Zero waits indefinitely.
+	MaxWait time.Duration
+}
+
+// renderLimiter is shared by the renderers of a LayerMultiplex.
+type renderLimiter struct {
+	mu    sync.RWMutex
+	limit RenderLimit
+	sem   chan struct{}
+}
+
+func (l *renderLimiter) set(limit RenderLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.sem = nil
+	if limit.MaxConcurrent > 0 {
+		l.sem = make(chan struct{}, limit.MaxConcurrent)
+	}
+}
+
+// acquire waits for a free slot and returns the semaphore to release it
+// to, or nil without a limit.
+func (l *renderLimiter) acquire() (chan struct{}, error) {
+	l.mu.RLock()
+	sem, limit := l.sem, l.limit
+	l.mu.RUnlock()
+	if sem == nil {
+		return nil, nil
+	}
+	if limit.Reject {
+		select {
+		case sem <- struct{}{}:
+			return sem, nil
+		default:
+			return nil, ErrRenderBusy
+		}
+	}
+	if limit.MaxWait == 0 {
+		sem <- struct{}{}
+		return sem, nil
+	}
+	timer := time.NewTimer(limit.MaxWait)
+	defer timer.Stop()
+	select {
+	case sem <- struct{}{}:
+		return sem, nil
+	case <-timer.C:
+		return nil, ErrRenderBusy
+	}
+}
+
+// SetRenderLimit caps the number of concurrent renders of the mapnik
+// renderers created by the multiplex, including those created before.
+func (l *LayerMultiplex) SetRenderLimit(limit RenderLimit) {
+	l.limiter.set(limit)
+}
+
+// rejectRequest answers a request with err.
+func rejectRequest(request FetchRequest, err error) {
+	switch r := request.(type) {
+	case StaticMapRequest:
+		r.OutChan <- TileFetchResult{Coord: r.GetCoord(), Error: err}
+	case FeatureInfoRequest:
+		r.OutChan <- FeatureInfoResult{Error: err}
+	default:
+		if request.IsMetaTile() {
+			mc := request.GetMetaCoord()
+			for _, c := range mc.TileCoords() {
+				request.GetOutChan() <- TileFetchResult{Coord: c, Error: err}
+			}
+		} else {
+			request.GetOutChan() <- TileFetchResult{Coord: request.GetCoord(), Error: err}
+		}
+	}
+}
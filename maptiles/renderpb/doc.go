@@ -0,0 +1,4 @@
+// Package renderpb contains the messages and the gRPC service of
+// render.proto, generated with protoc-gen-go and protoc-gen-go-grpc. See
+// maptiles.RenderService and maptiles.RemoteRenderer.
+package renderpb
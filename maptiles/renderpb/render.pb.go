@@ -0,0 +1,714 @@
+// The render service of maptiles.RenderService, to deploy rendering
+// capacity separately from the tile servers.
+//
+// Regenerate the Go code with
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//		--go-grpc_out=. --go-grpc_opt=paths=source_relative render.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: render.proto
+
+package renderpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type TileCoord struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	X         uint64 `protobuf:"varint,1,opt,name=x,proto3" json:"x,omitempty"`
+	Y         uint64 `protobuf:"varint,2,opt,name=y,proto3" json:"y,omitempty"`
+	Zoom      uint64 `protobuf:"varint,3,opt,name=zoom,proto3" json:"zoom,omitempty"`
+	Tms       bool   `protobuf:"varint,4,opt,name=tms,proto3" json:"tms,omitempty"`
+	Layer     string `protobuf:"bytes,5,opt,name=layer,proto3" json:"layer,omitempty"`
+	MapLayers string `protobuf:"bytes,6,opt,name=map_layers,json=mapLayers,proto3" json:"map_layers,omitempty"`
+	Variables string `protobuf:"bytes,7,opt,name=variables,proto3" json:"variables,omitempty"`
+	Format    string `protobuf:"bytes,8,opt,name=format,proto3" json:"format,omitempty"`
+}
+
+func (x *TileCoord) Reset() {
+	*x = TileCoord{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_render_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TileCoord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TileCoord) ProtoMessage() {}
+
+func (x *TileCoord) ProtoReflect() protoreflect.Message {
+	mi := &file_render_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TileCoord.ProtoReflect.Descriptor instead.
+func (*TileCoord) Descriptor() ([]byte, []int) {
+	return file_render_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *TileCoord) GetX() uint64 {
+	if x != nil {
+		return x.X
+	}
+	return 0
+}
+
+func (x *TileCoord) GetY() uint64 {
+	if x != nil {
+		return x.Y
+	}
+	return 0
+}
+
+func (x *TileCoord) GetZoom() uint64 {
+	if x != nil {
+		return x.Zoom
+	}
+	return 0
+}
+
+func (x *TileCoord) GetTms() bool {
+	if x != nil {
+		return x.Tms
+	}
+	return false
+}
+
+func (x *TileCoord) GetLayer() string {
+	if x != nil {
+		return x.Layer
+	}
+	return ""
+}
+
+func (x *TileCoord) GetMapLayers() string {
+	if x != nil {
+		return x.MapLayers
+	}
+	return ""
+}
+
+func (x *TileCoord) GetVariables() string {
+	if x != nil {
+		return x.Variables
+	}
+	return ""
+}
+
+func (x *TileCoord) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+type MetaTileCoord struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	MinX      uint64 `protobuf:"varint,1,opt,name=min_x,json=minX,proto3" json:"min_x,omitempty"`
+	MinY      uint64 `protobuf:"varint,2,opt,name=min_y,json=minY,proto3" json:"min_y,omitempty"`
+	MaxX      uint64 `protobuf:"varint,3,opt,name=max_x,json=maxX,proto3" json:"max_x,omitempty"`
+	MaxY      uint64 `protobuf:"varint,4,opt,name=max_y,json=maxY,proto3" json:"max_y,omitempty"`
+	Zoom      uint64 `protobuf:"varint,5,opt,name=zoom,proto3" json:"zoom,omitempty"`
+	Tms       bool   `protobuf:"varint,6,opt,name=tms,proto3" json:"tms,omitempty"`
+	Layer     string `protobuf:"bytes,7,opt,name=layer,proto3" json:"layer,omitempty"`
+	MapLayers string `protobuf:"bytes,8,opt,name=map_layers,json=mapLayers,proto3" json:"map_layers,omitempty"`
+	Variables string `protobuf:"bytes,9,opt,name=variables,proto3" json:"variables,omitempty"`
+	Format    string `protobuf:"bytes,10,opt,name=format,proto3" json:"format,omitempty"`
+}
+
+func (x *MetaTileCoord) Reset() {
+	*x = MetaTileCoord{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_render_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MetaTileCoord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetaTileCoord) ProtoMessage() {}
+
+func (x *MetaTileCoord) ProtoReflect() protoreflect.Message {
+	mi := &file_render_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetaTileCoord.ProtoReflect.Descriptor instead.
+func (*MetaTileCoord) Descriptor() ([]byte, []int) {
+	return file_render_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *MetaTileCoord) GetMinX() uint64 {
+	if x != nil {
+		return x.MinX
+	}
+	return 0
+}
+
+func (x *MetaTileCoord) GetMinY() uint64 {
+	if x != nil {
+		return x.MinY
+	}
+	return 0
+}
+
+func (x *MetaTileCoord) GetMaxX() uint64 {
+	if x != nil {
+		return x.MaxX
+	}
+	return 0
+}
+
+func (x *MetaTileCoord) GetMaxY() uint64 {
+	if x != nil {
+		return x.MaxY
+	}
+	return 0
+}
+
+func (x *MetaTileCoord) GetZoom() uint64 {
+	if x != nil {
+		return x.Zoom
+	}
+	return 0
+}
+
+func (x *MetaTileCoord) GetTms() bool {
+	if x != nil {
+		return x.Tms
+	}
+	return false
+}
+
+func (x *MetaTileCoord) GetLayer() string {
+	if x != nil {
+		return x.Layer
+	}
+	return ""
+}
+
+func (x *MetaTileCoord) GetMapLayers() string {
+	if x != nil {
+		return x.MapLayers
+	}
+	return ""
+}
+
+func (x *MetaTileCoord) GetVariables() string {
+	if x != nil {
+		return x.Variables
+	}
+	return ""
+}
+
+func (x *MetaTileCoord) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+type Tile struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Coord *TileCoord `protobuf:"bytes,1,opt,name=coord,proto3" json:"coord,omitempty"`
+	Png   []byte     `protobuf:"bytes,2,opt,name=png,proto3" json:"png,omitempty"`
+	// error is set if the tile could not be rendered.
+	Error      string `protobuf:"bytes,3,opt,name=error,proto3" json:"error,omitempty"`
+	DurationNs int64  `protobuf:"varint,4,opt,name=duration_ns,json=durationNs,proto3" json:"duration_ns,omitempty"`
+	Renderer   string `protobuf:"bytes,5,opt,name=renderer,proto3" json:"renderer,omitempty"`
+}
+
+func (x *Tile) Reset() {
+	*x = Tile{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_render_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Tile) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Tile) ProtoMessage() {}
+
+func (x *Tile) ProtoReflect() protoreflect.Message {
+	mi := &file_render_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Tile.ProtoReflect.Descriptor instead.
+func (*Tile) Descriptor() ([]byte, []int) {
+	return file_render_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Tile) GetCoord() *TileCoord {
+	if x != nil {
+		return x.Coord
+	}
+	return nil
+}
+
+func (x *Tile) GetPng() []byte {
+	if x != nil {
+		return x.Png
+	}
+	return nil
+}
+
+func (x *Tile) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+func (x *Tile) GetDurationNs() int64 {
+	if x != nil {
+		return x.DurationNs
+	}
+	return 0
+}
+
+func (x *Tile) GetRenderer() string {
+	if x != nil {
+		return x.Renderer
+	}
+	return ""
+}
+
+type MetaTile struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Tiles []*Tile `protobuf:"bytes,1,rep,name=tiles,proto3" json:"tiles,omitempty"`
+}
+
+func (x *MetaTile) Reset() {
+	*x = MetaTile{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_render_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MetaTile) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MetaTile) ProtoMessage() {}
+
+func (x *MetaTile) ProtoReflect() protoreflect.Message {
+	mi := &file_render_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MetaTile.ProtoReflect.Descriptor instead.
+func (*MetaTile) Descriptor() ([]byte, []int) {
+	return file_render_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *MetaTile) GetTiles() []*Tile {
+	if x != nil {
+		return x.Tiles
+	}
+	return nil
+}
+
+type StaticMap struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Layer string `protobuf:"bytes,1,opt,name=layer,proto3" json:"layer,omitempty"`
+	// bbox is the WGS84 extent as minlon, minlat, maxlon, maxlat.
+	Bbox   []float64 `protobuf:"fixed64,2,rep,packed,name=bbox,proto3" json:"bbox,omitempty"`
+	Width  uint32    `protobuf:"varint,3,opt,name=width,proto3" json:"width,omitempty"`
+	Height uint32    `protobuf:"varint,4,opt,name=height,proto3" json:"height,omitempty"`
+	// format is png, svg, pdf or geotiff. If empty, png is used.
+	Format string `protobuf:"bytes,5,opt,name=format,proto3" json:"format,omitempty"`
+}
+
+func (x *StaticMap) Reset() {
+	*x = StaticMap{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_render_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StaticMap) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StaticMap) ProtoMessage() {}
+
+func (x *StaticMap) ProtoReflect() protoreflect.Message {
+	mi := &file_render_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StaticMap.ProtoReflect.Descriptor instead.
+func (*StaticMap) Descriptor() ([]byte, []int) {
+	return file_render_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *StaticMap) GetLayer() string {
+	if x != nil {
+		return x.Layer
+	}
+	return ""
+}
+
+func (x *StaticMap) GetBbox() []float64 {
+	if x != nil {
+		return x.Bbox
+	}
+	return nil
+}
+
+func (x *StaticMap) GetWidth() uint32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *StaticMap) GetHeight() uint32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *StaticMap) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+type StaticImage struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Image []byte `protobuf:"bytes,1,opt,name=image,proto3" json:"image,omitempty"`
+}
+
+func (x *StaticImage) Reset() {
+	*x = StaticImage{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_render_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StaticImage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StaticImage) ProtoMessage() {}
+
+func (x *StaticImage) ProtoReflect() protoreflect.Message {
+	mi := &file_render_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StaticImage.ProtoReflect.Descriptor instead.
+func (*StaticImage) Descriptor() ([]byte, []int) {
+	return file_render_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *StaticImage) GetImage() []byte {
+	if x != nil {
+		return x.Image
+	}
+	return nil
+}
+
+var File_render_proto protoreflect.FileDescriptor
+
+var file_render_proto_rawDesc = []byte{
+	0x0a, 0x0c, 0x72, 0x65, 0x6e, 0x64, 0x65, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x08,
+	0x6d, 0x61, 0x70, 0x74, 0x69, 0x6c, 0x65, 0x73, 0x22, 0xb8, 0x01, 0x0a, 0x09, 0x54, 0x69, 0x6c,
+	0x65, 0x43, 0x6f, 0x6f, 0x72, 0x64, 0x12, 0x0c, 0x0a, 0x01, 0x78, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x04, 0x52, 0x01, 0x78, 0x12, 0x0c, 0x0a, 0x01, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x01, 0x79, 0x12, 0x12, 0x0a, 0x04, 0x7a, 0x6f, 0x6f, 0x6d, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04,
+	0x52, 0x04, 0x7a, 0x6f, 0x6f, 0x6d, 0x12, 0x10, 0x0a, 0x03, 0x74, 0x6d, 0x73, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x08, 0x52, 0x03, 0x74, 0x6d, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x6c, 0x61, 0x79, 0x65,
+	0x72, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x6c, 0x61, 0x79, 0x65, 0x72, 0x12, 0x1d,
+	0x0a, 0x0a, 0x6d, 0x61, 0x70, 0x5f, 0x6c, 0x61, 0x79, 0x65, 0x72, 0x73, 0x18, 0x06, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x09, 0x6d, 0x61, 0x70, 0x4c, 0x61, 0x79, 0x65, 0x72, 0x73, 0x12, 0x1c, 0x0a,
+	0x09, 0x76, 0x61, 0x72, 0x69, 0x61, 0x62, 0x6c, 0x65, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x09, 0x76, 0x61, 0x72, 0x69, 0x61, 0x62, 0x6c, 0x65, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x66,
+	0x6f, 0x72, 0x6d, 0x61, 0x74, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x66, 0x6f, 0x72,
+	0x6d, 0x61, 0x74, 0x22, 0xf4, 0x01, 0x0a, 0x0d, 0x4d, 0x65, 0x74, 0x61, 0x54, 0x69, 0x6c, 0x65,
+	0x43, 0x6f, 0x6f, 0x72, 0x64, 0x12, 0x13, 0x0a, 0x05, 0x6d, 0x69, 0x6e, 0x5f, 0x78, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x6d, 0x69, 0x6e, 0x58, 0x12, 0x13, 0x0a, 0x05, 0x6d, 0x69,
+	0x6e, 0x5f, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x6d, 0x69, 0x6e, 0x59, 0x12,
+	0x13, 0x0a, 0x05, 0x6d, 0x61, 0x78, 0x5f, 0x78, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04,
+	0x6d, 0x61, 0x78, 0x58, 0x12, 0x13, 0x0a, 0x05, 0x6d, 0x61, 0x78, 0x5f, 0x79, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x04, 0x52, 0x04, 0x6d, 0x61, 0x78, 0x59, 0x12, 0x12, 0x0a, 0x04, 0x7a, 0x6f, 0x6f,
+	0x6d, 0x18, 0x05, 0x20, 0x01, 0x28, 0x04, 0x52, 0x04, 0x7a, 0x6f, 0x6f, 0x6d, 0x12, 0x10, 0x0a,
+	0x03, 0x74, 0x6d, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x74, 0x6d, 0x73, 0x12,
+	0x14, 0x0a, 0x05, 0x6c, 0x61, 0x79, 0x65, 0x72, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05,
+	0x6c, 0x61, 0x79, 0x65, 0x72, 0x12, 0x1d, 0x0a, 0x0a, 0x6d, 0x61, 0x70, 0x5f, 0x6c, 0x61, 0x79,
+	0x65, 0x72, 0x73, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x6d, 0x61, 0x70, 0x4c, 0x61,
+	0x79, 0x65, 0x72, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x76, 0x61, 0x72, 0x69, 0x61, 0x62, 0x6c, 0x65,
+	0x73, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x76, 0x61, 0x72, 0x69, 0x61, 0x62, 0x6c,
+	0x65, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x18, 0x0a, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x06, 0x66, 0x6f, 0x72, 0x6d, 0x61, 0x74, 0x22, 0x96, 0x01, 0x0a, 0x04, 0x54,
+	0x69, 0x6c, 0x65, 0x12, 0x29, 0x0a, 0x05, 0x63, 0x6f, 0x6f, 0x72, 0x64, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x13, 0x2e, 0x6d, 0x61, 0x70, 0x74, 0x69, 0x6c, 0x65, 0x73, 0x2e, 0x54, 0x69,
+	0x6c, 0x65, 0x43, 0x6f, 0x6f, 0x72, 0x64, 0x52, 0x05, 0x63, 0x6f, 0x6f, 0x72, 0x64, 0x12, 0x10,
+	0x0a, 0x03, 0x70, 0x6e, 0x67, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03, 0x70, 0x6e, 0x67,
+	0x12, 0x14, 0x0a, 0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x05, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x12, 0x1f, 0x0a, 0x0b, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x5f, 0x6e, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0a, 0x64, 0x75, 0x72,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x4e, 0x73, 0x12, 0x1a, 0x0a, 0x08, 0x72, 0x65, 0x6e, 0x64, 0x65,
+	0x72, 0x65, 0x72, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x72, 0x65, 0x6e, 0x64, 0x65,
+	0x72, 0x65, 0x72, 0x22, 0x30, 0x0a, 0x08, 0x4d, 0x65, 0x74, 0x61, 0x54, 0x69, 0x6c, 0x65, 0x12,
+	0x24, 0x0a, 0x05, 0x74, 0x69, 0x6c, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0e,
+	0x2e, 0x6d, 0x61, 0x70, 0x74, 0x69, 0x6c, 0x65, 0x73, 0x2e, 0x54, 0x69, 0x6c, 0x65, 0x52, 0x05,
+	0x74, 0x69, 0x6c, 0x65, 0x73, 0x22, 0x7b, 0x0a, 0x09, 0x53, 0x74, 0x61, 0x74, 0x69, 0x63, 0x4d,
+	0x61, 0x70, 0x12, 0x14, 0x0a, 0x05, 0x6c, 0x61, 0x79, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x05, 0x6c, 0x61, 0x79, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x62, 0x62, 0x6f, 0x78,
+	0x18, 0x02, 0x20, 0x03, 0x28, 0x01, 0x52, 0x04, 0x62, 0x62, 0x6f, 0x78, 0x12, 0x14, 0x0a, 0x05,
+	0x77, 0x69, 0x64, 0x74, 0x68, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x77, 0x69, 0x64,
+	0x74, 0x68, 0x12, 0x16, 0x0a, 0x06, 0x68, 0x65, 0x69, 0x67, 0x68, 0x74, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x06, 0x68, 0x65, 0x69, 0x67, 0x68, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x66, 0x6f,
+	0x72, 0x6d, 0x61, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x66, 0x6f, 0x72, 0x6d,
+	0x61, 0x74, 0x22, 0x23, 0x0a, 0x0b, 0x53, 0x74, 0x61, 0x74, 0x69, 0x63, 0x49, 0x6d, 0x61, 0x67,
+	0x65, 0x12, 0x14, 0x0a, 0x05, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c,
+	0x52, 0x05, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x32, 0xb6, 0x01, 0x0a, 0x06, 0x52, 0x65, 0x6e, 0x64,
+	0x65, 0x72, 0x12, 0x31, 0x0a, 0x0a, 0x52, 0x65, 0x6e, 0x64, 0x65, 0x72, 0x54, 0x69, 0x6c, 0x65,
+	0x12, 0x13, 0x2e, 0x6d, 0x61, 0x70, 0x74, 0x69, 0x6c, 0x65, 0x73, 0x2e, 0x54, 0x69, 0x6c, 0x65,
+	0x43, 0x6f, 0x6f, 0x72, 0x64, 0x1a, 0x0e, 0x2e, 0x6d, 0x61, 0x70, 0x74, 0x69, 0x6c, 0x65, 0x73,
+	0x2e, 0x54, 0x69, 0x6c, 0x65, 0x12, 0x3d, 0x0a, 0x0e, 0x52, 0x65, 0x6e, 0x64, 0x65, 0x72, 0x4d,
+	0x65, 0x74, 0x61, 0x54, 0x69, 0x6c, 0x65, 0x12, 0x17, 0x2e, 0x6d, 0x61, 0x70, 0x74, 0x69, 0x6c,
+	0x65, 0x73, 0x2e, 0x4d, 0x65, 0x74, 0x61, 0x54, 0x69, 0x6c, 0x65, 0x43, 0x6f, 0x6f, 0x72, 0x64,
+	0x1a, 0x12, 0x2e, 0x6d, 0x61, 0x70, 0x74, 0x69, 0x6c, 0x65, 0x73, 0x2e, 0x4d, 0x65, 0x74, 0x61,
+	0x54, 0x69, 0x6c, 0x65, 0x12, 0x3a, 0x0a, 0x0c, 0x52, 0x65, 0x6e, 0x64, 0x65, 0x72, 0x53, 0x74,
+	0x61, 0x74, 0x69, 0x63, 0x12, 0x13, 0x2e, 0x6d, 0x61, 0x70, 0x74, 0x69, 0x6c, 0x65, 0x73, 0x2e,
+	0x53, 0x74, 0x61, 0x74, 0x69, 0x63, 0x4d, 0x61, 0x70, 0x1a, 0x15, 0x2e, 0x6d, 0x61, 0x70, 0x74,
+	0x69, 0x6c, 0x65, 0x73, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x69, 0x63, 0x49, 0x6d, 0x61, 0x67, 0x65,
+	0x42, 0x30, 0x5a, 0x2e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6e,
+	0x6b, 0x6f, 0x76, 0x61, 0x63, 0x73, 0x2f, 0x67, 0x6f, 0x2d, 0x6d, 0x61, 0x70, 0x6e, 0x69, 0x6b,
+	0x2f, 0x6d, 0x61, 0x70, 0x74, 0x69, 0x6c, 0x65, 0x73, 0x2f, 0x72, 0x65, 0x6e, 0x64, 0x65, 0x72,
+	0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_render_proto_rawDescOnce sync.Once
+	file_render_proto_rawDescData = file_render_proto_rawDesc
+)
+
+func file_render_proto_rawDescGZIP() []byte {
+	file_render_proto_rawDescOnce.Do(func() {
+		file_render_proto_rawDescData = protoimpl.X.CompressGZIP(file_render_proto_rawDescData)
+	})
+	return file_render_proto_rawDescData
+}
+
+var file_render_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_render_proto_goTypes = []any{
+	(*TileCoord)(nil),     // 0: maptiles.TileCoord
+	(*MetaTileCoord)(nil), // 1: maptiles.MetaTileCoord
+	(*Tile)(nil),          // 2: maptiles.Tile
+	(*MetaTile)(nil),      // 3: maptiles.MetaTile
+	(*StaticMap)(nil),     // 4: maptiles.StaticMap
+	(*StaticImage)(nil),   // 5: maptiles.StaticImage
+}
+var file_render_proto_depIdxs = []int32{
+	0, // 0: maptiles.Tile.coord:type_name -> maptiles.TileCoord
+	2, // 1: maptiles.MetaTile.tiles:type_name -> maptiles.Tile
+	0, // 2: maptiles.Render.RenderTile:input_type -> maptiles.TileCoord
+	1, // 3: maptiles.Render.RenderMetaTile:input_type -> maptiles.MetaTileCoord
+	4, // 4: maptiles.Render.RenderStatic:input_type -> maptiles.StaticMap
+	2, // 5: maptiles.Render.RenderTile:output_type -> maptiles.Tile
+	3, // 6: maptiles.Render.RenderMetaTile:output_type -> maptiles.MetaTile
+	5, // 7: maptiles.Render.RenderStatic:output_type -> maptiles.StaticImage
+	5, // [5:8] is the sub-list for method output_type
+	2, // [2:5] is the sub-list for method input_type
+	2, // [2:2] is the sub-list for extension type_name
+	2, // [2:2] is the sub-list for extension extendee
+	0, // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_render_proto_init() }
+func file_render_proto_init() {
+	if File_render_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_render_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*TileCoord); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_render_proto_msgTypes[1].Exporter = func(v any, i int) any {
+			switch v := v.(*MetaTileCoord); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_render_proto_msgTypes[2].Exporter = func(v any, i int) any {
+			switch v := v.(*Tile); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_render_proto_msgTypes[3].Exporter = func(v any, i int) any {
+			switch v := v.(*MetaTile); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_render_proto_msgTypes[4].Exporter = func(v any, i int) any {
+			switch v := v.(*StaticMap); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_render_proto_msgTypes[5].Exporter = func(v any, i int) any {
+			switch v := v.(*StaticImage); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_render_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_render_proto_goTypes,
+		DependencyIndexes: file_render_proto_depIdxs,
+		MessageInfos:      file_render_proto_msgTypes,
+	}.Build()
+	File_render_proto = out.File
+	file_render_proto_rawDesc = nil
+	file_render_proto_goTypes = nil
+	file_render_proto_depIdxs = nil
+}
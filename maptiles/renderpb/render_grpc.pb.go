@@ -0,0 +1,202 @@
+// The render service of maptiles.RenderService, to deploy rendering
+// capacity separately from the tile servers.
+//
+// Regenerate the Go code with
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//		--go-grpc_out=. --go-grpc_opt=paths=source_relative render.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             (unknown)
+// source: render.proto
+
+package renderpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	Render_RenderTile_FullMethodName     = "/maptiles.Render/RenderTile"
+	Render_RenderMetaTile_FullMethodName = "/maptiles.Render/RenderMetaTile"
+	Render_RenderStatic_FullMethodName   = "/maptiles.Render/RenderStatic"
+)
+
+// RenderClient is the client API for Render service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Render errors of single tiles are returned in Tile.error. Requests for
+// unknown layers fail with NOT_FOUND and busy workers with
+// RESOURCE_EXHAUSTED, so clients retry on another worker.
+type RenderClient interface {
+	RenderTile(ctx context.Context, in *TileCoord, opts ...grpc.CallOption) (*Tile, error)
+	RenderMetaTile(ctx context.Context, in *MetaTileCoord, opts ...grpc.CallOption) (*MetaTile, error)
+	RenderStatic(ctx context.Context, in *StaticMap, opts ...grpc.CallOption) (*StaticImage, error)
+}
+
+type renderClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRenderClient(cc grpc.ClientConnInterface) RenderClient {
+	return &renderClient{cc}
+}
+
+func (c *renderClient) RenderTile(ctx context.Context, in *TileCoord, opts ...grpc.CallOption) (*Tile, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Tile)
+	err := c.cc.Invoke(ctx, Render_RenderTile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *renderClient) RenderMetaTile(ctx context.Context, in *MetaTileCoord, opts ...grpc.CallOption) (*MetaTile, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MetaTile)
+	err := c.cc.Invoke(ctx, Render_RenderMetaTile_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *renderClient) RenderStatic(ctx context.Context, in *StaticMap, opts ...grpc.CallOption) (*StaticImage, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StaticImage)
+	err := c.cc.Invoke(ctx, Render_RenderStatic_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RenderServer is the server API for Render service.
+// All implementations must embed UnimplementedRenderServer
+// for forward compatibility
+//
+// Render errors of single tiles are returned in Tile.error. Requests for
+// unknown layers fail with NOT_FOUND and busy workers with
+// RESOURCE_EXHAUSTED, so clients retry on another worker.
+type RenderServer interface {
+	RenderTile(context.Context, *TileCoord) (*Tile, error)
+	RenderMetaTile(context.Context, *MetaTileCoord) (*MetaTile, error)
+	RenderStatic(context.Context, *StaticMap) (*StaticImage, error)
+	mustEmbedUnimplementedRenderServer()
+}
+
+// UnimplementedRenderServer must be embedded to have forward compatible implementations.
+type UnimplementedRenderServer struct {
+}
+
+func (UnimplementedRenderServer) RenderTile(context.Context, *TileCoord) (*Tile, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenderTile not implemented")
+}
+func (UnimplementedRenderServer) RenderMetaTile(context.Context, *MetaTileCoord) (*MetaTile, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenderMetaTile not implemented")
+}
+func (UnimplementedRenderServer) RenderStatic(context.Context, *StaticMap) (*StaticImage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RenderStatic not implemented")
+}
+func (UnimplementedRenderServer) mustEmbedUnimplementedRenderServer() {}
+
+// UnsafeRenderServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RenderServer will
+// result in compilation errors.
+type UnsafeRenderServer interface {
+	mustEmbedUnimplementedRenderServer()
+}
+
+func RegisterRenderServer(s grpc.ServiceRegistrar, srv RenderServer) {
+	s.RegisterService(&Render_ServiceDesc, srv)
+}
+
+func _Render_RenderTile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TileCoord)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RenderServer).RenderTile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Render_RenderTile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RenderServer).RenderTile(ctx, req.(*TileCoord))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Render_RenderMetaTile_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MetaTileCoord)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RenderServer).RenderMetaTile(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Render_RenderMetaTile_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RenderServer).RenderMetaTile(ctx, req.(*MetaTileCoord))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Render_RenderStatic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StaticMap)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RenderServer).RenderStatic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Render_RenderStatic_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RenderServer).RenderStatic(ctx, req.(*StaticMap))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Render_ServiceDesc is the grpc.ServiceDesc for Render service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Render_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "maptiles.Render",
+	HandlerType: (*RenderServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RenderTile",
+			Handler:    _Render_RenderTile_Handler,
+		},
+		{
+			MethodName: "RenderMetaTile",
+			Handler:    _Render_RenderMetaTile_Handler,
+		},
+		{
+			MethodName: "RenderStatic",
+			Handler:    _Render_RenderStatic_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "render.proto",
+}
@@ -0,0 +1,154 @@
+package maptiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// identifier is implemented by renderers that report a renderer ID.
+type identifier interface {
+	rendererID() string
+}
+
+var rendererCount int64
+
+// newRendererID returns a process wide unique renderer ID.
+func newRendererID() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d/%d", host, os.Getpid(), atomic.AddInt64(&rendererCount, 1))
+}
+
+func (t *TileRenderer) rendererID() string {
+	return t.id
+}
+
+// renderStats returns the stats of a render by r that started at start.
+func renderStats(r Renderer, start time.Time) *RenderStats {
+	s := &RenderStats{Duration: time.Since(start)}
+	if id, ok := r.(identifier); ok {
+		s.Renderer = id.rendererID()
+	}
+	return s
+}
+
+// TileStats are the render statistics stored with a cached tile.
+type TileStats struct {
+	// Zoom, X and Y are XYZ tile coordinates.
+	Zoom, X, Y uint64
+	Duration   time.Duration
+	Size       int64
+	Renderer   string
+}
+
+// SlowestTiles returns the limit tiles of the layer that took longest to
+// render, at zoom level zoom or at all levels if zoom is negative.
+func (m *TileDb) SlowestTiles(layer string, zoom int, limit int) ([]TileStats, error) {
+	return m.tileStats(layer, zoom, limit, "render_ms")
+}
+
+// BiggestTiles returns the limit largest tiles of the layer, at zoom level
+// zoom or at all levels if zoom is negative.
+func (m *TileDb) BiggestTiles(layer string, zoom int, limit int) ([]TileStats, error) {
+	return m.tileStats(layer, zoom, limit, "size")
+}
+
+func (m *TileDb) tileStats(layer string, zoom int, limit int, order string) ([]TileStats, error) {
+	m.dbLock.RLock()
+	defer m.dbLock.RUnlock()
+	rows, err := m.db.Query(`
+		SELECT zoom_level, tile_column, tile_row, COALESCE(render_ms, 0), COALESCE(size, 0), COALESCE(renderer, '')
+		FROM layered_tiles
+		WHERE layer_id=(SELECT rowid FROM layers WHERE layer_name=?)
+			AND (? < 0 OR zoom_level=?)
+			AND `+order+` IS NOT NULL
+		ORDER BY `+order+` DESC
+		LIMIT ?`, layer, zoom, zoom, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var stats []TileStats
+	for rows.Next() {
+		var ms int64
+		var s TileStats
+		if err := rows.Scan(&s.Zoom, &s.X, &s.Y, &ms, &s.Size, &s.Renderer); err != nil {
+			return nil, err
+		}
+		c := TileCoord{X: s.X, Y: s.Y, Zoom: s.Zoom, Tms: true}
+		c.SetTMS(false)
+		s.Y = c.Y
+		s.Duration = time.Duration(ms) * time.Millisecond
+		stats = append(stats, s)
+	}
+	return stats, rows.Err()
+}
+
+type adminTileStats struct {
+	Z        uint64 `json:"z"`
+	X        uint64 `json:"x"`
+	Y        uint64 `json:"y"`
+	RenderMs int64  `json:"render_ms"`
+	Bytes    int64  `json:"bytes"`
+	Renderer string `json:"renderer"`
+}
+
+// tileStats answers GET /admin/layers/{name}/tiles/slowest and
+// /admin/layers/{name}/tiles/biggest with a JSON array of XYZ tiles.
+// The zoom parameter restricts the result to one zoom level, limit sets
+// the number of tiles, 20 by default.
+func (h *AdminHandler) tileStats(w http.ResponseWriter, r *http.Request, layer, kind string) {
+	db, ok := h.t.m.(*TileDb)
+	if !ok {
+		http.Error(w, "the cache does not record render statistics", http.StatusNotImplemented)
+		return
+	}
+	q := r.URL.Query()
+	zoom := -1
+	if s := q.Get("zoom"); s != "" {
+		z, err := strconv.Atoi(s)
+		if err != nil || z < 0 {
+			http.Error(w, "invalid zoom", http.StatusBadRequest)
+			return
+		}
+		zoom = z
+	}
+	limit := 20
+	if s := q.Get("limit"); s != "" {
+		l, err := strconv.Atoi(s)
+		if err != nil || l <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = l
+	}
+
+	var stats []TileStats
+	var err error
+	switch kind {
+	case "slowest":
+		stats, err = db.SlowestTiles(layer, zoom, limit)
+	case "biggest":
+		stats, err = db.BiggestTiles(layer, zoom, limit)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tiles := make([]adminTileStats, 0, len(stats))
+	for _, s := range stats {
+		tiles = append(tiles, adminTileStats{s.Zoom, s.X, s.Y, s.Duration.Nanoseconds() / int64(time.Millisecond), s.Size, s.Renderer})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(tiles); err != nil {
+		log.Println(err)
+	}
+}
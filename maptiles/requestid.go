@@ -0,0 +1,71 @@
+package maptiles
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader carries the ID of a request. ServeHTTP takes it from the
+// request or generates one and sends it in the response. It is included
+// in the log messages about the request and passed on to peers and render
+// workers, so failed tiles can be traced across servers.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the length of request IDs taken from clients.
+const maxRequestIDLength = 128
+
+type requestIDContextKey struct{}
+
+// withRequestID attaches the ID of the request, taken from the
+// X-Request-ID header or generated, to the request and the response.
+func withRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	id := r.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, id))
+}
+
+// RequestID returns the ID of a request served by ServeHTTP or Handler,
+// e.g. for logging in middleware or RenderHooks. It returns an empty
+// string for other requests.
+func RequestID(r *http.Request) string {
+	return contextRequestID(r.Context())
+}
+
+func contextRequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// validRequestID reports whether id is a non-empty, printable ASCII string
+// without spaces of at most maxRequestIDLength characters, so it is safe to
+// log and to send on.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestTag prefixes log messages about a request with its ID.
+func requestTag(id string) string {
+	if id == "" {
+		return ""
+	}
+	return "[" + id + "] "
+}
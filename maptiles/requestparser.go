@@ -0,0 +1,99 @@
+package maptiles
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// RequestParser extracts the requested tile from an HTTP request.
+// ParseRequest returns false if the request is not a tile request.
+type RequestParser interface {
+	ParseRequest(r *http.Request) (TileCoord, bool)
+}
+
+// RequestParserFunc adapts a function to the RequestParser interface.
+type RequestParserFunc func(r *http.Request) (TileCoord, bool)
+
+func (f RequestParserFunc) ParseRequest(r *http.Request) (TileCoord, bool) {
+	return f(r)
+}
+
+var pathRegex = regexp.MustCompile(`/([A-Za-z0-9]+(?:@[0-9.]+)?(?:,[A-Za-z0-9]+(?:@[0-9.]+)?)*)/([0-9]+)/([0-9]+)/([0-9]+)\.png`)
+
+var quadkeyRegex = regexp.MustCompile(`/([A-Za-z0-9]+)/q/([0-3]+)\.png`)
+
+// PathRequestParser handles the /{layer}/{z}/{x}/{y}.png scheme,
+// and Bing Maps quadkeys as /{layer}/q/{quadkey}.png.
+// The layer may be a comma separated list of layers with optional opacity,
+// e.g. /base,overlay@0.5/{z}/{x}/{y}.png, which are composited into one tile.
+type PathRequestParser struct {
+	// Tms indicates that y is counted from the bottom.
+	Tms bool
+}
+
+func (p PathRequestParser) ParseRequest(r *http.Request) (TileCoord, bool) {
+	if q := quadkeyRegex.FindStringSubmatch(r.URL.Path); q != nil {
+		c, err := TileCoordFromQuadkey(q[2], q[1])
+		return c, err == nil
+	}
+
+	path := pathRegex.FindStringSubmatch(r.URL.Path)
+	if path == nil {
+		return TileCoord{}, false
+	}
+
+	l := path[1]
+	z, _ := strconv.ParseUint(path[2], 10, 64)
+	x, _ := strconv.ParseUint(path[3], 10, 64)
+	y, _ := strconv.ParseUint(path[4], 10, 64)
+
+	return TileCoord{X: x, Y: y, Zoom: z, Tms: p.Tms, Layer: l}, true
+}
+
+// QueryRequestParser handles Google Maps style requests that pass the tile
+// as query parameters, e.g. /tiles?layer=default&x=1&y=2&z=3.
+// The layer parameter is optional.
+type QueryRequestParser struct {
+	Tms bool
+
+	// DefaultLayer is used if the layer parameter is missing.
+	DefaultLayer string
+}
+
+func (p QueryRequestParser) ParseRequest(r *http.Request) (TileCoord, bool) {
+	q := r.URL.Query()
+	x, errX := strconv.ParseUint(q.Get("x"), 10, 64)
+	y, errY := strconv.ParseUint(q.Get("y"), 10, 64)
+	z, errZ := strconv.ParseUint(q.Get("z"), 10, 64)
+	if errX != nil || errY != nil || errZ != nil {
+		return TileCoord{}, false
+	}
+
+	l := q.Get("layer")
+	if l == "" {
+		l = p.DefaultLayer
+	}
+
+	return TileCoord{X: x, Y: y, Zoom: z, Tms: p.Tms, Layer: l}, true
+}
+
+// ArcGISRequestParser handles ArcGIS REST style tile paths of the form
+// /{layer}/tile/{z}/{y}/{x}, where y is counted from the top.
+type ArcGISRequestParser struct{}
+
+var arcgisRegex = regexp.MustCompile(`/([A-Za-z0-9]+)/tile/([0-9]+)/([0-9]+)/([0-9]+)`)
+
+func (p ArcGISRequestParser) ParseRequest(r *http.Request) (TileCoord, bool) {
+	path := arcgisRegex.FindStringSubmatch(r.URL.Path)
+	if path == nil {
+		return TileCoord{}, false
+	}
+
+	l := path[1]
+	z, _ := strconv.ParseUint(path[2], 10, 64)
+	y, _ := strconv.ParseUint(path[3], 10, 64)
+	x, _ := strconv.ParseUint(path[4], 10, 64)
+
+	return TileCoord{X: x, Y: y, Zoom: z, Layer: l}, true
+}
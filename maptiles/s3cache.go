@@ -0,0 +1,257 @@
+package maptiles
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Cache stores tiles as objects named {prefix}{layer}/{z}/{x}/{y}.png in an
+// S3 compatible bucket, e.g. for serving them through a CDN.
+type S3Cache struct {
+	// Endpoint is the base URL of the service, e.g. https://s3.eu-west-1.amazonaws.com.
+	// The bucket is addressed path-style.
+	Endpoint string
+	Region   string
+	Bucket   string
+	Prefix   string
+
+	AccessKey string
+	SecretKey string
+
+	// Client is used for the requests. If nil, http.DefaultClient is used.
+	Client *http.Client
+
+	// VectorLayer reports whether the tiles of a layer are gzip compressed
+	// vector tiles, which are stored with their content type and encoding.
+	// NewTileServer sets it if it is nil.
+	VectorLayer func(layer string) bool
+
+	// ReportError is called with errors uploading tiles. NewTileServer
+	// sets it to report to TileServer.ReportError if it is nil.
+	ReportError ErrorReporter
+}
+
+func (s *S3Cache) key(c TileCoord) string {
+	c.SetTMS(false)
+	return fmt.Sprintf("%s%s/%d/%d/%d.%s", s.Prefix, c.CacheLayer(), c.Zoom, c.X, c.Y, tileExt(c.Format))
+}
+
+func (s *S3Cache) do(method, key string, body []byte) (*http.Response, error) {
+	return s.doQuery(method, key, nil, nil, body)
+}
+
+// doQuery is do with query parameters, e.g. for listing the bucket, and
+// extra headers.
+func (s *S3Cache) doQuery(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := strings.TrimRight(s.Endpoint, "/") + "/" + s.Bucket + "/" + key
+	if len(query) > 0 {
+		u += "?" + canonicalQuery(query)
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if method != "PUT" {
+		// compressed vector tiles are kept compressed
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	s.sign(req, body, time.Now().UTC())
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// sign adds an AWS signature version 4 Authorization header to req.
+func (s *S3Cache) sign(req *http.Request, body []byte, now time.Time) {
+	date := now.Format("20060102")
+	stamp := now.Format("20060102T150405Z")
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+
+	req.Header.Set("x-amz-date", stamp)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	req.Header.Set("Host", req.URL.Host)
+
+	var names []string
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	var canonicalHeaders string
+	for _, name := range names {
+		canonicalHeaders += name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(v url.Values) string {
+	// url.Values.Encode sorts by key and escapes spaces as +, S3 wants %20
+	return strings.Replace(v.Encode(), "+", "%20", -1)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func (s *S3Cache) Fetch(c TileCoord) ([]byte, error) {
+	resp, err := s.do("GET", s.key(c), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(resp.Body)
+	case http.StatusNotFound, http.StatusForbidden:
+		// S3 returns 403 for missing keys without list permission
+		return nil, nil
+	}
+	return nil, fmt.Errorf("s3: unexpected status %v", resp.Status)
+}
+
+func (s *S3Cache) BatchInsert(tiles []TileFetchResult) {
+	for _, t := range tiles {
+		vector := s.VectorLayer != nil && s.VectorLayer(t.Coord.Layer)
+		header := http.Header{"Content-Type": {tileContentType(t.BlobPNG, vector)}}
+		if vector {
+			header.Set("Content-Encoding", "gzip")
+		}
+		resp, err := s.doQuery("PUT", s.key(t.Coord), nil, header, t.BlobPNG)
+		if err != nil {
+			log.Println("error uploading tile", err)
+			s.reportError(err, t.Coord)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			log.Println("error uploading tile", t.Coord, resp.Status)
+			s.reportError(fmt.Errorf("s3: unexpected status %v", resp.Status), t.Coord)
+		}
+	}
+}
+
+func (s *S3Cache) reportError(err error, c TileCoord) {
+	if s.ReportError != nil {
+		s.ReportError(ErrorReport{Err: err, Layer: c.Layer, Coord: c, Stage: StageCacheWrite})
+	}
+}
+
+// s3ListResult is the response of ListObjectsV2.
+type s3ListResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// PurgeLayer deletes the tiles of a layer, including those rendered with a
+// selection of mapnik layers, variables or another format. It needs
+// permission to list the bucket.
+func (s *S3Cache) PurgeLayer(layer string) error {
+	if layer == "" {
+		layer = "default"
+	}
+	prefix := s.Prefix + layer
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := s.doQuery("GET", "", query, nil, nil)
+		if err != nil {
+			return err
+		}
+		var list s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("s3: listing tiles: %v", resp.Status)
+		}
+		if err != nil {
+			return fmt.Errorf("s3: listing tiles: %v", err)
+		}
+		for _, obj := range list.Contents {
+			// other layers with the same prefix
+			rest := strings.TrimPrefix(obj.Key, prefix)
+			if !strings.HasPrefix(rest, "/") && !strings.HasPrefix(rest, "+") {
+				continue
+			}
+			resp, err := s.do("DELETE", obj.Key, nil)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("s3: deleting %v: %v", obj.Key, resp.Status)
+			}
+		}
+		if !list.IsTruncated {
+			return nil
+		}
+		query.Set("continuation-token", list.NextContinuationToken)
+	}
+}
+
+func (s *S3Cache) BatchCheckSince(coords []TileCoord, since time.Time) []bool {
+	results := make([]bool, len(coords))
+	for i, c := range coords {
+		resp, err := s.do("HEAD", s.key(c), nil)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			continue
+		}
+		if since.IsZero() {
+			results[i] = true
+			continue
+		}
+		modified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+		results[i] = err == nil && !modified.Before(since.Truncate(time.Second))
+	}
+	return results
+}
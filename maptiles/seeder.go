@@ -0,0 +1,347 @@
+package maptiles
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/nkovacs/go-mapnik/mapnik"
+	"github.com/nkovacs/go-mapnik/tilegrid"
+)
+
+// Seeder pre-renders all tiles of a region into a TileDb using metatiles.
+type Seeder struct {
+	// Layer is the name the tiles are stored under.
+	Layer string
+
+	// Renderer receives the metatile requests, e.g. a channel created by
+	// NewTileRendererChan or LayerMultiplex.CreateRenderer.
+	Renderer chan<- FetchRequest
+
+	// Cache receives the rendered tiles, e.g. a TileDb, DirCache or S3Cache.
+	Cache TileCache
+
+	// LowLeft and UpRight define the region to seed in WGS84 coordinates.
+	LowLeft, UpRight mapnik.Coord
+
+	// Polygon optionally restricts seeding to the metatiles intersecting it.
+	// The coordinates are WGS84, the polygon is closed implicitly.
+	Polygon []mapnik.Coord
+
+	// Cells seeds the heatmap cells of Layer instead of the region, in the
+	// order given, to warm the cache with the most requested tiles first.
+	// LowLeft, UpRight and Order are ignored then. See TileServer.Heatmap,
+	// ReadHeatmapCSV and ParseAccessLog.
+	Cells []HeatmapCell
+
+	MinZoom, MaxZoom uint64
+
+	// MetaSize is the width and height of a metatile in tiles.
+	// If zero, 8 will be used.
+	MetaSize uint64
+
+	// Workers is the number of metatiles in flight at the same time.
+	// If zero, 1 will be used.
+	Workers int
+
+	// SkipExisting skips metatiles whose tiles are all present in the cache.
+	SkipExisting bool
+
+	// Order is the order in which metatiles are rendered.
+	Order SeedOrder
+
+	// SkipBlank does not store fully transparent tiles. Use
+	// TileServerConfig.BlankMissing to serve them from such a cache.
+	SkipBlank bool
+
+	// SkipNewerThan limits SkipExisting to tiles written at or after
+	// this time. The zero value accepts tiles of any age.
+	SkipNewerThan time.Time
+
+	// Progress is called after each metatile with the number of processed
+	// metatiles and the total number of metatiles.
+	Progress func(done, total uint64)
+}
+
+// SeedOrder determines the order in which a Seeder visits metatiles.
+type SeedOrder int
+
+const (
+	// SeedRowMajor seeds from the lowest zoom level up, column by column.
+	SeedRowMajor SeedOrder = iota
+
+	// SeedPyramid seeds from the highest zoom level down and visits the
+	// metatiles of a zoom level along a Hilbert curve, so neighbouring
+	// metatiles are rendered close in time. This keeps database pages and
+	// datasource caches warm.
+	SeedPyramid
+)
+
+// Run renders the region and stores the tiles in the cache.
+func (s *Seeder) Run() {
+	workers := s.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	log.Println("starting seed of layer", s.Layer)
+
+	var total, done uint64
+	if s.Progress != nil {
+		total = s.Estimate().MetaTiles
+	}
+	var progressMx sync.Mutex
+	progress := func() {
+		if s.Progress == nil {
+			return
+		}
+		progressMx.Lock()
+		done++
+		s.Progress(done, total)
+		progressMx.Unlock()
+	}
+
+	c := make(chan MetaTileCoord)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			results := make(chan TileFetchResult)
+			for coord := range c {
+				if !s.SkipExisting || !s.exists(coord) {
+					if err := s.seedMetaTile(coord, results); err != nil {
+						log.Println("Error seeding metatile:", err)
+					}
+				}
+				progress()
+			}
+		}()
+	}
+
+	z := uint64(math.MaxUint64)
+	s.eachMetaTile(func(coord MetaTileCoord) {
+		if coord.Zoom != z {
+			z = coord.Zoom
+			log.Println("seeding zoom level", z)
+		}
+		c <- coord
+	})
+	close(c)
+	wg.Wait()
+
+	log.Println("finished seed of layer", s.Layer)
+}
+
+// seedMetaTile renders a metatile and stores its tiles in the cache. It
+// returns the first render error. The tiles rendered without an error are
+// stored anyway.
+func (s *Seeder) seedMetaTile(coord MetaTileCoord, results chan TileFetchResult) error {
+	s.Renderer <- MetaTileFetchRequest{Coord: coord, OutChan: results}
+	tiles := make([]TileFetchResult, 0, coord.Count())
+	var err error
+	for n := uint64(0); n < coord.Count(); n++ {
+		r := <-results
+		if r.Error != nil && err == nil {
+			err = fmt.Errorf("tile %v: %v", r.Coord, r.Error)
+		}
+		if r.Error != nil || r.BlobPNG == nil {
+			continue
+		}
+		if s.SkipBlank && isBlank(r.BlobPNG) {
+			continue
+		}
+		tiles = append(tiles, r)
+	}
+	if s.Cache != nil && len(tiles) > 0 {
+		s.Cache.BatchInsert(tiles)
+	}
+	return err
+}
+
+// exists reports whether all tiles of the metatile are already cached.
+func (s *Seeder) exists(coord MetaTileCoord) bool {
+	if s.Cache == nil {
+		return false
+	}
+	for _, ok := range s.Cache.BatchCheckSince(coord.TileCoords(), s.SkipNewerThan) {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Seeder) metaSize() uint64 {
+	if s.MetaSize == 0 {
+		return 8
+	}
+	return s.MetaSize
+}
+
+// eachMetaTile calls fn for every metatile of the region in the order
+// given by s.Order.
+func (s *Seeder) eachMetaTile(fn func(MetaTileCoord)) {
+	if len(s.Cells) > 0 {
+		s.eachCellMetaTile(fn)
+		return
+	}
+	if s.Order != SeedPyramid {
+		for z := s.MinZoom; z <= s.MaxZoom; z++ {
+			s.eachMetaTileAt(z, fn)
+		}
+		return
+	}
+
+	size := s.metaSize()
+	for z := s.MaxZoom + 1; z > s.MinZoom; z-- {
+		minX, minY, maxX, maxY := tileRange(s.LowLeft, s.UpRight, z-1)
+		// side length of the metatile grid, rounded up to a power of two
+		n := uint64(1)
+		for n*size < uint64(1)<<(z-1) {
+			n *= 2
+		}
+		hilbertWalk(n, minX/size, minY/size, maxX/size, maxY/size, func(x, y uint64) {
+			if c, ok := s.metaTileAt(z-1, x*size, y*size); ok {
+				fn(c)
+			}
+		})
+	}
+}
+
+// eachMetaTileAt calls fn for every metatile of the region at zoom level z
+// in row-major order.
+func (s *Seeder) eachMetaTileAt(z uint64, fn func(MetaTileCoord)) {
+	size := s.metaSize()
+	minX, minY, maxX, maxY := tileRange(s.LowLeft, s.UpRight, z)
+	for mx := minX / size * size; mx <= maxX; mx += size {
+		for my := minY / size * size; my <= maxY; my += size {
+			if c, ok := s.metaTileAt(z, mx, my); ok {
+				fn(c)
+			}
+		}
+	}
+}
+
+// metaTileAt returns the metatile starting at tile mx, my and reports
+// whether it intersects s.Polygon.
+func (s *Seeder) metaTileAt(z, mx, my uint64) (MetaTileCoord, bool) {
+	size := s.metaSize()
+	coord := MetaTileCoord{
+		MinX:  mx,
+		MinY:  my,
+		MaxX:  tilegrid.ClampTile(mx+size-1, z),
+		MaxY:  tilegrid.ClampTile(my+size-1, z),
+		Zoom:  z,
+		Layer: s.Layer,
+	}
+	if len(s.Polygon) > 0 && !polygonIntersectsBox(s.Polygon, metaTileBounds(coord)) {
+		return coord, false
+	}
+	return coord, true
+}
+
+// hilbertWalk calls fn for the cells of an n×n grid, where n is a power of
+// two, that are within minX..maxX and minY..maxY in the order of the
+// Hilbert curve filling the grid. Parts of the curve outside of the range
+// are skipped without visiting their cells.
+func hilbertWalk(n, minX, minY, maxX, maxY uint64, fn func(x, y uint64)) {
+	var walk func(d, side uint64)
+	walk = func(d, side uint64) {
+		// the side×side cells starting at d form an aligned square
+		x, y := hilbertPoint(n, d)
+		x0, y0 := x&^(side-1), y&^(side-1)
+		if x0 > maxX || y0 > maxY || x0+side-1 < minX || y0+side-1 < minY {
+			return
+		}
+		if side == 1 {
+			fn(x, y)
+			return
+		}
+		half := side / 2
+		for i := uint64(0); i < 4; i++ {
+			walk(d+i*half*half, half)
+		}
+	}
+	walk(0, n)
+}
+
+// hilbertPoint returns the cell at distance d along the Hilbert curve
+// filling an n×n grid, where n is a power of two.
+func hilbertPoint(n, d uint64) (x, y uint64) {
+	for s := uint64(1); s < n; s *= 2 {
+		rx := 1 & (d / 2)
+		ry := 1 & (d ^ rx)
+		// rotate the quadrant
+		if ry == 0 {
+			if rx == 1 {
+				x = s - 1 - x
+				y = s - 1 - y
+			}
+			x, y = y, x
+		}
+		x += s * rx
+		y += s * ry
+		d /= 4
+	}
+	return x, y
+}
+
+// tileRange returns the range of tiles covering the bounding box at zoom z.
+func tileRange(lowLeft, upRight mapnik.Coord, z uint64) (minX, minY, maxX, maxY uint64) {
+	min, max := tilegrid.BBoxToTileRange(tilegrid.BBox{lowLeft.X, lowLeft.Y, upRight.X, upRight.Y}, z)
+	return min.X, min.Y, max.X, max.Y
+}
+
+// metaTileBounds returns the WGS84 bounding box of an XYZ metatile
+// as minx, miny, maxx, maxy.
+func metaTileBounds(c MetaTileCoord) [4]float64 {
+	return tilegrid.TileRangeToBBox(c.Zoom, c.MinX, c.MinY, c.MaxX, c.MaxY)
+}
+
+func polygonIntersectsBox(poly []mapnik.Coord, box [4]float64) bool {
+	for _, p := range poly {
+		if p.X >= box[0] && p.X <= box[2] && p.Y >= box[1] && p.Y <= box[3] {
+			return true
+		}
+	}
+	corners := []mapnik.Coord{{X: box[0], Y: box[1]}, {X: box[2], Y: box[1]}, {X: box[2], Y: box[3]}, {X: box[0], Y: box[3]}}
+	for _, c := range corners {
+		if pointInPolygon(poly, c) {
+			return true
+		}
+	}
+	for i := range poly {
+		a, b := poly[i], poly[(i+1)%len(poly)]
+		for j := range corners {
+			if segmentsIntersect(a, b, corners[j], corners[(j+1)%len(corners)]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func pointInPolygon(poly []mapnik.Coord, p mapnik.Coord) bool {
+	in := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[i], poly[j]
+		if (a.Y > p.Y) != (b.Y > p.Y) && p.X < (b.X-a.X)*(p.Y-a.Y)/(b.Y-a.Y)+a.X {
+			in = !in
+		}
+	}
+	return in
+}
+
+func segmentsIntersect(p1, p2, q1, q2 mapnik.Coord) bool {
+	cross := func(o, a, b mapnik.Coord) float64 {
+		return (a.X-o.X)*(b.Y-o.Y) - (a.Y-o.Y)*(b.X-o.X)
+	}
+	d1 := cross(q1, q2, p1)
+	d2 := cross(q1, q2, p2)
+	d3 := cross(p1, p2, q1)
+	d4 := cross(p1, p2, q2)
+	return ((d1 > 0) != (d2 > 0)) && ((d3 > 0) != (d4 > 0))
+}
@@ -0,0 +1,80 @@
+package maptiles
+
+import (
+	"testing"
+
+	"github.com/nkovacs/go-mapnik/mapnik"
+)
+
+func TestHilbertPoint(t *testing.T) {
+	const n = 16
+	seen := make(map[[2]uint64]bool)
+	var px, py uint64
+	for d := uint64(0); d < n*n; d++ {
+		x, y := hilbertPoint(n, d)
+		if x >= n || y >= n || seen[[2]uint64{x, y}] {
+			t.Fatalf("%d: invalid or repeated cell %d,%d", d, x, y)
+		}
+		seen[[2]uint64{x, y}] = true
+		if d > 0 {
+			dx, dy := int64(x)-int64(px), int64(y)-int64(py)
+			if dx*dx+dy*dy != 1 {
+				t.Fatalf("%d: cell %d,%d is not next to %d,%d", d, x, y, px, py)
+			}
+		}
+		px, py = x, y
+	}
+}
+
+func TestHilbertWalk(t *testing.T) {
+	const n = 32
+	var want [][2]uint64
+	for d := uint64(0); d < n*n; d++ {
+		if x, y := hilbertPoint(n, d); x >= 3 && x <= 20 && y >= 7 && y <= 9 {
+			want = append(want, [2]uint64{x, y})
+		}
+	}
+	var got [][2]uint64
+	hilbertWalk(n, 3, 7, 20, 9, func(x, y uint64) {
+		got = append(got, [2]uint64{x, y})
+	})
+	if len(got) != len(want) {
+		t.Fatalf("got %d cells, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("cell %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestSeederOrder(t *testing.T) {
+	s := Seeder{
+		LowLeft: mapnik.Coord{X: 5, Y: 45},
+		UpRight: mapnik.Coord{X: 25, Y: 55},
+		MinZoom: 0,
+		MaxZoom: 9,
+	}
+	rowMajor := s.Estimate()
+	s.Order = SeedPyramid
+	pyramid := s.Estimate()
+	if rowMajor.MetaTiles != pyramid.MetaTiles || rowMajor.Tiles != pyramid.Tiles {
+		t.Errorf("pyramid order seeds %+v, row-major order %+v", pyramid, rowMajor)
+	}
+
+	seen := make(map[MetaTileCoord]bool)
+	z := s.MaxZoom
+	s.eachMetaTile(func(c MetaTileCoord) {
+		if c.Zoom > z {
+			t.Fatalf("zoom level %d after %d", c.Zoom, z)
+		}
+		z = c.Zoom
+		if seen[c] {
+			t.Fatalf("metatile %+v seeded twice", c)
+		}
+		seen[c] = true
+	})
+	if uint64(len(seen)) != rowMajor.MetaTiles {
+		t.Errorf("got %d metatiles, want %d", len(seen), rowMajor.MetaTiles)
+	}
+}
@@ -0,0 +1,280 @@
+package maptiles
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nkovacs/go-mapnik/mapnik"
+)
+
+// maxStaticMapSize is the maximum width and height of a static map in pixels.
+const maxStaticMapSize = 4096
+
+// StaticMapRequest asks a renderer for an image of an arbitrary extent.
+// The result is sent to OutChan, its Coord only contains the layer.
+type StaticMapRequest struct {
+	Layer string
+
+	// BBox is the WGS84 extent as minlon, minlat, maxlon, maxlat.
+	BBox [4]float64
+
+	Width, Height uint32
+
+	// Format is one of staticMapFormats. If empty, png will be used.
+	Format string
+
+	OutChan chan<- TileFetchResult
+}
+
+// staticMapFormats maps the supported static map formats to their
+// content types. svg and pdf are rendered with cairo.
+var staticMapFormats = map[string]string{
+	"png": "image/png",
+	"svg": "image/svg+xml",
+	"pdf": "application/pdf",
+	// georeferenced in the SRS of the layer
+	"geotiff": "image/tiff",
+}
+
+func (r StaticMapRequest) IsMetaTile() bool {
+	return false
+}
+
+func (r StaticMapRequest) GetCoord() TileCoord {
+	return TileCoord{Layer: r.Layer}
+}
+
+func (r StaticMapRequest) GetLayer() string {
+	return r.Layer
+}
+
+func (r StaticMapRequest) GetMetaCoord() MetaTileCoord {
+	panic("GetMetaCoord called on StaticMapRequest")
+}
+
+func (r StaticMapRequest) GetOutChan() chan<- TileFetchResult {
+	return r.OutChan
+}
+
+func (r StaticMapRequest) GetRequestID() string {
+	return ""
+}
+
+// StaticRenderer is implemented by renderers that can render arbitrary
+// extents, like TileRenderer.
+type StaticRenderer interface {
+	RenderStaticMap(bbox [4]float64, width, height uint32, format string) ([]byte, error)
+}
+
+func processStaticMap(t Renderer, r StaticMapRequest) {
+	result := TileFetchResult{Coord: r.GetCoord()}
+	s, ok := t.(StaticRenderer)
+	if !ok {
+		result.Error = fmt.Errorf("layer %v does not support static maps", r.Layer)
+	} else {
+		result.BlobPNG, result.Error = s.RenderStaticMap(r.BBox, r.Width, r.Height, r.Format)
+	}
+	r.OutChan <- result
+}
+
+// RenderStaticMap renders the WGS84 extent to an image of the given size.
+// format is png, geotiff, or svg or pdf for vector output.
+func (t *TileRenderer) RenderStaticMap(bbox [4]float64, width, height uint32, format string) ([]byte, error) {
+	c0 := t.mp.Forward(mapnik.Coord{X: bbox[0], Y: bbox[1]})
+	c1 := t.mp.Forward(mapnik.Coord{X: bbox[2], Y: bbox[3]})
+	t.m.Resize(width, height)
+	t.m.ZoomToMinMax(c0.X, c0.Y, c1.X, c1.Y)
+	t.m.SetBufferSize(int(t.bufferSize))
+	switch format {
+	case "", "png":
+		img, err := t.m.RenderImage()
+		if err != nil {
+			return nil, err
+		}
+		defer img.Free()
+		return img.Encode(t.pngFormat)
+	case "svg", "pdf":
+		return t.m.RenderToMemoryCairo(format, 1)
+	case "geotiff":
+		return t.renderGeoTIFF(1)
+	}
+	return nil, fmt.Errorf("unsupported format %v", format)
+}
+
+// serveStaticMap answers /staticmap?layer=&bbox=&width=&height=&format=
+// requests. Static maps are rendered by the layer's renderers but are
+// not cached. Annotations are drawn on top of the map, see parseOverlay.
+func (t *TileServer) serveStaticMap(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	layer := q.Get("layer")
+	if layer == "" {
+		layer = "default"
+	}
+	format := q.Get("format")
+	if format == "" {
+		format = "png"
+	}
+	contentType, ok := staticMapFormats[format]
+	if !ok {
+		http.Error(w, "unsupported format "+format, http.StatusBadRequest)
+		return
+	}
+	width, errW := strconv.ParseUint(q.Get("width"), 10, 32)
+	height, errH := strconv.ParseUint(q.Get("height"), 10, 32)
+	if errW != nil || errH != nil || width == 0 || height == 0 || width > maxStaticMapSize || height > maxStaticMapSize {
+		http.Error(w, fmt.Sprintf("width and height must be between 1 and %d", maxStaticMapSize), http.StatusBadRequest)
+		return
+	}
+	bbox, err := parseBBoxParam(q.Get("bbox"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	overlay, err := parseOverlay(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !overlay.Empty() && format != "png" {
+		http.Error(w, "annotations are only supported for png", http.StatusBadRequest)
+		return
+	}
+
+	t.mu.RLock()
+	cfg := t.layers[layer]
+	t.mu.RUnlock()
+	var srs string
+	if cfg.Grid != nil {
+		srs = cfg.Grid.SRS
+	}
+
+	ch := make(chan TileFetchResult)
+	if !t.lmp.SubmitRequest(StaticMapRequest{layer, bbox, uint32(width), uint32(height), format, ch}) {
+		http.NotFound(w, r)
+		return
+	}
+	result := <-ch
+	if result.Error == ErrRenderBusy {
+		serviceUnavailable(w, time.Second)
+		return
+	}
+	if result.Error != nil {
+		http.Error(w, result.Error.Error(), http.StatusInternalServerError)
+		return
+	}
+	blob := result.BlobPNG
+	if !overlay.Empty() {
+		blob, err = DrawOverlaySRS(blob, bbox, overlay, srs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	if _, err := w.Write(blob); err != nil {
+		log.Println(err)
+	}
+}
+
+// parseOverlay reads the annotations of a static map request:
+// markers=lon,lat|lon,lat, any number of path=lon,lat|lon,lat, and
+// GeoJSON in the geojson parameter or a POST body. color=rrggbb sets
+// the color of all of them.
+func parseOverlay(r *http.Request) (Overlay, error) {
+	var o Overlay
+	q := r.URL.Query()
+	c := color.Color(color.RGBA{0xff, 0, 0, 0xff})
+	if s := q.Get("color"); s != "" {
+		var err error
+		if c, err = parseColor(s); err != nil {
+			return o, err
+		}
+	}
+	if s := q.Get("markers"); s != "" {
+		coords, err := parseCoordList(s)
+		if err != nil {
+			return o, fmt.Errorf("invalid markers: %v", err)
+		}
+		for _, pos := range coords {
+			o.Markers = append(o.Markers, Marker{Pos: pos, Color: c})
+		}
+	}
+	for _, s := range q["path"] {
+		coords, err := parseCoordList(s)
+		if err != nil {
+			return o, fmt.Errorf("invalid path: %v", err)
+		}
+		o.Paths = append(o.Paths, Path{Coords: coords, Color: c})
+	}
+	var geojson []byte
+	if s := q.Get("geojson"); s != "" {
+		geojson = []byte(s)
+	} else if r.Method == http.MethodPost {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			return o, err
+		}
+		geojson = body
+	}
+	if len(geojson) > 0 {
+		g, err := ParseGeoJSONOverlay(geojson, c)
+		if err != nil {
+			return o, fmt.Errorf("invalid geojson: %v", err)
+		}
+		o.Markers = append(o.Markers, g.Markers...)
+		o.Paths = append(o.Paths, g.Paths...)
+	}
+	return o, o.Validate()
+}
+
+// parseCoordList parses lon,lat pairs separated by |.
+func parseCoordList(s string) ([]mapnik.Coord, error) {
+	var coords []mapnik.Coord
+	for _, pair := range strings.Split(s, "|") {
+		parts := strings.Split(pair, ",")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected lon,lat, got %q", pair)
+		}
+		lon, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		if err != nil {
+			return nil, err
+		}
+		lat, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return nil, err
+		}
+		coords = append(coords, mapnik.Coord{X: lon, Y: lat})
+	}
+	return coords, nil
+}
+
+// parseBBoxParam parses a minlon,minlat,maxlon,maxlat bounding box.
+func parseBBoxParam(s string) ([4]float64, error) {
+	var bbox [4]float64
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return bbox, fmt.Errorf("bbox must be minlon,minlat,maxlon,maxlat")
+	}
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return bbox, fmt.Errorf("invalid bbox: %v", err)
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return bbox, fmt.Errorf("invalid bbox: %v is not a finite number", p)
+		}
+		bbox[i] = v
+	}
+	if bbox[0] >= bbox[2] || bbox[1] >= bbox[3] {
+		return bbox, fmt.Errorf("invalid bbox: empty extent")
+	}
+	return bbox, nil
+}
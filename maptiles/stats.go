@@ -0,0 +1,152 @@
+package maptiles
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencySamples is the number of recent render latencies per layer the
+// percentiles are computed from.
+const latencySamples = 1024
+
+// layerStats counts the tile requests of a layer.
+type layerStats struct {
+	requests, hits, misses, errors int64
+	// latencies is a ring buffer of the last render latencies
+	latencies []time.Duration
+	next      int
+}
+
+// serverStats collects request statistics since the server was started.
+type serverStats struct {
+	start  time.Time
+	mu     sync.Mutex
+	layers map[string]*layerStats
+}
+
+func newServerStats() *serverStats {
+	return &serverStats{start: time.Now(), layers: make(map[string]*layerStats)}
+}
+
+// layer returns the stats of a layer. s.mu must be held.
+func (s *serverStats) layer(name string) *layerStats {
+	l, ok := s.layers[name]
+	if !ok {
+		l = new(layerStats)
+		s.layers[name] = l
+	}
+	return l
+}
+
+func (s *serverStats) request(layer string) {
+	s.mu.Lock()
+	s.layer(layer).requests++
+	s.mu.Unlock()
+}
+
+func (s *serverStats) hit(layer string) {
+	s.mu.Lock()
+	s.layer(layer).hits++
+	s.mu.Unlock()
+}
+
+// rendered records a cache miss that took d to render.
+func (s *serverStats) rendered(layer string, d time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.layer(layer)
+	l.misses++
+	if err != nil {
+		l.errors++
+	}
+	if len(l.latencies) < latencySamples {
+		l.latencies = append(l.latencies, d)
+	} else {
+		l.latencies[l.next] = d
+		l.next = (l.next + 1) % latencySamples
+	}
+}
+
+// LayerStats summarizes the tile requests of a layer, see TileServer.Stats.
+type LayerStats struct {
+	Requests int64 `json:"requests"`
+	Hits     int64 `json:"cache_hits"`
+	Misses   int64 `json:"cache_misses"`
+	Errors   int64 `json:"errors"`
+	// HitRatio is Hits / (Hits + Misses), or 0 without cache lookups.
+	HitRatio float64 `json:"cache_hit_ratio"`
+	// P50 and P95 are render latency percentiles of the recent misses
+	// in milliseconds.
+	P50 float64 `json:"render_p50_ms"`
+	P95 float64 `json:"render_p95_ms"`
+	// Breaker is the state of the circuit breaker of the layer: closed,
+	// open or half_open, and BreakerTrips the number of times it opened,
+	// see TileServerConfig.BreakerThreshold.
+	Breaker      string `json:"breaker,omitempty"`
+	BreakerTrips int64  `json:"breaker_trips,omitempty"`
+}
+
+// ServerStats are the request statistics returned by TileServer.Stats.
+type ServerStats struct {
+	Uptime float64               `json:"uptime_seconds"`
+	Layers map[string]LayerStats `json:"layers"`
+}
+
+// Stats returns per layer request counts, cache hit ratios and render
+// latencies since the server was created.
+func (t *TileServer) Stats() ServerStats {
+	s := t.stats
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := ServerStats{
+		Uptime: time.Since(s.start).Seconds(),
+		Layers: make(map[string]LayerStats, len(s.layers)),
+	}
+	for name, l := range s.layers {
+		ls := LayerStats{
+			Requests: l.requests,
+			Hits:     l.hits,
+			Misses:   l.misses,
+			Errors:   l.errors,
+		}
+		if l.hits+l.misses > 0 {
+			ls.HitRatio = float64(l.hits) / float64(l.hits+l.misses)
+		}
+		if len(l.latencies) > 0 {
+			sorted := append([]time.Duration(nil), l.latencies...)
+			sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+			ls.P50 = percentile(sorted, 0.5)
+			ls.P95 = percentile(sorted, 0.95)
+		}
+		ls.Breaker, ls.BreakerTrips = t.breaker.state(name)
+		stats.Layers[name] = ls
+	}
+	return stats
+}
+
+// percentile returns the p-th percentile of sorted in milliseconds.
+func percentile(sorted []time.Duration, p float64) float64 {
+	i := int(p*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+// serveStats answers /stats with the Stats as JSON.
+func (t *TileServer) serveStats(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(t.Stats()); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,126 @@
+package maptiles
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/nkovacs/go-mapnik/mapnik"
+)
+
+// CompositeStylesheet is an additional stylesheet of a layer. It is
+// rendered in parallel with the main stylesheet and composited onto it,
+// e.g. a hillshade multiplied over a base map, without merging the XML
+// files.
+type CompositeStylesheet struct {
+	Stylesheet string
+	Params     map[string]string
+
+	// Op is a mapnik compositing operation like multiply, overlay or
+	// screen. If empty, src-over will be used.
+	Op string
+
+	// Opacity is between 0 and 1. If zero, 1 will be used.
+	Opacity float64
+}
+
+// compositeMap is a loaded CompositeStylesheet.
+type compositeMap struct {
+	m       *mapnik.Map
+	op      string
+	opacity float64
+}
+
+// loadComposite loads the stylesheets and reprojects them to srs, the
+// reference system of the main map.
+func loadComposite(stylesheets []CompositeStylesheet, srs string) ([]compositeMap, error) {
+	maps := make([]compositeMap, 0, len(stylesheets))
+	for _, s := range stylesheets {
+		c := compositeMap{op: s.Op, opacity: s.Opacity}
+		if c.op == "" {
+			c.op = "src-over"
+		}
+		if c.opacity == 0 {
+			c.opacity = 1
+		}
+		if !mapnik.ValidCompositeOp(c.op) {
+			freeComposite(maps)
+			return nil, fmt.Errorf("%v: unknown compositing operation %v", s.Stylesheet, c.op)
+		}
+		c.m = mapnik.NewMap(256, 256)
+		if err := loadStylesheet(c.m, s.Stylesheet, s.Params); err != nil {
+			c.m.Free()
+			freeComposite(maps)
+			return nil, fmt.Errorf("loading stylesheet %v: %v", s.Stylesheet, err)
+		}
+		c.m.SetSRS(srs)
+		maps = append(maps, c)
+	}
+	return maps, nil
+}
+
+func freeComposite(maps []compositeMap) {
+	for _, c := range maps {
+		c.m.Free()
+	}
+}
+
+func cloneComposite(maps []compositeMap) []compositeMap {
+	if maps == nil {
+		return nil
+	}
+	clones := make([]compositeMap, len(maps))
+	for i, c := range maps {
+		clones[i] = c
+		clones[i].m = c.m.Clone()
+	}
+	return clones
+}
+
+// renderComposite renders the main map and the composite stylesheets at
+// the current extent of the main map in parallel, and composites them in
+// order onto the image of the main map.
+func (t *TileRenderer) renderComposite() (*mapnik.Image, error) {
+	e := t.m.CurrentExtent()
+	width, height := t.m.Width(), t.m.Height()
+	bufferSize := t.m.BufferSize()
+
+	images := make([]*mapnik.Image, len(t.composite)+1)
+	errs := make([]error, len(images))
+	var wg sync.WaitGroup
+	for i := range images {
+		m := t.m
+		if i > 0 {
+			m = t.composite[i-1].m
+			m.Resize(width, height)
+			m.ZoomToMinMax(e[0], e[1], e[2], e[3])
+			m.SetBufferSize(bufferSize)
+		}
+		wg.Add(1)
+		go func(i int, m *mapnik.Map) {
+			defer wg.Done()
+			images[i], errs[i] = t.renderImage(m)
+		}(i, m)
+	}
+	wg.Wait()
+
+	var err error
+	for i, img := range images {
+		if err == nil && errs[i] != nil {
+			err = errs[i]
+		}
+		if err == nil && i > 0 {
+			c := t.composite[i-1]
+			err = images[0].Composite(img, c.op, c.opacity)
+		}
+		if i > 0 && img != nil {
+			img.Free()
+		}
+	}
+	if err != nil {
+		if images[0] != nil {
+			images[0].Free()
+		}
+		return nil, err
+	}
+	return images[0], nil
+}
@@ -0,0 +1,110 @@
+package maptiles
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/nkovacs/go-mapnik/mapnik"
+)
+
+var (
+	placeholderRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	parameterRegex   = regexp.MustCompile(`(?s)(<Parameter\b[^>]*>)(.*?)(</Parameter>)`)
+)
+
+// expandStylesheet replaces ${NAME} placeholders in the datasource
+// parameters of the stylesheet XML, e.g.
+// <Parameter name="password">${PGPASSWORD}</Parameter>, with the value from
+// params, or from the environment if params does not contain NAME. The rest
+// of the stylesheet is left alone, so it cannot read the environment. The
+// values are XML escaped, unless the parameter is a CDATA section. It
+// returns an error if a placeholder cannot be resolved.
+func expandStylesheet(style string, params map[string]string) (string, error) {
+	var missing []string
+	var err error
+	expanded := parameterRegex.ReplaceAllStringFunc(style, func(elem string) string {
+		m := parameterRegex.FindStringSubmatch(elem)
+		cdata := strings.HasPrefix(strings.TrimSpace(m[2]), "<![CDATA[")
+		value := placeholderRegex.ReplaceAllStringFunc(m[2], func(p string) string {
+			name := placeholderRegex.FindStringSubmatch(p)[1]
+			v, ok := params[name]
+			if !ok {
+				v, ok = os.LookupEnv(name)
+			}
+			if !ok {
+				missing = append(missing, name)
+				return p
+			}
+			if !cdata {
+				return escapeXML(v)
+			}
+			if strings.Contains(v, "]]>") && err == nil {
+				err = fmt.Errorf("stylesheet parameter %v cannot be used in a CDATA section", name)
+			}
+			return v
+		})
+		return m[1] + value + m[3]
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("unresolved stylesheet parameters: %v", missing)
+	}
+	return expanded, nil
+}
+
+// escapeXML escapes s for use in XML attribute values and text.
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// ValidateStylesheet checks that the stylesheet XML can be loaded by
+// mapnik, substituting placeholders from params like RendererConfig.Params.
+// Relative datasource paths are resolved against the working directory.
+func ValidateStylesheet(style []byte, params map[string]string) error {
+	return ValidateStylesheetFile(style, params, "")
+}
+
+// ValidateStylesheetFile is ValidateStylesheet for XML that is going to be
+// stored at path, so relative datasource paths are resolved against its
+// directory like when the file is loaded.
+func ValidateStylesheetFile(style []byte, params map[string]string, path string) error {
+	expanded, err := expandStylesheet(string(style), params)
+	if err != nil {
+		return err
+	}
+	m := mapnik.NewMap(256, 256)
+	defer m.Free()
+	if path == "" {
+		return m.LoadString(expanded)
+	}
+	return m.LoadStringBase(expanded, filepath.Dir(path))
+}
+
+// loadStylesheet loads the stylesheet file into m, substituting placeholders
+// first. Stylesheets without placeholders in datasource parameters are loaded
+// from the file directly.
+// Relative datasource paths are resolved against the directory of the file
+// in both cases.
+func loadStylesheet(m *mapnik.Map, stylesheet string, params map[string]string) error {
+	b, err := ioutil.ReadFile(stylesheet)
+	if err != nil {
+		return err
+	}
+	expanded, err := expandStylesheet(string(b), params)
+	if err != nil {
+		return err
+	}
+	if expanded == string(b) {
+		return m.Load(stylesheet)
+	}
+	return m.LoadStringBase(expanded, filepath.Dir(stylesheet))
+}
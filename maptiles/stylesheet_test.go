@@ -0,0 +1,54 @@
+package maptiles
+
+import (
+	"encoding/xml"
+	"os"
+	"testing"
+)
+
+func TestExpandStylesheet(t *testing.T) {
+	os.Setenv("MAPTILES_TEST_DB", "gis")
+	defer os.Unsetenv("MAPTILES_TEST_DB")
+
+	style := `<Parameter name="password">${PASSWORD}</Parameter><Parameter name="dbname">${MAPTILES_TEST_DB}</Parameter><Parameter name="user"><![CDATA[${USER}]]></Parameter><Parameter name="table">${MAPTILES_TEST_DB}.roads</Parameter>`
+	expanded, err := expandStylesheet(style, map[string]string{
+		"PASSWORD": `a<b&c`,
+		"USER":     `"x" 'y'`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var params struct {
+		Params []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:"value,attr"`
+			Text  string `xml:",chardata"`
+		} `xml:"Parameter"`
+	}
+	if err := xml.Unmarshal([]byte("<Datasource>"+expanded+"</Datasource>"), &params); err != nil {
+		t.Fatalf("expanded stylesheet is not valid XML: %v\n%s", err, expanded)
+	}
+	got := map[string]string{}
+	for _, p := range params.Params {
+		got[p.Name] = p.Value + p.Text
+	}
+	want := map[string]string{"password": `a<b&c`, "dbname": "gis", "user": `"x" 'y'`, "table": "gis.roads"}
+	for name, v := range want {
+		if got[name] != v {
+			t.Errorf("%v: got %q, want %q", name, got[name], v)
+		}
+	}
+
+	if _, err := expandStylesheet(`<Parameter name="srs">${MAPTILES_TEST_MISSING}</Parameter>`, nil); err == nil {
+		t.Error("unresolved placeholder accepted")
+	}
+	if _, err := expandStylesheet(`<Parameter name="password"><![CDATA[${PASSWORD}]]></Parameter>`, map[string]string{"PASSWORD": "a]]>b"}); err == nil {
+		t.Error("CDATA terminator accepted")
+	}
+
+	// placeholders outside of datasource parameters are not expanded
+	style = `<Map srs="${MAPTILES_TEST_DB}"><TextSymbolizer>"${MAPTILES_TEST_MISSING}"</TextSymbolizer></Map>`
+	if expanded, err := expandStylesheet(style, nil); err != nil || expanded != style {
+		t.Errorf("got %q, %v, want the stylesheet unchanged", expanded, err)
+	}
+}
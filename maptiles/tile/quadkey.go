@@ -0,0 +1,49 @@
+package tile
+
+import (
+	"fmt"
+)
+
+// Quadkey returns the Bing Maps quadkey of the tile. Zoom level 0 has an
+// empty quadkey.
+func (c Coord) Quadkey() string {
+	c.SetTMS(false)
+	key := make([]byte, c.Zoom)
+	for i := uint64(0); i < c.Zoom; i++ {
+		mask := uint64(1) << (c.Zoom - 1 - i)
+		digit := byte('0')
+		if c.X&mask != 0 {
+			digit++
+		}
+		if c.Y&mask != 0 {
+			digit += 2
+		}
+		key[i] = digit
+	}
+	return string(key)
+}
+
+// FromQuadkey returns the XYZ coordinates of a Bing Maps quadkey.
+func FromQuadkey(quadkey string, layer string) (Coord, error) {
+	if len(quadkey) >= 30 {
+		return Coord{}, fmt.Errorf("quadkey too long")
+	}
+	c := Coord{Zoom: uint64(len(quadkey)), Layer: layer}
+	for _, digit := range quadkey {
+		c.X <<= 1
+		c.Y <<= 1
+		switch digit {
+		case '0':
+		case '1':
+			c.X |= 1
+		case '2':
+			c.Y |= 1
+		case '3':
+			c.X |= 1
+			c.Y |= 1
+		default:
+			return Coord{}, fmt.Errorf("invalid quadkey digit %q", digit)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,161 @@
+// Package tile contains the tile coordinates and render results passed
+// between the tile server, renderers and caches of package maptiles.
+//
+// The package does not depend on mapnik, so it can be used by tools and
+// tests that do not render anything.
+package tile
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nkovacs/go-mapnik/tilegrid"
+)
+
+type Coord struct {
+	X, Y, Zoom uint64
+	Tms        bool
+	Layer      string
+
+	// MapLayers is a sorted, comma separated list of the mapnik layers to
+	// render, see maptiles.TileRenderer. Empty means all active layers.
+	MapLayers string
+
+	// Variables are substituted for @name variables in the stylesheet,
+	// encoded like a sorted query string, e.g. highlight=42.
+	Variables string
+
+	// Format is the image format of the tile, jpeg or webp, see
+	// maptiles.LayerConfig.Formats. Empty means png.
+	Format string
+}
+
+type MetaCoord struct {
+	MinX, MinY, MaxX, MaxY, Zoom uint64
+	Tms                          bool
+	Layer                        string
+	MapLayers                    string
+	Variables                    string
+	Format                       string
+}
+
+func (c Coord) OSMFilename() string {
+	return fmt.Sprintf("%d/%d/%d.png", c.Zoom, c.X, c.Y)
+}
+
+// Valid reports whether the zoom level is supported and X and Y are
+// within the range of the zoom level.
+func (c Coord) Valid() bool {
+	if c.Zoom > tilegrid.MaxZoom {
+		return false
+	}
+	n := uint64(1) << c.Zoom
+	return c.X < n && c.Y < n
+}
+
+// Valid reports whether the zoom level is supported and the metatile
+// is a non-empty range of valid tiles.
+func (c MetaCoord) Valid() bool {
+	if c.Zoom > tilegrid.MaxZoom {
+		return false
+	}
+	n := uint64(1) << c.Zoom
+	return c.MinX <= c.MaxX && c.MinY <= c.MaxY && c.MaxX < n && c.MaxY < n
+}
+
+// SetTMS converts the coordinates to the TMS scheme, where the Y axis
+// points north, or back to XYZ.
+func (c *Coord) SetTMS(tms bool) {
+	if c.Tms != tms {
+		c.Y = (1 << c.Zoom) - c.Y - 1
+		c.Tms = tms
+	}
+}
+
+// SetTMS converts the coordinates to the TMS scheme or back to XYZ.
+func (c *MetaCoord) SetTMS(tms bool) {
+	if c.Tms != tms {
+		c.MinY = (1 << c.Zoom) - c.MinY - 1
+		c.MaxY = (1 << c.Zoom) - c.MaxY - 1
+		c.MinY, c.MaxY = c.MaxY, c.MinY
+		c.Tms = tms
+	}
+}
+
+func (c *MetaCoord) XSize() uint64 {
+	if c.MaxX < c.MinX {
+		panic(fmt.Errorf("Invalid metatile coordinates"))
+	}
+	return c.MaxX - c.MinX + 1
+}
+
+func (c *MetaCoord) YSize() uint64 {
+	if c.MaxY < c.MinY {
+		panic(fmt.Errorf("Invalid metatile coordinates"))
+	}
+	return c.MaxY - c.MinY + 1
+}
+
+func (c *MetaCoord) Count() uint64 {
+	return c.XSize() * c.YSize()
+}
+
+func (c *MetaCoord) TileCoords() []Coord {
+	xSize := c.XSize()
+	ySize := c.YSize()
+	coords := make([]Coord, 0, xSize*ySize)
+	for x := 0; x < int(xSize); x++ {
+		for y := 0; y < int(ySize); y++ {
+			coords = append(coords, Coord{
+				X:         c.MinX + uint64(x),
+				Y:         c.MinY + uint64(y),
+				Zoom:      c.Zoom,
+				Tms:       c.Tms,
+				Layer:     c.Layer,
+				MapLayers: c.MapLayers,
+				Variables: c.Variables,
+				Format:    c.Format,
+			})
+		}
+	}
+	return coords
+}
+
+// CacheLayer returns the layer name the tile is stored under. Tiles
+// rendered with a selection of mapnik layers, with variables or in another
+// format than png are stored under layer+maplayer1,maplayer2+name=value+format.
+func (c Coord) CacheLayer() string {
+	l := c.Layer
+	if l == "" {
+		l = "default"
+	}
+	if c.MapLayers != "" || c.Variables != "" || c.Format != "" {
+		l += "+" + c.MapLayers
+	}
+	if c.Variables != "" || c.Format != "" {
+		l += "+" + c.Variables
+	}
+	if c.Format != "" {
+		l += "+" + c.Format
+	}
+	return l
+}
+
+type FetchResult struct {
+	Coord   Coord
+	BlobPNG []byte
+	Error   error
+
+	// Stats are set for rendered tiles and stored by maptiles.TileDb.
+	Stats *RenderStats
+}
+
+// RenderStats describe how a tile was rendered.
+type RenderStats struct {
+	// Duration is the render time of the tile, or of the whole metatile
+	// for tiles rendered as part of one.
+	Duration time.Duration
+
+	// Renderer identifies the renderer as host/pid/number.
+	Renderer string
+}
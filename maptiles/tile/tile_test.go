@@ -0,0 +1,101 @@
+package tile
+
+import (
+	"testing"
+)
+
+func TestSetTMS(t *testing.T) {
+	c := Coord{X: 1, Y: 0, Zoom: 2}
+	c.SetTMS(true)
+	if c.Y != 3 || !c.Tms {
+		t.Errorf("got %+v, want Y 3 in TMS", c)
+	}
+	c.SetTMS(true)
+	if c.Y != 3 {
+		t.Errorf("converting twice changed Y to %v", c.Y)
+	}
+	c.SetTMS(false)
+	if c.Y != 0 || c.Tms {
+		t.Errorf("got %+v, want Y 0 in XYZ", c)
+	}
+
+	m := MetaCoord{MinX: 0, MinY: 0, MaxX: 1, MaxY: 1, Zoom: 3}
+	m.SetTMS(true)
+	if m.MinY != 6 || m.MaxY != 7 {
+		t.Errorf("got metatile rows %v-%v, want 6-7", m.MinY, m.MaxY)
+	}
+}
+
+func TestQuadkey(t *testing.T) {
+	tests := []struct {
+		c   Coord
+		key string
+	}{
+		{Coord{}, ""},
+		{Coord{X: 1, Y: 0, Zoom: 1}, "1"},
+		{Coord{X: 3, Y: 5, Zoom: 3}, "213"},
+		{Coord{X: 3, Y: 2, Zoom: 3, Tms: true}, "213"},
+	}
+	for _, test := range tests {
+		if key := test.c.Quadkey(); key != test.key {
+			t.Errorf("%+v: got quadkey %q, want %q", test.c, key, test.key)
+		}
+		c, err := FromQuadkey(test.key, "osm")
+		if err != nil {
+			t.Errorf("%q: %v", test.key, err)
+			continue
+		}
+		want := test.c
+		want.SetTMS(false)
+		want.Layer = "osm"
+		if c != want {
+			t.Errorf("%q: got %+v, want %+v", test.key, c, want)
+		}
+	}
+
+	if _, err := FromQuadkey("0124", ""); err == nil {
+		t.Error("invalid quadkey digit accepted")
+	}
+}
+
+func TestCacheLayer(t *testing.T) {
+	tests := []struct {
+		c     Coord
+		layer string
+	}{
+		{Coord{}, "default"},
+		{Coord{Layer: "osm"}, "osm"},
+		{Coord{Layer: "osm", MapLayers: "roads"}, "osm+roads"},
+		{Coord{Layer: "osm", Variables: "a=1"}, "osm++a=1"},
+		{Coord{Layer: "osm", Format: "webp"}, "osm+++webp"},
+		{Coord{Layer: "osm", MapLayers: "roads", Variables: "a=1", Format: "jpeg"}, "osm+roads+a=1+jpeg"},
+	}
+	for _, test := range tests {
+		if l := test.c.CacheLayer(); l != test.layer {
+			t.Errorf("%+v: got %q, want %q", test.c, l, test.layer)
+		}
+	}
+}
+
+func TestTileCoords(t *testing.T) {
+	m := MetaCoord{MinX: 2, MinY: 4, MaxX: 3, MaxY: 6, Zoom: 4, Layer: "osm", Format: "png"}
+	if !m.Valid() {
+		t.Fatal("metatile not valid")
+	}
+	coords := m.TileCoords()
+	if uint64(len(coords)) != m.Count() || len(coords) != 6 {
+		t.Fatalf("got %d tiles, want 6", len(coords))
+	}
+	for _, c := range coords {
+		if c.X < 2 || c.X > 3 || c.Y < 4 || c.Y > 6 || c.Zoom != 4 || c.Layer != "osm" || c.Format != "png" {
+			t.Errorf("tile %+v not in metatile", c)
+		}
+	}
+
+	if (MetaCoord{MinX: 1, MaxX: 2, Zoom: 1}).Valid() {
+		t.Error("metatile outside of zoom level is valid")
+	}
+	if (Coord{X: 4, Zoom: 2}).Valid() {
+		t.Error("tile outside of zoom level is valid")
+	}
+}
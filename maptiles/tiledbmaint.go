@@ -0,0 +1,179 @@
+package maptiles
+
+import (
+	"crypto/md5"
+	"fmt"
+	"unicode/utf8"
+)
+
+// TileDbStats summarizes the contents of a TileDb.
+type TileDbStats struct {
+	// Tiles is the number of tile rows in all layers.
+	Tiles int64
+	// Blobs is the number of distinct tile images.
+	Blobs int64
+	// BlobBytes is the total size of the tile images.
+	BlobBytes int64
+}
+
+// Layers returns the names of all layers in the database.
+func (m *TileDb) Layers() ([]string, error) {
+	m.dbLock.RLock()
+	defer m.dbLock.RUnlock()
+	rows, err := m.db.Query("SELECT layer_name FROM layers ORDER BY layer_name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var layers []string
+	for rows.Next() {
+		var l string
+		if err := rows.Scan(&l); err != nil {
+			return nil, err
+		}
+		layers = append(layers, l)
+	}
+	return layers, rows.Err()
+}
+
+// ZoomCounts returns the number of tiles per zoom level of a layer.
+func (m *TileDb) ZoomCounts(layer string) (map[uint64]uint64, error) {
+	m.dbLock.RLock()
+	defer m.dbLock.RUnlock()
+	rows, err := m.db.Query(`
+		SELECT zoom_level, COUNT(*)
+		FROM layered_tiles
+		WHERE layer_id=(SELECT rowid FROM layers WHERE layer_name=?)
+		GROUP BY zoom_level`, layer)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[uint64]uint64)
+	for rows.Next() {
+		var z, n uint64
+		if err := rows.Scan(&z, &n); err != nil {
+			return nil, err
+		}
+		counts[z] = n
+	}
+	return counts, rows.Err()
+}
+
+// Stats returns the number of tiles and blobs and the size of the blobs.
+func (m *TileDb) Stats() (TileDbStats, error) {
+	m.dbLock.RLock()
+	defer m.dbLock.RUnlock()
+	var s TileDbStats
+	if err := m.db.QueryRow("SELECT COUNT(*) FROM layered_tiles").Scan(&s.Tiles); err != nil {
+		return s, err
+	}
+	err := m.db.QueryRow("SELECT COUNT(*), COALESCE(SUM(LENGTH(tile_data)), 0) FROM tile_blobs").Scan(&s.Blobs, &s.BlobBytes)
+	return s, err
+}
+
+// Metadata returns all rows of the metadata table.
+func (m *TileDb) Metadata() (map[string]string, error) {
+	m.dbLock.RLock()
+	defer m.dbLock.RUnlock()
+	rows, err := m.db.Query("SELECT name, value FROM metadata")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	md := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		md[name] = value
+	}
+	return md, rows.Err()
+}
+
+// Vacuum rebuilds the database file to reclaim unused space.
+func (m *TileDb) Vacuum() error {
+	m.dbLock.Lock()
+	defer m.dbLock.Unlock()
+	_, err := m.db.Exec("VACUUM")
+	return err
+}
+
+// VerifyBlobs recomputes the checksum of every blob and returns the stored
+// checksums that don't match their data. Since identical tiles share a blob,
+// a mismatch means every tile referencing it is corrupt.
+func (m *TileDb) VerifyBlobs() ([]string, error) {
+	m.dbLock.RLock()
+	defer m.dbLock.RUnlock()
+	rows, err := m.db.Query("SELECT checksum, tile_data FROM tile_blobs")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var bad []string
+	for rows.Next() {
+		var checksum string
+		var data []byte
+		if err := rows.Scan(&checksum, &data); err != nil {
+			return nil, err
+		}
+		if fmt.Sprintf("%x", md5.Sum(data)) != checksum {
+			bad = append(bad, checksum)
+		}
+	}
+	return bad, rows.Err()
+}
+
+// DeleteOrphanedBlobs removes blobs that are not referenced by any tile
+// and returns the number of removed blobs.
+func (m *TileDb) DeleteOrphanedBlobs() (int64, error) {
+	m.dbLock.Lock()
+	defer m.dbLock.Unlock()
+	res, err := m.db.Exec("DELETE FROM tile_blobs WHERE checksum NOT IN (SELECT checksum FROM layered_tiles)")
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DropLayer deletes all tiles of a layer and the blobs only they referenced.
+// If vacuum is true, the database file is compacted afterwards.
+func (m *TileDb) DropLayer(layer string, vacuum bool) error {
+	m.dbLock.Lock()
+	layerMx.Lock()
+	// tiles rendered with a selection of mapnik layers are dropped too, they
+	// are stored under layer+... (LIKE would treat _ and % as wildcards)
+	prefix := layer + "+"
+	n := utf8.RuneCountInString(prefix)
+	_, err := m.db.Exec("DELETE FROM layered_tiles WHERE layer_id IN (SELECT rowid FROM layers WHERE layer_name=? OR substr(layer_name, 1, ?)=?)", layer, n, prefix)
+	if err == nil {
+		_, err = m.db.Exec("DELETE FROM layers WHERE substr(layer_name, 1, ?)=?", n, prefix)
+	}
+	if err == nil && layer != "default" {
+		// the default layer is kept since the tiles view refers to it
+		_, err = m.db.Exec("DELETE FROM layers WHERE layer_name=?", layer)
+	}
+	if err == nil {
+		err = m.readLayers()
+	}
+	layerMx.Unlock()
+	if err == nil && layer == "default" {
+		m.extentMx.Lock()
+		m.extent = metadataExtent{}
+		m.extentMx.Unlock()
+		_, err = m.db.Exec("DELETE FROM metadata WHERE name IN ('bounds', 'minzoom', 'maxzoom')")
+	}
+	m.dbLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if _, err := m.DeleteOrphanedBlobs(); err != nil {
+		return err
+	}
+	if vacuum {
+		return m.Vacuum()
+	}
+	return nil
+}
@@ -1,20 +1,119 @@
 package maptiles
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
 	"log"
+	"math"
 	"net/http"
-	"regexp"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nkovacs/go-mapnik/tilegrid"
 )
 
 // TODO serve list of registered layers per HTTP (preferably leafletjs-compatible js-array)
 
 // Handles HTTP requests for map tiles, caching any produced tiles
-// in an MBtiles 1.2 compatible sqlite db.
+// in an MBtiles 1.2 compatible sqlite db or another TileCache.
 type TileServer struct {
-	m         *TileDb
+	m         TileCache
 	lmp       *LayerMultiplex
 	TmsSchema bool
+
+	// uncached contains the layers whose tiles are not stored in the cache
+	uncached map[string]bool
+	// layers contains the configuration of the mapnik layers
+	layers map[string]LayerConfig
+	// headers are the extra response headers of layers
+	headers map[string]http.Header
+	// vector contains the layers serving gzip compressed vector tiles,
+	// known from the metadata of their MBTiles file or their config
+	vector map[string]bool
+	mu     sync.RWMutex
+
+	// configPath and config are set by NewTileServerFromConfig
+	configPath string
+	config     *Config
+	cfgMx      sync.Mutex
+	hup        chan os.Signal
+
+	failures *failureCache
+	breaker  *circuitBreaker
+
+	// ownsCache is set if the cache was opened by NewTileServer
+	ownsCache bool
+
+	metaTileSize uint64
+	maxAge       time.Duration
+	bundleLimit  int
+	bundles      chan struct{}
+	blankMissing bool
+	errorTiles   bool
+	errorTile    []byte
+	// inflight holds the metatiles currently being rendered
+	inflight   map[MetaTileCoord]*metaRender
+	inflightMx sync.Mutex
+
+	stats     *serverStats
+	heatmap   *heatmap
+	prefetch  *prefetcher
+	rateLimit *rateLimiter
+	referers  *refererPolicy
+	// trustForwarded is TileServerConfig.TrustForwardedFor
+	trustForwarded bool
+	// layerLocks holds a *sync.Mutex per layer, see layerLock
+	layerLocks sync.Map
+
+	// trustUnix is TileServerConfig.TrustUnixSocket
+	trustUnix      bool
+	trustedProxies ipList
+	allowIPs       ipList
+	denyIPs        ipList
+
+	// Parser decodes the tile coordinates from incoming requests.
+	// If nil, the /{layer}/{z}/{x}/{y}.png scheme is used.
+	Parser RequestParser
+
+	// APIKeys enables API key checking in ServeHTTP. The key is passed in
+	// the key query parameter or the X-API-Key header. Requests without a
+	// key are answered with 401 Unauthorized, requests with an unknown or
+	// disabled key, or for a layer the key is not allowed to use, with
+	// 403 Forbidden.
+	APIKeys APIKeyLookup
+
+	// JWT enables JWT bearer token authentication in ServeHTTP, alone or
+	// in addition to APIKeys. Invalid tokens are answered with
+	// 401 Unauthorized.
+	JWT *JWTAuth
+
+	// Usage enables usage accounting: the tiles served per API key or JWT
+	// subject and day are counted in it, and the quotas of the keys are
+	// enforced, see APIKey.Quota.
+	Usage UsageStore
+
+	// Peers shares the tiles of mapnik layers between the tile servers
+	// of a cluster, see PeerCache.
+	Peers *PeerCache
+
+	// Hooks are called around tile renders.
+	Hooks RenderHooks
+
+	// ReportError is called with render failures and errors of the cache,
+	// see ErrorReporter. It must be set before the server is used.
+	ReportError ErrorReporter
+
+	// middleware is set by Use
+	middleware []Middleware
+	mwMx       sync.RWMutex
 }
 
 // TileServerConfig
@@ -23,44 +122,717 @@ type TileServerConfig struct {
 	// An empty string disables caching.
 	CacheFile string
 
+	// Cache is used instead of CacheFile if set, e.g. a
+	// maptilestest.MemoryCache in tests.
+	Cache TileCache
+
+	// PruneInterval is the interval at which expired tiles are deleted
+	// from a TileDb cache and the size limits are enforced. Zero disables
+	// pruning, unless a size limit is set, in which case one minute is used.
+	PruneInterval time.Duration
+
+	// MaxCacheBytes and MaxCacheTiles limit the size of a TileDb cache.
+	// The least recently used tiles are evicted when a limit is exceeded.
+	// Zero means no limit.
+	MaxCacheBytes int64
+	MaxCacheTiles int64
+
 	// NumRenderers specified the number of renderers to start for each layer.
 	// If zero, runtime.GOMAXPROCS will be used.
 	NumRenderers int
+
+	// RenderLimit caps the concurrent renders across all layers.
+	// Rejected renders are answered with 503 Service Unavailable.
+	RenderLimit RenderLimit
+
+	// Watchdog replaces hung mapnik renderers and recycles them after a
+	// number of tiles.
+	Watchdog Watchdog
+
+	// MetaTileSize enables metatile rendering: on a cache miss the
+	// MetaTileSize×MetaTileSize metatile containing the tile is rendered
+	// and all its tiles are cached. This avoids labels cut off at tile
+	// edges and is faster than rendering the tiles one by one.
+	// Zero or one renders single tiles. Layers that are not cached always
+	// render single tiles.
+	MetaTileSize uint64
+
+	// BlankMissing answers requests for tiles a layer does not have with a
+	// transparent tile instead of 404 Not Found, e.g. for MBTiles layers
+	// seeded with Seeder.SkipBlank. Rendered tiles on which mapnik did not
+	// paint anything are not cached then.
+	BlankMissing bool
+
+	// FailureTTL is the time a failed render is remembered. During that time
+	// requests for the tile are answered with 503 Service Unavailable
+	// instead of rendering it again. Zero disables this.
+	FailureTTL time.Duration
+
+	// BreakerThreshold enables a circuit breaker per layer: after that many
+	// consecutive failed renders of a layer, e.g. because its datasource
+	// is down, requests that need a render are answered with 503 Service
+	// Unavailable without rendering for BreakerCooldown. Then one render
+	// is tried, if it succeeds the layer is rendered again. If
+	// BreakerCooldown is zero, 30 seconds will be used. Zero disables the
+	// circuit breaker.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// ErrorTiles answers requests for tiles that failed to render with a
+	// tile showing the error, see ErrorTile, instead of 404 Not Found or
+	// 503 Service Unavailable. If ErrorTile is set, that PNG is sent
+	// instead.
+	ErrorTiles bool
+	ErrorTile  []byte
+
+	// Heatmap counts the requested tiles per layer and per tile at
+	// HeatmapZoom, to find the regions worth seeding. Requests for tiles
+	// below HeatmapZoom are not counted. See TileServer.Heatmap.
+	// If HeatmapZoom is zero, 10 will be used.
+	Heatmap     bool
+	HeatmapZoom uint64
+
+	// Prefetch renders the 8 neighbours of a tile rendered on demand in
+	// the background, as panning the map is likely to request them next.
+	// Only cached layers are prefetched. At most PrefetchQueue tiles are
+	// queued, further ones are dropped, and PrefetchWorkers renderers are
+	// used for prefetching so on demand requests are not starved.
+	// If zero, 256 and 1 will be used.
+	Prefetch        bool
+	PrefetchQueue   int
+	PrefetchWorkers int
+
+	// RateLimit limits the requests per second of each API key, see
+	// TileServer.APIKeys, or of each client IP without API keys. Clients
+	// may send RateBurst requests at once before they are limited.
+	// Requests over the limit are answered with 429 Too Many Requests.
+	// Batch and bundle requests count as one request per tile.
+	// Zero disables rate limiting. If RateBurst is zero, RateLimit
+	// rounded up will be used.
+	RateLimit float64
+	RateBurst int
+
+	// TrustForwardedFor takes the client IP from the last address of the
+	// X-Forwarded-For header set by a reverse proxy, see
+	// TileServer.ClientIP. Only enable it behind a proxy, otherwise
+	// clients can choose their IP.
+	TrustForwardedFor bool
+
+	// TrustedProxies are the IP addresses or CIDR blocks of reverse
+	// proxies. For requests from them, the client IP is the last address
+	// of the X-Forwarded-For header not in TrustedProxies, or the
+	// X-Real-IP header.
+	TrustedProxies []string
+
+	// TrustUnixSocket takes the client IP of requests on unix sockets from
+	// the X-Forwarded-For or X-Real-IP header, like for TrustedProxies.
+	// Only enable it if only the reverse proxy can connect to the socket.
+	TrustUnixSocket bool
+
+	// AllowIPs and DenyIPs are IP addresses or CIDR blocks of clients.
+	// Requests from clients in DenyIPs, and if AllowIPs is not empty from
+	// clients not in AllowIPs, are answered with 403 Forbidden.
+	AllowIPs []string
+	DenyIPs  []string
+
+	// AllowedReferers restricts requests to pages on these hosts, taken
+	// from the Origin or Referer header, to prevent hotlinking. Patterns
+	// like *.example.com are matched with path.Match. Requests without
+	// either header are rejected unless AllowEmptyReferer is set, as are
+	// requests from other hosts, with 403 Forbidden. Clients in
+	// RefererBypass, IP addresses or CIDR blocks, e.g. seeding tools,
+	// are not checked. If empty, all referers are allowed.
+	AllowedReferers   []string
+	AllowEmptyReferer bool
+	RefererBypass     []string
+
+	// MaxAge is sent in the Cache-Control header of tile responses, so
+	// browsers and CDNs cache them. Tiles of requests authenticated with
+	// an API key or JWT are only cached by browsers. Zero sends no
+	// Cache-Control header.
+	MaxAge time.Duration
+
+	// BundleRenderLimit is the number of tiles missing from the cache
+	// rendered for an offline bundle, see serveBundle. Tiles beyond it
+	// are left out of the bundle. If zero, 1000 will be used, if negative,
+	// bundles only contain cached tiles.
+	BundleRenderLimit int
 }
 
 // NewTileServer creates a new tile server
-func NewTileServer(cfg TileServerConfig) *TileServer {
+func NewTileServer(cfg TileServerConfig) (*TileServer, error) {
 	t := TileServer{}
 	t.lmp = NewLayerMultiplex(cfg.NumRenderers)
-	if cfg.CacheFile != "" {
-		t.m = NewTileDb(cfg.CacheFile)
+	t.lmp.SetRenderLimit(cfg.RenderLimit)
+	t.lmp.SetWatchdog(cfg.Watchdog)
+	t.uncached = make(map[string]bool)
+	t.layers = make(map[string]LayerConfig)
+	t.headers = make(map[string]http.Header)
+	t.vector = make(map[string]bool)
+	t.metaTileSize = cfg.MetaTileSize
+	t.maxAge = cfg.MaxAge
+	t.bundleLimit = cfg.BundleRenderLimit
+	if t.bundleLimit == 0 {
+		t.bundleLimit = 1000
+	}
+	t.bundles = make(chan struct{}, maxConcurrentBundles)
+	t.blankMissing = cfg.BlankMissing
+	t.errorTiles = cfg.ErrorTiles
+	t.errorTile = cfg.ErrorTile
+	t.inflight = make(map[MetaTileCoord]*metaRender)
+	t.stats = newServerStats()
+	if cfg.Heatmap {
+		zoom := cfg.HeatmapZoom
+		if zoom == 0 {
+			zoom = 10
+		}
+		t.heatmap = newHeatmap(zoom)
+	}
+	t.trustForwarded = cfg.TrustForwardedFor
+	t.trustUnix = cfg.TrustUnixSocket
+	var err error
+	if t.trustedProxies, err = parseIPList(cfg.TrustedProxies); err != nil {
+		return nil, err
+	}
+	if t.allowIPs, err = parseIPList(cfg.AllowIPs); err != nil {
+		return nil, err
+	}
+	if t.denyIPs, err = parseIPList(cfg.DenyIPs); err != nil {
+		return nil, err
+	}
+	if len(cfg.AllowedReferers) > 0 {
+		t.referers, err = newRefererPolicy(cfg.AllowedReferers, cfg.AllowEmptyReferer, cfg.RefererBypass)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.RateLimit > 0 {
+		t.rateLimit = newRateLimiter(cfg.RateLimit, cfg.RateBurst)
+	}
+	if cfg.Prefetch {
+		queue, workers := cfg.PrefetchQueue, cfg.PrefetchWorkers
+		if queue == 0 {
+			queue = 256
+		}
+		if workers == 0 {
+			workers = 1
+		}
+		t.prefetch = newPrefetcher(queue)
+		t.startPrefetch(workers)
+	}
+	if cfg.FailureTTL > 0 {
+		t.failures = newFailureCache(cfg.FailureTTL)
+	}
+	if cfg.BreakerThreshold > 0 {
+		t.breaker = newCircuitBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown)
+	}
+	if cfg.Cache != nil {
+		t.m = cfg.Cache
+	} else if cfg.CacheFile != "" {
+		db, err := NewTileDb(cfg.CacheFile)
+		if err != nil {
+			return nil, err
+		}
+		t.m = db
+		t.ownsCache = true
+	}
+	switch c := t.m.(type) {
+	case *S3Cache:
+		if c.VectorLayer == nil {
+			c.VectorLayer = t.vectorLayer
+		}
+		if c.ReportError == nil {
+			c.ReportError = t.reportError
+		}
+	case *DirCache:
+		if c.ReportError == nil {
+			c.ReportError = t.reportError
+		}
+	}
+	if db, ok := t.m.(*TileDb); ok {
+		if db.ReportError == nil {
+			db.ReportError = t.reportError
+		}
+		db.SetSizeLimit(cfg.MaxCacheBytes, cfg.MaxCacheTiles)
+		interval := cfg.PruneInterval
+		if interval == 0 && (cfg.MaxCacheBytes > 0 || cfg.MaxCacheTiles > 0) {
+			interval = time.Minute
+		}
+		if interval > 0 {
+			db.StartJanitor(interval)
+		}
+	}
+
+	return &t, nil
+}
+
+// LayerConfig describes a layer rendered by mapnik.
+type LayerConfig struct {
+	Name string
+	RendererConfig
+
+	// Autoscale enables a renderer pool that adapts its size to the load
+	// instead of using TileServerConfig.NumRenderers renderers.
+	Autoscale *AutoscaleConfig
+
+	// Isolate renders in TileServerConfig.NumRenderers child processes,
+	// see ProcessRenderer, so a crash of mapnik does not take down the
+	// server. Autoscale is ignored. The program must call RunRenderWorker.
+	Isolate bool
+
+	// Attribution is stored in the cache metadata for the default layer.
+	Attribution string
+
+	// TTL is the time after which cached tiles of the layer are rendered
+	// again. Zero means tiles never expire. Only supported by TileDb caches.
+	TTL time.Duration
+
+	// MinZoom and MaxZoom restrict the zoom levels that are served.
+	// A zero MaxZoom means no limit.
+	MinZoom, MaxZoom uint64
+
+	// Bounds restricts the layer to the tiles intersecting the WGS84
+	// bounding box minlon, minlat, maxlon, maxlat. The zero value means
+	// no restriction. Bounds are ignored for layers with a Grid, which
+	// only serve the tiles of the grid.
+	Bounds [4]float64
+
+	// OutOfRange determines the response for tiles outside of the zoom
+	// range and bounds.
+	OutOfRange OutOfRange
+
+	// Overzoom serves requests up to 8 zoom levels above MaxZoom by scaling
+	// up the ancestor tile at MaxZoom. CacheOverzoom stores these tiles in
+	// the cache.
+	Overzoom      bool
+	CacheOverzoom bool
+
+	// MetaTileSize overrides TileServerConfig.MetaTileSize for the layer.
+	// One renders single tiles.
+	MetaTileSize uint64
+
+	// Fallback answers requests for tiles that could not be rendered,
+	// e.g. a ProxySource. Tiles from the fallback are not cached, so
+	// they are rendered again on the next request.
+	Fallback Renderer
+
+	// Formats are the image formats the tiles are served in: png, jpeg
+	// or webp, which requires mapnik with webp support. If there are
+	// several, the format is chosen by the Accept header of the request,
+	// preferring them in order, and every format is cached separately.
+	// Empty means png.
+	Formats []string
+
+	// Variables are the stylesheet variables that can be set with
+	// var.name=value query parameters, with their allowed values. Requests
+	// with other variables or values are rejected. Every combination is
+	// cached separately, so tiles rendered with variables that allow any
+	// value, i.e. have no values listed, are not cached.
+	Variables map[string][]string
+}
+
+func (t *TileServer) AddMapnikLayer(layerName string, stylesheet string) error {
+	return t.AddLayer(LayerConfig{
+		Name:           layerName,
+		RendererConfig: RendererConfig{Stylesheet: stylesheet},
+	})
+}
+
+// ErrLayerExists is returned by AddNewLayer for layers that already exist.
+var ErrLayerExists = errors.New("layer already exists")
+
+// AddLayer adds a mapnik layer using the given configuration, replacing
+// a layer with the same name.
+// It returns an error if the stylesheet cannot be loaded.
+func (t *TileServer) AddLayer(cfg LayerConfig) error {
+	return t.addLayer(cfg, true)
+}
+
+// AddNewLayer adds a mapnik layer like AddLayer. It returns
+// ErrLayerExists if there is a layer with the same name.
+func (t *TileServer) AddNewLayer(cfg LayerConfig) error {
+	return t.addLayer(cfg, false)
+}
+
+func (t *TileServer) addLayer(cfg LayerConfig, replace bool) error {
+	c, err := t.newLayerSource(cfg)
+	if err != nil {
+		return err
 	}
+	return t.installLayer(cfg, c, replace)
+}
 
-	return &t
+// newLayerSource checks the formats of a mapnik layer and starts its
+// renderers without adding the layer.
+func (t *TileServer) newLayerSource(cfg LayerConfig) (chan<- FetchRequest, error) {
+	for _, f := range cfg.Formats {
+		if _, ok := tileFormats[f]; !ok {
+			return nil, fmt.Errorf("unsupported format %v", f)
+		}
+	}
+	return t.createRenderer(cfg)
 }
 
-func (t *TileServer) AddMapnikLayer(layerName string, stylesheet string) {
-	t.lmp.AddRenderer(layerName, stylesheet)
+// installLayer adds a mapnik layer with the renderers started by
+// newLayerSource.
+func (t *TileServer) installLayer(cfg LayerConfig, c chan<- FetchRequest, replace bool) error {
+	t.mu.Lock()
+	if replace {
+		t.lmp.AddSource(cfg.Name, c)
+	} else if !t.lmp.addSourceIfAbsent(cfg.Name, c) {
+		t.mu.Unlock()
+		close(c)
+		return ErrLayerExists
+	}
+	t.layers[cfg.Name] = cfg
+	t.mu.Unlock()
+	if db, ok := t.m.(*TileDb); ok {
+		if cfg.Name == "default" && cfg.Attribution != "" {
+			if err := db.SetMetadata("attribution", cfg.Attribution); err != nil {
+				log.Println(err)
+			}
+		}
+		if cfg.Name == "default" {
+			if err := db.SetMetadata(layerFormatKey(cfg.Name), tileExt(defaultFormat(cfg.Formats))); err != nil {
+				log.Println(err)
+			}
+		}
+		db.SetLayerTTL(cfg.Name, cfg.TTL)
+	}
+	return nil
+}
+
+func (t *TileServer) createRenderer(cfg LayerConfig) (chan<- FetchRequest, error) {
+	if cfg.Isolate {
+		r, err := NewProcessRenderer(cfg.RendererConfig, t.lmp.numRenderers)
+		if err != nil {
+			return nil, err
+		}
+		return t.lmp.CreateSource(r, t.lmp.numRenderers), nil
+	}
+	if cfg.Autoscale != nil {
+		return t.lmp.CreateAutoscaledRenderer(cfg.RendererConfig, *cfg.Autoscale)
+	}
+	return t.lmp.CreateRendererFromConfig(cfg.RendererConfig)
+}
+
+// AddMBTilesLayer adds a read-only layer serving the tiles of an existing
+// MBTiles file. No rendering is done for this layer and its tiles are not
+// copied into the cache.
+func (t *TileServer) AddMBTilesLayer(layerName string, path string) error {
+	src, err := OpenMBTilesSource(path)
+	if err != nil {
+		return err
+	}
+	t.addMBTilesSource(layerName, src)
+	return nil
+}
+
+func (t *TileServer) addMBTilesSource(layerName string, src *MBTilesSource) {
+	t.mu.Lock()
+	t.uncached[layerName] = true
+	t.mu.Unlock()
+	t.setVectorLayer(layerName, src.Format() == vectorFormat)
+	t.lmp.AddSource(layerName, t.lmp.CreateSource(src, 0))
+}
+
+// setVectorLayer records whether the layer serves gzip compressed vector
+// tiles, in the cache metadata too, see ExportLayer.
+func (t *TileServer) setVectorLayer(layerName string, vector bool) {
+	t.mu.Lock()
+	if vector {
+		t.vector[layerName] = true
+	} else {
+		delete(t.vector, layerName)
+	}
+	t.mu.Unlock()
+	if db, ok := t.m.(*TileDb); ok && vector {
+		if err := db.SetMetadata(layerFormatKey(layerName), vectorFormat); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// vectorLayer reports whether the layer serves gzip compressed vector
+// tiles, see setVectorLayer.
+func (t *TileServer) vectorLayer(layerName string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.vector[layerName]
+}
+
+// AddRenderer adds a layer served by an arbitrary Renderer, e.g. a
+// maptilestest.StubRenderer. The renderer must be safe for concurrent
+// use, it is called by TileServerConfig.NumRenderers goroutines. It is
+// closed when the layer is removed, if it has a Close method.
+func (t *TileServer) AddRenderer(layerName string, r Renderer) {
+	t.lmp.AddSource(layerName, t.lmp.CreateSource(r, 0))
+}
+
+// Close removes all layers, stops their renderers and closes the cache
+// if it was opened from TileServerConfig.CacheFile.
+func (t *TileServer) Close() {
+	t.cfgMx.Lock()
+	if t.hup != nil {
+		signal.Stop(t.hup)
+		close(t.hup)
+		t.hup = nil
+	}
+	t.cfgMx.Unlock()
+
+	if t.prefetch != nil {
+		t.prefetch.close()
+	}
+	t.lmp.Close()
+	t.mu.Lock()
+	t.uncached = make(map[string]bool)
+	t.layers = make(map[string]LayerConfig)
+	t.headers = make(map[string]http.Header)
+	t.vector = make(map[string]bool)
+	t.mu.Unlock()
+	if db, ok := t.m.(*TileDb); ok && t.ownsCache {
+		db.Close()
+	}
+}
+
+// RemoveLayer stops serving a layer and stops its renderers.
+func (t *TileServer) RemoveLayer(layerName string) {
+	c, ok := t.lmp.RemoveSource(layerName)
+	t.mu.Lock()
+	delete(t.uncached, layerName)
+	delete(t.layers, layerName)
+	delete(t.headers, layerName)
+	delete(t.vector, layerName)
+	t.mu.Unlock()
+	t.breaker.reset(layerName)
+	if ok {
+		close(c)
+	}
+}
+
+// SetLayerHeaders sets extra headers sent with the tiles of a layer, e.g.
+// an attribution, X-Tile-Source or CDN hints. They replace the headers set
+// before, nil removes them.
+func (t *TileServer) SetLayerHeaders(layerName string, headers map[string]string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(headers) == 0 {
+		delete(t.headers, layerName)
+		return
+	}
+	h := make(http.Header, len(headers))
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+	t.headers[layerName] = h
+}
+
+// layerPurger is implemented by caches other than TileDb that can delete
+// the tiles of a layer.
+type layerPurger interface {
+	PurgeLayer(layer string) error
+}
+
+// PurgeLayer deletes all cached tiles of a layer. It works with TileDb,
+// DirCache and S3Cache caches. vacuum is passed to TileDb.DropLayer.
+func (t *TileServer) PurgeLayer(layerName string, vacuum bool) error {
+	switch c := t.m.(type) {
+	case *TileDb:
+		return c.DropLayer(layerName, vacuum)
+	case layerPurger:
+		return c.PurgeLayer(layerName)
+	}
+	return fmt.Errorf("cache does not support purging layers")
+}
+
+// canPurge reports whether PurgeLayer works with the cache.
+func (t *TileServer) canPurge() bool {
+	switch t.m.(type) {
+	case *TileDb, layerPurger:
+		return true
+	}
+	return false
 }
 
-var pathRegex = regexp.MustCompile(`/([A-Za-z0-9]+)/([0-9]+)/([0-9]+)/([0-9]+)\.png`)
+// AddSource adds a layer served by an arbitrary source, e.g. a channel
+// created by LayerMultiplex.CreateSource.
+func (t *TileServer) AddSource(layerName string, fetchChan chan<- FetchRequest) {
+	t.lmp.AddSource(layerName, fetchChan)
+}
 
 func (t *TileServer) ServeTileRequest(w http.ResponseWriter, r *http.Request, tc TileCoord) {
+	cache := t.m
+	t.mu.RLock()
+	if t.uncached[tc.Layer] {
+		cache = nil
+	}
+	cfg, mapnikLayer := t.layers[tc.Layer]
+	headers := t.headers[tc.Layer]
+	vector := t.vector[tc.Layer]
+	t.mu.RUnlock()
+
+	for k, v := range headers {
+		w.Header()[k] = append([]string(nil), v...)
+	}
+	if !cfg.validTile(tc) {
+		http.Error(w, "tile coordinates out of range", http.StatusBadRequest)
+		return
+	}
+	if !apiKeyAllowsLayer(r, tc.Layer) {
+		http.Error(w, "access to this layer is not allowed", http.StatusForbidden)
+		return
+	}
+	if !t.checkQuota(w, r, 1) {
+		return
+	}
+	t.stats.request(tc.Layer)
+	if t.heatmap != nil {
+		t.heatmap.record(tc)
+	}
+	if mapnikLayer && tc.MapLayers == "" {
+		tc.MapLayers = mapLayersParam(r)
+	}
+	if mapnikLayer && tc.Variables == "" {
+		vars, err := cfg.variablesParam(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tc.Variables = vars
+	}
+	if !cfg.cacheable(tc) {
+		cache = nil
+	}
+	if mapnikLayer && len(cfg.Formats) > 0 {
+		if len(cfg.Formats) > 1 {
+			w.Header().Add("Vary", "Accept")
+		}
+		if f, ok := t.Peers.format(r, cfg.Formats); ok {
+			tc.Format = f
+		} else {
+			tc.Format = negotiateFormat(r, cfg.Formats)
+		}
+	}
+
+	if parts, err := parseComposite(tc.Layer); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	} else if parts != nil {
+		t.serveComposite(w, r, tc, parts)
+		return
+	}
+
 	ch := make(chan TileFetchResult)
 
-	tr := TileFetchRequest{tc, ch}
+	tr := TileFetchRequest{tc, ch, RequestID(r)}
 	var result TileFetchResult
 
-	if t.m != nil {
-		t.m.RequestQueue() <- tr
-		result = <-ch
+	if mapnikLayer && !cfg.inBounds(tc) {
+		t.serveOutOfRange(w, r, cfg)
+		return
+	}
+	if mapnikLayer && cfg.Overzoom && cfg.MaxZoom != 0 && tc.Zoom > cfg.MaxZoom {
+		t.serveOverzoom(w, r, tc, cfg)
+		return
+	}
+	if mapnikLayer && !cfg.inZoomRange(tc) {
+		t.serveOutOfRange(w, r, cfg)
+		return
+	}
+
+	metaTileSize := t.metaTileSize
+	if cfg.MetaTileSize > 0 {
+		metaTileSize = cfg.MetaTileSize
+	}
+	if mapnikLayer && t.Peers != nil {
+		if blob := t.Peers.get(r, tc, metaTileSize); blob != nil {
+			t.writeTile(w, r, blob, false)
+			return
+		}
+	}
+
+	if cache != nil {
+		result.BlobPNG, result.Error = cache.Fetch(tc)
+		if result.Error != nil {
+			t.tileError(result.Error, tc, StageCacheRead, RequestID(r))
+		}
 	}
 	needsInsert := false
+	if cache != nil && result.BlobPNG != nil {
+		t.stats.hit(tc.Layer)
+	}
+
+	if cache == nil || result.BlobPNG == nil {
+		if t.failures != nil {
+			if left := t.failures.check(tc); left > 0 {
+				if !t.serveFallback(w, r, cfg, tc) && !t.serveErrorTile(w, r, "rendering failed, retrying in "+left.Round(time.Second).String()) {
+					serviceUnavailable(w, left)
+				}
+				return
+			}
+		}
+
+		if !t.beforeRender(w, r, tc) {
+			return
+		}
+		if ok, left := t.breaker.allow(tc.Layer); !ok {
+			if !t.serveFallback(w, r, cfg, tc) && !t.serveErrorTile(w, r, "layer unavailable, retrying in "+left.Round(time.Second).String()) {
+				serviceUnavailable(w, left)
+			}
+			return
+		}
 
-	if t.m == nil || result.BlobPNG == nil {
 		// Tile was not provided by DB, so submit the tile request to the renderer
-		t.lmp.SubmitRequest(tr)
-		result = <-ch
+		start := time.Now()
+		prefetch := t.prefetch != nil && cache != nil
+		if cache != nil && metaTileSize > 1 {
+			var ok bool
+			if result, ok = t.renderMetaTile(tc, cache, metaTileSize, cfg.Grid, RequestID(r)); !ok {
+				t.breaker.abort(tc.Layer)
+				http.NotFound(w, r)
+				return
+			}
+			// renderMetaTile has stored the tiles
+			cache = nil
+		} else {
+			if !t.lmp.SubmitRequest(tr) {
+				t.breaker.abort(tc.Layer)
+				http.NotFound(w, r)
+				return
+			}
+			result = <-ch
+		}
+		t.breaker.record(tc.Layer, result.Error)
+		d := time.Since(start)
+		t.stats.rendered(tc.Layer, d, result.Error)
+		t.afterRender(r, result, d)
+		if result.Error == ErrRenderBusy {
+			serviceUnavailable(w, time.Second)
+			return
+		}
+		if prefetch && result.Error == nil && result.BlobPNG != nil {
+			t.prefetchNeighbours(tc, cfg, mapnikLayer)
+		}
+		if result.Error != nil {
+			t.tileError(result.Error, tc, StageRender, RequestID(r))
+		}
+		if result.Error != nil && t.failures != nil {
+			t.failures.add(tc)
+		}
+		if (result.Error != nil || result.BlobPNG == nil) && t.serveFallback(w, r, cfg, tc) {
+			return
+		}
+		if result.Error != nil && t.serveErrorTile(w, r, result.Error.Error()) {
+			return
+		}
+		if result.Error != nil && t.failures != nil {
+			serviceUnavailable(w, t.failures.ttl)
+			return
+		}
+		if result.BlobPNG == nil && result.Error == nil && t.blankMissing {
+			t.writeTile(w, r, gridBlankTile(cfg.Grid), false)
+			return
+		}
 		if result.BlobPNG == nil {
 			// The tile could not be rendered, now we need to bail out.
 			http.NotFound(w, r)
@@ -69,28 +841,249 @@ func (t *TileServer) ServeTileRequest(w http.ResponseWriter, r *http.Request, tc
 		needsInsert = true
 	}
 
-	w.Header().Set("Content-Type", "image/png")
-	_, err := w.Write(result.BlobPNG)
+	t.writeTile(w, r, result.BlobPNG, vector)
+	if mapnikLayer && t.Peers != nil {
+		t.Peers.add(tc, metaTileSize, result.BlobPNG)
+	}
+	if cache != nil && needsInsert && !t.skipCaching(result.BlobPNG, cfg.Grid) {
+		insertTiles(cache, []TileFetchResult{result}) // insert newly rendered tile into cache db
+	}
+}
+
+// skipCaching reports whether the tile is not stored in the cache because
+// the shared blank tile is served for missing tiles anyway.
+func (t *TileServer) skipCaching(blob []byte, grid *tilegrid.Grid) bool {
+	return t.blankMissing && bytes.Equal(blob, gridBlankTile(grid))
+}
+
+// mapLayersParam returns the mapnik layers selected by the layers query
+// parameter, e.g. ?layers=roads,water, sorted and without duplicates so
+// that every selection is cached once.
+func mapLayersParam(r *http.Request) string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(r.URL.Query().Get("layers"), ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+// variablesParam returns the stylesheet variables given as var.name=value
+// query parameters, encoded for TileCoord.Variables. It returns an error
+// for variables and values not allowed by cfg.Variables.
+func (cfg LayerConfig) variablesParam(r *http.Request) (string, error) {
+	vars := make(url.Values)
+	for k, v := range r.URL.Query() {
+		name := strings.TrimPrefix(k, "var.")
+		if name == k || name == "" || len(v) == 0 {
+			continue
+		}
+		allowed, ok := cfg.Variables[name]
+		if !ok {
+			return "", fmt.Errorf("variable %v is not allowed", name)
+		}
+		if len(allowed) > 0 && !stringInSlice(v[0], allowed) {
+			return "", fmt.Errorf("value %q of variable %v is not allowed", v[0], name)
+		}
+		vars.Set(name, v[0])
+	}
+	// Encode sorts by name, so every combination is cached once
+	return vars.Encode(), nil
+}
+
+// cacheable reports whether tiles rendered with the variables of tc are
+// cached, i.e. whether all of them have a list of allowed values.
+func (cfg LayerConfig) cacheable(tc TileCoord) bool {
+	if tc.Variables == "" {
+		return true
+	}
+	vars, err := url.ParseQuery(tc.Variables)
+	if err != nil {
+		return false
+	}
+	for name := range vars {
+		if len(cfg.Variables[name]) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// serveFallback answers the request with a tile from the layer's fallback
+// source and reports whether it did.
+func (t *TileServer) serveFallback(w http.ResponseWriter, r *http.Request, cfg LayerConfig, tc TileCoord) bool {
+	if cfg.Fallback == nil {
+		return false
+	}
+	blob, err := cfg.Fallback.RenderTile(tc)
 	if err != nil {
+		log.Println(requestTag(RequestID(r))+"Error while fetching fallback tile", tc, ":", err)
+		t.tileError(err, tc, StageFallback, RequestID(r))
+		return false
+	}
+	if blob == nil {
+		return false
+	}
+	t.writeTile(w, r, blob, false)
+	return true
+}
+
+// writeTile sends a tile with its length and an ETag, or 304 Not Modified
+// if the client has it already. vector tells whether the tile is a gzip
+// compressed vector tile, see vectorLayer. The body of HEAD requests is dropped by
+// net/http. Unless the response already has a Cache-Control header, it is
+// cacheable for MaxAge, only by the client if the request was
+// authenticated.
+func (t *TileServer) writeTile(w http.ResponseWriter, r *http.Request, blob []byte, vector bool) {
+	h := w.Header()
+	if t.maxAge > 0 && h.Get("Cache-Control") == "" {
+		scope := "public"
+		if authenticated(r) {
+			scope = "private"
+		}
+		h.Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", scope, int(t.maxAge.Seconds())))
+	}
+	h.Set("Content-Type", tileContentType(blob, vector))
+	if vector {
+		h.Set("Content-Encoding", "gzip")
+	}
+	etag := tileETag(blob)
+	h.Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Length", strconv.Itoa(len(blob)))
+	if _, err := w.Write(blob); err != nil {
 		log.Println(err)
 	}
-	if t.m != nil && needsInsert {
-		t.m.InsertQueue() <- result // insert newly rendered tile into cache db
+}
+
+// tileETag returns a strong ETag computed from the content of a tile.
+func tileETag(blob []byte) string {
+	h := fnv.New64a()
+	h.Write(blob)
+	return fmt.Sprintf(`"%016x"`, h.Sum64())
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using
+// the weak comparison of RFC 9110.
+func etagMatches(ifNoneMatch, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
 	}
+	return false
+}
+
+// serviceUnavailable responds with 503 and a Retry-After header. It must
+// not be cached, unlike the tiles.
+func serviceUnavailable(w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
 }
 
 func (t *TileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	path := pathRegex.FindStringSubmatch(r.URL.Path)
+	r = withRequestID(w, r)
+	t.chain(http.HandlerFunc(t.serveHTTP)).ServeHTTP(w, r)
+}
 
-	if path == nil {
-		http.NotFound(w, r)
+// checkAccess applies the IP lists, referer restriction, authentication
+// and rate limit to the request. It answers rejected requests and returns
+// false, otherwise it returns the request with the API key attached.
+func (t *TileServer) checkAccess(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if t.Peers != nil && t.Peers.trusted(r) {
+		return r, true
+	}
+	ip := t.ClientIP(r)
+	if !t.checkIP(w, ip) {
+		return nil, false
+	}
+	if t.referers != nil && !t.referers.allowed(r, ip) {
+		http.Error(w, "requests from this site are not allowed", http.StatusForbidden)
+		return nil, false
+	}
+	if t.APIKeys != nil || t.JWT != nil {
+		var ok bool
+		if r, ok = t.authenticate(w, r); !ok {
+			return nil, false
+		}
+	}
+	if !t.checkRateLimitN(w, r, 1) {
+		return nil, false
+	}
+	return r, true
+}
+
+func (t *TileServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	ew := newEncodingWriter(w, r)
+	defer ew.Close()
+	w = ew
+	r, ok := t.checkAccess(w, r)
+	if !ok {
+		return
+	}
+	if r.URL.Path == peerInvalidatePath && t.Peers != nil {
+		t.Peers.serveInvalidate(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/tms/") || r.URL.Path == "/tms" {
+		t.serveTMS(w, r)
 		return
 	}
+	if (r.URL.Path == "/staticmap" || r.URL.Path == "/stats" || featureInfoRegex.MatchString(r.URL.Path)) && apiKeyRestricted(r) {
+		http.Error(w, "the credentials only allow tile requests", http.StatusForbidden)
+		return
+	}
+	if r.URL.Path == "/staticmap" {
+		t.serveStaticMap(w, r)
+		return
+	}
+	if r.URL.Path == "/stats" {
+		t.serveStats(w)
+		return
+	}
+	if r.URL.Path == "/batch" {
+		t.serveBatch(w, r)
+		return
+	}
+	if r.URL.Path == "/bundle.mbtiles" {
+		t.serveBundle(w, r)
+		return
+	}
+	if m := featureInfoRegex.FindStringSubmatch(r.URL.Path); m != nil {
+		t.serveFeatureInfo(w, r, m)
+		return
+	}
+
+	parser := t.Parser
+	if parser == nil {
+		parser = PathRequestParser{Tms: t.TmsSchema}
+	}
 
-	l := path[1]
-	z, _ := strconv.ParseUint(path[2], 10, 64)
-	x, _ := strconv.ParseUint(path[3], 10, 64)
-	y, _ := strconv.ParseUint(path[4], 10, 64)
+	tc, ok := parser.ParseRequest(r)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
 
-	t.ServeTileRequest(w, r, TileCoord{x, y, z, t.TmsSchema, l})
+	t.ServeTileRequest(w, r, tc)
 }
@@ -0,0 +1,155 @@
+package maptiles
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nkovacs/go-mapnik/maptiles/maptilestest"
+)
+
+var (
+	_ TileCache = (*maptilestest.MemoryCache)(nil)
+	_ Renderer  = maptilestest.StubRenderer{}
+)
+
+// notifyCache signals every BatchInsert, which TileServer runs in the
+// background.
+type notifyCache struct {
+	*maptilestest.MemoryCache
+	inserted chan struct{}
+}
+
+func (c notifyCache) BatchInsert(tiles []TileFetchResult) {
+	c.MemoryCache.BatchInsert(tiles)
+	c.inserted <- struct{}{}
+}
+
+func TestServeTile(t *testing.T) {
+	cache := notifyCache{maptilestest.NewMemoryCache(), make(chan struct{}, 1)}
+	ts, err := NewTileServer(TileServerConfig{Cache: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	ts.AddRenderer("stub", maptilestest.StubRenderer{})
+
+	w := httptest.NewRecorder()
+	ts.ServeHTTP(w, httptest.NewRequest("GET", "/stub/2/1/3.png", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("got content type %q, want image/png", ct)
+	}
+	etag := w.Header().Get("ETag")
+
+	select {
+	case <-cache.inserted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tile was not cached")
+	}
+	if blob, _ := cache.Fetch(TileCoord{X: 1, Y: 3, Zoom: 2, Layer: "stub"}); blob == nil {
+		t.Error("tile cached under the wrong coordinates")
+	}
+
+	r := httptest.NewRequest("GET", "/stub/2/1/3.png", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	ts.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Errorf("got status %d for matching ETag, want 304", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	ts.ServeHTTP(w, httptest.NewRequest("GET", "/stub/2/4/0.png", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d for tile out of range, want 400", w.Code)
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	ts, err := NewTileServer(TileServerConfig{MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.Close()
+	ts.AddRenderer("stub", maptilestest.StubRenderer{})
+
+	tests := []struct {
+		url          string
+		cacheControl string
+	}{
+		{"/stub/2/1/3.png", "public, max-age=3600"},
+		{"/stub/2/4/0.png", ""},
+		{"/stub/2/1/3.png?key=k", "private, max-age=3600"},
+	}
+	for i, test := range tests {
+		if i == 2 {
+			ts.APIKeys = APIKeyLookupFunc(func(key string) (APIKey, bool) {
+				return APIKey{}, key == "k"
+			})
+		}
+		w := httptest.NewRecorder()
+		ts.ServeHTTP(w, httptest.NewRequest("GET", test.url, nil))
+		if cc := w.Header().Get("Cache-Control"); cc != test.cacheControl {
+			t.Errorf("%s: got Cache-Control %q, want %q", test.url, cc, test.cacheControl)
+		}
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch string
+		match       bool
+	}{
+		{"", false},
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{`"xyz"`, false},
+		{"*", true},
+	}
+	for _, test := range tests {
+		if match := etagMatches(test.ifNoneMatch, `"abc"`); match != test.match {
+			t.Errorf("%q: got %v, want %v", test.ifNoneMatch, match, test.match)
+		}
+	}
+	if !etagMatches(`"abc"`, `W/"abc"`) {
+		t.Error("weak ETag does not match")
+	}
+}
+
+func TestVariablesParam(t *testing.T) {
+	cfg := LayerConfig{Variables: map[string][]string{
+		"theme":     {"dark", "light"},
+		"highlight": nil,
+	}}
+	tests := []struct {
+		query     string
+		vars      string
+		ok        bool
+		cacheable bool
+	}{
+		{"", "", true, true},
+		{"layers=roads", "", true, true},
+		{"var.theme=dark", "theme=dark", true, true},
+		{"var.theme=blue", "", false, false},
+		{"var.theme=dark&var.highlight=42", "highlight=42&theme=dark", true, false},
+		{"var.other=1", "", false, false},
+	}
+	for _, test := range tests {
+		vars, err := cfg.variablesParam(httptest.NewRequest("GET", "/default/0/0/0.png?"+test.query, nil))
+		if (err == nil) != test.ok {
+			t.Errorf("%q: got error %v", test.query, err)
+			continue
+		}
+		if vars != test.vars {
+			t.Errorf("%q: got variables %q, want %q", test.query, vars, test.vars)
+		}
+		if c := cfg.cacheable(TileCoord{Variables: vars}); test.ok && c != test.cacheable {
+			t.Errorf("%q: got cacheable %v, want %v", test.query, c, test.cacheable)
+		}
+	}
+}
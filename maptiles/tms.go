@@ -0,0 +1,229 @@
+package maptiles
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nkovacs/go-mapnik/tilegrid"
+)
+
+// The TMS endpoint tree follows the OSGeo Tile Map Service specification:
+//
+//	/tms/                               service list
+//	/tms/1.0.0/                         TileMapService listing the layers
+//	/tms/1.0.0/{layer}/                 TileMap of a layer
+//	/tms/1.0.0/{layer}/{z}/{x}/{y}.png  tile, y counted from the bottom
+//
+// Layers with a custom tile grid other than tilegrid.Geodetic are not part
+// of the tree.
+
+var tmsTileRegex = regexp.MustCompile(`^/tms/1\.0\.0/([^/]+)/([0-9]+)/([0-9]+)/([0-9]+)\.png$`)
+
+// tmsDefaultMaxZoom is advertised for layers without a MaxZoom.
+const tmsDefaultMaxZoom = 18
+
+// mercatorExtent is half the width of the EPSG:3857 world in meters.
+const mercatorExtent = 20037508.342789244
+
+type tmsServices struct {
+	XMLName         xml.Name  `xml:"Services"`
+	TileMapServices []tmsLink `xml:"TileMapService"`
+}
+
+type tmsLink struct {
+	Title   string `xml:"title,attr"`
+	Version string `xml:"version,attr,omitempty"`
+	SRS     string `xml:"srs,attr,omitempty"`
+	Profile string `xml:"profile,attr,omitempty"`
+	Href    string `xml:"href,attr"`
+}
+
+type tmsService struct {
+	XMLName  xml.Name  `xml:"TileMapService"`
+	Version  string    `xml:"version,attr"`
+	Title    string    `xml:"Title"`
+	TileMaps []tmsLink `xml:"TileMaps>TileMap"`
+}
+
+type tmsBoundingBox struct {
+	MinX float64 `xml:"minx,attr"`
+	MinY float64 `xml:"miny,attr"`
+	MaxX float64 `xml:"maxx,attr"`
+	MaxY float64 `xml:"maxy,attr"`
+}
+
+type tmsTileSet struct {
+	Href          string  `xml:"href,attr"`
+	UnitsPerPixel float64 `xml:"units-per-pixel,attr"`
+	Order         uint64  `xml:"order,attr"`
+}
+
+type tmsTileMap struct {
+	XMLName     xml.Name       `xml:"TileMap"`
+	Version     string         `xml:"version,attr"`
+	Service     string         `xml:"tilemapservice,attr"`
+	Title       string         `xml:"Title"`
+	SRS         string         `xml:"SRS"`
+	BoundingBox tmsBoundingBox `xml:"BoundingBox"`
+	Origin      struct {
+		X float64 `xml:"x,attr"`
+		Y float64 `xml:"y,attr"`
+	} `xml:"Origin"`
+	TileFormat struct {
+		Width     int    `xml:"width,attr"`
+		Height    int    `xml:"height,attr"`
+		MimeType  string `xml:"mime-type,attr"`
+		Extension string `xml:"extension,attr"`
+	} `xml:"TileFormat"`
+	TileSets struct {
+		Profile  string       `xml:"profile,attr"`
+		TileSets []tmsTileSet `xml:"TileSet"`
+	} `xml:"TileSets"`
+}
+
+// baseURL returns the scheme and host the request was made to, and the
+// prefix stripped by RegisterRoutes.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	var prefix string
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil && strings.HasSuffix(u.Path, r.URL.Path) {
+		prefix = strings.TrimSuffix(u.Path, r.URL.Path)
+	}
+	return scheme + "://" + r.Host + prefix
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		log.Println(err)
+		return
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Println(err)
+	}
+}
+
+// serveTMS answers requests below /tms/.
+func (t *TileServer) serveTMS(w http.ResponseWriter, r *http.Request) {
+	if m := tmsTileRegex.FindStringSubmatch(r.URL.Path); m != nil {
+		z, _ := strconv.ParseUint(m[2], 10, 64)
+		x, _ := strconv.ParseUint(m[3], 10, 64)
+		y, _ := strconv.ParseUint(m[4], 10, 64)
+		if !tmsGrid(t.layerGrid(m[1])) {
+			http.NotFound(w, r)
+			return
+		}
+		t.ServeTileRequest(w, r, TileCoord{X: x, Y: y, Zoom: z, Tms: true, Layer: m[1]})
+		return
+	}
+
+	base := baseURL(r) + "/tms/1.0.0/"
+	path := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/tms"), "/")
+	switch {
+	case path == "":
+		writeXML(w, tmsServices{TileMapServices: []tmsLink{{
+			Title:   "go-mapnik Tile Map Service",
+			Version: "1.0.0",
+			Href:    base,
+		}}})
+	case path == "/1.0.0":
+		s := tmsService{Version: "1.0.0", Title: "go-mapnik Tile Map Service"}
+		for _, l := range t.lmp.Layers() {
+			grid := t.layerGrid(l)
+			if !tmsGrid(grid) {
+				continue
+			}
+			srs, profile := "EPSG:3857", "global-mercator"
+			if grid == tilegrid.Geodetic {
+				srs, profile = "EPSG:4326", "global-geodetic"
+			}
+			s.TileMaps = append(s.TileMaps, tmsLink{
+				Title:   l,
+				SRS:     srs,
+				Profile: profile,
+				Href:    base + l + "/",
+			})
+		}
+		writeXML(w, s)
+	case strings.HasPrefix(path, "/1.0.0/") && !strings.Contains(path[len("/1.0.0/"):], "/"):
+		layer := path[len("/1.0.0/"):]
+		if !t.lmp.hasSource(layer) || !tmsGrid(t.layerGrid(layer)) {
+			http.NotFound(w, r)
+			return
+		}
+		writeXML(w, t.tmsTileMap(layer, base))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// tmsGrid reports whether tiles of the grid can be served as TMS tiles.
+func tmsGrid(g *tilegrid.Grid) bool {
+	return g == nil || g == tilegrid.WebMercator || g == tilegrid.Geodetic
+}
+
+// layerGrid returns the custom tile grid of a layer, or nil.
+func (t *TileServer) layerGrid(layer string) *tilegrid.Grid {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.layers[layer].Grid
+}
+
+func (t *TileServer) tmsTileMap(layer, base string) tmsTileMap {
+	t.mu.RLock()
+	cfg := t.layers[layer]
+	t.mu.RUnlock()
+
+	m := tmsTileMap{
+		Version: "1.0.0",
+		Service: base,
+		Title:   layer,
+		SRS:     "EPSG:3857",
+		BoundingBox: tmsBoundingBox{
+			MinX: -mercatorExtent, MinY: -mercatorExtent,
+			MaxX: mercatorExtent, MaxY: mercatorExtent,
+		},
+	}
+	m.Origin.X, m.Origin.Y = -mercatorExtent, -mercatorExtent
+	m.TileFormat.Width, m.TileFormat.Height = 256, 256
+	m.TileFormat.MimeType, m.TileFormat.Extension = "image/png", "png"
+	m.TileSets.Profile = "global-mercator"
+
+	unitsPerPixel := func(z uint64) float64 {
+		return 2 * mercatorExtent / 256 / math.Exp2(float64(z))
+	}
+	if cfg.Grid == tilegrid.Geodetic {
+		m.SRS = "EPSG:4326"
+		m.BoundingBox = tmsBoundingBox{MinX: -180, MinY: -90, MaxX: 180, MaxY: 90}
+		m.Origin.X, m.Origin.Y = -180, -90
+		m.TileSets.Profile = "global-geodetic"
+		unitsPerPixel = func(z uint64) float64 {
+			return tilegrid.Geodetic.Resolutions[z]
+		}
+	}
+
+	maxZoom := cfg.MaxZoom
+	if maxZoom == 0 {
+		maxZoom = tmsDefaultMaxZoom
+	}
+	for z := cfg.MinZoom; z <= maxZoom; z++ {
+		m.TileSets.TileSets = append(m.TileSets.TileSets, tmsTileSet{
+			Href:          fmt.Sprintf("%v%v/%d", base, layer, z),
+			UnitsPerPixel: unitsPerPixel(z),
+			Order:         z,
+		})
+	}
+	return m
+}
@@ -0,0 +1,245 @@
+package maptiles
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/nkovacs/go-mapnik/tilegrid"
+)
+
+// eachCellMetaTile calls fn for every metatile between MinZoom and MaxZoom
+// that covers one of s.Cells, cell by cell. Metatiles shared by several
+// cells are only visited for the first one.
+func (s *Seeder) eachCellMetaTile(fn func(MetaTileCoord)) {
+	size := s.metaSize()
+	seen := make(map[MetaTileCoord]bool)
+	for _, cell := range s.Cells {
+		if cell.Layer != s.Layer {
+			continue
+		}
+		for z := s.MinZoom; z <= s.MaxZoom; z++ {
+			var minX, minY, maxX, maxY uint64
+			if z < cell.Zoom {
+				minX, minY = cell.X>>(cell.Zoom-z), cell.Y>>(cell.Zoom-z)
+				maxX, maxY = minX, minY
+			} else {
+				shift := z - cell.Zoom
+				minX, minY = cell.X<<shift, cell.Y<<shift
+				maxX, maxY = (cell.X+1)<<shift-1, (cell.Y+1)<<shift-1
+			}
+			for mx := minX / size * size; mx <= maxX; mx += size {
+				for my := minY / size * size; my <= maxY; my += size {
+					coord := MetaTileCoord{
+						MinX:  mx,
+						MinY:  my,
+						MaxX:  tilegrid.ClampTile(mx+size-1, z),
+						MaxY:  tilegrid.ClampTile(my+size-1, z),
+						Zoom:  z,
+						Layer: s.Layer,
+					}
+					if seen[coord] {
+						continue
+					}
+					seen[coord] = true
+					if len(s.Polygon) > 0 && !polygonIntersectsBox(s.Polygon, metaTileBounds(coord)) {
+						continue
+					}
+					fn(coord)
+				}
+			}
+		}
+	}
+}
+
+// ReadHeatmapCSV reads heatmap cells written by WriteHeatmapCSV, e.g. an
+// export of /admin/heatmap.csv. The cells keep their order.
+func ReadHeatmapCSV(r io.Reader) ([]HeatmapCell, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+	cols := make(map[string]int)
+	for i, name := range header {
+		cols[name] = i
+	}
+	for _, name := range []string{"layer", "z", "x", "y", "count"} {
+		if _, ok := cols[name]; !ok {
+			return nil, fmt.Errorf("heatmap csv: missing column %v", name)
+		}
+	}
+
+	var cells []HeatmapCell
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var c HeatmapCell
+		c.Layer = record[cols["layer"]]
+		var errs [4]error
+		c.Zoom, errs[0] = strconv.ParseUint(record[cols["z"]], 10, 64)
+		c.X, errs[1] = strconv.ParseUint(record[cols["x"]], 10, 64)
+		c.Y, errs[2] = strconv.ParseUint(record[cols["y"]], 10, 64)
+		c.Count, errs[3] = strconv.ParseInt(record[cols["count"]], 10, 64)
+		for _, err := range errs {
+			if err != nil {
+				line, _ := cr.FieldPos(0)
+				return nil, fmt.Errorf("heatmap csv line %d: %v", line, err)
+			}
+		}
+		if !(TileCoord{X: c.X, Y: c.Y, Zoom: c.Zoom}).Valid() {
+			line, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("heatmap csv line %d: invalid tile %d/%d/%d", line, c.Zoom, c.X, c.Y)
+		}
+		c.BBox = tilegrid.TileToBBox(tilegrid.Tile{X: c.X, Y: c.Y, Zoom: c.Zoom})
+		cells = append(cells, c)
+	}
+	return cells, nil
+}
+
+// ParseAccessLog counts the /{layer}/{z}/{x}/{y}.png requests in an access
+// log, e.g. of nginx, per cell at the zoom level. Lines without a tile
+// request and requests for composite layers are skipped. If tms is set,
+// y is counted from the bottom. The cells are sorted like TileServer.Heatmap.
+func ParseAccessLog(r io.Reader, zoom uint64, tms bool) ([]HeatmapCell, error) {
+	h := newHeatmap(zoom)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		m := pathRegex.FindStringSubmatch(sc.Text())
+		if m == nil || !layerNameRegex.MatchString(m[1]) {
+			continue
+		}
+		z, errZ := strconv.ParseUint(m[2], 10, 64)
+		x, errX := strconv.ParseUint(m[3], 10, 64)
+		y, errY := strconv.ParseUint(m[4], 10, 64)
+		tc := TileCoord{X: x, Y: y, Zoom: z, Tms: tms, Layer: m[1]}
+		if errZ != nil || errX != nil || errY != nil || !tc.Valid() {
+			continue
+		}
+		h.record(tc)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return h.cells(), nil
+}
+
+// WarmLayer renders the tiles between minZoom and maxZoom of the top most
+// requested heatmap cells of a layer into the cache, e.g. after the layer
+// was purged or its style changed. Tiles that are already cached are
+// skipped. If top is zero, all cells are warmed. It blocks until the tiles
+// are rendered and requires TileServerConfig.Heatmap and a cache.
+func (t *TileServer) WarmLayer(layerName string, minZoom, maxZoom uint64, top int) error {
+	if t.heatmap == nil {
+		return fmt.Errorf("the heatmap is disabled")
+	}
+	t.mu.RLock()
+	uncached := t.uncached[layerName]
+	t.mu.RUnlock()
+	if t.m == nil || uncached {
+		return fmt.Errorf("layer %v is not cached", layerName)
+	}
+	if !t.lmp.hasSource(layerName) {
+		return fmt.Errorf("no such layer %v", layerName)
+	}
+
+	var cells []HeatmapCell
+	for _, c := range t.Heatmap() {
+		if c.Layer == layerName {
+			cells = append(cells, c)
+		}
+	}
+	if top > 0 && len(cells) > top {
+		cells = cells[:top]
+	}
+	if len(cells) == 0 {
+		return nil
+	}
+
+	metaSize := t.metaTileSize
+	if metaSize <= 1 {
+		metaSize = 8
+	}
+	// forward through the multiplex, the layer's channel is replaced
+	// when it is reloaded
+	c := make(chan FetchRequest)
+	defer close(c)
+	go func() {
+		for r := range c {
+			if !t.lmp.SubmitRequest(r) {
+				coord := r.GetMetaCoord()
+				for n := uint64(0); n < coord.Count(); n++ {
+					r.GetOutChan() <- TileFetchResult{Error: fmt.Errorf("no such layer %v", layerName)}
+				}
+			}
+		}
+	}()
+
+	s := Seeder{
+		Layer:        layerName,
+		Renderer:     c,
+		Cache:        t.m,
+		Cells:        cells,
+		MinZoom:      minZoom,
+		MaxZoom:      maxZoom,
+		MetaSize:     metaSize,
+		Workers:      t.lmp.numRenderers,
+		SkipExisting: true,
+	}
+	s.Run()
+	return nil
+}
+
+// warm answers POST /admin/layers/{name}/warm by starting WarmLayer in the
+// background. The min_zoom and max_zoom parameters default to 0 and 16,
+// top to 100 cells.
+func (h *AdminHandler) warm(w http.ResponseWriter, r *http.Request, layer string) {
+	if h.t.heatmap == nil {
+		http.Error(w, "the heatmap is disabled", http.StatusNotImplemented)
+		return
+	}
+	if !h.t.lmp.hasSource(layer) {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	minZoom, maxZoom, top := uint64(0), uint64(16), 100
+	var err error
+	if s := q.Get("min_zoom"); s != "" {
+		if minZoom, err = strconv.ParseUint(s, 10, 64); err != nil {
+			http.Error(w, "invalid min_zoom", http.StatusBadRequest)
+			return
+		}
+	}
+	if s := q.Get("max_zoom"); s != "" {
+		if maxZoom, err = strconv.ParseUint(s, 10, 64); err != nil || maxZoom > tilegrid.MaxZoom {
+			http.Error(w, "invalid max_zoom", http.StatusBadRequest)
+			return
+		}
+	}
+	if minZoom > maxZoom {
+		http.Error(w, "min_zoom must not be greater than max_zoom", http.StatusBadRequest)
+		return
+	}
+	if s := q.Get("top"); s != "" {
+		if top, err = strconv.Atoi(s); err != nil || top < 0 {
+			http.Error(w, "invalid top", http.StatusBadRequest)
+			return
+		}
+	}
+	go func() {
+		if err := h.t.WarmLayer(layer, minZoom, maxZoom, top); err != nil {
+			log.Println("warming layer", layer, "failed:", err)
+		}
+	}()
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,332 @@
+package maptiles
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrRenderHung is the error of requests whose renderer was replaced by
+// the watchdog because it hung.
+var ErrRenderHung = errors.New("renderer hung")
+
+// Watchdog replaces mapnik renderers that hang or have rendered too many
+// tiles with fresh ones loaded from the stylesheet. The replacement is
+// transparent: it listens on the same channel, requests are not lost.
+type Watchdog struct {
+	// MaxTiles recycles a renderer after it rendered this many tiles, to
+	// release memory leaked by mapnik or its datasources. Zero disables
+	// recycling.
+	MaxTiles uint64
+
+	// HangTimeout is the time after which a render is considered hung.
+	// The request fails with ErrRenderHung, its render limit slot is
+	// released and a replacement is started. The hung renderer is closed
+	// once its render returns, since a mapnik call cannot be interrupted.
+	// At most as many hung renderers as the pool has renderers are
+	// replaced at a time, further ones are only abandoned and resume
+	// serving requests when their render returns. Zero disables hang
+	// detection.
+	HangTimeout time.Duration
+}
+
+func (w Watchdog) enabled() bool {
+	return w.MaxTiles > 0 || w.HangTimeout > 0
+}
+
+// SetWatchdog sets the watchdog of the mapnik renderers created by the
+// multiplex afterwards.
+func (l *LayerMultiplex) SetWatchdog(w Watchdog) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.watchdog = w
+}
+
+// watchedRenderer is a renderer of a watchdogPool.
+type watchedRenderer struct {
+	renderer *TileRenderer
+	tiles    uint64
+
+	mu sync.Mutex
+	// request is the request being rendered since busySince, nil while
+	// idle. release releases its render limit slot.
+	request   FetchRequest
+	release   func()
+	busySince time.Time
+	// abandoned is set when the current request was failed because the
+	// renderer hung
+	abandoned bool
+	// replaced is set when a replacement was started, it is guarded by
+	// the mutex of the pool
+	replaced bool
+}
+
+func (w *watchedRenderer) begin(request FetchRequest, release func()) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.request, w.release, w.busySince = request, release, time.Now()
+}
+
+// finish marks the renderer idle and reports whether the request was
+// abandoned. Otherwise its slot is released.
+func (w *watchedRenderer) finish() (abandoned bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.abandoned {
+		w.release()
+	}
+	abandoned = w.abandoned
+	w.request, w.release, w.abandoned = nil, nil, false
+	return abandoned
+}
+
+// abandon releases the slot of a hung render and returns its request, or
+// nil if the render returned in the meantime.
+func (w *watchedRenderer) abandon() FetchRequest {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.request == nil || w.abandoned {
+		return nil
+	}
+	w.abandoned = true
+	w.release()
+	return w.request
+}
+
+// watchdogPool runs the renderers of a stylesheet and replaces them
+// according to the watchdog.
+type watchdogPool struct {
+	l   *LayerMultiplex
+	cfg RendererConfig
+	dog Watchdog
+	c   chan FetchRequest
+
+	// size is the number of renderers the pool was started with
+	size int
+
+	mu      sync.Mutex
+	workers map[*watchedRenderer]bool
+	// replaced is the number of hung renderers that were replaced and
+	// are still rendering
+	replaced int
+	done     chan struct{}
+}
+
+func (l *LayerMultiplex) startWatchdogPool(cfg RendererConfig, dog Watchdog, renderers []*TileRenderer) chan<- FetchRequest {
+	p := &watchdogPool{
+		l:       l,
+		cfg:     cfg,
+		dog:     dog,
+		c:       make(chan FetchRequest),
+		size:    len(renderers),
+		workers: make(map[*watchedRenderer]bool),
+		done:    make(chan struct{}),
+	}
+	for _, renderer := range renderers {
+		p.start(renderer)
+	}
+	if dog.HangTimeout > 0 {
+		go p.monitor()
+	}
+	return p.c
+}
+
+// start starts a goroutine answering requests with renderer. Once all
+// renderers have stopped, renderer is closed instead.
+func (p *watchdogPool) start(renderer *TileRenderer) {
+	w := &watchedRenderer{renderer: renderer}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.done:
+		renderer.Close()
+		return
+	default:
+	}
+	p.workers[w] = true
+	p.l.renderers.Add(1)
+	go p.run(w)
+}
+
+func (p *watchdogPool) remove(w *watchedRenderer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.workers, w)
+	if w.replaced {
+		p.replaced--
+	}
+	if len(p.workers) == 0 {
+		close(p.done)
+	}
+}
+
+func (p *watchdogPool) run(w *watchedRenderer) {
+	defer p.l.renderers.Done()
+	defer w.renderer.Close()
+	defer p.remove(w)
+	for request := range p.c {
+		release, err := w.renderer.acquireSlot(request)
+		if err != nil {
+			rejectRequest(request, err)
+			continue
+		}
+		detached, forward := detachRequest(request)
+		w.begin(request, release)
+		processRequest(w.renderer, detached)
+		if w.finish() {
+			if p.isReplaced(w) {
+				return
+			}
+			continue
+		}
+		forward()
+		w.tiles += requestTiles(request)
+		if p.dog.MaxTiles == 0 || w.tiles < p.dog.MaxTiles {
+			continue
+		}
+		fresh, err := p.load()
+		if err != nil {
+			log.Println("Error recycling renderer for", p.cfg.Stylesheet, err)
+			w.tiles = 0
+			continue
+		}
+		p.start(fresh)
+		return
+	}
+}
+
+// load loads a new renderer from the stylesheet.
+func (p *watchdogPool) load() (*TileRenderer, error) {
+	renderer, err := NewTileRendererFromConfig(p.cfg)
+	if err != nil {
+		return nil, err
+	}
+	renderer.limiter = p.l.limiter
+	return renderer, nil
+}
+
+// monitor fails the requests of hung renderers and replaces them until
+// all renderers have stopped.
+func (p *watchdogPool) monitor() {
+	ticker := time.NewTicker(p.dog.HangTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			for _, w := range p.hung(now) {
+				var fresh *TileRenderer
+				if p.canReplace() {
+					var err error
+					if fresh, err = p.load(); err != nil {
+						log.Println("Error replacing hung renderer for", p.cfg.Stylesheet, err)
+					}
+				}
+				request, replaced := p.abandon(w, fresh != nil)
+				if !replaced && fresh != nil {
+					fresh.Close()
+				}
+				if request == nil {
+					continue
+				}
+				go rejectRequest(request, ErrRenderHung)
+				if replaced {
+					log.Println("Renderer for", p.cfg.Stylesheet, "hung, starting a new one")
+					p.start(fresh)
+				} else {
+					log.Println("Renderer for", p.cfg.Stylesheet, "hung, not replaced")
+				}
+			}
+		}
+	}
+}
+
+// canReplace reports whether fewer hung renderers than the pool has are
+// replaced.
+func (p *watchdogPool) canReplace() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.replaced < p.size
+}
+
+// abandon fails the request of a hung renderer and returns it, or nil if
+// the render returned in the meantime. If replace is set and the limit of
+// replaced renderers allows, the renderer exits once the render returns
+// and abandon reports that it must be replaced.
+func (p *watchdogPool) abandon(w *watchedRenderer, replace bool) (FetchRequest, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	request := w.abandon()
+	if request == nil || !replace || p.replaced >= p.size {
+		return request, false
+	}
+	p.replaced++
+	w.replaced = true
+	return request, true
+}
+
+func (p *watchdogPool) isReplaced(w *watchedRenderer) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return w.replaced
+}
+
+// hung returns the renderers busy for longer than the hang timeout.
+func (p *watchdogPool) hung(now time.Time) []*watchedRenderer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var hung []*watchedRenderer
+	for w := range p.workers {
+		w.mu.Lock()
+		if w.request != nil && !w.abandoned && now.Sub(w.busySince) > p.dog.HangTimeout {
+			hung = append(hung, w)
+		}
+		w.mu.Unlock()
+	}
+	return hung
+}
+
+// detachRequest returns a copy of the request answered on buffered
+// channels, so a render abandoned by the watchdog never blocks, and a
+// function forwarding the answer to the request.
+func detachRequest(request FetchRequest) (FetchRequest, func()) {
+	switch r := request.(type) {
+	case TileFetchRequest:
+		ch := make(chan TileFetchResult, 1)
+		out := r.OutChan
+		r.OutChan = ch
+		return r, func() { out <- <-ch }
+	case MetaTileFetchRequest:
+		n := r.Coord.Count()
+		ch := make(chan TileFetchResult, n)
+		out := r.OutChan
+		r.OutChan = ch
+		return r, func() {
+			for i := uint64(0); i < n; i++ {
+				out <- <-ch
+			}
+		}
+	case StaticMapRequest:
+		ch := make(chan TileFetchResult, 1)
+		out := r.OutChan
+		r.OutChan = ch
+		return r, func() { out <- <-ch }
+	case FeatureInfoRequest:
+		ch := make(chan FeatureInfoResult, 1)
+		out := r.OutChan
+		r.OutChan = ch
+		return r, func() { out <- <-ch }
+	}
+	return request, func() {}
+}
+
+// requestTiles returns the number of tiles rendered for a request.
+func requestTiles(request FetchRequest) uint64 {
+	if request.IsMetaTile() {
+		mc := request.GetMetaCoord()
+		return mc.Count()
+	}
+	return 1
+}
@@ -0,0 +1,19 @@
+package maptiles
+
+import "testing"
+
+func TestDetachRequest(t *testing.T) {
+	out := make(chan TileFetchResult, 4)
+	request := MetaTileFetchRequest{Coord: MetaTileCoord{Zoom: 1, MaxX: 1, MaxY: 1}, OutChan: out}
+	detached, forward := detachRequest(request)
+
+	// an abandoned render must not block on the detached channel
+	processRequestMeta(DebugRenderer{}, detached.GetMetaCoord(), detached.GetOutChan(), "")
+	if len(out) != 0 {
+		t.Fatal("result delivered before forward")
+	}
+	forward()
+	if len(out) != 4 {
+		t.Errorf("got %d results, want 4", len(out))
+	}
+}
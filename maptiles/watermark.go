@@ -0,0 +1,140 @@
+package maptiles
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Corner is the placement of a watermark on a tile.
+type Corner int
+
+const (
+	BottomRight Corner = iota
+	BottomLeft
+	TopRight
+	TopLeft
+)
+
+// Watermark is an attribution text and/or logo stamped onto every tile
+// of a layer before it is cached.
+type Watermark struct {
+	// Text is drawn in a 7x13 pixel font on a white background.
+	Text string
+
+	// Logo is the path of a PNG image drawn left of the text.
+	Logo string
+
+	Corner Corner
+
+	// Opacity is between 0 and 1. If zero, 0.7 will be used.
+	Opacity float64
+}
+
+// watermarkMargin is the distance of the watermark from the tile edges.
+const watermarkMargin = 2
+
+// stamp is a prepared watermark.
+type stamp struct {
+	img    *image.NRGBA
+	corner Corner
+	mask   image.Image
+}
+
+// newStamp draws the text and logo of the watermark into an image.
+func newStamp(wm Watermark) (*stamp, error) {
+	var logo image.Image
+	if wm.Logo != "" {
+		f, err := os.Open(wm.Logo)
+		if err != nil {
+			return nil, err
+		}
+		logo, err = png.Decode(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("decoding watermark logo %v: %v", wm.Logo, err)
+		}
+	}
+
+	face := basicfont.Face7x13
+	const pad = 2
+	var textW, w, h int
+	if wm.Text != "" {
+		textW = font.MeasureString(face, wm.Text).Ceil() + 2*pad
+		w = textW
+		h = face.Height
+	}
+	if logo != nil {
+		w += logo.Bounds().Dx()
+		if lh := logo.Bounds().Dy(); lh > h {
+			h = lh
+		}
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	x := 0
+	if logo != nil {
+		lb := logo.Bounds()
+		draw.Draw(img, image.Rect(0, (h-lb.Dy())/2, lb.Dx(), (h+lb.Dy())/2), logo, lb.Min, draw.Src)
+		x = lb.Dx()
+	}
+	if wm.Text != "" {
+		draw.Draw(img, image.Rect(x, 0, x+textW, h), image.White, image.Point{}, draw.Src)
+		d := font.Drawer{
+			Dst:  img,
+			Src:  image.Black,
+			Face: face,
+			Dot:  fixed.P(x+pad, (h-face.Height)/2+face.Ascent),
+		}
+		d.DrawString(wm.Text)
+	}
+
+	opacity := wm.Opacity
+	if opacity == 0 {
+		opacity = 0.7
+	}
+	return &stamp{
+		img:    img,
+		corner: wm.Corner,
+		mask:   image.NewUniform(color.Alpha{uint8(opacity * 0xff)}),
+	}, nil
+}
+
+// draw stamps the watermark onto the corner of img.
+func (s *stamp) draw(img draw.Image) {
+	b := img.Bounds()
+	size := s.img.Bounds().Size()
+	min := image.Point{b.Max.X - size.X - watermarkMargin, b.Max.Y - size.Y - watermarkMargin}
+	if s.corner == BottomLeft || s.corner == TopLeft {
+		min.X = b.Min.X + watermarkMargin
+	}
+	if s.corner == TopRight || s.corner == TopLeft {
+		min.Y = b.Min.Y + watermarkMargin
+	}
+	draw.DrawMask(img, image.Rectangle{min, min.Add(size)}, s.img, image.Point{}, s.mask, image.Point{}, draw.Over)
+}
+
+// apply returns a copy of a tile with the watermark stamped onto it.
+func (s *stamp) apply(src image.Image) *image.NRGBA {
+	b := src.Bounds()
+	img := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(img, img.Bounds(), src, b.Min, draw.Src)
+	s.draw(img)
+	return img
+}
+
+// applyPNG is apply for PNG encoded tiles.
+func (s *stamp) applyPNG(blob []byte) (*image.NRGBA, error) {
+	img, err := png.Decode(bytes.NewReader(blob))
+	if err != nil {
+		return nil, err
+	}
+	return s.apply(img), nil
+}
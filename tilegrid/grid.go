@@ -0,0 +1,139 @@
+package tilegrid
+
+import "math"
+
+// Grid describes a tile pyramid in an arbitrary projection. The tiles of
+// each zoom level are counted from the top left corner, like in the XYZ
+// scheme. For example, the swisstopo grid in EPSG:2056 is
+//
+//	Grid{
+//		Name:        "EPSG:2056",
+//		SRS:         "+init=epsg:2056",
+//		Origin:      [2]float64{2420000, 1350000},
+//		Extent:      [4]float64{2420000, 1030000, 2900000, 1350000},
+//		Resolutions: []float64{4000, 3750, 3500, ..., 0.1},
+//	}
+type Grid struct {
+	// Name identifies the grid in capabilities documents, e.g. EPSG:3857.
+	Name string
+
+	// SRS is the projection passed to mapnik, e.g. +init=epsg:3857.
+	SRS string
+
+	// Origin is the top left corner of the grid in SRS units.
+	Origin [2]float64
+
+	// Extent is the area covered by the grid as minx, miny, maxx, maxy
+	// in SRS units.
+	Extent [4]float64
+
+	// Resolutions contains the size of a pixel in SRS units for each
+	// zoom level, starting with zoom level 0.
+	Resolutions []float64
+
+	// TileSize is the width and height of a tile in pixels.
+	// If zero, 256 is used.
+	TileSize uint64
+
+	// MetersPerUnit is the size of an SRS unit at the equator in meters,
+	// used for scale denominators. If zero, 1 is used.
+	MetersPerUnit float64
+}
+
+// WebMercator is the grid of the XYZ scheme, see the package functions.
+var WebMercator = newWebMercator()
+
+// Geodetic is the EPSG:4326 grid with two 180° tiles at zoom level 0, used
+// by the TMS global-geodetic profile and Cesium. Tiles can also be requested
+// with TMS coordinates, as each zoom level has 2^z rows.
+var Geodetic = newGeodetic()
+
+// Named returns the predefined grid with the name, or nil.
+func Named(name string) *Grid {
+	switch name {
+	case WebMercator.Name:
+		return WebMercator
+	case Geodetic.Name:
+		return Geodetic
+	}
+	return nil
+}
+
+func newGeodetic() *Grid {
+	g := &Grid{
+		Name:          "EPSG:4326",
+		SRS:           "+init=epsg:4326",
+		Origin:        [2]float64{-180, 90},
+		Extent:        [4]float64{-180, -90, 180, 90},
+		TileSize:      TileSize,
+		MetersPerUnit: EarthCircumference / 360,
+	}
+	for z := uint64(0); z <= MaxZoom; z++ {
+		g.Resolutions = append(g.Resolutions, 180.0/TileSize/math.Exp2(float64(z)))
+	}
+	return g
+}
+
+func newWebMercator() *Grid {
+	half := EarthCircumference / 2
+	g := &Grid{
+		Name:     "EPSG:3857",
+		SRS:      "+init=epsg:3857",
+		Origin:   [2]float64{-half, half},
+		Extent:   [4]float64{-half, -half, half, half},
+		TileSize: TileSize,
+	}
+	for z := uint64(0); z <= MaxZoom; z++ {
+		g.Resolutions = append(g.Resolutions, Resolution(z))
+	}
+	return g
+}
+
+// TilePixels returns the tile size in pixels.
+func (g *Grid) TilePixels() uint64 {
+	if g.TileSize == 0 {
+		return TileSize
+	}
+	return g.TileSize
+}
+
+// MaxZoom returns the highest zoom level of the grid.
+func (g *Grid) MaxZoom() uint64 {
+	return uint64(len(g.Resolutions) - 1)
+}
+
+// TileSpan returns the width and height of a tile at zoom z in SRS units.
+func (g *Grid) TileSpan(z uint64) float64 {
+	return g.Resolutions[z] * float64(g.TilePixels())
+}
+
+// MatrixSize returns the number of tile columns and rows at zoom z.
+func (g *Grid) MatrixSize(z uint64) (cols, rows uint64) {
+	span := g.TileSpan(z)
+	// the small epsilon avoids an extra column for extents that are a
+	// multiple of the tile span up to rounding errors
+	cols = uint64(math.Ceil((g.Extent[2]-g.Origin[0])/span - 1e-9))
+	rows = uint64(math.Ceil((g.Origin[1]-g.Extent[1])/span - 1e-9))
+	return cols, rows
+}
+
+// Contains reports whether the tile is part of the grid.
+func (g *Grid) Contains(t Tile) bool {
+	if t.Zoom > g.MaxZoom() {
+		return false
+	}
+	cols, rows := g.MatrixSize(t.Zoom)
+	return t.X < cols && t.Y < rows
+}
+
+// TileExtent returns the extent of the tiles from minX, minY to maxX, maxY
+// inclusive as minx, miny, maxx, maxy in SRS units.
+func (g *Grid) TileExtent(z, minX, minY, maxX, maxY uint64) [4]float64 {
+	span := g.TileSpan(z)
+	return [4]float64{
+		g.Origin[0] + float64(minX)*span,
+		g.Origin[1] - float64(maxY+1)*span,
+		g.Origin[0] + float64(maxX+1)*span,
+		g.Origin[1] - float64(minY)*span,
+	}
+}
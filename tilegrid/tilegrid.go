@@ -0,0 +1,138 @@
+// Package tilegrid implements the tile math of the Web Mercator (EPSG:3857)
+// XYZ tile scheme used by OpenStreetMap and Google Maps. Tiles are 256
+// pixels wide, the upper left tile of every zoom level is 0,0.
+//
+// The package does not depend on mapnik, so it can be used by tools and
+// tests that do not render anything.
+package tilegrid
+
+import (
+	"math"
+)
+
+// TileSize is the width and height of a tile in pixels.
+const TileSize = 256
+
+// MaxZoom is the highest supported zoom level.
+const MaxZoom = 29
+
+// EarthCircumference is the width of the Web Mercator world in meters.
+const EarthCircumference = 2 * math.Pi * 6378137
+
+// Tile is an XYZ tile.
+type Tile struct {
+	X, Y, Zoom uint64
+}
+
+// BBox is a WGS84 bounding box as minlon, minlat, maxlon, maxlat.
+type BBox [4]float64
+
+// This has been reimplemented based on OpenStreetMap generate_tiles.py
+func minmax(a, b, c float64) float64 {
+	a = math.Max(a, b)
+	a = math.Min(a, c)
+	return a
+}
+
+var gp struct {
+	Bc []float64
+	Cc []float64
+	zc [][2]float64
+	Ac []float64
+}
+
+func init() {
+	c := float64(TileSize)
+	for d := 0; d <= MaxZoom; d++ {
+		e := c / 2
+		gp.Bc = append(gp.Bc, c/360.0)
+		gp.Cc = append(gp.Cc, c/(2*math.Pi))
+		gp.zc = append(gp.zc, [2]float64{e, e})
+		gp.Ac = append(gp.Ac, c)
+		c *= 2
+	}
+}
+
+// FromLLToPixel converts a lon, lat coordinate to global pixel coordinates
+// at zoom, rounded to whole pixels.
+func FromLLToPixel(ll [2]float64, zoom uint64) [2]float64 {
+	d := gp.zc[zoom]
+	e := math.Trunc((d[0] + ll[0]*gp.Bc[zoom]) + 0.5)
+	f := minmax(math.Sin(ll[1]*math.Pi/180.0), -0.9999, 0.9999)
+	g := math.Trunc((d[1] + 0.5*math.Log((1+f)/(1-f))*-gp.Cc[zoom]) + 0.5)
+	return [2]float64{e, g}
+}
+
+// FromPixelToLL converts global pixel coordinates at zoom to lon, lat.
+func FromPixelToLL(px [2]float64, zoom uint64) [2]float64 {
+	e := gp.zc[zoom]
+	f := (px[0] - e[0]) / gp.Bc[zoom]
+	g := (px[1] - e[1]) / -gp.Cc[zoom]
+	h := 180.0 / math.Pi * (2*math.Atan(math.Exp(g)) - 0.5*math.Pi)
+	return [2]float64{f, h}
+}
+
+// ClampTile limits a tile column or row to the tiles of zoom level z.
+func ClampTile(v, z uint64) uint64 {
+	if last := uint64(1)<<z - 1; v > last {
+		return last
+	}
+	return v
+}
+
+// TileToBBox returns the bounding box of a tile.
+func TileToBBox(t Tile) BBox {
+	return TileRangeToBBox(t.Zoom, t.X, t.Y, t.X, t.Y)
+}
+
+// TileRangeToBBox returns the bounding box of the tiles from minX, minY
+// to maxX, maxY inclusive.
+func TileRangeToBBox(z, minX, minY, maxX, maxY uint64) BBox {
+	ll := FromPixelToLL([2]float64{float64(minX) * TileSize, float64(maxY+1) * TileSize}, z)
+	ur := FromPixelToLL([2]float64{float64(maxX+1) * TileSize, float64(minY) * TileSize}, z)
+	return BBox{ll[0], ll[1], ur[0], ur[1]}
+}
+
+// BBoxToTileRange returns the upper left and lower right tiles covering
+// the bounding box at zoom z. Tiles touching the edges of the bounding box
+// are included.
+func BBoxToTileRange(b BBox, z uint64) (min, max Tile) {
+	px0 := FromLLToPixel([2]float64{b[0], b[3]}, z)
+	px1 := FromLLToPixel([2]float64{b[2], b[1]}, z)
+	min = Tile{ClampTile(uint64(px0[0]/TileSize), z), ClampTile(uint64(px0[1]/TileSize), z), z}
+	max = Tile{ClampTile(uint64(px1[0]/TileSize), z), ClampTile(uint64(px1[1]/TileSize), z), z}
+	return min, max
+}
+
+// LatLonToTile returns the tile containing the coordinate at zoom z.
+func LatLonToTile(lat, lon float64, z uint64) Tile {
+	px := FromLLToPixel([2]float64{lon, lat}, z)
+	return Tile{ClampTile(uint64(px[0]/TileSize), z), ClampTile(uint64(px[1]/TileSize), z), z}
+}
+
+// Resolution returns the size of a pixel at the equator in meters.
+func Resolution(z uint64) float64 {
+	return EarthCircumference / TileSize / math.Exp2(float64(z))
+}
+
+// ScaleDenominator returns the OGC scale denominator of zoom level z,
+// assuming the standard pixel size of 0.28 mm.
+func ScaleDenominator(z uint64) float64 {
+	return Resolution(z) / 0.00028
+}
+
+// Parent returns the tile of the next lower zoom level containing t.
+// The parent of the zoom level 0 tile is itself.
+func Parent(t Tile) Tile {
+	if t.Zoom == 0 {
+		return t
+	}
+	return Tile{t.X / 2, t.Y / 2, t.Zoom - 1}
+}
+
+// Children returns the four tiles of the next zoom level covering t,
+// in the order upper left, upper right, lower left, lower right.
+func Children(t Tile) [4]Tile {
+	x, y, z := 2*t.X, 2*t.Y, t.Zoom+1
+	return [4]Tile{{x, y, z}, {x + 1, y, z}, {x, y + 1, z}, {x + 1, y + 1, z}}
+}